@@ -0,0 +1,474 @@
+// Package cassette records API traffic into a file and replays it back without network access.
+//
+// A cassette is meant to be attached to bug reports, so all credentials are redacted before they
+// are written: authentication headers, known secret values (e.g. the API token), JSON fields with
+// secret looking names, and PEM private keys.
+package cassette
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// Cassettes used to be a single JSON document, they are now written as JSON lines: a header
+	// followed by an interaction per line, so that recording a request appends a line.
+	version       = 2
+	versionSingle = 1
+	Redacted      = "REDACTED"
+
+	// Larger bodies are not recorded, and passed through as they are, e.g. Target downloads
+	MaxBodySize = 1024 * 1024
+)
+
+// Cassette is a sequence of recorded request/response pairs.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Recorded     time.Time     `json:"recorded"`
+	Interactions []Interaction `json:"interactions,omitempty"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Request struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    Body        `json:"body,omitempty"`
+}
+
+type Response struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    Body        `json:"body,omitempty"`
+}
+
+// Body is kept as a string when it is a valid UTF-8 text, and base64 encoded otherwise. Large
+// or binary bodies are omitted, with the reason why.
+type Body struct {
+	Text    string `json:"text,omitempty"`
+	Base64  string `json:"base64,omitempty"`
+	Omitted string `json:"omitted,omitempty"`
+}
+
+func newBody(data []byte) Body {
+	if utf8.Valid(data) {
+		return Body{Text: string(data)}
+	}
+	return Body{Base64: base64.StdEncoding.EncodeToString(data)}
+}
+
+func (b Body) Bytes() ([]byte, error) {
+	if len(b.Omitted) > 0 {
+		return nil, fmt.Errorf("The body was not recorded: %s", b.Omitted)
+	}
+	if len(b.Base64) > 0 {
+		return base64.StdEncoding.DecodeString(b.Base64)
+	}
+	return []byte(b.Text), nil
+}
+
+// Load reads a cassette file.
+func Load(path string) (*Cassette, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read cassette: %w", err)
+	}
+	defer file.Close()
+	dec := json.NewDecoder(file)
+	var c Cassette
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("Unable to parse cassette %s: %w", path, err)
+	}
+	switch c.Version {
+	case versionSingle:
+		return &c, nil
+	case version:
+		for dec.More() {
+			var interaction Interaction
+			if err := dec.Decode(&interaction); err != nil {
+				return nil, fmt.Errorf("Unable to parse cassette %s: %w", path, err)
+			}
+			c.Interactions = append(c.Interactions, interaction)
+		}
+		return &c, nil
+	}
+	return nil, fmt.Errorf("Unsupported cassette version %d in %s", c.Version, path)
+}
+
+// Save writes a cassette file. It is only readable by the user: even though credentials are
+// redacted, the API responses may have other sensitive data, e.g. device details.
+func (c *Cassette) Save(path string) error {
+	file, err := createCassette(path, c.Recorded)
+	if err != nil {
+		return err
+	}
+	for _, interaction := range c.Interactions {
+		if err := writeInteraction(file, interaction); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// createCassette creates a cassette file with its header line.
+func createCassette(path string, recorded time.Time) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to save cassette: %w", err)
+	}
+	if err := writeHeader(file, recorded); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// appendCassette opens a cassette file for appending interactions, so that several commands
+// can be recorded into one cassette. A missing or empty file gets a header line first.
+func appendCassette(path string, recorded time.Time) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to save cassette: %w", err)
+	}
+	info, err := file.Stat()
+	if err == nil && info.Size() == 0 {
+		err = writeHeader(file, recorded)
+	} else if err == nil {
+		var c *Cassette
+		if c, err = Load(path); err == nil && c.Version != version {
+			err = fmt.Errorf("Unable to append to cassette %s of version %d, record into a new file", path, c.Version)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func writeHeader(file *os.File, recorded time.Time) error {
+	header, err := json.Marshal(Cassette{Version: version, Recorded: recorded})
+	if err == nil {
+		_, err = file.Write(append(header, '\n'))
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to save cassette: %w", err)
+	}
+	return nil
+}
+
+func writeInteraction(file *os.File, interaction Interaction) error {
+	buf, err := json.Marshal(interaction)
+	if err == nil {
+		_, err = file.Write(append(buf, '\n'))
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to save cassette: %w", err)
+	}
+	return nil
+}
+
+// Recorder is an HTTP transport which saves all traffic passing through it into a cassette.
+type Recorder struct {
+	transport http.RoundTripper
+	path      string
+	redactor  redactor
+	recorded  time.Time
+
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewRecorder creates a recorder which sends requests via the transport and saves them to path.
+// The secrets, e.g. an API token, are replaced wherever they appear in the traffic.
+func NewRecorder(transport http.RoundTripper, path string, secrets ...string) *Recorder {
+	return &Recorder{
+		transport: transport,
+		path:      path,
+		redactor:  newRedactor(secrets),
+		recorded:  time.Now().UTC(),
+	}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := r.capture(&req.Body, req.ContentLength, req.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resBody, err := r.capture(&res.Body, res.ContentLength, res.Header.Get("Content-Type"))
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	interaction := Interaction{
+		Request: Request{
+			Method:  req.Method,
+			URL:     r.redactor.text(req.URL.String()),
+			Headers: r.redactor.headers(req.Header),
+			Body:    reqBody,
+		},
+		Response: Response{
+			Status:  res.StatusCode,
+			Headers: r.redactor.headers(res.Header),
+			Body:    resBody,
+		},
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.file == nil {
+		if r.file, err = appendCassette(r.path, r.recorded); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	}
+	// Each interaction is written right away: commands exit right away on errors, which are
+	// the most interesting traces to capture.
+	if err := writeInteraction(r.file, interaction); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// capture returns the redacted body to record, and replaces the body with one which can still
+// be read by the caller. Bodies which are not text, or larger than MaxBodySize, are streamed
+// through as they are.
+func (r *Recorder) capture(body *io.ReadCloser, length int64, contentType string) (Body, error) {
+	if *body == nil || *body == http.NoBody {
+		return Body{}, nil
+	}
+	if !textContent(contentType) {
+		return Body{Omitted: "content type " + contentType}, nil
+	}
+	if length > MaxBodySize {
+		return Body{Omitted: fmt.Sprintf("%d bytes", length)}, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(*body, MaxBodySize+1))
+	if err != nil {
+		(*body).Close()
+		return Body{}, err
+	}
+	if len(data) > MaxBodySize {
+		// The length was unknown, the rest is streamed after what was read
+		*body = readCloser{io.MultiReader(bytes.NewReader(data), *body), *body}
+		return Body{Omitted: fmt.Sprintf("more than %d bytes", MaxBodySize)}, nil
+	}
+	(*body).Close()
+	*body = io.NopCloser(bytes.NewReader(data))
+	return newBody(r.redactor.body(data)), nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// textContent tells if a content type is worth recording: JSON, text, and the like.
+func textContent(contentType string) bool {
+	if len(contentType) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "yaml") ||
+		strings.HasSuffix(mediaType, "xml") || mediaType == "application/x-www-form-urlencoded"
+}
+
+// Replayer is an HTTP transport which serves responses from a cassette without network access.
+// A request is matched by its method, path, and query. Identical requests get the recorded
+// responses in order, and the last one once they are exhausted (e.g. when polling a status).
+type Replayer struct {
+	lock     sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+func NewReplayer(c *Cassette) *Replayer {
+	return &Replayer{cassette: c, used: make([]bool, len(c.Interactions))}
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	found := -1
+	for i, interaction := range r.cassette.Interactions {
+		if !r.matches(interaction.Request, req) {
+			continue
+		}
+		found = i
+		if !r.used[i] {
+			break
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("No recorded response in the cassette for %s %s", req.Method, req.URL)
+	}
+	r.used[found] = true
+
+	recorded := r.cassette.Interactions[found].Response
+	body, err := recorded.Body.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to replay the response to %s %s: %w", req.Method, req.URL, err)
+	}
+	header := recorded.Headers.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Replayer) matches(recorded Request, req *http.Request) bool {
+	if recorded.Method != req.Method {
+		return false
+	}
+	// The host is not compared, so that a cassette can be replayed with another server config
+	idx := strings.Index(recorded.URL, "://")
+	uri := recorded.URL
+	if idx >= 0 {
+		uri = uri[idx+3:]
+		if slash := strings.Index(uri, "/"); slash >= 0 {
+			uri = uri[slash:]
+		} else {
+			uri = "/"
+		}
+	}
+	return uri == req.URL.RequestURI()
+}
+
+var (
+	// Headers with credentials which are always redacted
+	secretHeaders = map[string]bool{
+		"Authorization":       true,
+		"Proxy-Authorization": true,
+		"Cookie":              true,
+		"Set-Cookie":          true,
+		"Osf-Token":           true,
+	}
+	secretName = regexp.MustCompile(`(?i)(token|secret|passw|private[-_]?key|client[-_]?key|credential|api[-_]?key)`)
+	privateKey = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[^-]*-----END [A-Z ]*PRIVATE KEY-----`)
+)
+
+type redactor struct {
+	secrets []string
+}
+
+func newRedactor(secrets []string) redactor {
+	var r redactor
+	for _, s := range secrets {
+		if len(s) > 0 {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+	return r
+}
+
+func (r redactor) text(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, Redacted)
+	}
+	return privateKey.ReplaceAllString(s, Redacted)
+}
+
+func (r redactor) headers(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	redacted := make(http.Header, len(h))
+	for name, values := range h {
+		if secretHeaders[http.CanonicalHeaderKey(name)] || secretName.MatchString(name) {
+			redacted[name] = []string{Redacted}
+			continue
+		}
+		for _, v := range values {
+			redacted[name] = append(redacted[name], r.text(v))
+		}
+	}
+	return redacted
+}
+
+// body redacts a JSON document field by field, and any other content as a text.
+func (r redactor) body(data []byte) []byte {
+	if len(data) == 0 || !utf8.Valid(data) {
+		return data
+	}
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err == nil && !dec.More() {
+		if changed := r.json(&doc, false); changed {
+			if buf, err := json.Marshal(doc); err == nil {
+				// Original bytes are kept otherwise, as the content may be signed, e.g. TUF metadata
+				data = buf
+			}
+		}
+	}
+	return []byte(r.text(string(data)))
+}
+
+// json redacts the values of secret looking fields in place. Secret values of Factory secrets
+// are kept in "value" fields of objects inside a "secrets" list, so those are redacted as well.
+func (r redactor) json(doc *interface{}, inSecrets bool) bool {
+	changed := false
+	switch v := (*doc).(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			if _, isString := val.(string); isString && (secretName.MatchString(key) || (inSecrets && key == "value")) {
+				if val != Redacted {
+					v[key] = Redacted
+					changed = true
+				}
+				continue
+			}
+			if r.json(&val, strings.EqualFold(key, "secrets")) {
+				v[key] = val
+				changed = true
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			if r.json(&v[i], inSecrets) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
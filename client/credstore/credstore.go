@@ -0,0 +1,58 @@
+// Package credstore keeps the secret parts of OAuth client credentials outside of the fioctl config
+// file: in the OS keyring, or in a passphrase-encrypted file for hosts without a keyring.
+package credstore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Names of the available stores, as used by "fioctl login --store".
+const (
+	Plain   = "plain" // Secrets are kept in the fioctl config file, like before stores were added
+	Keyring = "keyring"
+	File    = "file"
+)
+
+var Names = []string{Keyring, File, Plain}
+
+var ErrNotFound = errors.New("credentials not found in the store")
+
+// Secrets are the sensitive fields of client.OAuthConfig.
+type Secrets struct {
+	ClientSecret string `json:"client_secret"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Store persists secrets by a key, which is an OAuth client ID.
+type Store interface {
+	// Get returns the secrets saved under a key, or ErrNotFound.
+	Get(key string) (Secrets, error)
+	Set(key string, secrets Secrets) error
+	// Delete removes the secrets saved under a key; it is not an error if they do not exist.
+	Delete(key string) error
+}
+
+// Open returns a store by its name. The configFile is the path to the fioctl config, next to which
+// the file store is kept. The plain store has no implementation: its secrets are a part of the
+// config, so the caller handles them.
+func Open(name, configFile string) (Store, error) {
+	switch name {
+	case Keyring:
+		return keyringStore{}, nil
+	case File:
+		return newFileStore(configFile), nil
+	}
+	return nil, fmt.Errorf("Unsupported credential store: %s", name)
+}
+
+// Valid returns an error if a store name is unknown.
+func Valid(name string) error {
+	for _, n := range Names {
+		if n == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid credential store %q, must be one of: %v", name, Names)
+}
@@ -0,0 +1,73 @@
+package credstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando/go-keyring"
+)
+
+func testStore(t *testing.T, st Store) {
+	_, err := st.Get("client-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	secrets := Secrets{ClientSecret: "secret", AccessToken: "access", RefreshToken: "refresh"}
+	require.Nil(t, st.Set("client-1", secrets))
+	require.Nil(t, st.Set("client-2", Secrets{ClientSecret: "other"}))
+	val, err := st.Get("client-1")
+	require.Nil(t, err)
+	assert.Equal(t, secrets, val)
+
+	require.Nil(t, st.Delete("client-1"))
+	require.Nil(t, st.Delete("client-1"))
+	_, err = st.Get("client-1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	val, err = st.Get("client-2")
+	require.Nil(t, err)
+	assert.Equal(t, "other", val.ClientSecret)
+}
+
+func TestKeyringStore(t *testing.T) {
+	keyring.MockInit()
+	st, err := Open(Keyring, "/tmp/fioctl.yaml")
+	require.Nil(t, err)
+	testStore(t, st)
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "fioctl.yaml")
+	prompts := 0
+	ReadPassphrase = func(path string, confirm bool) (string, error) {
+		prompts += 1
+		assert.True(t, confirm, "A new file must ask to confirm the passphrase")
+		return "correct horse", nil
+	}
+	defer func() { ReadPassphrase = promptPassphrase }()
+
+	st, err := Open(File, config)
+	require.Nil(t, err)
+	testStore(t, st)
+	assert.Equal(t, 1, prompts, "The passphrase must be cached")
+
+	path := filepath.Join(dir, "fioctl-credentials.enc")
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	buf, err := os.ReadFile(path)
+	require.Nil(t, err)
+	assert.NotContains(t, string(buf), "other")
+
+	delete(passphrases, path)
+	t.Setenv(PassphraseEnv, "wrong")
+	_, err = st.Get("client-2")
+	assert.ErrorContains(t, err, "wrong passphrase")
+
+	t.Setenv(PassphraseEnv, "correct horse")
+	val, err := st.Get("client-2")
+	require.Nil(t, err)
+	assert.Equal(t, "other", val.ClientSecret)
+}
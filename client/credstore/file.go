@@ -0,0 +1,195 @@
+package credstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// PassphraseEnv allows to provide a passphrase for the file store non-interactively.
+const PassphraseEnv = "FIOCTL_CREDS_PASSPHRASE"
+
+// ReadPassphrase asks a user for the passphrase of the file store. When a new file is created,
+// confirm is true and the passphrase should be asked twice.
+var ReadPassphrase = promptPassphrase
+
+// Passphrases are cached by file, so that a user is asked once per command.
+var passphrases = make(map[string]string)
+
+// The file content: secrets by key are encrypted with AES-GCM, using a key derived from the
+// passphrase with scrypt.
+type encryptedFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+type fileStore struct {
+	path string
+}
+
+func newFileStore(configFile string) *fileStore {
+	return &fileStore{path: filepath.Join(filepath.Dir(configFile), "fioctl-credentials.enc")}
+}
+
+func (s *fileStore) Get(key string) (Secrets, error) {
+	all, err := s.read()
+	if err != nil {
+		return Secrets{}, err
+	}
+	secrets, ok := all[key]
+	if !ok {
+		return secrets, ErrNotFound
+	}
+	return secrets, nil
+}
+
+func (s *fileStore) Set(key string, secrets Secrets) error {
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	all[key] = secrets
+	return s.write(all)
+}
+
+func (s *fileStore) Delete(key string) error {
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := all[key]; !ok {
+		return nil
+	}
+	delete(all, key)
+	return s.write(all)
+}
+
+func (s *fileStore) read() (map[string]Secrets, error) {
+	all := make(map[string]Secrets)
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return all, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var enc encryptedFile
+	if err := json.Unmarshal(buf, &enc); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %w", s.path, err)
+	}
+	if enc.Version != 1 {
+		return nil, fmt.Errorf("Unsupported version of %s: %d", s.path, enc.Version)
+	}
+	passphrase, err := s.passphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newCipher(passphrase, enc.Salt)
+	if err != nil {
+		return nil, err
+	}
+	data, err := aead.Open(nil, enc.Nonce, enc.Data, nil)
+	if err != nil {
+		delete(passphrases, s.path)
+		return nil, fmt.Errorf("Unable to decrypt %s: wrong passphrase?", s.path)
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %w", s.path, err)
+	}
+	return all, nil
+}
+
+func (s *fileStore) write(all map[string]Secrets) error {
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	_, statErr := os.Stat(s.path)
+	passphrase, err := s.passphrase(errors.Is(statErr, fs.ErrNotExist))
+	if err != nil {
+		return err
+	}
+	// A new salt and nonce for each write; nonce reuse would break GCM
+	enc := encryptedFile{Version: 1, Salt: make([]byte, 16)}
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return err
+	}
+	aead, err := newCipher(passphrase, enc.Salt)
+	if err != nil {
+		return err
+	}
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return err
+	}
+	enc.Data = aead.Seal(nil, enc.Nonce, data, nil)
+	buf, err := json.Marshal(enc)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, buf, 0o600)
+}
+
+func (s *fileStore) passphrase(confirm bool) (string, error) {
+	if p, ok := passphrases[s.path]; ok {
+		return p, nil
+	}
+	p := os.Getenv(PassphraseEnv)
+	if len(p) == 0 {
+		var err error
+		if p, err = ReadPassphrase(s.path, confirm); err != nil {
+			return "", err
+		}
+	}
+	if len(p) == 0 {
+		return "", errors.New("The passphrase for the credentials file must not be empty")
+	}
+	passphrases[s.path] = p
+	return p, nil
+}
+
+func newCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func promptPassphrase(path string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("Unable to ask for the passphrase of %s, set it via the %s environment variable", path, PassphraseEnv)
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", path)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil || !confirm {
+		return string(p), err
+	}
+	fmt.Fprint(os.Stderr, "Repeat the passphrase: ")
+	p2, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(p) != string(p2) {
+		return "", errors.New("Passphrases do not match")
+	}
+	return string(p), nil
+}
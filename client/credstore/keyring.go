@@ -0,0 +1,52 @@
+package credstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// The service name under which secrets are kept in the Secret Service (Linux), Keychain (macOS),
+// or Credential Manager (Windows).
+const keyringService = "fioctl"
+
+type keyringStore struct{}
+
+func (keyringStore) Get(key string) (Secrets, error) {
+	var secrets Secrets
+	val, err := keyring.Get(keyringService, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return secrets, ErrNotFound
+	} else if err != nil {
+		return secrets, keyringError(err)
+	}
+	if err := json.Unmarshal([]byte(val), &secrets); err != nil {
+		return secrets, fmt.Errorf("Unable to parse credentials from the OS keyring: %w", err)
+	}
+	return secrets, nil
+}
+
+func (keyringStore) Set(key string, secrets Secrets) error {
+	val, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	if err := keyring.Set(keyringService, key, string(val)); err != nil {
+		return keyringError(err)
+	}
+	return nil
+}
+
+func (keyringStore) Delete(key string) error {
+	if err := keyring.Delete(keyringService, key); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return keyringError(err)
+	}
+	return nil
+}
+
+func keyringError(err error) error {
+	return fmt.Errorf("Unable to access the OS keyring: %w\n"+
+		"Hosts without a keyring (e.g. headless Linux servers) can use the \"file\" store instead", err)
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sentinel errors for the common classes of API failures. An *HttpError matches these via
+// errors.Is based on its HTTP status code, e.g.: errors.Is(err, client.ErrNotFound).
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServer       = errors.New("server error")
+)
+
+// This is an error returned in case if we've successfully received an HTTP response which contains
+// an unexpected HTTP status code
+type HttpError struct {
+	Message  string
+	Response *http.Response
+
+	// Below is the error detail returned by the API, if it is in a well known format.
+	// Code is a machine readable error code, e.g. "invalid_target".
+	Code string
+	// Errors lists error messages not tied to a specific request field.
+	Errors []string
+	// FieldErrors maps request fields to their error messages.
+	FieldErrors map[string]string
+}
+
+func (err *HttpError) Error() string {
+	return err.Message
+}
+
+// StatusCode returns the HTTP status code of the response, or 0 if there is no response.
+func (err *HttpError) StatusCode() int {
+	if err.Response == nil {
+		return 0
+	}
+	return err.Response.StatusCode
+}
+
+// Is allows to match an HttpError against the sentinel errors via errors.Is.
+func (err *HttpError) Is(target error) bool {
+	status := err.StatusCode()
+	switch target {
+	case ErrUnauthorized:
+		return status == http.StatusUnauthorized
+	case ErrForbidden:
+		return status == http.StatusForbidden
+	case ErrNotFound:
+		return status == http.StatusNotFound
+	case ErrConflict:
+		return status == http.StatusConflict
+	case ErrRateLimited:
+		return status == http.StatusTooManyRequests
+	case ErrServer:
+		return status >= 500 && status < 600
+	}
+	return false
+}
+
+// This is much better than err.(HttpError) as it also accounts for wrapped errors.
+func AsHttpError(err error) *HttpError {
+	var httpError *HttpError
+	if errors.As(err, &httpError) {
+		return httpError
+	} else {
+		return nil
+	}
+}
+
+// newHttpError builds an error for an unexpected response, using the error detail from the body
+// for both the message and the structured fields.
+func newHttpError(res *http.Response, body []byte) *HttpError {
+	const PRINT_LIMIT = 512
+	herr := &HttpError{Response: res}
+	msg := fmt.Sprintf("HTTP error during %s '%s': %s",
+		res.Request.Method, res.Request.URL.String(), res.Status)
+
+	// Some APIs return well-formatted errors, try to use them.
+	// Errors can be either a list of messages or a dict of messages by field name.
+	var detail struct {
+		Msg     string          `json:"msg,omitempty"`
+		Message string          `json:"message,omitempty"`
+		Code    json.RawMessage `json:"code,omitempty"`
+		Errors  json.RawMessage `json:"errors,omitempty"`
+	}
+	useGenericError := false
+	if merr := json.Unmarshal(body, &detail); merr == nil {
+		herr.Code = errorCode(detail.Code)
+		if len(detail.Errors) > 0 {
+			if json.Unmarshal(detail.Errors, &herr.Errors) != nil {
+				_ = json.Unmarshal(detail.Errors, &herr.FieldErrors)
+			}
+		}
+		if detail.Msg != "" {
+			msg += "\n= " + detail.Msg
+		} else if detail.Message != "" {
+			msg += "\n= " + detail.Message
+		} else {
+			useGenericError = true
+		}
+	}
+
+	if useGenericError {
+		logrus.Debugf("Failed to parse error data... return original error")
+		if len(body) < PRINT_LIMIT {
+			// return an error response body up to a meaningful limit - if it spans beyond a few
+			// lines, need to find a more appropriate message.
+			msg = fmt.Sprintf("%s\n= %s", msg, body)
+		} else {
+			msg += "\n= Error body too long, try to use the --verbose option"
+		}
+	} else {
+		for _, emsg := range herr.Errors {
+			msg += "\n * " + emsg
+		}
+		fields := make([]string, 0, len(herr.FieldErrors))
+		for field := range herr.FieldErrors {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			msg += fmt.Sprintf("\n * %s: %s", field, herr.FieldErrors[field])
+		}
+	}
+	herr.Message = msg
+	return herr
+}
+
+// errorCode accepts both string and numeric error codes.
+func errorCode(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var code string
+	if err := json.Unmarshal(raw, &code); err == nil {
+		return code
+	}
+	return string(raw)
+}
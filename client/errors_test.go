@@ -0,0 +1,69 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHttpErrorDetail(t *testing.T) {
+	bodies := map[string]string{
+		"/list":    `{"message": "Invalid request", "code": "invalid", "errors": ["bad name", "bad tag"]}`,
+		"/dict":    `{"msg": "Invalid request", "code": 42, "errors": {"tag": "bad tag", "name": "bad name"}}`,
+		"/generic": `{"detail": "something"}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(bodies[r.URL.Path]))
+	}))
+	defer srv.Close()
+	api := newTestApi(srv, RetryConfig{MaxAttempts: 1})
+
+	_, err := api.Get(srv.URL + "/list")
+	herr := AsHttpError(err)
+	require.NotNil(t, herr)
+	assert.Equal(t, http.StatusBadRequest, herr.StatusCode())
+	assert.Equal(t, "invalid", herr.Code)
+	assert.Equal(t, []string{"bad name", "bad tag"}, herr.Errors)
+	assert.Contains(t, herr.Message, "\n= Invalid request\n * bad name\n * bad tag")
+
+	_, err = api.Get(srv.URL + "/dict")
+	herr = AsHttpError(err)
+	require.NotNil(t, herr)
+	assert.Equal(t, "42", herr.Code)
+	assert.Equal(t, map[string]string{"name": "bad name", "tag": "bad tag"}, herr.FieldErrors)
+	assert.Contains(t, herr.Message, "\n= Invalid request\n * name: bad name\n * tag: bad tag")
+
+	_, err = api.Get(srv.URL + "/generic")
+	herr = AsHttpError(err)
+	require.NotNil(t, herr)
+	assert.Contains(t, herr.Message, `= {"detail": "something"}`)
+}
+
+func TestHttpErrorIs(t *testing.T) {
+	sentinels := map[int]error{
+		http.StatusUnauthorized:        ErrUnauthorized,
+		http.StatusForbidden:           ErrForbidden,
+		http.StatusNotFound:            ErrNotFound,
+		http.StatusConflict:            ErrConflict,
+		http.StatusTooManyRequests:     ErrRateLimited,
+		http.StatusInternalServerError: ErrServer,
+		http.StatusBadGateway:          ErrServer,
+	}
+	for status, sentinel := range sentinels {
+		herr := &HttpError{Response: &http.Response{StatusCode: status}}
+		wrapped := fmt.Errorf("wrapped: %w", herr)
+		assert.True(t, errors.Is(wrapped, sentinel), "status %d", status)
+		for _, other := range sentinels {
+			if other != sentinel {
+				assert.False(t, errors.Is(wrapped, other), "status %d matches %s", status, other)
+			}
+		}
+	}
+	assert.False(t, errors.Is(&HttpError{Response: &http.Response{StatusCode: 400}}, ErrNotFound))
+}
@@ -0,0 +1,221 @@
+package fakeapi
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func (s *Server) addDeviceRoutes(mux *http.ServeMux) {
+	s.handle(mux, "GET /ota/devices/{$}", s.listDevices)
+	s.handle(mux, "GET /ota/devices/{device}/{$}", s.withDevice(s.getDevice))
+	s.handle(mux, "PATCH /ota/devices/{device}/{$}", s.withDevice(s.patchDevice))
+	s.handle(mux, "DELETE /ota/devices/{device}/{$}", s.withDevice(s.deleteDevice))
+
+	s.handle(mux, "GET /ota/devices/{device}/config/{$}", s.withDevice(func(w http.ResponseWriter, r *http.Request, d *client.Device) {
+		s.listConfig(w, r, s.state.DeviceConfigs[d.Uuid])
+	}))
+	s.handle(mux, "POST /ota/devices/{device}/config/{$}", s.withDevice(func(w http.ResponseWriter, r *http.Request, d *client.Device) {
+		s.state.DeviceConfigs[d.Uuid] = s.createConfig(w, r, s.state.DeviceConfigs[d.Uuid], false)
+	}))
+	s.handle(mux, "PATCH /ota/devices/{device}/config/{$}", s.withDevice(func(w http.ResponseWriter, r *http.Request, d *client.Device) {
+		s.state.DeviceConfigs[d.Uuid] = s.createConfig(w, r, s.state.DeviceConfigs[d.Uuid], true)
+	}))
+	s.handle(mux, "DELETE /ota/devices/{device}/config/{file}/{$}", s.withDevice(func(w http.ResponseWriter, r *http.Request, d *client.Device) {
+		s.state.DeviceConfigs[d.Uuid] = s.deleteConfig(w, r, s.state.DeviceConfigs[d.Uuid])
+	}))
+
+	s.handle(mux, "GET /ota/devices/{device}/updates/{$}", s.withDevice(func(w http.ResponseWriter, r *http.Request, d *client.Device) {
+		updates := s.state.DeviceUpdates[d.Uuid]
+		page, next := paginate(r, s.URL, updates, 50)
+		writeJSON(w, http.StatusOK, client.UpdateList{Updates: page, Total: len(updates), Next: next})
+	}))
+	s.handle(mux, "GET /ota/devices/{device}/updates/{update}/{$}", s.withDevice(func(w http.ResponseWriter, r *http.Request, d *client.Device) {
+		events, ok := s.state.UpdateEvents[r.PathValue("update")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Update not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, events)
+	}))
+	s.handle(mux, "GET /ota/devices/{device}/apps-states/{$}", s.withDevice(func(w http.ResponseWriter, r *http.Request, d *client.Device) {
+		writeJSON(w, http.StatusOK, s.state.AppsStates[d.Uuid])
+	}))
+}
+
+// findDevice looks up a device the same way the API does: by name, or by UUID if requested.
+func (s *Server) findDevice(r *http.Request) int {
+	id := r.PathValue("device")
+	byUuid := r.URL.Query().Get("by-uuid") == "1"
+	for idx, d := range s.state.Devices {
+		if (byUuid && d.Uuid == id) || (!byUuid && d.Name == id) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (s *Server) withDevice(fn func(http.ResponseWriter, *http.Request, *client.Device)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idx := s.findDevice(r)
+		if idx < 0 {
+			writeError(w, http.StatusNotFound, "Device not found")
+			return
+		}
+		fn(w, r, &s.state.Devices[idx])
+	}
+}
+
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	devices := []client.Device{}
+	for _, d := range s.state.Devices {
+		if pattern := q.Get("name"); len(pattern) > 0 {
+			if ok, _ := path.Match(pattern, d.Name); !ok {
+				continue
+			}
+		}
+		if val := q.Get("uuid"); len(val) > 0 && val != d.Uuid {
+			continue
+		}
+		if val := q.Get("group"); len(val) > 0 && val != d.GroupName {
+			continue
+		}
+		if val := q.Get("match_tag"); len(val) > 0 && val != d.Tag {
+			continue
+		}
+		if val := q.Get("target_name"); len(val) > 0 && val != d.TargetName {
+			continue
+		}
+		if val := q.Get("prod"); len(val) > 0 && (val == "1") != d.IsProd {
+			continue
+		}
+		devices = append(devices, d)
+	}
+	page, next := paginate(r, s.URL, devices, 500)
+	writeJSON(w, http.StatusOK, client.DeviceList{Devices: page, Total: len(devices), Next: next})
+}
+
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request, d *client.Device) {
+	dev := *d
+	if len(dev.GroupName) > 0 {
+		for _, g := range s.state.Groups {
+			if g.Name == dev.GroupName {
+				dev.Group = &g
+			}
+		}
+	}
+	if cfgs := s.state.DeviceConfigs[d.Uuid]; len(cfgs) > 0 {
+		dev.ActiveConfig = &cfgs[0]
+	}
+	writeJSON(w, http.StatusOK, dev)
+}
+
+func (s *Server) patchDevice(w http.ResponseWriter, r *http.Request, d *client.Device) {
+	var body map[string]string
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if name, ok := body["name"]; ok {
+		for _, other := range s.state.Devices {
+			if other.Name == name && other.Uuid != d.Uuid {
+				writeError(w, http.StatusConflict, "A device with this name already exists")
+				return
+			}
+		}
+		d.Name = name
+	}
+	if owner, ok := body["owner"]; ok {
+		d.Owner = owner
+	}
+	if group, ok := body["group"]; ok {
+		if len(group) > 0 && s.findGroup(group) < 0 {
+			writeError(w, http.StatusNotFound, "Device group not found")
+			return
+		}
+		d.GroupName = group
+	}
+	d.ChangeMeta.UpdatedAt = now()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteDevice(w http.ResponseWriter, r *http.Request, d *client.Device) {
+	uuid := d.Uuid
+	for idx := range s.state.Devices {
+		if s.state.Devices[idx].Uuid == uuid {
+			s.state.Devices = append(s.state.Devices[:idx], s.state.Devices[idx+1:]...)
+			break
+		}
+	}
+	delete(s.state.DeviceConfigs, uuid)
+	delete(s.state.DeviceUpdates, uuid)
+	delete(s.state.AppsStates, uuid)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listConfig(w http.ResponseWriter, r *http.Request, configs []client.DeviceConfig) {
+	page, next := paginate(r, s.URL, configs, 20)
+	if page == nil {
+		page = []client.DeviceConfig{}
+	}
+	writeJSON(w, http.StatusOK, client.DeviceConfigList{Configs: page, Total: len(configs), Next: next})
+}
+
+// createConfig adds a new changelog entry. A patch merges files into the most recent entry,
+// while a create replaces all files.
+func (s *Server) createConfig(
+	w http.ResponseWriter, r *http.Request, configs []client.DeviceConfig, patch bool,
+) []client.DeviceConfig {
+	var req client.ConfigCreateRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return configs
+	}
+	files := req.Files
+	if patch && len(configs) > 0 {
+		files = mergeFiles(configs[0].Files, req.Files)
+	}
+	entry := client.DeviceConfig{CreatedAt: now(), Reason: req.Reason, Files: files}
+	w.WriteHeader(http.StatusCreated)
+	return append([]client.DeviceConfig{entry}, configs...)
+}
+
+func (s *Server) deleteConfig(w http.ResponseWriter, r *http.Request, configs []client.DeviceConfig) []client.DeviceConfig {
+	name := r.PathValue("file")
+	if len(configs) == 0 {
+		writeError(w, http.StatusNotFound, "Config file not found")
+		return configs
+	}
+	var files []client.ConfigFile
+	for _, f := range configs[0].Files {
+		if f.Name != name {
+			files = append(files, f)
+		}
+	}
+	if len(files) == len(configs[0].Files) {
+		writeError(w, http.StatusNotFound, "Config file not found")
+		return configs
+	}
+	entry := client.DeviceConfig{CreatedAt: now(), Reason: "Delete " + name, Files: files}
+	w.WriteHeader(http.StatusNoContent)
+	return append([]client.DeviceConfig{entry}, configs...)
+}
+
+func mergeFiles(current, updates []client.ConfigFile) []client.ConfigFile {
+	merged := append([]client.ConfigFile(nil), current...)
+	for _, u := range updates {
+		replaced := false
+		for idx := range merged {
+			if merged[idx].Name == u.Name {
+				merged[idx] = u
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, u)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,292 @@
+package fakeapi
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func (s *Server) addFactoryRoutes(mux *http.ServeMux) {
+	s.handle(mux, "GET /ota/factories/{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, []client.Factory{{Name: s.Factory, Id: s.Factory}})
+	})
+	s.handle(mux, "GET /ota/factories/{factory}/status/{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.state.Status)
+	})
+	s.handle(mux, "GET /ota/factories/{factory}/denied-devices/{$}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, client.DeviceList{Devices: []client.Device{}})
+	})
+
+	s.handle(mux, "GET /ota/factories/{factory}/config/{$}", func(w http.ResponseWriter, r *http.Request) {
+		s.listConfig(w, r, s.state.FactoryConfigs)
+	})
+	s.handle(mux, "POST /ota/factories/{factory}/config/{$}", func(w http.ResponseWriter, r *http.Request) {
+		s.state.FactoryConfigs = s.createConfig(w, r, s.state.FactoryConfigs, false)
+	})
+	s.handle(mux, "PATCH /ota/factories/{factory}/config/{$}", func(w http.ResponseWriter, r *http.Request) {
+		s.state.FactoryConfigs = s.createConfig(w, r, s.state.FactoryConfigs, true)
+	})
+	s.handle(mux, "DELETE /ota/factories/{factory}/config/{file}/{$}", func(w http.ResponseWriter, r *http.Request) {
+		s.state.FactoryConfigs = s.deleteConfig(w, r, s.state.FactoryConfigs)
+	})
+
+	s.handle(mux, "GET /ota/factories/{factory}/device-groups/{$}", func(w http.ResponseWriter, r *http.Request) {
+		groups := s.state.Groups
+		if groups == nil {
+			groups = []client.DeviceGroup{}
+		}
+		writeJSON(w, http.StatusOK, map[string][]client.DeviceGroup{"groups": groups})
+	})
+	s.handle(mux, "POST /ota/factories/{factory}/device-groups/{$}", s.createGroup)
+	s.handle(mux, "PATCH /ota/factories/{factory}/device-groups/{group}/{$}", s.withGroup(s.patchGroup))
+	s.handle(mux, "DELETE /ota/factories/{factory}/device-groups/{group}/{$}", s.withGroup(s.deleteGroup))
+
+	s.handle(mux, "GET /ota/factories/{factory}/device-groups/{group}/config/{$}", s.withGroup(func(w http.ResponseWriter, r *http.Request, g *client.DeviceGroup) {
+		s.listConfig(w, r, s.state.GroupConfigs[g.Name])
+	}))
+	s.handle(mux, "POST /ota/factories/{factory}/device-groups/{group}/config/{$}", s.withGroup(func(w http.ResponseWriter, r *http.Request, g *client.DeviceGroup) {
+		s.state.GroupConfigs[g.Name] = s.createConfig(w, r, s.state.GroupConfigs[g.Name], false)
+	}))
+	s.handle(mux, "PATCH /ota/factories/{factory}/device-groups/{group}/config/{$}", s.withGroup(func(w http.ResponseWriter, r *http.Request, g *client.DeviceGroup) {
+		s.state.GroupConfigs[g.Name] = s.createConfig(w, r, s.state.GroupConfigs[g.Name], true)
+	}))
+	s.handle(mux, "DELETE /ota/factories/{factory}/device-groups/{group}/config/{file}/{$}", s.withGroup(func(w http.ResponseWriter, r *http.Request, g *client.DeviceGroup) {
+		s.state.GroupConfigs[g.Name] = s.deleteConfig(w, r, s.state.GroupConfigs[g.Name])
+	}))
+
+	s.handle(mux, "GET /ota/factories/{factory}/users/{$}", func(w http.ResponseWriter, r *http.Request) {
+		users := s.state.Users
+		if users == nil {
+			users = []client.FactoryUser{}
+		}
+		writeJSON(w, http.StatusOK, users)
+	})
+	s.handle(mux, "GET /ota/factories/{factory}/users/{user}/{$}", func(w http.ResponseWriter, r *http.Request) {
+		for _, u := range s.state.Users {
+			if u.PolisId == r.PathValue("user") {
+				writeJSON(w, http.StatusOK, client.FactoryUserAccessDetails{PolisId: u.PolisId, Name: u.Name, Role: u.Role})
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "User not found")
+	})
+
+	s.handle(mux, "GET /ota/factories/{factory}/certs/{$}", func(w http.ResponseWriter, r *http.Request) {
+		if len(s.state.Certs.RootCrt) == 0 {
+			// This is how the API reports that the Factory PKI is not configured yet
+			writeJSON(w, http.StatusPartialContent, s.state.Certs)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.state.Certs)
+	})
+	s.handle(mux, "POST /ota/factories/{factory}/certs/{$}", func(w http.ResponseWriter, r *http.Request) {
+		var opts client.CaCreateOptions
+		if err := decodeBody(r, &opts); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if opts.FirstTimeInit && len(s.state.Certs.RootCrt) > 0 {
+			writeError(w, http.StatusConflict, "Factory PKI is already configured")
+			return
+		}
+		// A fake server cannot produce meaningful CSRs; tests seeding certs directly is more useful.
+		writeJSON(w, http.StatusCreated, client.CaCsrs{})
+	})
+	s.handle(mux, "PATCH /ota/factories/{factory}/certs/{$}", func(w http.ResponseWriter, r *http.Request) {
+		var certs client.CaCerts
+		if err := decodeBody(r, &certs); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		c := &s.state.Certs
+		for _, field := range []struct {
+			dst *string
+			src string
+		}{
+			{&c.RootCrt, certs.RootCrt},
+			{&c.CaCrt, certs.CaCrt},
+			{&c.EstCrt, certs.EstCrt},
+			{&c.TlsCrt, certs.TlsCrt},
+			{&c.CaRevokeCrl, certs.CaRevokeCrl},
+		} {
+			if len(field.src) > 0 {
+				*field.dst = field.src
+			}
+		}
+		c.ChangeMeta.UpdatedAt = now()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.handle(mux, "GET /ota/factories/{factory}/event-queues/{$}", func(w http.ResponseWriter, r *http.Request) {
+		queues := s.state.EventQueues
+		if queues == nil {
+			queues = []client.EventQueue{}
+		}
+		writeJSON(w, http.StatusOK, queues)
+	})
+	s.handle(mux, "POST /ota/factories/{factory}/event-queues/{$}", func(w http.ResponseWriter, r *http.Request) {
+		var q client.EventQueue
+		if err := decodeBody(r, &q); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.state.EventQueues = append(s.state.EventQueues, q)
+		writeJSON(w, http.StatusCreated, map[string]string{"label": q.Label})
+	})
+	s.handle(mux, "DELETE /ota/factories/{factory}/event-queues/{label}/{$}", func(w http.ResponseWriter, r *http.Request) {
+		for idx, q := range s.state.EventQueues {
+			if q.Label == r.PathValue("label") {
+				s.state.EventQueues = append(s.state.EventQueues[:idx], s.state.EventQueues[idx+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "Event queue not found")
+	})
+
+	s.handle(mux, "GET /projects/{factory}/lmp/triggers/{$}", func(w http.ResponseWriter, r *http.Request) {
+		triggers := []client.ProjectTrigger{}
+		for _, t := range s.state.Triggers {
+			secrets := []client.ProjectSecret{}
+			for _, secret := range t.Secrets {
+				secrets = append(secrets, client.ProjectSecret{Name: secret.Name})
+			}
+			t.Secrets = secrets
+			triggers = append(triggers, t)
+		}
+		writeJSON(w, http.StatusOK, map[string][]client.ProjectTrigger{"data": triggers})
+	})
+	s.handle(mux, "POST /projects/{factory}/lmp/triggers/{$}", func(w http.ResponseWriter, r *http.Request) {
+		var t client.ProjectTrigger
+		if err := decodeBody(r, &t); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		t.Id = len(s.state.Triggers) + 1
+		t.Secrets = mergeSecrets(nil, t.Secrets)
+		s.state.Triggers = append(s.state.Triggers, t)
+		writeJSON(w, http.StatusCreated, t)
+	})
+	s.handle(mux, "PATCH /projects/{factory}/lmp/triggers/{trigger}/{$}", func(w http.ResponseWriter, r *http.Request) {
+		var t client.ProjectTrigger
+		if err := decodeBody(r, &t); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		for idx := range s.state.Triggers {
+			if strconv.Itoa(s.state.Triggers[idx].Id) == r.PathValue("trigger") {
+				s.state.Triggers[idx].Secrets = mergeSecrets(s.state.Triggers[idx].Secrets, t.Secrets)
+				writeJSON(w, http.StatusOK, s.state.Triggers[idx])
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "Trigger not found")
+	})
+}
+
+// mergeSecrets sets the updated secrets, and deletes those updated without a value.
+func mergeSecrets(current, updates []client.ProjectSecret) []client.ProjectSecret {
+	merged := append([]client.ProjectSecret(nil), current...)
+	for _, u := range updates {
+		idx := slices.IndexFunc(merged, func(s client.ProjectSecret) bool { return s.Name == u.Name })
+		switch {
+		case u.Value == nil && idx >= 0:
+			merged = slices.Delete(merged, idx, idx+1)
+		case u.Value != nil && idx >= 0:
+			merged[idx] = u
+		case u.Value != nil:
+			merged = append(merged, u)
+		}
+	}
+	return merged
+}
+
+func (s *Server) findGroup(name string) int {
+	for idx, g := range s.state.Groups {
+		if g.Name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (s *Server) withGroup(fn func(http.ResponseWriter, *http.Request, *client.DeviceGroup)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idx := s.findGroup(r.PathValue("group"))
+		if idx < 0 {
+			writeError(w, http.StatusNotFound, "Device group not found")
+			return
+		}
+		fn(w, r, &s.state.Groups[idx])
+	}
+}
+
+func (s *Server) createGroup(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.findGroup(body["name"]) >= 0 {
+		writeError(w, http.StatusConflict, "A device group with this name already exists")
+		return
+	}
+	id := 1
+	for _, g := range s.state.Groups {
+		if g.Id >= id {
+			id = g.Id + 1
+		}
+	}
+	g := client.DeviceGroup{
+		Id:          id,
+		Name:        body["name"],
+		Description: body["description"],
+		ChangeMeta:  client.ChangeMeta{CreatedAt: now()},
+	}
+	s.state.Groups = append(s.state.Groups, g)
+	writeJSON(w, http.StatusCreated, g)
+}
+
+func (s *Server) patchGroup(w http.ResponseWriter, r *http.Request, g *client.DeviceGroup) {
+	var body map[string]string
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if name, ok := body["name"]; ok && name != g.Name {
+		if s.findGroup(name) >= 0 {
+			writeError(w, http.StatusConflict, "A device group with this name already exists")
+			return
+		}
+		for idx := range s.state.Devices {
+			if s.state.Devices[idx].GroupName == g.Name {
+				s.state.Devices[idx].GroupName = name
+			}
+		}
+		if cfgs, ok := s.state.GroupConfigs[g.Name]; ok {
+			s.state.GroupConfigs[name] = cfgs
+			delete(s.state.GroupConfigs, g.Name)
+		}
+		g.Name = name
+	}
+	if desc, ok := body["description"]; ok {
+		g.Description = desc
+	}
+	g.ChangeMeta.UpdatedAt = now()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteGroup(w http.ResponseWriter, r *http.Request, g *client.DeviceGroup) {
+	for _, d := range s.state.Devices {
+		if d.GroupName == g.Name {
+			writeError(w, http.StatusConflict, "There are devices assigned to this device group")
+			return
+		}
+	}
+	delete(s.state.GroupConfigs, g.Name)
+	idx := s.findGroup(g.Name)
+	s.state.Groups = append(s.state.Groups[:idx], s.state.Groups[idx+1:]...)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,291 @@
+// Package fakeapi provides an in-process emulation of the Foundries.io REST API endpoints used by
+// fioctl. It is meant for tests: state is kept in memory, can be seeded with fixtures, and is
+// inspected after running commands against the server.
+package fakeapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	tuf "github.com/theupdateframework/notary/tuf/data"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+// State is the in-memory content of a fake Factory.
+// Tests may seed it before running commands, and examine it afterwards via Server.State.
+type State struct {
+	Devices        []client.Device
+	DeviceConfigs  map[string][]client.DeviceConfig // By device UUID, most recent first
+	DeviceUpdates  map[string][]client.Update       // By device UUID, most recent first
+	UpdateEvents   map[string][]client.UpdateEvent  // By update correlation ID
+	AppsStates     map[string]client.AppsStates     // By device UUID
+	Groups         []client.DeviceGroup
+	GroupConfigs   map[string][]client.DeviceConfig // By group name, most recent first
+	FactoryConfigs []client.DeviceConfig            // Most recent first
+	Users          []client.FactoryUser
+	Status         client.FactoryStatus
+
+	Targets     tuf.Files
+	ProdTargets map[string]client.AtsTufTargets // By tag
+	Waves       []client.Wave
+	WaveTargets map[string]client.AtsTufTargets    // By wave name
+	ComposeApps map[string]client.ComposeAppBundle // By "<target>/<app>"
+
+	Root             client.AtsTufRoot
+	ProdRoot         client.AtsTufRoot
+	RootVersions     map[int]client.AtsTufRoot
+	RootUpdates      client.TufRootUpdates
+	TargetsOnlineKey client.AtsKey
+
+	Certs       client.CaCerts
+	EventQueues []client.EventQueue
+	Triggers    []client.ProjectTrigger // Secret values are kept, but never returned
+
+	// Jobserv console logs by "<build>/<run>/<artifact>".
+	// Target updates (e.g. a prune) add a log under "<build>/UpdateTargets/console.log".
+	Consoles  map[string]string
+	LastBuild int
+}
+
+// Request is a record of a request received by the fake server.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Header http.Header
+	Body   []byte
+}
+
+type Server struct {
+	*httptest.Server
+	Factory string
+
+	mu       sync.Mutex
+	state    State
+	requests []Request
+}
+
+// New starts a fake API server for a given factory with an initial state.
+// Nil maps in the state are initialized, so a zero State is a valid empty Factory.
+func New(factory string, state State) *Server {
+	s := &Server{Factory: factory, state: state}
+	s.state.init()
+	// The handlers read s.URL, so the server is only started once it is set
+	s.Server = httptest.NewUnstartedServer(s.handler())
+	s.Start()
+	return s
+}
+
+func (st *State) init() {
+	if st.DeviceConfigs == nil {
+		st.DeviceConfigs = make(map[string][]client.DeviceConfig)
+	}
+	if st.DeviceUpdates == nil {
+		st.DeviceUpdates = make(map[string][]client.Update)
+	}
+	if st.UpdateEvents == nil {
+		st.UpdateEvents = make(map[string][]client.UpdateEvent)
+	}
+	if st.AppsStates == nil {
+		st.AppsStates = make(map[string]client.AppsStates)
+	}
+	if st.GroupConfigs == nil {
+		st.GroupConfigs = make(map[string][]client.DeviceConfig)
+	}
+	if st.Targets == nil {
+		st.Targets = make(tuf.Files)
+	}
+	if st.ProdTargets == nil {
+		st.ProdTargets = make(map[string]client.AtsTufTargets)
+	}
+	if st.WaveTargets == nil {
+		st.WaveTargets = make(map[string]client.AtsTufTargets)
+	}
+	if st.RootVersions == nil {
+		st.RootVersions = make(map[int]client.AtsTufRoot)
+	}
+	if len(st.RootUpdates.Status) == 0 {
+		st.RootUpdates.Status = client.TufRootUpdatesStatusNone
+	}
+	if st.Consoles == nil {
+		st.Consoles = make(map[string]string)
+	}
+}
+
+// State returns a deep copy of the current state, which a caller may keep while the server runs.
+// Use Update to modify the state of the server.
+func (s *Server) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return deepCopy(reflect.ValueOf(s.state)).Interface().(State)
+}
+
+// deepCopy copies a value along with the maps, slices and pointers it holds. Unexported fields,
+// like those of time.Time, are copied as they are.
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Elem().Type())
+		c.Elem().Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem()))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+		return c
+	}
+	return v
+}
+
+// Update safely modifies the server state.
+func (s *Server) Update(fn func(*State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.state)
+}
+
+// Requests returns all requests received by the server so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	s.addDeviceRoutes(mux)
+	s.addFactoryRoutes(mux)
+	s.addTargetRoutes(mux)
+	s.addTufRoutes(mux)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// All handlers work with the state under the lock, which makes them simple and safe.
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, Request{r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Clone(), body})
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// handle registers a handler which only serves requests for the server's factory.
+// A factory is either a part of the URL path or passed as a query parameter.
+func (s *Server) handle(mux *http.ServeMux, pattern string, fn http.HandlerFunc) {
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		factory := r.PathValue("factory")
+		if len(factory) == 0 {
+			factory = r.URL.Query().Get("factory")
+		}
+		if len(factory) > 0 && factory != s.Factory {
+			writeError(w, http.StatusNotFound, "Factory not found")
+			return
+		}
+		fn(w, r)
+	})
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError mimics the error format of the real API, which fioctl parses into a message.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"message": msg})
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+func queryInt(r *http.Request, name string, def int) int {
+	if val, err := strconv.Atoi(r.URL.Query().Get(name)); err == nil && val > 0 {
+		return val
+	}
+	return def
+}
+
+// paginate returns a page of items along with the URL of the next page, if any.
+func paginate[T any](r *http.Request, serverUrl string, items []T, defLimit int) ([]T, *string) {
+	limit := queryInt(r, "limit", defLimit)
+	page := queryInt(r, "page", 1)
+	start := (page - 1) * limit
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + limit
+	var next *string
+	if end < len(items) {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(page+1))
+		nextUrl := serverUrl + r.URL.Path + "?" + q.Encode()
+		next = &nextUrl
+	} else {
+		end = len(items)
+	}
+	return items[start:end], next
+}
+
+// jobservResponse registers a console log for a new CI build and returns the response fioctl
+// expects when it triggers a CI job.
+func (s *Server) jobservResponse(runName, console string) map[string]string {
+	s.state.LastBuild += 1
+	build := s.state.LastBuild
+	s.state.Consoles[fmt.Sprintf("%d/%s/console.log", build, runName)] = console
+	return map[string]string{
+		"jobserv-url": fmt.Sprintf("%s/projects/%s/lmp/builds/%d/", s.URL, s.Factory, build),
+		"web-url":     fmt.Sprintf("%s/%s/targets/%d/", s.URL, s.Factory, build),
+	}
+}
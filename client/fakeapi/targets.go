@@ -0,0 +1,319 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	canonical "github.com/docker/go/canonical/json"
+	tuf "github.com/theupdateframework/notary/tuf/data"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func (s *Server) addTargetRoutes(mux *http.ServeMux) {
+	s.handle(mux, "GET /ota/factories/{factory}/targets/{$}", s.listTargets)
+	s.handle(mux, "PATCH /ota/factories/{factory}/targets/{$}", s.tagTargets)
+	s.handle(mux, "DELETE /ota/factories/{factory}/targets/{$}", s.deleteTargets)
+	s.handle(mux, "GET /ota/factories/{factory}/targets/{target}", func(w http.ResponseWriter, r *http.Request) {
+		target, ok := s.state.Targets[r.PathValue("target")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Target not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, target)
+	})
+	s.handle(mux, "GET /ota/factories/{factory}/targets/{target}/compose-apps/{app}/{$}", func(w http.ResponseWriter, r *http.Request) {
+		bundle, ok := s.state.ComposeApps[r.PathValue("target")+"/"+r.PathValue("app")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "App not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, bundle)
+	})
+	s.handle(mux, "GET /ota/factories/{factory}/prod-targets/{$}", func(w http.ResponseWriter, r *http.Request) {
+		s.listSignedTargets(w, r.URL.Query().Get("tag"), s.state.ProdTargets)
+	})
+	s.handle(mux, "GET /ota/factories/{factory}/wave-targets/{$}", func(w http.ResponseWriter, r *http.Request) {
+		s.listSignedTargets(w, r.URL.Query().Get("name"), s.state.WaveTargets)
+	})
+
+	s.handle(mux, "GET /projects/{factory}/lmp/builds/{build}/runs/{run}/{artifact...}", func(w http.ResponseWriter, r *http.Request) {
+		key := fmt.Sprintf("%s/%s/%s", r.PathValue("build"), r.PathValue("run"), r.PathValue("artifact"))
+		console, ok := s.state.Consoles[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Artifact not found")
+			return
+		}
+		// No X-RUN-STATUS header means a run is complete, so a tail ends after printing this
+		_, _ = w.Write([]byte(console))
+	})
+
+	s.handle(mux, "GET /ota/factories/{factory}/waves/{$}", s.listWaves)
+	s.handle(mux, "POST /ota/factories/{factory}/waves/{$}", s.createWave)
+	s.handle(mux, "GET /ota/factories/{factory}/waves/{wave}/{$}", s.withWave(func(w http.ResponseWriter, r *http.Request, wave *client.Wave) {
+		writeJSON(w, http.StatusOK, wave)
+	}))
+	s.handle(mux, "POST /ota/factories/{factory}/waves/{wave}/sign/{$}", s.withWave(func(w http.ResponseWriter, r *http.Request, wave *client.Wave) {
+		var body map[string][]tuf.Signature
+		if err := decodeBody(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		signed := s.state.WaveTargets[wave.Name]
+		signed.Signatures = append(signed.Signatures, body["signatures"]...)
+		s.state.WaveTargets[wave.Name] = signed
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	s.handle(mux, "POST /ota/factories/{factory}/waves/{wave}/rollout/{$}", s.withWave(s.rolloutWave))
+	s.handle(mux, "POST /ota/factories/{factory}/waves/{wave}/cancel/{$}", s.withWave(func(w http.ResponseWriter, r *http.Request, wave *client.Wave) {
+		s.finishWave(w, wave, "canceled")
+	}))
+	s.handle(mux, "POST /ota/factories/{factory}/waves/{wave}/complete/{$}", s.withWave(func(w http.ResponseWriter, r *http.Request, wave *client.Wave) {
+		if s.finishWave(w, wave, "complete") {
+			s.state.ProdTargets[wave.Tag] = s.state.WaveTargets[wave.Name]
+		}
+	}))
+	s.handle(mux, "GET /ota/factories/{factory}/waves/{wave}/status/{$}", s.withWave(func(w http.ResponseWriter, r *http.Request, wave *client.Wave) {
+		status := client.WaveStatus{
+			Name:      wave.Name,
+			Tag:       wave.Tag,
+			Status:    wave.Status,
+			CreatedAt: wave.ChangeMeta.CreatedAt,
+		}
+		fmt.Sscanf(wave.Version, "%d", &status.Version)
+		for _, d := range s.state.Devices {
+			if d.Tag == wave.Tag && d.IsProd {
+				status.TotalDevices += 1
+			}
+		}
+		writeJSON(w, http.StatusOK, status)
+	}))
+}
+
+func (s *Server) listTargets(w http.ResponseWriter, r *http.Request) {
+	version := r.URL.Query().Get("version")
+	targets := make(tuf.Files)
+	for name, file := range s.state.Targets {
+		if len(version) > 0 {
+			var custom client.TufCustom
+			if file.Custom != nil {
+				_ = json.Unmarshal(*file.Custom, &custom)
+			}
+			if custom.Version != version {
+				continue
+			}
+		}
+		targets[name] = file
+	}
+	writeJSON(w, http.StatusOK, targets)
+}
+
+func (s *Server) tagTargets(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Targets client.UpdateTargets `json:"targets"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var names []string
+	for name, update := range body.Targets {
+		file, ok := s.state.Targets[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Target not found: "+name)
+			return
+		}
+		custom := map[string]interface{}{}
+		if file.Custom != nil {
+			_ = json.Unmarshal(*file.Custom, &custom)
+		}
+		custom["tags"] = update.Custom.Tags
+		raw, _ := json.Marshal(custom)
+		msg := canonical.RawMessage(raw)
+		file.Custom = &msg
+		s.state.Targets[name] = file
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, s.jobservResponse("UpdateTargets", "Tagged Targets: "+strings.Join(names, ", ")+"\n"))
+}
+
+func (s *Server) deleteTargets(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Targets []string `json:"targets"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, name := range body.Targets {
+		if _, ok := s.state.Targets[name]; !ok {
+			writeError(w, http.StatusNotFound, "Target not found: "+name)
+			return
+		}
+	}
+	for _, name := range body.Targets {
+		delete(s.state.Targets, name)
+	}
+	writeJSON(w, http.StatusOK, s.jobservResponse("UpdateTargets", "Pruned Targets: "+strings.Join(body.Targets, ", ")+"\n"))
+}
+
+// listSignedTargets serves production or wave targets by a comma separated list of keys (tags or names).
+func (s *Server) listSignedTargets(w http.ResponseWriter, keys string, source map[string]client.AtsTufTargets) {
+	resp := make(map[string]client.AtsTufTargets)
+	for _, key := range strings.Split(keys, ",") {
+		targets, ok := source[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "No production Targets found for "+key)
+			return
+		}
+		resp[key] = targets
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) findWave(name string) int {
+	for idx, wave := range s.state.Waves {
+		if wave.Name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (s *Server) withWave(fn func(http.ResponseWriter, *http.Request, *client.Wave)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idx := s.findWave(r.PathValue("wave"))
+		if idx < 0 {
+			writeError(w, http.StatusNotFound, "Wave not found")
+			return
+		}
+		fn(w, r, &s.state.Waves[idx])
+	}
+}
+
+func (s *Server) listWaves(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	waves := []client.Wave{}
+	// Most recent first, like the real API
+	for idx := len(s.state.Waves) - 1; idx >= 0; idx-- {
+		wave := s.state.Waves[idx]
+		if status := q.Get("status"); len(status) > 0 && status != wave.Status {
+			continue
+		}
+		if tag := q.Get("tag"); len(tag) > 0 && tag != wave.Tag {
+			continue
+		}
+		wave.Targets = nil
+		waves = append(waves, wave)
+	}
+	page, next := paginate(r, s.URL, waves, 20)
+	writeJSON(w, http.StatusOK, client.WaveList{Waves: page, Total: len(waves), Next: next})
+}
+
+func (s *Server) createWave(w http.ResponseWriter, r *http.Request) {
+	var req client.WaveCreate
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if s.findWave(req.Name) >= 0 {
+		writeError(w, http.StatusConflict, "A wave with this name already exists")
+		return
+	}
+	for _, wave := range s.state.Waves {
+		if wave.Tag == req.Tag && wave.Status == "active" {
+			writeError(w, http.StatusConflict, "There is an active wave for this tag")
+			return
+		}
+	}
+	raw, err := json.Marshal(req.Targets)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var signed client.AtsTufTargets
+	if err := json.Unmarshal(raw, &signed); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	targets := json.RawMessage(raw)
+	s.state.Waves = append(s.state.Waves, client.Wave{
+		Name:       req.Name,
+		Version:    req.Version,
+		Tag:        req.Tag,
+		Targets:    &targets,
+		Status:     "active",
+		ChangeMeta: client.ChangeMeta{CreatedAt: now()},
+	})
+	s.state.WaveTargets[req.Name] = signed
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) rolloutWave(w http.ResponseWriter, r *http.Request, wave *client.Wave) {
+	var opts client.WaveRolloutOptions
+	if err := decodeBody(r, &opts); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if wave.Status != "active" {
+		writeError(w, http.StatusConflict, "Wave is not active")
+		return
+	}
+	res := client.WaveRolloutResult{Wave: *wave}
+	for _, d := range s.state.Devices {
+		if !d.IsProd || d.Tag != wave.Tag {
+			continue
+		}
+		if len(opts.Group) > 0 && d.GroupName != opts.Group {
+			continue
+		}
+		if len(opts.Uuids) > 0 && !contains(opts.Uuids, d.Uuid) {
+			continue
+		}
+		if opts.Limit > 0 && res.DeviceNum >= opts.Limit {
+			break
+		}
+		res.DeviceNum += 1
+		if opts.PrintUuids {
+			res.DeviceUuids = append(res.DeviceUuids, d.Uuid)
+		}
+		if opts.PrintNames {
+			res.DeviceNames = append(res.DeviceNames, d.Name)
+		}
+	}
+	if !opts.DryRun {
+		wave.History = append(wave.History, client.RolloutHistory{
+			GroupName:    opts.Group,
+			RolloutAt:    time.Now().UTC().Format(time.RFC3339),
+			IsFullGroup:  len(opts.Group) > 0 && len(opts.Uuids) == 0 && opts.Limit == 0,
+			DeviceNumber: res.DeviceNum,
+		})
+		res.Wave = *wave
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) finishWave(w http.ResponseWriter, wave *client.Wave, status string) bool {
+	if wave.Status != "active" {
+		writeError(w, http.StatusConflict, "Wave is not active")
+		return false
+	}
+	wave.Status = status
+	wave.ChangeMeta.UpdatedAt = now()
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func contains(list []string, item string) bool {
+	for _, val := range list {
+		if val == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func (s *Server) addTufRoutes(mux *http.ServeMux) {
+	s.handle(mux, "GET /ota/factories/{factory}/ci-targets.pub", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.state.TargetsOnlineKey)
+	})
+
+	s.handle(mux, "GET /ota/repo/{factory}/api/v1/user_repo/{file}", s.getTufMetadata)
+	s.handle(mux, "POST /ota/repo/{factory}/api/v1/user_repo/root", func(w http.ResponseWriter, r *http.Request) {
+		var root client.AtsTufRoot
+		if err := decodeBody(r, &root); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if r.URL.Query().Get("production") == "1" {
+			s.state.ProdRoot = root
+		} else {
+			s.state.Root = root
+			s.state.RootVersions[root.Signed.Version] = root
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	s.handle(mux, "GET /ota/repo/{factory}/api/v1/user_repo/root/updates", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.state.RootUpdates)
+	})
+	s.handle(mux, "POST /ota/repo/{factory}/api/v1/user_repo/root/updates", func(w http.ResponseWriter, r *http.Request) {
+		if s.state.RootUpdates.Status != client.TufRootUpdatesStatusNone {
+			writeError(w, http.StatusConflict, "A TUF root updates transaction is already in progress")
+			return
+		}
+		var body struct {
+			Message   string `json:"message"`
+			FirstTime bool   `json:"first-time"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.state.LastBuild += 1
+		s.state.RootUpdates = client.TufRootUpdates{
+			Status:     client.TufRootUpdatesStatusStarted,
+			FirstTime:  body.FirstTime,
+			ChangeMeta: &client.ChangeMeta{CreatedAt: now()},
+		}
+		writeJSON(w, http.StatusCreated, client.TufRootUpdatesInit{TransactionId: "tx-" + strconv.Itoa(s.state.LastBuild)})
+	})
+	s.handle(mux, "PUT /ota/repo/{factory}/api/v1/user_repo/root/updates", s.withRootUpdates(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			CiRoot   *client.AtsTufRoot `json:"ci-root"`
+			ProdRoot *client.AtsTufRoot `json:"prod-root"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		updated := client.TufRootPair{}
+		if body.CiRoot != nil {
+			raw, _ := json.Marshal(body.CiRoot)
+			updated.CiRoot = string(raw)
+		}
+		if body.ProdRoot != nil {
+			raw, _ := json.Marshal(body.ProdRoot)
+			updated.ProdRoot = string(raw)
+		}
+		s.state.RootUpdates.Updated = &updated
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	s.handle(mux, "POST /ota/repo/{factory}/api/v1/user_repo/root/updates/apply", s.withRootUpdates(func(w http.ResponseWriter, r *http.Request) {
+		if updated := s.state.RootUpdates.Updated; updated != nil {
+			for _, pair := range []struct {
+				raw  string
+				prod bool
+			}{{updated.CiRoot, false}, {updated.ProdRoot, true}} {
+				if len(pair.raw) == 0 {
+					continue
+				}
+				var root client.AtsTufRoot
+				if err := json.Unmarshal([]byte(pair.raw), &root); err != nil {
+					writeError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				if pair.prod {
+					s.state.ProdRoot = root
+				} else {
+					s.state.Root = root
+					s.state.RootVersions[root.Signed.Version] = root
+				}
+			}
+		}
+		s.state.RootUpdates = client.TufRootUpdates{Status: client.TufRootUpdatesStatusNone}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	s.handle(mux, "POST /ota/repo/{factory}/api/v1/user_repo/root/updates/cancel", s.withRootUpdates(func(w http.ResponseWriter, r *http.Request) {
+		s.state.RootUpdates = client.TufRootUpdates{Status: client.TufRootUpdatesStatusNone}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	s.handle(mux, "POST /ota/repo/{factory}/api/v1/user_repo/root/updates/gen-online-keys", s.withRootUpdates(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func (s *Server) withRootUpdates(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.state.RootUpdates.Status == client.TufRootUpdatesStatusNone {
+			writeError(w, http.StatusConflict, "There is no TUF root updates transaction in progress")
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func (s *Server) getTufMetadata(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	switch {
+	case file == "root.json":
+		if r.URL.Query().Get("production") == "1" {
+			writeJSON(w, http.StatusOK, s.state.ProdRoot)
+		} else {
+			writeJSON(w, http.StatusOK, s.state.Root)
+		}
+	case strings.HasSuffix(file, ".root.json"):
+		version, err := strconv.Atoi(strings.TrimSuffix(file, ".root.json"))
+		if root, ok := s.state.RootVersions[version]; err == nil && ok {
+			writeJSON(w, http.StatusOK, root)
+		} else {
+			writeError(w, http.StatusNotFound, "Root version not found")
+		}
+	case file == "targets.json":
+		writeJSON(w, http.StatusOK, client.AtsTufTargets{
+			Signed: client.AtsTargetsMeta{Targets: s.state.Targets},
+		})
+	default:
+		writeError(w, http.StatusNotFound, "Metadata not found")
+	}
+}
@@ -2,11 +2,9 @@ package client
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -26,12 +24,14 @@ type Config struct {
 	ClientCredentials  OAuthConfig
 	ExtraHeaders       map[string]string
 	InsecureSkipVerify bool
+	Retries            RetryConfig
+	Transport          TransportConfig
 }
 
 type Api struct {
 	serverUrl string
 	config    Config
-	client    http.Client
+	client    *http.Client
 	clientVer string
 }
 
@@ -305,62 +305,23 @@ type WireGuardIp struct {
 	Enabled bool   `json:"enabled"`
 }
 
-// This is an error returned in case if we've successfully received an HTTP response which contains
-// an unexpected HTTP status code
-type HttpError struct {
-	Message  string
-	Response *http.Response
-}
-
-func (err *HttpError) Error() string {
-	return err.Message
-}
-
-// This is much better than err.(HttpError) as it also accounts for wrapped errors.
-func AsHttpError(err error) *HttpError {
-	var httpError *HttpError
-	if errors.As(err, &httpError) {
-		return httpError
-	} else {
-		return nil
+// NewApiClient creates an API client with a private transport configured by config.Transport.
+// Library users may prefer NewApiClientWithOptions, which returns errors instead of exiting.
+func NewApiClient(serverUrl string, config Config, caCertPath string, version string) *Api {
+	api, err := NewApiClientWithOptions(serverUrl, WithConfig(config), WithCACert(caCertPath), WithVersion(version))
+	if err != nil {
+		logrus.Fatal(err)
 	}
+	return api
 }
 
-func NewApiClient(serverUrl string, config Config, caCertPath string, version string) *Api {
-	tlsCfg := &tls.Config{
-		InsecureSkipVerify: config.InsecureSkipVerify,
-	}
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = tlsCfg
-	// targets/artifacts.go needs to know the Content-Length in order to
-	// compute the download progress. If certain services like CloudFlare
-	// see the client accepts compressed responsed (content-encoding not
-	// content-type) then it will give a compressed response. Golang will
-	// automagically decompress as you read the response *and* set
-	// content-length to -1 thereby breaking our download progress logic
-	http.DefaultTransport.(*http.Transport).DisableCompression = true
-	if len(caCertPath) > 0 {
-		rootCAs, _ := x509.SystemCertPool()
-		if rootCAs == nil {
-			rootCAs = x509.NewCertPool()
-		}
-
-		certs, err := os.ReadFile(caCertPath)
-		if err != nil {
-			logrus.Fatalf("Failed to append %q to RootCAs: %v", caCertPath, err)
-		}
-
-		if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
-			logrus.Warning("No certs appended, using system certs only")
-		}
-		tlsCfg.RootCAs = rootCAs
-	}
-	api := Api{
+func newApi(serverUrl string, config Config, client *http.Client, version string) *Api {
+	return &Api{
 		serverUrl: strings.TrimRight(serverUrl, "/"),
 		config:    config,
-		client:    *http.DefaultClient,
+		client:    client,
 		clientVer: version,
 	}
-	return &api
 }
 
 func httpLogger(req *http.Request) logrus.FieldLogger {
@@ -389,7 +350,7 @@ func readResponse(res *http.Response) (*[]byte, error) {
 	case 204:
 		break
 	default:
-		var PRINT_LIMIT, DEBUG_LIMIT int = 512, 8196
+		const DEBUG_LIMIT = 8196
 		errBody := (string)(body)
 		if len(body) > DEBUG_LIMIT {
 			// too much is too much, even for a debug message
@@ -399,62 +360,7 @@ func readResponse(res *http.Response) (*[]byte, error) {
 		log.Debugf(errBody)
 
 		// Still return a body, a caller might need it, but also return an error
-		msg := fmt.Sprintf("HTTP error during %s '%s': %s",
-			res.Request.Method, res.Request.URL.String(), res.Status)
-
-		// Some APIs return well-formatted errors, try to use them
-		var (
-			useGenericError bool
-			listErrors      struct {
-				Msg     string   `json:"msg,omitempty"`
-				Message string   `json:"message,omitempty"`
-				Errors  []string `json:"errors,omitempty"`
-			}
-			dictErrors struct {
-				Msg     string            `json:"msg,omitempty"`
-				Message string            `json:"message,omitempty"`
-				Errors  map[string]string `json:"errors,omitempty"`
-			}
-		)
-
-		if merr := json.Unmarshal(body, &listErrors); merr == nil {
-			if listErrors.Msg != "" {
-				msg += "\n= " + listErrors.Msg
-			} else if listErrors.Message != "" {
-				msg += "\n= " + listErrors.Message
-			} else {
-				useGenericError = true
-			}
-			if !useGenericError && listErrors.Errors != nil {
-				for _, emsg := range listErrors.Errors {
-					msg += "\n * " + emsg
-				}
-			}
-		} else if merr = json.Unmarshal(body, &dictErrors); merr == nil {
-			if dictErrors.Msg != "" {
-				msg += "\n= " + dictErrors.Msg
-			} else if dictErrors.Message != "" {
-				msg += "\n= " + dictErrors.Message
-			} else {
-				useGenericError = true
-			}
-			if !useGenericError && dictErrors.Errors != nil {
-				for field, emsg := range dictErrors.Errors {
-					msg += fmt.Sprintf("\n * %s: %s", field, emsg)
-				}
-			}
-		}
-		if useGenericError {
-			logrus.Debugf("Failed to parse error data... return original error")
-			if len(body) < PRINT_LIMIT {
-				// return an error response body up to a meaningful limit - if it spans beyond a few
-				// lines, need to find a more appropriate message.
-				msg = fmt.Sprintf("%s\n= %s", msg, body)
-			} else {
-				msg += "\n= Error body too long, try to use the --verbose option"
-			}
-		}
-		err = &HttpError{msg, res}
+		err = newHttpError(res, body)
 	}
 	return &body, err
 }
@@ -506,39 +412,93 @@ func (a *Api) GetOauthConfig() OAuthConfig {
 	return a.config.ClientCredentials
 }
 
-func (a *Api) rawMethod(method, url string, data []byte, headers *map[string]string) (*http.Response, error) {
-	var body io.Reader
-	if data != nil {
-		body = bytes.NewBuffer(data)
+// sleepCtx waits for a given duration, but returns early with an error if the context is done.
+func sleepCtx(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
+}
 
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, err
+func (a *Api) rawMethod(
+	ctx context.Context, method, url string, data []byte, headers *map[string]string,
+) (*http.Response, error) {
+	return a.rawMethodRetry(ctx, method, url, data, headers, isIdempotentMethod(method))
+}
+
+func (a *Api) rawMethodRetry(
+	ctx context.Context, method, url string, data []byte, headers *map[string]string, retryable bool,
+) (*http.Response, error) {
+	retries := a.config.Retries.withDefaults()
+	if !retryable {
+		retries.MaxAttempts = 1
 	}
+	for attempt := 1; ; attempt++ {
+		var body io.Reader
+		if data != nil {
+			// A body is consumed by each attempt, so it needs to be re-created every time
+			body = bytes.NewBuffer(data)
+		}
 
-	a.setReqHeaders(req, data != nil)
-	if headers != nil {
-		for key, val := range *headers {
-			req.Header.Set(key, val)
+		req, err := http.NewRequestWithContext(ctx, method, url, body)
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	res, err := a.client.Do(req)
-	if err != nil {
+		a.setReqHeaders(req, data != nil)
+		if headers != nil {
+			for key, val := range *headers {
+				req.Header.Set(key, val)
+			}
+		}
 
-		httpLogger(req).Debugf("Network Error: %s", err)
-		return nil, err
+		log := httpLogger(req)
+		res, err := a.client.Do(req)
+		if err != nil {
+			log.Debugf("Network Error: %s", err)
+			if attempt >= retries.MaxAttempts || ctx.Err() != nil {
+				return nil, err
+			}
+		} else if !isRetryableStatus(res.StatusCode) || attempt >= retries.MaxAttempts {
+			return res, nil
+		}
+
+		wait := retries.backoff(attempt)
+		if res != nil {
+			if after, ok := retries.retryAfter(res); ok {
+				wait = after
+			}
+			// Drain the body so that the connection can be reused
+			_, _ = io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			log.Debugf("Retrying in %s after HTTP %s (attempt %d of %d)", wait, res.Status, attempt, retries.MaxAttempts)
+		} else {
+			log.Debugf("Retrying in %s after network error (attempt %d of %d)", wait, attempt, retries.MaxAttempts)
+		}
+		if err := sleepCtx(ctx, wait); err != nil {
+			return nil, err
+		}
 	}
-	return res, err
 }
 
 func (a *Api) RawGet(url string, headers *map[string]string) (*http.Response, error) {
-	return a.rawMethod(http.MethodGet, url, nil, headers)
+	return a.RawGetCtx(context.Background(), url, headers)
+}
+
+func (a *Api) RawGetCtx(ctx context.Context, url string, headers *map[string]string) (*http.Response, error) {
+	return a.rawMethod(ctx, http.MethodGet, url, nil, headers)
 }
 
 func (a *Api) Get(url string) (*[]byte, error) {
-	if res, err := a.RawGet(url, nil); err != nil {
+	return a.GetCtx(context.Background(), url)
+}
+
+func (a *Api) GetCtx(ctx context.Context, url string) (*[]byte, error) {
+	if res, err := a.RawGetCtx(ctx, url, nil); err != nil {
 		return nil, err
 	} else {
 		return readResponse(res)
@@ -546,11 +506,19 @@ func (a *Api) Get(url string) (*[]byte, error) {
 }
 
 func (a *Api) RawPatch(url string, data []byte, headers *map[string]string) (*http.Response, error) {
-	return a.rawMethod(http.MethodPatch, url, data, headers)
+	return a.RawPatchCtx(context.Background(), url, data, headers)
+}
+
+func (a *Api) RawPatchCtx(ctx context.Context, url string, data []byte, headers *map[string]string) (*http.Response, error) {
+	return a.rawMethod(ctx, http.MethodPatch, url, data, headers)
 }
 
 func (a *Api) Patch(url string, data []byte) (*[]byte, error) {
-	if res, err := a.RawPatch(url, data, nil); err != nil {
+	return a.PatchCtx(context.Background(), url, data)
+}
+
+func (a *Api) PatchCtx(ctx context.Context, url string, data []byte) (*[]byte, error) {
+	if res, err := a.RawPatchCtx(ctx, url, data, nil); err != nil {
 		return nil, err
 	} else {
 		return readResponse(res)
@@ -558,11 +526,33 @@ func (a *Api) Patch(url string, data []byte) (*[]byte, error) {
 }
 
 func (a *Api) RawPost(url string, data []byte, headers *map[string]string) (*http.Response, error) {
-	return a.rawMethod(http.MethodPost, url, data, headers)
+	return a.RawPostCtx(context.Background(), url, data, headers)
+}
+
+func (a *Api) RawPostCtx(ctx context.Context, url string, data []byte, headers *map[string]string) (*http.Response, error) {
+	return a.rawMethod(ctx, http.MethodPost, url, data, headers)
 }
 
 func (a *Api) Post(url string, data []byte) (*[]byte, error) {
-	if res, err := a.RawPost(url, data, nil); err != nil {
+	return a.PostCtx(context.Background(), url, data)
+}
+
+func (a *Api) PostCtx(ctx context.Context, url string, data []byte) (*[]byte, error) {
+	if res, err := a.RawPostCtx(ctx, url, data, nil); err != nil {
+		return nil, err
+	} else {
+		return readResponse(res)
+	}
+}
+
+// PostIdempotent is like Post, but for requests which are safe to resend (e.g. a repeated POST does
+// not create a duplicate resource). Such requests are retried on transient failures like GET or PUT.
+func (a *Api) PostIdempotent(url string, data []byte) (*[]byte, error) {
+	return a.PostIdempotentCtx(context.Background(), url, data)
+}
+
+func (a *Api) PostIdempotentCtx(ctx context.Context, url string, data []byte) (*[]byte, error) {
+	if res, err := a.rawMethodRetry(ctx, http.MethodPost, url, data, nil, true); err != nil {
 		return nil, err
 	} else {
 		return readResponse(res)
@@ -570,11 +560,19 @@ func (a *Api) Post(url string, data []byte) (*[]byte, error) {
 }
 
 func (a *Api) RawPut(url string, data []byte, headers *map[string]string) (*http.Response, error) {
-	return a.rawMethod(http.MethodPut, url, data, headers)
+	return a.RawPutCtx(context.Background(), url, data, headers)
+}
+
+func (a *Api) RawPutCtx(ctx context.Context, url string, data []byte, headers *map[string]string) (*http.Response, error) {
+	return a.rawMethod(ctx, http.MethodPut, url, data, headers)
 }
 
 func (a *Api) Put(url string, data []byte) (*[]byte, error) {
-	if res, err := a.RawPut(url, data, nil); err != nil {
+	return a.PutCtx(context.Background(), url, data)
+}
+
+func (a *Api) PutCtx(ctx context.Context, url string, data []byte) (*[]byte, error) {
+	if res, err := a.RawPutCtx(ctx, url, data, nil); err != nil {
 		return nil, err
 	} else {
 		return readResponse(res)
@@ -582,11 +580,19 @@ func (a *Api) Put(url string, data []byte) (*[]byte, error) {
 }
 
 func (a *Api) RawDelete(url string, data []byte, headers *map[string]string) (*http.Response, error) {
-	return a.rawMethod(http.MethodDelete, url, data, headers)
+	return a.RawDeleteCtx(context.Background(), url, data, headers)
+}
+
+func (a *Api) RawDeleteCtx(ctx context.Context, url string, data []byte, headers *map[string]string) (*http.Response, error) {
+	return a.rawMethod(ctx, http.MethodDelete, url, data, headers)
 }
 
 func (a *Api) Delete(url string, data []byte) (*[]byte, error) {
-	if res, err := a.RawDelete(url, data, nil); err != nil {
+	return a.DeleteCtx(context.Background(), url, data)
+}
+
+func (a *Api) DeleteCtx(ctx context.Context, url string, data []byte) (*[]byte, error) {
+	if res, err := a.RawDeleteCtx(ctx, url, data, nil); err != nil {
 		return nil, err
 	} else {
 		return readResponse(res)
@@ -713,7 +719,7 @@ func (a *Api) FactoryCreateDeviceGroup(factory string, name string, description
 	resp, err := a.Post(url, data)
 	if err != nil {
 		if herr := AsHttpError(err); herr != nil && herr.Response.StatusCode == 409 {
-			err = fmt.Errorf("A device group with this name already exists")
+			herr.Message = "A device group with this name already exists"
 		}
 		return nil, err
 	}
@@ -731,7 +737,7 @@ func (a *Api) FactoryDeleteDeviceGroup(factory string, name string) error {
 	logrus.Debugf("Deleting factory device group: %s", url)
 	_, err := a.Delete(url, nil)
 	if herr := AsHttpError(err); herr != nil && herr.Response.StatusCode == 409 {
-		err = fmt.Errorf("There are devices assigned to this device group")
+		herr.Message = "There are devices assigned to this device group"
 	}
 	return err
 }
@@ -753,7 +759,7 @@ func (a *Api) FactoryPatchDeviceGroup(factory string, name string, new_name *str
 	logrus.Debugf("Updating factory device group :%s", url)
 	_, err = a.Patch(url, data)
 	if herr := AsHttpError(err); herr != nil && herr.Response.StatusCode == 409 {
-		err = fmt.Errorf("A device group with this name already exists")
+		herr.Message = "A device group with this name already exists"
 	}
 	return err
 }
@@ -820,7 +826,7 @@ func (a *Api) TufTargetMetadataRefresh(
 	if err != nil {
 		return nil, err
 	}
-	r, err := a.Post(url, b)
+	r, err := a.PostIdempotent(url, b)
 	if err != nil {
 		return nil, err
 	}
@@ -853,11 +859,15 @@ func (a *Api) TargetGet(factory string, targetName string) (*tuf.FileMeta, error
 }
 
 func (a *Api) TargetsList(factory string, version ...string) (tuf.Files, error) {
+	return a.TargetsListCtx(context.Background(), factory, version...)
+}
+
+func (a *Api) TargetsListCtx(ctx context.Context, factory string, version ...string) (tuf.Files, error) {
 	url := a.serverUrl + "/ota/factories/" + factory + "/targets/"
 	if len(version) == 1 {
 		url += "?version=" + version[0]
 	}
-	body, err := a.Get(url)
+	body, err := a.GetCtx(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -1070,32 +1080,47 @@ func (a *Api) JobservRun(runUrl string) (*JobservRun, error) {
 }
 
 func (a *Api) JobservRunArtifact(factory string, build int, run string, artifact string) (*http.Response, error) {
+	return a.JobservRunArtifactCtx(context.Background(), factory, build, run, artifact)
+}
+
+func (a *Api) JobservRunArtifactCtx(
+	ctx context.Context, factory string, build int, run string, artifact string,
+) (*http.Response, error) {
 	url := a.serverUrl + "/projects/" + factory + "/lmp/builds/" + strconv.Itoa(build) + "/runs/" + run + "/" + artifact
 	logrus.Debugf("JobservRunArtifact with url: %s", url)
-	return a.RawGet(url, nil)
+	return a.RawGetCtx(ctx, url, nil)
+}
+
+func (a *Api) JobservTailRun(factory string, build int, run string, artifact string) error {
+	return a.JobservTailRunCtx(context.Background(), factory, build, run, artifact)
 }
 
-func (a *Api) JobservTailRun(factory string, build int, run string, artifact string) {
+func (a *Api) JobservTailRunCtx(ctx context.Context, factory string, build int, run string, artifact string) error {
 	url := a.serverUrl + "/projects/" + factory + "/lmp/builds/" + strconv.Itoa(build) + "/runs/" + run + "/" + artifact
-	a.JobservTail(url)
+	return a.JobservTailCtx(ctx, url)
+}
+
+func (a *Api) JobservTail(url string) error {
+	return a.JobservTailCtx(context.Background(), url)
 }
 
-func (a *Api) JobservTail(url string) {
+// JobservTailCtx prints a run's console log to STDOUT until the run is finished or the context is done.
+func (a *Api) JobservTailCtx(ctx context.Context, url string) error {
 	offset := 0
 	status := ""
 	for {
 		headers := map[string]string{"X-OFFSET": strconv.Itoa(offset)}
-		resp, err := a.RawGet(url, &headers)
+		resp, err := a.RawGetCtx(ctx, url, &headers)
 		if err != nil {
-			fmt.Printf("TODO LOG ERROR OR SOMETHING: %s\n", err)
+			return fmt.Errorf("Unable to get '%s': %w", url, err)
 		}
 		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			fmt.Printf("Unable to read body resp: %s", err)
+			return fmt.Errorf("Unable to read body resp: %w", err)
 		}
 		if resp.StatusCode != 200 {
-			fmt.Printf("Unable to get '%s': HTTP_%d\n=%s", url, resp.StatusCode, body)
-			return
+			return fmt.Errorf("Unable to get '%s': HTTP_%d\n=%s", url, resp.StatusCode, body)
 		}
 
 		newstatus := resp.Header.Get("X-RUN-STATUS")
@@ -1108,7 +1133,7 @@ func (a *Api) JobservTail(url string) {
 		} else if len(newstatus) == 0 {
 			body = body[offset:]
 			os.Stdout.Write(body)
-			return
+			return nil
 		} else {
 			if newstatus != status {
 				color.New(color.FgGreen).Printf("\n--- Status change: %s -> %s\n", status, newstatus)
@@ -1117,7 +1142,9 @@ func (a *Api) JobservTail(url string) {
 			offset += len(body)
 		}
 		status = newstatus
-		time.Sleep(5 * time.Second)
+		if err := sleepCtx(ctx, 5*time.Second); err != nil {
+			return err
+		}
 	}
 }
 
@@ -1214,7 +1241,7 @@ func (a *Api) FactorySignWave(factory string, wave string, signatures []tuf.Sign
 		return err
 	}
 
-	_, err = a.Post(url, data)
+	_, err = a.PostIdempotent(url, data)
 	return err
 }
 
@@ -1254,10 +1281,16 @@ func (a *Api) FactoryCompleteWave(factory string, wave string) error {
 }
 
 func (a *Api) FactoryWaveStatus(factory string, wave string, inactiveThreshold int) (*WaveStatus, error) {
+	return a.FactoryWaveStatusCtx(context.Background(), factory, wave, inactiveThreshold)
+}
+
+func (a *Api) FactoryWaveStatusCtx(
+	ctx context.Context, factory string, wave string, inactiveThreshold int,
+) (*WaveStatus, error) {
 	url := fmt.Sprintf("%s/ota/factories/%s/waves/%s/status/?offline-threshold=%d",
 		a.serverUrl, factory, wave, inactiveThreshold)
 	logrus.Debugf("Fetching factory wave status %s", url)
-	body, err := a.Get(url)
+	body, err := a.GetCtx(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -50,6 +51,7 @@ type EventType struct {
 }
 
 type EventDetail struct {
+	Ecu        string `json:"ecu,omitempty"`
 	Version    string `json:"version"`
 	TargetName string `json:"targetName"`
 	Success    *bool  `json:"success,omitempty"`
@@ -205,6 +207,12 @@ func (a *DeviceApi) Get() (*Device, error) {
 }
 
 func (a *Api) DeviceList(filterBy map[string]string, sortBy string, page, limit uint64) (*DeviceList, error) {
+	return a.DeviceListCtx(context.Background(), filterBy, sortBy, page, limit)
+}
+
+func (a *Api) DeviceListCtx(
+	ctx context.Context, filterBy map[string]string, sortBy string, page, limit uint64,
+) (*DeviceList, error) {
 	url := a.serverUrl + "/ota/devices/?"
 	query := netUrl.Values{}
 	for key, val := range filterBy {
@@ -221,12 +229,16 @@ func (a *Api) DeviceList(filterBy map[string]string, sortBy string, page, limit
 	query.Set("limit", strconv.FormatUint(limit, 10))
 	url += query.Encode()
 	logrus.Debugf("DeviceList with url: %s", url)
-	return a.DeviceListCont(url)
+	return a.DeviceListContCtx(ctx, url)
 }
 
 func (a *Api) DeviceListCont(url string) (*DeviceList, error) {
+	return a.DeviceListContCtx(context.Background(), url)
+}
+
+func (a *Api) DeviceListContCtx(ctx context.Context, url string) (*DeviceList, error) {
 	logrus.Debugf("DeviceListCont with url: %s", url)
-	body, err := a.Get(url)
+	body, err := a.GetCtx(ctx, url)
 	if err != nil {
 		return nil, err
 	}
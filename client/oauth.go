@@ -1,7 +1,6 @@
 package client
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -26,11 +25,15 @@ type OAuthConfig struct {
 	Created      string
 	DefaultOrg   string
 	URL          string
+	// Store is where the secrets are kept, see the credstore package. Empty means the config file.
+	Store string `mapstructure:"store"`
 }
 
 type ClientCredentials struct {
 	Config      OAuthConfig
 	InsecureSSL bool
+	// Client, if set, sends the token requests, e.g. to use the same proxy as the API client.
+	Client *http.Client
 }
 
 type Org struct {
@@ -73,12 +76,13 @@ func (c *ClientCredentials) updateConfig(r OAuthResponse) {
 
 // Perform a POST request.
 func (c *ClientCredentials) post(uri string, data url.Values) (*[]byte, error) {
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: c.InsecureSSL,
-			},
-		},
+	client := c.Client
+	if client == nil {
+		transport, err := NewTransport(TransportConfig{}, "", c.InsecureSSL)
+		if err != nil {
+			return nil, err
+		}
+		client = &http.Client{Transport: transport}
 	}
 	res, err := client.PostForm(uri, data)
 	if err != nil {
@@ -185,5 +189,5 @@ func NewClientCredentials(c OAuthConfig) ClientCredentials {
 	if len(c.URL) == 0 {
 		c.URL = OauthURL
 	}
-	return ClientCredentials{c, false}
+	return ClientCredentials{Config: c}
 }
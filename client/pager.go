@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"iter"
+)
+
+// PageOf is implemented by API responses which are split into pages linked by a "next" URL.
+type PageOf[T any] interface {
+	PageItems() []T
+	NextPage() *string
+}
+
+// Paginate returns an iterator over all items of a paginated listing.
+// Pages are fetched lazily: the first one via the first function, and each subsequent one via the
+// next function (usually one of the *Cont API methods) as the caller consumes the items.
+// An error stops the iteration after being yielded with a zero item.
+func Paginate[T any, P PageOf[T]](first func() (P, error), next func(string) (P, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		page, err := first()
+		for {
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range page.PageItems() {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			nextUrl := page.NextPage()
+			if nextUrl == nil {
+				return
+			}
+			page, err = next(*nextUrl)
+		}
+	}
+}
+
+func (l *DeviceList) PageItems() []Device {
+	return l.Devices
+}
+
+func (l *DeviceList) NextPage() *string {
+	return l.Next
+}
+
+func (l *UpdateList) PageItems() []Update {
+	return l.Updates
+}
+
+func (l *UpdateList) NextPage() *string {
+	return l.Next
+}
+
+func (l *DeviceConfigList) PageItems() []DeviceConfig {
+	return l.Configs
+}
+
+func (l *DeviceConfigList) NextPage() *string {
+	return l.Next
+}
+
+func (l *TargetTestList) PageItems() []TargetTest {
+	return l.Tests
+}
+
+func (l *TargetTestList) NextPage() *string {
+	return l.Next
+}
+
+// DeviceListAll is like DeviceList, but iterates over devices from all pages starting at the first.
+func (a *Api) DeviceListAll(filterBy map[string]string, sortBy string, limit uint64) iter.Seq2[Device, error] {
+	return a.DeviceListAllCtx(context.Background(), filterBy, sortBy, limit)
+}
+
+func (a *Api) DeviceListAllCtx(
+	ctx context.Context, filterBy map[string]string, sortBy string, limit uint64,
+) iter.Seq2[Device, error] {
+	return Paginate(func() (*DeviceList, error) {
+		return a.DeviceListCtx(ctx, filterBy, sortBy, 1, limit)
+	}, func(url string) (*DeviceList, error) {
+		return a.DeviceListContCtx(ctx, url)
+	})
+}
+
+func (d *DeviceApi) ListUpdatesAll() iter.Seq2[Update, error] {
+	return Paginate(d.ListUpdates, d.ListUpdatesCont)
+}
+
+func (d *DeviceApi) ListConfigAll() iter.Seq2[DeviceConfig, error] {
+	return Paginate(d.ListConfig, d.api.DeviceListConfigCont)
+}
+
+func (a *Api) FactoryListConfigAll(factory string) iter.Seq2[DeviceConfig, error] {
+	return Paginate(func() (*DeviceConfigList, error) {
+		return a.FactoryListConfig(factory)
+	}, a.FactoryListConfigCont)
+}
+
+func (a *Api) GroupListConfigAll(factory, group string) iter.Seq2[DeviceConfig, error] {
+	return Paginate(func() (*DeviceConfigList, error) {
+		return a.GroupListConfig(factory, group)
+	}, a.GroupListConfigCont)
+}
+
+func (a *Api) TargetTestsAll(factory string, target int) iter.Seq2[TargetTest, error] {
+	return Paginate(func() (*TargetTestList, error) {
+		return a.TargetTests(factory, target)
+	}, a.TargetTestsCont)
+}
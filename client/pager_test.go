@@ -0,0 +1,61 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginate(t *testing.T) {
+	next1, next2 := "page-2", "page-3"
+	pages := map[string]*UpdateList{
+		"":       {Updates: []Update{{Version: "1"}, {Version: "2"}}, Next: &next1},
+		"page-2": {Updates: []Update{{Version: "3"}}, Next: &next2},
+		"page-3": {Updates: []Update{{Version: "4"}}},
+	}
+	var fetched []string
+	fetch := func(url string) (*UpdateList, error) {
+		fetched = append(fetched, url)
+		return pages[url], nil
+	}
+	first := func() (*UpdateList, error) { return fetch("") }
+
+	var versions []string
+	for u, err := range Paginate(first, fetch) {
+		assert.Nil(t, err)
+		versions = append(versions, u.Version)
+	}
+	assert.Equal(t, []string{"1", "2", "3", "4"}, versions)
+	assert.Equal(t, []string{"", "page-2", "page-3"}, fetched)
+
+	// Pages are fetched lazily, so an early break does not fetch the rest
+	fetched = nil
+	for u := range Paginate(first, fetch) {
+		if u.Version == "2" {
+			break
+		}
+	}
+	assert.Equal(t, []string{""}, fetched)
+}
+
+func TestPaginateError(t *testing.T) {
+	next := "page-2"
+	first := func() (*UpdateList, error) {
+		return &UpdateList{Updates: []Update{{Version: "1"}}, Next: &next}, nil
+	}
+	fetch := func(url string) (*UpdateList, error) {
+		return nil, errors.New("boom")
+	}
+	var items int
+	var lastErr error
+	for _, err := range Paginate(first, fetch) {
+		if err != nil {
+			lastErr = err
+		} else {
+			items++
+		}
+	}
+	assert.Equal(t, 1, items)
+	assert.EqualError(t, lastErr, "boom")
+}
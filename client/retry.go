@@ -0,0 +1,89 @@
+package client
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultRetryMaxAttempts = 4
+	DefaultRetryMinBackoff  = 500 * time.Millisecond
+	DefaultRetryMaxBackoff  = 30 * time.Second
+)
+
+// RetryConfig controls how transient API failures are retried.
+// It is configured in fioctl.yaml under the server.retries section.
+type RetryConfig struct {
+	// The total number of attempts for a request: 1 disables retries, 0 uses a default.
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+}
+
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.MaxAttempts < 1 {
+		c.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = DefaultRetryMinBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		c.MaxBackoff = c.MinBackoff
+	}
+	return c
+}
+
+// Only methods which are idempotent by the HTTP spec are retried automatically.
+// POST requests can opt in via Api.PostIdempotent.
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns how long to wait before the given retry attempt (starting at 1).
+// It is an exponential backoff with an "equal jitter": a random value between a half and a full step.
+func (c RetryConfig) backoff(attempt int) time.Duration {
+	step := c.MinBackoff
+	for i := 1; i < attempt && step < c.MaxBackoff; i++ {
+		step *= 2
+	}
+	if step > c.MaxBackoff {
+		step = c.MaxBackoff
+	}
+	half := step / 2
+	return half + rand.N(half+1)
+}
+
+// retryAfter parses a Retry-After header, which holds either a number of seconds or an HTTP date.
+// The wait is capped at the maximum backoff, so that a server cannot stall a command for hours.
+func (c RetryConfig) retryAfter(res *http.Response) (time.Duration, bool) {
+	val := res.Header.Get("Retry-After")
+	if len(val) == 0 {
+		return 0, false
+	}
+	var wait time.Duration
+	if secs, err := strconv.Atoi(val); err == nil && secs >= 0 {
+		wait = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(val); err == nil {
+		wait = max(time.Until(t), 0)
+	} else {
+		return 0, false
+	}
+	return min(wait, c.MaxBackoff), true
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestApi(srv *httptest.Server, retries RetryConfig) *Api {
+	return NewApiClient(srv.URL, Config{Retries: retries}, "", "test")
+}
+
+func TestRetryTransientStatus(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	api := newTestApi(srv, RetryConfig{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	body, err := api.Get(srv.URL + "/")
+	require.Nil(t, err)
+	assert.Equal(t, "ok", string(*body))
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	api := newTestApi(srv, RetryConfig{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	_, err := api.Get(srv.URL + "/")
+	herr := AsHttpError(err)
+	require.NotNil(t, herr)
+	assert.Equal(t, http.StatusBadGateway, herr.Response.StatusCode)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRetryOnlyIdempotent(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	api := newTestApi(srv, RetryConfig{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	_, err := api.Post(srv.URL+"/", []byte("{}"))
+	require.NotNil(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+
+	calls.Store(0)
+	_, err = api.PostIdempotent(srv.URL+"/", []byte("{}"))
+	require.NotNil(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestRetryAfter(t *testing.T) {
+	cfg := RetryConfig{MaxBackoff: 30 * time.Second}.withDefaults()
+	tests := []struct {
+		name  string
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"missing", "", 0, false},
+		{"invalid", "soon", 0, false},
+		{"seconds", "7", 7 * time.Second, true},
+		{"seconds over the cap", "3600", 30 * time.Second, true},
+		{"past date", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0, true},
+		{"date over the cap", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 30 * time.Second, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := &http.Response{Header: http.Header{}}
+			if len(tc.value) > 0 {
+				res.Header.Set("Retry-After", tc.value)
+			}
+			wait, ok := cfg.retryAfter(res)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.wait, wait)
+		})
+	}
+}
+
+func TestRetryAfterCapped(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 2 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	api := newTestApi(srv, RetryConfig{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond})
+	start := time.Now()
+	body, err := api.Get(srv.URL + "/")
+	require.Nil(t, err)
+	assert.Equal(t, "ok", string(*body))
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	cfg := RetryConfig{MinBackoff: time.Second, MaxBackoff: 4 * time.Second}.withDefaults()
+	for attempt, step := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		wait := cfg.backoff(attempt + 1)
+		assert.GreaterOrEqual(t, wait, step/2)
+		assert.LessOrEqual(t, wait, step)
+	}
+}
+
+func TestRetryCanceledContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	api := newTestApi(srv, RetryConfig{})
+	start := time.Now()
+	_, err := api.GetCtx(ctx, srv.URL+"/")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
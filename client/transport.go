@@ -0,0 +1,268 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	DefaultConnectTimeout = 30 * time.Second
+	DefaultIdleTimeout    = 90 * time.Second
+)
+
+// TransportConfig controls the HTTP connections to the API.
+// It is configured in fioctl.yaml under the server section.
+type TransportConfig struct {
+	// The longest time to establish a connection, including the TLS handshake: 0 uses a default.
+	ConnectTimeout time.Duration
+	// The longest time to wait for data from the server, e.g. for the response headers or for
+	// the next chunk of a download: 0 or a negative value means no limit. It is off by default,
+	// because streams like "events listen" and "devices watch" may be idle for a long time.
+	ReadTimeout time.Duration
+	// How long an unused connection is kept open for reuse: 0 uses a default.
+	IdleTimeout time.Duration
+	Proxy       ProxyConfig
+	// A client certificate and key (PEM files) for servers which require mutual TLS.
+	ClientCert string
+	ClientKey  string
+}
+
+// ProxyConfig overrides the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables.
+// An empty value means that the environment variable applies.
+type ProxyConfig struct {
+	Http    string
+	Https   string
+	NoProxy string
+}
+
+func (c TransportConfig) withDefaults() TransportConfig {
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = DefaultConnectTimeout
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	return c
+}
+
+// proxyFunc returns a proxy selector like http.ProxyFromEnvironment, but with the environment
+// overridden by the config. Unlike http.ProxyFromEnvironment, the environment is not cached.
+func (c ProxyConfig) proxyFunc() func(*http.Request) (*url.URL, error) {
+	proxy := httpproxy.FromEnvironment()
+	if len(c.Http) > 0 {
+		proxy.HTTPProxy = c.Http
+	}
+	if len(c.Https) > 0 {
+		proxy.HTTPSProxy = c.Https
+	}
+	if len(c.NoProxy) > 0 {
+		proxy.NoProxy = c.NoProxy
+	}
+	fn := proxy.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := fn(req.URL)
+		if u != nil {
+			logrus.Debugf("Using proxy %s for %s", u.Redacted(), req.URL.Host)
+		}
+		return u, err
+	}
+}
+
+// NewTransport returns an HTTP transport for the API. Each API client has a private transport,
+// so that the settings of one client (or a library user's) never leak into http.DefaultTransport.
+func NewTransport(config TransportConfig, caCertPath string, insecureSkipVerify bool) (*http.Transport, error) {
+	config = config.withDefaults()
+	tlsCfg := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if len(caCertPath) > 0 {
+		rootCAs, _ := x509.SystemCertPool()
+		if rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		certs, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to append %q to RootCAs: %w", caCertPath, err)
+		}
+
+		if ok := rootCAs.AppendCertsFromPEM(certs); !ok {
+			logrus.Warning("No certs appended, using system certs only")
+		}
+		tlsCfg.RootCAs = rootCAs
+	}
+	if len(config.ClientCert) > 0 || len(config.ClientKey) > 0 {
+		if len(config.ClientCert) == 0 || len(config.ClientKey) == 0 {
+			return nil, fmt.Errorf("Both a client certificate and a client key are required for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load the client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   config.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	dial := dialer.DialContext
+	if config.ReadTimeout > 0 {
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &readTimeoutConn{conn, config.ReadTimeout}, nil
+		}
+	}
+
+	return &http.Transport{
+		Proxy:                 config.Proxy.proxyFunc(),
+		DialContext:           dial,
+		TLSClientConfig:       tlsCfg,
+		TLSHandshakeTimeout:   config.ConnectTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       config.IdleTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		// targets/artifacts.go needs to know the Content-Length in order to
+		// compute the download progress. If certain services like CloudFlare
+		// see the client accepts compressed responsed (content-encoding not
+		// content-type) then it will give a compressed response. Golang will
+		// automagically decompress as you read the response *and* set
+		// content-length to -1 thereby breaking our download progress logic
+		DisableCompression: true,
+	}, nil
+}
+
+// readTimeoutConn fails a read when the server sends nothing for too long. Unlike
+// http.Transport.ResponseHeaderTimeout, this also catches downloads which stall midway.
+// Writes push the deadline back too, so that a slow upload is not cut off while the server
+// is still waiting for the rest of the request.
+type readTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *readTimeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *readTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// Option configures an API client created by NewApiClientWithOptions.
+type Option func(*apiOptions)
+
+type apiOptions struct {
+	config     Config
+	caCertPath string
+	version    string
+	client     *http.Client
+	transport  http.RoundTripper
+}
+
+// WithConfig sets the API client config. As it replaces the whole config, including the
+// transport settings, it should precede other options.
+func WithConfig(config Config) Option {
+	return func(o *apiOptions) {
+		o.config = config
+	}
+}
+
+// WithCACert adds the certificates of a PEM file to the system ones to verify the server.
+func WithCACert(path string) Option {
+	return func(o *apiOptions) {
+		o.caCertPath = path
+	}
+}
+
+// WithVersion sets the version reported by the User-Agent header.
+func WithVersion(version string) Option {
+	return func(o *apiOptions) {
+		o.version = version
+	}
+}
+
+// WithTimeouts sets the connection timeouts, see TransportConfig.
+func WithTimeouts(connect, read, idle time.Duration) Option {
+	return func(o *apiOptions) {
+		o.config.Transport.ConnectTimeout = connect
+		o.config.Transport.ReadTimeout = read
+		o.config.Transport.IdleTimeout = idle
+	}
+}
+
+// WithProxy overrides the proxy environment variables.
+func WithProxy(proxy ProxyConfig) Option {
+	return func(o *apiOptions) {
+		o.config.Transport.Proxy = proxy
+	}
+}
+
+// WithClientCert authenticates the client with a certificate for servers which require mutual TLS.
+func WithClientCert(certPath, keyPath string) Option {
+	return func(o *apiOptions) {
+		o.config.Transport.ClientCert = certPath
+		o.config.Transport.ClientKey = keyPath
+	}
+}
+
+// WithTransport makes the API client send requests via the given round tripper.
+// The transport related settings of the config are ignored then.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *apiOptions) {
+		o.transport = transport
+	}
+}
+
+// WithHTTPClient makes the API client send requests via the given HTTP client.
+// The transport related settings of the config are ignored then.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *apiOptions) {
+		o.client = client
+	}
+}
+
+// NewApiClientWithOptions creates an API client for library users, e.g.:
+//
+//	api, err := client.NewApiClientWithOptions("https://api.foundries.io",
+//		client.WithConfig(client.Config{Token: token}),
+//		client.WithTimeouts(10*time.Second, time.Minute, 0),
+//	)
+func NewApiClientWithOptions(serverUrl string, opts ...Option) (*Api, error) {
+	o := apiOptions{version: "lib"}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	httpClient := o.client
+	if httpClient == nil {
+		transport := o.transport
+		if transport == nil {
+			var err error
+			if transport, err = NewTransport(o.config.Transport, o.caCertPath, o.config.InsecureSkipVerify); err != nil {
+				return nil, err
+			}
+		}
+		httpClient = &http.Client{Transport: transport}
+	}
+	return newApi(serverUrl, o.config, httpClient, o.version), nil
+}
@@ -0,0 +1,198 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportIsPrivate(t *testing.T) {
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	api := NewApiClient("https://api.example.com", Config{InsecureSkipVerify: true}, "", "test")
+	if defaultTransport.TLSClientConfig != nil {
+		assert.False(t, defaultTransport.TLSClientConfig.InsecureSkipVerify)
+	}
+	assert.False(t, defaultTransport.DisableCompression)
+
+	transport := api.client.Transport.(*http.Transport)
+	assert.NotSame(t, defaultTransport, transport)
+	assert.True(t, transport.TLSClientConfig.InsecureSkipVerify)
+	assert.True(t, transport.DisableCompression)
+	assert.Equal(t, DefaultConnectTimeout, transport.TLSHandshakeTimeout)
+	assert.Equal(t, DefaultIdleTimeout, transport.IdleConnTimeout)
+}
+
+func TestTransportReadTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	api, err := NewApiClientWithOptions(srv.URL,
+		WithConfig(Config{Retries: RetryConfig{MaxAttempts: 1}}),
+		WithTimeouts(time.Second, 50*time.Millisecond, 0),
+	)
+	require.Nil(t, err)
+	_, err = api.Get(srv.URL + "/")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "timeout")
+
+	api, err = NewApiClientWithOptions(srv.URL, WithTimeouts(time.Second, time.Second, 0))
+	require.Nil(t, err)
+	body, err := api.Get(srv.URL + "/")
+	require.Nil(t, err)
+	assert.Equal(t, "ok", string(*body))
+}
+
+func TestTransportReadTimeoutDefaults(t *testing.T) {
+	assert.Equal(t, time.Duration(0), TransportConfig{}.withDefaults().ReadTimeout)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	// An upload which takes longer than the read timeout is fine, as long as it makes progress
+	transport, err := NewTransport(TransportConfig{ReadTimeout: 100 * time.Millisecond}, "", false)
+	require.Nil(t, err)
+	body, writer := io.Pipe()
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(50 * time.Millisecond)
+			_, _ = writer.Write([]byte("chunk"))
+		}
+		writer.Close()
+	}()
+	res, err := (&http.Client{Transport: transport}).Post(srv.URL, "text/plain", body)
+	require.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
+
+func TestTransportProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		_, _ = w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("NO_PROXY", "")
+	api, err := NewApiClientWithOptions("http://api.example.com",
+		WithConfig(Config{Retries: RetryConfig{MaxAttempts: 1}}),
+		WithProxy(ProxyConfig{Http: proxy.URL, NoProxy: "direct.invalid"}),
+	)
+	require.Nil(t, err)
+	body, err := api.Get("http://api.example.com/ota/factories/")
+	require.Nil(t, err)
+	assert.Equal(t, "proxied", string(*body))
+	assert.Equal(t, []string{"http://api.example.com/ota/factories/"}, proxied)
+
+	// NO_PROXY hosts are reached directly, which fails for this fake domain
+	_, err = api.Get("http://direct.invalid/")
+	require.NotNil(t, err)
+	assert.Len(t, proxied, 1)
+}
+
+func TestTransportClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeClientCert(t, dir)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	caPath := filepath.Join(dir, "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	require.Nil(t, os.WriteFile(caPath, caPem, 0o600))
+
+	api, err := NewApiClientWithOptions(srv.URL,
+		WithConfig(Config{Retries: RetryConfig{MaxAttempts: 1}}),
+		WithCACert(caPath),
+		WithClientCert(certPath, keyPath),
+	)
+	require.Nil(t, err)
+	body, err := api.Get(srv.URL + "/")
+	require.Nil(t, err)
+	assert.Equal(t, "fioctl-test", string(*body))
+
+	// Without a client certificate the server rejects the TLS handshake
+	api, err = NewApiClientWithOptions(srv.URL,
+		WithConfig(Config{Retries: RetryConfig{MaxAttempts: 1}}),
+		WithCACert(caPath),
+	)
+	require.Nil(t, err)
+	_, err = api.Get(srv.URL + "/")
+	require.NotNil(t, err)
+
+	_, err = NewApiClientWithOptions(srv.URL, WithClientCert(certPath, ""))
+	require.NotNil(t, err)
+	_, err = NewApiClientWithOptions(srv.URL, WithClientCert(certPath, filepath.Join(dir, "missing.pem")))
+	require.NotNil(t, err)
+}
+
+func TestApiWithHTTPClient(t *testing.T) {
+	var calls int
+	httpClient := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		rec := httptest.NewRecorder()
+		_, _ = rec.WriteString("custom")
+		res := rec.Result()
+		res.Request = r
+		return res, nil
+	})}
+	api, err := NewApiClientWithOptions("https://api.example.com", WithHTTPClient(httpClient))
+	require.Nil(t, err)
+	body, err := api.Get("https://api.example.com/")
+	require.Nil(t, err)
+	assert.Equal(t, "custom", string(*body))
+	assert.Equal(t, 1, calls)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fioctl-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.Nil(t, err)
+
+	certPath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client.key")
+	require.Nil(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.Nil(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certPath, keyPath
+}
@@ -0,0 +1,140 @@
+// Package cmdtest runs fioctl commands end-to-end against a fake API server.
+//
+// Commands exit the process on errors, keep their flags in package globals, and print directly to
+// stdout. Therefore each command runs via cmd.Execute in a fresh child process: the test binary
+// re-executes itself. A test package enables this by calling Main from its TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		cmdtest.Main(m)
+//	}
+package cmdtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/foundriesio/fioctl/client/fakeapi"
+	"github.com/foundriesio/fioctl/cmd"
+)
+
+const argsEnv = "FIOCTL_CMDTEST_ARGS"
+
+// Main runs a fioctl command when invoked as a child process by Fioctl.Run,
+// or the tests otherwise.
+func Main(m *testing.M) {
+	if args := os.Getenv(argsEnv); len(args) > 0 {
+		var cmdArgs []string
+		if err := json.Unmarshal([]byte(args), &cmdArgs); err != nil {
+			fmt.Println("ERROR: invalid", argsEnv, err)
+			os.Exit(2)
+		}
+		os.Args = append([]string{"fioctl"}, cmdArgs...)
+		cmd.Execute()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Fioctl runs commands with a config pointing at a fake API server.
+type Fioctl struct {
+	t      *testing.T
+	Home   string
+	Config string
+	// Stdin, if set, is passed to the next command run.
+	Stdin string
+	// Env are extra environment variables for all commands, e.g. "FIOCTL_PROFILE=staging".
+	Env []string
+}
+
+// Result is the outcome of a command.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// New creates a fioctl config file in a temporary home directory with a token for the factory
+// served by srv. Retries are disabled to make failure tests fast.
+func New(t *testing.T, srv *fakeapi.Server) *Fioctl {
+	t.Helper()
+	home := t.TempDir()
+	config := filepath.Join(home, ".config", "fioctl.yaml")
+	content := fmt.Sprintf(`factory: %s
+token: test-token
+server:
+  url: %s
+  retries:
+    max_attempts: 1
+`, srv.Factory, srv.URL)
+	if err := os.MkdirAll(filepath.Dir(config), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &Fioctl{t: t, Home: home, Config: config}
+}
+
+// Run executes a fioctl command, e.g. Run("devices", "list").
+func (f *Fioctl) Run(args ...string) Result {
+	f.t.Helper()
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	child := exec.Command(os.Args[0], "-test.run=^$")
+	child.Dir = f.Home
+	child.Env = append(cleanEnv(),
+		argsEnv+"="+string(encoded),
+		"FIOCTL_CONFIG="+f.Config,
+		"HOME="+f.Home,
+	)
+	child.Env = append(child.Env, f.Env...)
+	var stdout, stderr bytes.Buffer
+	child.Stdout = &stdout
+	child.Stderr = &stderr
+	child.Stdin = strings.NewReader(f.Stdin)
+	f.Stdin = ""
+
+	res := Result{}
+	if err := child.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			f.t.Fatalf("Unable to run fioctl %v: %s", args, err)
+		}
+		res.ExitCode = exitErr.ExitCode()
+	}
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+	return res
+}
+
+// MustRun is like Run, but fails the test if a command does not succeed.
+func (f *Fioctl) MustRun(args ...string) Result {
+	f.t.Helper()
+	res := f.Run(args...)
+	if res.ExitCode != 0 {
+		f.t.Fatalf("fioctl %s exited with %d\nstdout:\n%s\nstderr:\n%s",
+			strings.Join(args, " "), res.ExitCode, res.Stdout, res.Stderr)
+	}
+	return res
+}
+
+// cleanEnv drops the developer's fioctl settings (viper reads FIOCTL_* variables) from the
+// environment, so that only the test config applies.
+func cleanEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "FIOCTL_") && !strings.HasPrefix(kv, "HOME=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
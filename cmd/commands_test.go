@@ -0,0 +1,1348 @@
+package cmd_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	canonical "github.com/docker/go/canonical/json"
+	ecies "github.com/foundriesio/go-ecies"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	tuf "github.com/theupdateframework/notary/tuf/data"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/client/fakeapi"
+	"github.com/foundriesio/fioctl/cmd/cmdtest"
+	"github.com/foundriesio/fioctl/subcommands"
+	fiox509 "github.com/foundriesio/fioctl/x509"
+)
+
+func TestMain(m *testing.M) {
+	cmdtest.Main(m)
+}
+
+func target(t *testing.T, version string, tags ...string) tuf.FileMeta {
+	raw, err := json.Marshal(client.TufCustom{Version: version, Tags: tags, TargetFormat: "OSTREE"})
+	require.Nil(t, err)
+	custom := canonical.RawMessage(raw)
+	return tuf.FileMeta{Length: 0, Hashes: tuf.Hashes{"sha256": []byte("1234")}, Custom: &custom}
+}
+
+func newFactory(t *testing.T, state fakeapi.State) (*fakeapi.Server, *cmdtest.Fioctl) {
+	srv := fakeapi.New("acme", state)
+	t.Cleanup(srv.Close)
+	return srv, cmdtest.New(t, srv)
+}
+
+func TestDevicesList(t *testing.T) {
+	_, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", TargetName: "acme-lmp-1"},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", TargetName: "acme-lmp-2"},
+			{Name: "other", Uuid: "uuid-3", Factory: "acme", TargetName: "acme-lmp-2"},
+		},
+	})
+
+	res := fioctl.MustRun("devices", "list", "dev-*", "--columns", "name,target")
+	assert.Contains(t, res.Stdout, "dev-1")
+	assert.Contains(t, res.Stdout, "acme-lmp-2")
+	assert.NotContains(t, res.Stdout, "other")
+
+	res = fioctl.MustRun("devices", "list", "--all", "--limit", "10", "--columns", "name")
+	assert.Contains(t, res.Stdout, "other")
+}
+
+func TestDevicesConfigGroup(t *testing.T) {
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{{Name: "dev-1", Uuid: "uuid-1", Factory: "acme"}},
+		Groups:  []client.DeviceGroup{{Id: 1, Name: "beta"}},
+	})
+
+	fioctl.MustRun("devices", "config", "group", "dev-1", "beta")
+	assert.Equal(t, "beta", srv.State().Devices[0].GroupName)
+
+	res := fioctl.Run("devices", "config", "group", "dev-1", "missing")
+	assert.Equal(t, subcommands.ExitNotFound, res.ExitCode)
+	assert.Contains(t, res.Stdout, "Device group not found")
+	assert.Equal(t, "beta", srv.State().Devices[0].GroupName)
+
+	fioctl.MustRun("devices", "config", "group", "dev-1", "--unset")
+	assert.Equal(t, "", srv.State().Devices[0].GroupName)
+}
+
+func TestTargetsPrune(t *testing.T) {
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Targets: tuf.Files{
+			"acme-lmp-1": target(t, "1", "devel"),
+			"acme-lmp-2": target(t, "2", "devel"),
+			"acme-lmp-3": target(t, "3", "main"),
+		},
+	})
+
+	res := fioctl.MustRun("targets", "prune", "--by-tag", "devel", "--dryrun")
+	assert.Contains(t, res.Stdout, "acme-lmp-1")
+	assert.Contains(t, res.Stdout, "Dry run, exiting")
+	assert.Len(t, srv.State().Targets, 3)
+
+	res = fioctl.MustRun("targets", "prune", "acme-lmp-1")
+	assert.Contains(t, res.Stdout, "Pruned Targets: acme-lmp-1")
+	assert.Len(t, srv.State().Targets, 2)
+	assert.NotContains(t, srv.State().Targets, "acme-lmp-1")
+
+	res = fioctl.Run("targets", "prune", "acme-lmp-1")
+	assert.Equal(t, 1, res.ExitCode)
+	assert.Contains(t, res.Stdout, "Target(acme-lmp-1) not found")
+}
+
+func TestWavesList(t *testing.T) {
+	_, fioctl := newFactory(t, fakeapi.State{
+		Waves: []client.Wave{
+			{Name: "wave-1", Version: "1", Tag: "prod", Status: "complete"},
+			{Name: "wave-2", Version: "2", Tag: "prod", Status: "active"},
+		},
+	})
+
+	res := fioctl.MustRun("waves", "list")
+	assert.Contains(t, res.Stdout, "wave-1")
+	assert.Contains(t, res.Stdout, "wave-2")
+
+	res = fioctl.MustRun("waves", "list", "--status", "active")
+	assert.NotContains(t, res.Stdout, "wave-1")
+	assert.Contains(t, res.Stdout, "wave-2")
+}
+
+func TestTufUpdatesCancel(t *testing.T) {
+	srv, fioctl := newFactory(t, fakeapi.State{
+		RootUpdates: client.TufRootUpdates{Status: client.TufRootUpdatesStatusStarted},
+	})
+
+	res := fioctl.MustRun("keys", "tuf", "updates", "cancel")
+	assert.Contains(t, res.Stdout, "The staged TUF root updates were canceled")
+	assert.Equal(t, client.TufRootUpdatesStatusNone, srv.State().RootUpdates.Status)
+
+	res = fioctl.Run("keys", "tuf", "updates", "cancel")
+	assert.Equal(t, subcommands.ExitConflict, res.ExitCode)
+	assert.Contains(t, res.Stdout, "There is no TUF root updates transaction in progress")
+}
+
+func TestConfigLog(t *testing.T) {
+	_, fioctl := newFactory(t, fakeapi.State{
+		FactoryConfigs: []client.DeviceConfig{
+			{CreatedAt: "2024-02-01", Reason: "second", Files: []client.ConfigFile{{Name: "b", Value: "2"}}},
+			{CreatedAt: "2024-01-01", Reason: "first", Files: []client.ConfigFile{{Name: "a", Value: "1"}}},
+		},
+	})
+
+	res := fioctl.MustRun("config", "log")
+	assert.Contains(t, res.Stdout, "second")
+	assert.Contains(t, res.Stdout, "first")
+
+	res = fioctl.MustRun("config", "log", "-n", "1")
+	assert.Contains(t, res.Stdout, "second")
+	assert.NotContains(t, res.Stdout, "first")
+
+	res = fioctl.MustRun("config", "log", "--all")
+	assert.Contains(t, res.Stdout, "first")
+	res = fioctl.Run("config", "log", "--all", "-n", "1")
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+	assert.Contains(t, res.Stdout, "[all limit] were all set")
+}
+
+func TestExitCodes(t *testing.T) {
+	srv, fioctl := newFactory(t, fakeapi.State{})
+
+	res := fioctl.Run("devices", "show", "missing")
+	assert.Equal(t, subcommands.ExitNotFound, res.ExitCode)
+
+	res = fioctl.Run("devices", "list", "--no-such-flag")
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+
+	srv.Close()
+	res = fioctl.Run("devices", "list")
+	assert.Equal(t, subcommands.ExitNetwork, res.ExitCode)
+}
+
+func TestTimeout(t *testing.T) {
+	hang := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(hang.Close)
+	fioctl := cmdtest.New(t, &fakeapi.Server{Server: hang, Factory: "acme"})
+
+	// Targets list passes its context to the API client, so it stops right away
+	start := time.Now()
+	res := fioctl.Run("targets", "list", "--timeout", "100ms")
+	assert.Equal(t, subcommands.ExitTimeout, res.ExitCode)
+	assert.Less(t, time.Since(start), 4*time.Second)
+
+	// Other commands are terminated after a grace period
+	res = fioctl.Run("devices", "show", "dev-1", "--timeout", "100ms")
+	assert.Equal(t, subcommands.ExitTimeout, res.ExitCode)
+	assert.Contains(t, res.Stderr, "context deadline exceeded")
+}
+
+func TestProfiles(t *testing.T) {
+	_, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{{Name: "prod-dev", Uuid: "uuid-1", Factory: "acme"}},
+	})
+	staging := fakeapi.New("acme-staging", fakeapi.State{
+		Devices: []client.Device{{Name: "staging-dev", Uuid: "uuid-2", Factory: "acme-staging"}},
+	})
+	t.Cleanup(staging.Close)
+
+	fioctl.MustRun("profile", "add", "staging", "--server-url", staging.URL, "--factory", "acme-staging",
+		"--token", "staging-token", "--header", "X-Env=staging")
+	res := fioctl.Run("profile", "add", "staging")
+	assert.Equal(t, 1, res.ExitCode)
+	assert.Contains(t, res.Stdout, "Profile staging already exists")
+
+	res = fioctl.MustRun("profile", "list")
+	assert.Regexp(t, `\*\s+default\s+acme\s+`, res.Stdout)
+	assert.Regexp(t, `staging\s+acme-staging\s+`+staging.URL+`\s+token`, res.Stdout)
+
+	res = fioctl.MustRun("--profile", "staging", "devices", "list")
+	assert.Contains(t, res.Stdout, "staging-dev")
+	assert.NotContains(t, res.Stdout, "prod-dev")
+	reqs := staging.Requests()
+	require.Len(t, reqs, 1)
+	assert.Equal(t, "staging-token", reqs[0].Header.Get("OSF-TOKEN"))
+	assert.Equal(t, "staging", reqs[0].Header.Get("X-Env"))
+
+	fioctl.MustRun("profile", "use", "staging")
+	res = fioctl.MustRun("devices", "list")
+	assert.Contains(t, res.Stdout, "staging-dev")
+	res = fioctl.MustRun("--profile", "default", "devices", "list")
+	assert.Contains(t, res.Stdout, "prod-dev")
+
+	// Credentials are saved into the profile in use
+	fioctl.MustRun("login", "--refresh-access-token")
+	buf, err := os.ReadFile(fioctl.Config)
+	require.Nil(t, err)
+	var cfg map[string]interface{}
+	require.Nil(t, yaml.Unmarshal(buf, &cfg))
+	assert.NotContains(t, cfg, "clientcredentials")
+	assert.Contains(t, cfg["profiles"].(map[interface{}]interface{})["staging"], "clientcredentials")
+
+	res = fioctl.Run("--profile", "missing", "devices", "list")
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+
+	fioctl.MustRun("profile", "delete", "staging")
+	res = fioctl.MustRun("devices", "list")
+	assert.Contains(t, res.Stdout, "prod-dev")
+	res = fioctl.MustRun("profile", "list")
+	assert.NotContains(t, res.Stdout, "staging")
+}
+
+func TestLoginStoreMigration(t *testing.T) {
+	srv, fioctl := newFactory(t, fakeapi.State{})
+	fioctl.Env = []string{"FIOCTL_CREDS_PASSPHRASE=secret passphrase"}
+	config := fmt.Sprintf(`factory: acme
+server:
+  url: %s
+clientcredentials:
+  client_id: client-1
+  client_secret: the-secret
+  access_token: the-access-token
+  refresh_token: the-refresh-token
+  token_type: bearer
+  expires_in: 36000
+  created: "%s"
+`, srv.URL, time.Now().UTC().Format(time.RFC3339))
+	require.Nil(t, os.WriteFile(fioctl.Config, []byte(config), 0o644))
+
+	readConfig := func() string {
+		buf, err := os.ReadFile(fioctl.Config)
+		require.Nil(t, err)
+		return string(buf)
+	}
+	credsFile := filepath.Join(filepath.Dir(fioctl.Config), "fioctl-credentials.enc")
+
+	res := fioctl.MustRun("login", "--store", "file")
+	assert.Contains(t, res.Stdout, "Your credentials were moved to the file store")
+	cfg := readConfig()
+	assert.Contains(t, cfg, "store: file")
+	assert.Contains(t, cfg, "client_id: client-1")
+	assert.NotContains(t, cfg, "the-secret")
+	assert.NotContains(t, cfg, "the-access-token")
+	assert.NotContains(t, cfg, "the-refresh-token")
+	info, err := os.Stat(fioctl.Config)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	buf, err := os.ReadFile(credsFile)
+	require.Nil(t, err)
+	assert.NotContains(t, string(buf), "the-secret")
+
+	res = fioctl.MustRun("login")
+	assert.Contains(t, res.Stdout, "You are already logged in")
+
+	fioctl.Env = []string{"FIOCTL_CREDS_PASSPHRASE=wrong"}
+	res = fioctl.Run("login")
+	assert.Equal(t, 1, res.ExitCode)
+	assert.Contains(t, res.Stdout, "wrong passphrase")
+
+	fioctl.Env = []string{"FIOCTL_CREDS_PASSPHRASE=secret passphrase"}
+	res = fioctl.MustRun("login", "--store", "plain")
+	assert.Contains(t, res.Stdout, "Your credentials were moved to the plain store")
+	cfg = readConfig()
+	assert.NotContains(t, cfg, "store:")
+	assert.Contains(t, cfg, "client_secret: the-secret")
+	assert.Contains(t, cfg, "refresh_token: the-refresh-token")
+
+	res = fioctl.Run("login", "--store", "vault")
+	assert.Equal(t, 1, res.ExitCode)
+	assert.Contains(t, res.Stdout, "Invalid credential store")
+}
+
+func TestRecordReplay(t *testing.T) {
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", TargetName: "acme-lmp-1"}},
+	})
+	cassette := filepath.Join(fioctl.Home, "cassette.json")
+
+	recorded := fioctl.MustRun("--record", cassette, "devices", "list")
+	assert.Contains(t, recorded.Stdout, "dev-1")
+	buf, err := os.ReadFile(cassette)
+	require.Nil(t, err)
+	assert.NotContains(t, string(buf), "test-token")
+
+	// The replay needs neither the server nor credentials
+	srv.Close()
+	config := "factory: acme\n"
+	require.Nil(t, os.WriteFile(fioctl.Config, []byte(config), 0o600))
+	replayed := fioctl.MustRun("--replay", cassette, "devices", "list")
+	assert.Equal(t, recorded.Stdout, replayed.Stdout)
+
+	res := fioctl.Run("--replay", cassette, "devices", "show", "dev-2")
+	assert.Equal(t, subcommands.ExitNetwork, res.ExitCode)
+	assert.Contains(t, res.Stdout, "No recorded response")
+
+	res = fioctl.Run("--replay", cassette, "--record", cassette, "devices", "list")
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+}
+
+func TestOutputFormats(t *testing.T) {
+	_, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", TargetName: "acme-lmp-1"},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", TargetName: "acme-lmp-2"},
+		},
+		Waves: []client.Wave{{Name: "wave-1", Version: "1", Tag: "prod", Status: "complete"}},
+	})
+
+	res := fioctl.MustRun("devices", "list", "-o", "json")
+	var devices []client.Device
+	require.Nil(t, json.Unmarshal([]byte(res.Stdout), &devices))
+	require.Len(t, devices, 2)
+	assert.Equal(t, "uuid-2", devices[1].Uuid)
+
+	res = fioctl.MustRun("devices", "show", "dev-1", "-o", "yaml")
+	var device map[string]interface{}
+	require.Nil(t, yaml.Unmarshal([]byte(res.Stdout), &device))
+	assert.Equal(t, "uuid-1", device["uuid"])
+
+	res = fioctl.MustRun("devices", "list", "-o", "csv")
+	lines := strings.Split(strings.TrimSpace(res.Stdout), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "uuid,name,"), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "uuid-1,dev-1,"), lines[1])
+
+	res = fioctl.MustRun("devices", "list", "-o", `go-template={{range .}}{{.name}}={{get . "target-name"}}{{"\n"}}{{end}}`)
+	assert.Equal(t, "dev-1=acme-lmp-1\ndev-2=acme-lmp-2\n", res.Stdout)
+
+	res = fioctl.MustRun("waves", "list", "-o", "json")
+	assert.Contains(t, res.Stdout, `"name": "wave-1"`)
+
+	res = fioctl.MustRun("devices", "list", "no-match-*", "-o", "json")
+	assert.Equal(t, "[]\n", res.Stdout)
+
+	res = fioctl.Run("devices", "list", "-o", "bogus")
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+	res = fioctl.Run("devices", "list", "-o", "go-template={{.")
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+}
+
+func TestDevicesBulk(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.Nil(t, err)
+	pubkey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", Tag: "devel", PublicKey: pubkey},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", Tag: "devel", PublicKey: pubkey},
+			{Name: "other", Uuid: "uuid-3", Factory: "acme", Tag: "prod"},
+		},
+		Groups: []client.DeviceGroup{{Name: "beta"}},
+	})
+	groups := func() map[string]string {
+		res := make(map[string]string)
+		for _, d := range srv.State().Devices {
+			res[d.Name] = d.GroupName
+		}
+		return res
+	}
+
+	res := fioctl.Run("devices", "bulk", "delete")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "explicitly select --all")
+	res = fioctl.Run("devices", "bulk", "set-group", "missing", "--by-tag", "devel")
+	assert.Contains(t, res.Stdout, "Device group missing does not exist")
+
+	res = fioctl.MustRun("devices", "bulk", "set-group", "beta", "--by-tag", "devel", "--dry-run")
+	assert.Contains(t, res.Stdout, `dev-1 (uuid-1): dry-run - group: "" -> "beta"`)
+	assert.NotContains(t, res.Stdout, "other")
+	assert.Equal(t, map[string]string{"dev-1": "", "dev-2": "", "other": ""}, groups())
+
+	report := filepath.Join(fioctl.Home, "report.csv")
+	fioctl.MustRun("devices", "bulk", "set-group", "beta", "--by-tag", "devel", "-j", "2",
+		"--report", report, "--report-format", "csv")
+	assert.Equal(t, map[string]string{"dev-1": "beta", "dev-2": "beta", "other": ""}, groups())
+	buf, err := os.ReadFile(report)
+	require.Nil(t, err)
+	assert.Equal(t, `uuid,name,status,details,error
+uuid-1,dev-1,ok,"group: """" -> ""beta""",
+uuid-2,dev-2,ok,"group: """" -> ""beta""",
+`, string(buf))
+
+	res = fioctl.MustRun("devices", "bulk", "config", "updates", "--by-group", "beta", "--tag", "beta")
+	assert.Contains(t, res.Stdout, "ok:        2")
+	assert.Contains(t, srv.State().DeviceConfigs["uuid-1"][0].Files[0].Value, `tags = "beta"`)
+	res = fioctl.MustRun("devices", "bulk", "config", "updates", "--by-group", "beta", "--tag", "beta")
+	assert.Contains(t, res.Stdout, "unchanged: 2")
+
+	fioctl.MustRun("devices", "bulk", "config", "set", "--name", "dev-*", "secret=hunter2")
+	files := srv.State().DeviceConfigs["uuid-2"][0].Files
+	require.Len(t, files, 2)
+	assert.Equal(t, "secret", files[1].Name)
+	assert.NotEqual(t, "hunter2", files[1].Value)
+
+	fioctl.Stdin = "n\n"
+	res = fioctl.MustRun("devices", "bulk", "delete", "--all")
+	assert.Contains(t, res.Stdout, "3 devices selected")
+	assert.Contains(t, res.Stdout, "Delete these 3 devices? [y/N]")
+	assert.Contains(t, res.Stdout, "No devices were changed")
+	assert.Len(t, srv.State().Devices, 3)
+
+	fioctl.Stdin = "uuid-3\n"
+	res = fioctl.Run("devices", "bulk", "delete", "--uuids-file", "-")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "use --yes to confirm")
+	assert.Len(t, srv.State().Devices, 3)
+
+	fioctl.Stdin = "uuid-3\n# comment\nuuid-missing\n"
+	report = filepath.Join(fioctl.Home, "report.json")
+	res = fioctl.Run("devices", "bulk", "delete", "--uuids-file", "-", "--report", report, "--yes")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Equal(t, map[string]string{"dev-1": "beta", "dev-2": "beta"}, groups())
+	buf, err = os.ReadFile(report)
+	require.Nil(t, err)
+	var results []map[string]string
+	require.Nil(t, json.Unmarshal(buf, &results))
+	require.Len(t, results, 2)
+	assert.Equal(t, "ok", results[0]["status"])
+	assert.Equal(t, "uuid-missing", results[1]["uuid"])
+	assert.Equal(t, "failed", results[1]["status"])
+}
+
+func TestDevicesListWhere(t *testing.T) {
+	recent := time.Now().UTC().Format(time.RFC3339)
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", TargetName: "acme-lmp-99", IsProd: true, DockerApps: []string{"shellhttpd"}},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", TargetName: "acme-lmp-120", IsProd: true, DockerApps: []string{"shellhttpd"}},
+			{Name: "dev-3", Uuid: "uuid-3", Factory: "acme", TargetName: "acme-lmp-99", IsProd: true, LastSeen: recent},
+			{Name: "dev-4", Uuid: "uuid-4", Factory: "acme", TargetName: "acme-lmp-99", DockerApps: []string{"shellhttpd"}},
+		},
+	})
+
+	res := fioctl.MustRun("devices", "list", "--columns", "name", "--limit", "10",
+		"--where", "is-prod and target < 120 and (apps contains shellhttpd or last-seen < 1h)")
+	assert.Contains(t, res.Stdout, "dev-1")
+	assert.NotContains(t, res.Stdout, "dev-2")
+	assert.Contains(t, res.Stdout, "dev-3")
+	assert.NotContains(t, res.Stdout, "dev-4")
+
+	// The is-prod term is filtered by the server
+	reqs := srv.Requests()
+	assert.Contains(t, reqs[len(reqs)-1].Query, "prod=1")
+
+	res = fioctl.MustRun("devices", "list", "--where", "last-seen > 1d", "-o", "go-template={{range .}}{{.name}} {{end}}")
+	assert.Equal(t, "dev-1 dev-2 dev-4 ", res.Stdout)
+
+	res = fioctl.Run("devices", "list", "--where", "no-such-field = 1")
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+}
+
+func TestDevicesWatch(t *testing.T) {
+	recent := time.Now().UTC().Format(time.RFC3339)
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", TargetName: "acme-lmp-1", LastSeen: recent},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", TargetName: "acme-lmp-1", LastSeen: recent},
+		},
+	})
+
+	// Update a device once the first poll is done
+	go func() {
+		for len(srv.Requests()) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		srv.Update(func(st *fakeapi.State) {
+			st.Devices[0].TargetName = "acme-lmp-2"
+			st.Devices = st.Devices[:1]
+		})
+	}()
+
+	log := filepath.Join(fioctl.Home, "rollout.jsonl")
+	res := fioctl.MustRun("devices", "watch", "--count", "2", "--interval", "1s", "--log", log, "--columns", "name,target")
+	assert.Regexp(t, `\*\s+dev-1\s+acme-lmp-2`, res.Stdout)
+	assert.Contains(t, res.Stdout, `dev-1 target-name: "acme-lmp-1" -> "acme-lmp-2"`)
+	assert.Contains(t, res.Stdout, `dev-2 device: "present" -> "removed"`)
+
+	buf, err := os.ReadFile(log)
+	require.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	require.Len(t, lines, 2)
+	var transition map[string]string
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &transition))
+	assert.Equal(t, "uuid-1", transition["uuid"])
+	assert.Equal(t, "target-name", transition["field"])
+	assert.Equal(t, "acme-lmp-2", transition["to"])
+}
+
+func TestDevicesExportSnapshot(t *testing.T) {
+	recent := time.Now().UTC().Format(time.RFC3339)
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	hw := json.RawMessage(`{"cpu":"imx8"}`)
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", GroupName: "alpha", TargetName: "acme-lmp-1", LmpVer: "95", LastSeen: recent, Hardware: &hw},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", TargetName: "acme-lmp-1", LastSeen: recent},
+			{Name: "dev-3", Uuid: "uuid-3", Factory: "acme", TargetName: "acme-lmp-1", LastSeen: old},
+		},
+		Groups: []client.DeviceGroup{{Name: "alpha"}, {Name: "beta"}},
+		DeviceConfigs: map[string][]client.DeviceConfig{
+			"uuid-1": {{Reason: "initial", Files: []client.ConfigFile{{Name: "foo", Value: "bar", Unencrypted: true}}}},
+		},
+		AppsStates: map[string]client.AppsStates{
+			"uuid-1": {States: []client.AppsState{{Ostree: "abc", Apps: map[string]client.AppState{"shellhttpd": {State: "healthy"}}}}},
+		},
+	})
+
+	for _, file := range []string{"devices.jsonl", "devices.csv", "devices.db"} {
+		res := fioctl.MustRun("devices", "export", file)
+		assert.Contains(t, res.Stdout, "Exported 3 devices to "+file)
+	}
+	res := fioctl.MustRun("devices", "export", "--where", "name = dev-1", "-")
+	var exported map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(res.Stdout), &exported))
+	assert.Equal(t, "alpha", exported["device-group"])
+	assert.Nil(t, exported["group"])
+	assert.Equal(t, "bar", exported["active-config"].(map[string]interface{})["files"].([]interface{})[0].(map[string]interface{})["value"])
+	assert.Equal(t, "imx8", exported["hardware-info"].(map[string]interface{})["cpu"])
+	assert.Contains(t, exported["apps-state"].(map[string]interface{})["apps"], "shellhttpd")
+
+	res = fioctl.Run("devices", "export", "devices.txt")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "use --format")
+
+	fioctl.MustRun("devices", "snapshot", "save", "before")
+	srv.Update(func(st *fakeapi.State) {
+		st.Devices[0].GroupName = "beta"
+		st.Devices[1].TargetName = "acme-lmp-2"
+		st.Devices[1].LastSeen = old
+		st.Devices = append(st.Devices[:2], client.Device{Name: "dev-4", Uuid: "uuid-4", Factory: "acme", LastSeen: recent})
+	})
+	fioctl.MustRun("devices", "snapshot", "save", "after")
+	res = fioctl.Run("devices", "snapshot", "save", "after")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "already exists")
+
+	res = fioctl.MustRun("devices", "snapshot", "list")
+	assert.Regexp(t, `after\s+3\s+`, res.Stdout)
+	assert.Regexp(t, `before\s+3\s+`, res.Stdout)
+
+	// Every export format can be compared with a snapshot
+	for _, before := range []string{"before", "devices.jsonl", "devices.csv", "devices.db"} {
+		res = fioctl.MustRun("devices", "snapshot", "diff", before, "after")
+		assert.Regexp(t, `added\s+dev-4\s+uuid-4`, res.Stdout, before)
+		assert.Regexp(t, `moved-group\s+dev-1\s+uuid-1\s+alpha\s+beta`, res.Stdout, before)
+		assert.Regexp(t, `offline\s+dev-2\s+uuid-2`, res.Stdout, before)
+	}
+
+	res = fioctl.MustRun("devices", "snapshot", "diff", "after", "after", "-o", "json")
+	assert.Equal(t, "[]\n", res.Stdout)
+
+	res = fioctl.Run("devices", "snapshot", "diff", "before", "missing")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "No snapshot or export file named missing")
+}
+
+func TestDevicesDiff(t *testing.T) {
+	toml := "[pacman]\ntags = \"main\"\n"
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", GroupName: "alpha", TargetName: "acme-lmp-1", OstreeHash: "abc", AktualizrToml: toml},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", GroupName: "beta", TargetName: "acme-lmp-1", OstreeHash: "abc", AktualizrToml: toml + "[extra]\n"},
+			{Name: "dev-3", Uuid: "uuid-3", Factory: "acme", GroupName: "alpha", TargetName: "acme-lmp-1", OstreeHash: "abc", AktualizrToml: toml},
+		},
+		Groups: []client.DeviceGroup{{Name: "alpha"}, {Name: "beta"}},
+		DeviceConfigs: map[string][]client.DeviceConfig{
+			"uuid-1": {{Files: []client.ConfigFile{
+				{Name: "env", Value: "A=1\nB=2", Unencrypted: true, OnChanged: []string{"/usr/bin/reload"}},
+				{Name: "secret", Value: "cipher-1"},
+			}}},
+			"uuid-2": {{Files: []client.ConfigFile{
+				{Name: "env", Value: "A=1\nB=3", Unencrypted: true, OnChanged: []string{"/usr/bin/reload"}},
+				{Name: "secret", Value: "cipher-2"},
+			}}},
+		},
+		AppsStates: map[string]client.AppsStates{
+			"uuid-1": {States: []client.AppsState{{Apps: map[string]client.AppState{
+				"shellhttpd": {State: "healthy", Services: []client.AppServiceState{{Name: "httpd", State: "running", Health: "healthy"}}},
+			}}}},
+			"uuid-2": {States: []client.AppsState{{Apps: map[string]client.AppState{
+				"shellhttpd": {State: "unhealthy", Services: []client.AppServiceState{{Name: "httpd", State: "running", Health: "unhealthy"}}},
+			}}}},
+		},
+	})
+
+	srv.Update(func(st *fakeapi.State) {
+		st.DeviceConfigs["uuid-3"] = st.DeviceConfigs["uuid-1"]
+		st.AppsStates["uuid-3"] = st.AppsStates["uuid-1"]
+	})
+
+	res := fioctl.MustRun("devices", "diff", "dev-1", "dev-2")
+	assert.Contains(t, res.Stdout, "--- dev-1 (uuid-1)\n+++ dev-2 (uuid-2)\n")
+	for _, line := range []string{
+		"-device-group: alpha\n+device-group: beta\n",
+		"-apps-state.shellhttpd: healthy\n",
+		"+apps-state.shellhttpd.httpd: state=running health=unhealthy\n",
+		" config.env.on-changed: /usr/bin/reload\n config.env.value: A=1\n-config.env.value: B=2\n+config.env.value: B=3\n config.secret.value: <encrypted>\n",
+		"+aktualizr-toml: [extra]\n",
+	} {
+		assert.Contains(t, res.Stdout, line)
+	}
+
+	res = fioctl.MustRun("devices", "diff", "dev-1", "dev-2", "-U", "0")
+	assert.NotContains(t, res.Stdout, "target-name")
+	assert.Contains(t, res.Stdout, "@@\n-device-group: alpha\n+device-group: beta\n@@\n")
+
+	res = fioctl.MustRun("devices", "diff", "dev-1", "dev-3")
+	assert.Equal(t, "No differences between dev-1 and dev-3\n", res.Stdout)
+
+	res = fioctl.Run("devices", "diff", "uuid-1", "dev-3", "--by-uuid")
+	assert.Equal(t, subcommands.ExitNotFound, res.ExitCode)
+}
+
+func TestDevicesUpdatesTimelineFailures(t *testing.T) {
+	ok, failed := true, false
+	event := func(at time.Time, id, target string, success *bool, details string) client.UpdateEvent {
+		return client.UpdateEvent{
+			Time:   at.UTC().Format(time.RFC3339),
+			Type:   client.EventType{Id: id},
+			Detail: client.EventDetail{Ecu: "ecu-1", TargetName: target, Success: success, Details: details},
+		}
+	}
+	update := func(id string, at time.Time, target string) client.Update {
+		return client.Update{CorrelationId: id, Target: target, Time: at.UTC().Format(time.RFC3339)}
+	}
+	start := time.Now().Add(-24 * time.Hour)
+	rebootFailure := []client.UpdateEvent{
+		event(start, "EcuDownloadStarted", "acme-lmp-2", nil, ""),
+		event(start.Add(12*time.Second), "EcuDownloadCompleted", "acme-lmp-2", &ok, ""),
+		event(start.Add(15*time.Second), "EcuInstallationStarted", "acme-lmp-2", nil, ""),
+		event(start.Add(75*time.Second), "EcuInstallationApplied", "acme-lmp-2", &ok, ""),
+		event(start.Add(195*time.Second), "EcuInstallationCompleted", "acme-lmp-2", &failed, "Apps failed to start\nshellhttpd: exited"),
+	}
+	_, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", GroupName: "beta"},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", GroupName: "beta"},
+			{Name: "dev-3", Uuid: "uuid-3", Factory: "acme", GroupName: "alpha"},
+		},
+		DeviceUpdates: map[string][]client.Update{
+			"uuid-1": {update("u1-2", start, "acme-lmp-2"), update("u1-1", start.Add(-30*24*time.Hour), "acme-lmp-1")},
+			"uuid-2": {update("u2-3", start, "acme-lmp-2"), update("u2-2", start.Add(-time.Hour), "acme-lmp-2"), update("u2-1", start.Add(-2*time.Hour), "acme-lmp-1")},
+			"uuid-3": {update("u3-1", start, "acme-lmp-2")},
+		},
+		UpdateEvents: map[string][]client.UpdateEvent{
+			"u1-2": rebootFailure,
+			"u2-3": {event(start, "EcuDownloadStarted", "acme-lmp-2", nil, ""), event(start, "EcuDownloadCompleted", "acme-lmp-2", &failed, "")},
+			"u2-2": rebootFailure,
+			"u2-1": {event(start, "EcuInstallationStarted", "acme-lmp-1", nil, ""), event(start, "EcuInstallationCompleted", "acme-lmp-1", &ok, "")},
+			"u3-1": rebootFailure,
+		},
+	})
+
+	res := fioctl.MustRun("devices", "updates", "dev-1", "u1-2")
+	assert.Contains(t, res.Stdout, "ECU ecu-1, Target acme-lmp-2:\n")
+	assert.Regexp(t, `\+3m15s\s+EcuInstallationCompleted\s+FAILED\n\s+\| Apps failed to start\n\s+\| shellhttpd: exited`, res.Stdout)
+	assert.Regexp(t, `download\s+\S+\s+12s\s+ok\n`, res.Stdout)
+	assert.Regexp(t, `install\s+\S+\s+1m0s\s+ok\n`, res.Stdout)
+	assert.Regexp(t, `reboot\s+\S+\s+2m0s\s+ok\n`, res.Stdout)
+	assert.Regexp(t, `apps\s+\S+\s+-\s+failed: Apps failed to start\n`, res.Stdout)
+
+	res = fioctl.MustRun("devices", "updates", "failures", "--since", "2d")
+	assert.Contains(t, res.Stdout, "4 failed updates on 3 of 3 devices since")
+	assert.Regexp(t, `2\s+acme-lmp-1\s+acme-lmp-2\s+Apps failed to start\s+dev-1, dev-2\n`, res.Stdout)
+	assert.Regexp(t, `1\s+acme-lmp-1\s+acme-lmp-2\s+EcuDownloadCompleted failed\s+dev-2\n`, res.Stdout)
+	assert.Regexp(t, `1\s+acme-lmp-2\s+Apps failed to start\s+dev-3\n`, res.Stdout)
+
+	res = fioctl.MustRun("devices", "updates", "failures", "--since", "2d", "--by-group", "beta", "-o", "json")
+	var groups []map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(res.Stdout), &groups))
+	require.Len(t, groups, 2)
+	assert.Equal(t, float64(2), groups[0]["count"])
+
+	res = fioctl.Run("devices", "updates", "failures", "--since", "yesterday")
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+}
+
+func TestDevicesAppsHealth(t *testing.T) {
+	appV1 := "hub.foundries.io/acme/shellhttpd@sha256:1111111111111111"
+	appV2 := "hub.foundries.io/acme/shellhttpd@sha256:2222222222222222"
+	imgV1 := "hub.foundries.io/acme/httpd@sha256:aaaaaaaaaaaaaaaa"
+	imgV2 := "hub.foundries.io/acme/httpd@sha256:bbbbbbbbbbbbbbbb"
+	raw, err := json.Marshal(client.TufCustom{
+		Version: "2", TargetFormat: "OSTREE", ComposeApps: map[string]client.ComposeApp{"shellhttpd": {Uri: appV2}},
+	})
+	require.Nil(t, err)
+	custom := canonical.RawMessage(raw)
+
+	state := func(appUri, appState, imageUri, health, svcState string) client.AppsStates {
+		return client.AppsStates{States: []client.AppsState{{Apps: map[string]client.AppState{
+			"shellhttpd": {Uri: appUri, State: appState, Services: []client.AppServiceState{
+				{Name: "httpd", ImageUri: imageUri, Health: health, State: svcState, Status: "Up 2 minutes"},
+			}},
+		}}}}
+	}
+	_, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", TargetName: "acme-lmp-2"},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", TargetName: "acme-lmp-2"},
+			{Name: "dev-3", Uuid: "uuid-3", Factory: "acme", TargetName: "acme-lmp-2"},
+			{Name: "dev-4", Uuid: "uuid-4", Factory: "acme", TargetName: "acme-lmp-2"},
+			{Name: "dev-5", Uuid: "uuid-5", Factory: "acme", TargetName: "acme-lmp-2"},
+		},
+		Targets: tuf.Files{"acme-lmp-2": {Hashes: tuf.Hashes{"sha256": []byte("1234")}, Custom: &custom}},
+		ComposeApps: map[string]client.ComposeAppBundle{
+			"acme-lmp-2/shellhttpd": {Uri: appV2, Content: client.ComposeAppContent{ComposeSpec: map[string]interface{}{
+				"services": map[string]interface{}{"httpd": map[string]interface{}{"image": imgV2}},
+			}}},
+		},
+		AppsStates: map[string]client.AppsStates{
+			"uuid-1": state(appV2, "healthy", imgV2, "healthy", "running"),
+			"uuid-2": state(appV1, "unhealthy", imgV1, "unhealthy", "restarting"),
+			"uuid-3": {States: []client.AppsState{{Apps: map[string]client.AppState{"extra": {State: "healthy"}}}}},
+			"uuid-5": state(appV2, "healthy", imgV1, "healthy", "running"),
+		},
+	})
+
+	res := fioctl.MustRun("devices", "apps-health")
+	assert.Contains(t, res.Stdout, "Apps of 5 devices, 1 did not report App states: dev-4\n")
+	assert.Regexp(t, `shellhttpd\s+3\s+1\s+1\s+0\s+1\n`, res.Stdout)
+	assert.Regexp(t, `extra\s+1\s+0\s+0\s+1\s+0\n`, res.Stdout)
+	assert.Regexp(t, `httpd\s+3\s+1\s+1\s+sha256:aaaaaaaaaaaa \(2\), sha256:bbbbbbbbbbbb \(1, expected\)\n`, res.Stdout)
+	assert.Regexp(t, `dev-2\s+outdated\s+sha256:111111111111, expected sha256:222222222222`, res.Stdout)
+	assert.Regexp(t, `dev-5\s+httpd\s+wrong-image\s+sha256:aaaaaaaaaaaa, expected sha256:bbbbbbbbbbbb`, res.Stdout)
+
+	res = fioctl.MustRun("devices", "apps-health", "--app", "shellhttpd", "--max-outliers", "2")
+	assert.NotContains(t, res.Stdout, "extra")
+	assert.Contains(t, res.Stdout, "and 4 more")
+
+	res = fioctl.MustRun("devices", "apps-health", "--where", "name = dev-1", "-o", "json")
+	var report map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(res.Stdout), &report))
+	assert.Equal(t, float64(1), report["devices"])
+	assert.Empty(t, report["apps"].([]interface{})[0].(map[string]interface{})["outliers"])
+}
+
+func TestDevicesPrune(t *testing.T) {
+	recent := time.Now().UTC().Format(time.RFC3339)
+	old := time.Now().Add(-40 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", GroupName: "alpha", TargetName: "acme-lmp-1", LastSeen: old},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", GroupName: "alpha", LastSeen: recent},
+			{Name: "dev-3", Uuid: "uuid-3", Factory: "acme", GroupName: "beta", LastSeen: old, IsProd: true},
+			{Name: "dev-4", Uuid: "uuid-4", Factory: "acme", ChangeMeta: client.ChangeMeta{CreatedAt: old}},
+		},
+		Groups: []client.DeviceGroup{{Name: "alpha"}, {Name: "beta"}},
+		DeviceConfigs: map[string][]client.DeviceConfig{
+			"uuid-1": {
+				{CreatedAt: "2024-02-01", Reason: "second", Files: []client.ConfigFile{{Name: "foo", Value: "2", Unencrypted: true}}},
+				{CreatedAt: "2024-01-01", Reason: "first", Files: []client.ConfigFile{{Name: "foo", Value: "1", Unencrypted: true}}},
+			},
+		},
+		DeviceUpdates: map[string][]client.Update{
+			"uuid-1": {{CorrelationId: "upd-1", Target: "acme-lmp-1", Time: old}},
+		},
+	})
+
+	res := fioctl.Run("devices", "prune", "--dry-run")
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+
+	res = fioctl.MustRun("devices", "prune", "--inactive-days", "30", "--exclude-prod", "--dry-run")
+	assert.Contains(t, res.Stdout, "dev-1")
+	assert.Contains(t, res.Stdout, "dev-4")
+	assert.NotContains(t, res.Stdout, "dev-2")
+	assert.NotContains(t, res.Stdout, "dev-3")
+	assert.Contains(t, res.Stdout, "Would back up and delete 2 devices")
+	assert.NoFileExists(t, filepath.Join(fioctl.Home, "backup.tar.gz"))
+
+	fioctl.Stdin = "n\n"
+	res = fioctl.MustRun("devices", "prune", "--inactive-days", "30", "--by-group", "alpha", "--backup", "declined.tar.gz")
+	assert.Contains(t, res.Stdout, "No devices were deleted")
+	assert.FileExists(t, filepath.Join(fioctl.Home, "declined.tar.gz"))
+	assert.Len(t, srv.State().Devices, 4)
+
+	fioctl.Stdin = "y\n"
+	res = fioctl.MustRun("devices", "prune", "--inactive-days", "30", "--exclude-prod", "--backup", "backup.tar.gz")
+	assert.Contains(t, res.Stdout, "Deleted 2 of 2 devices, backup saved to backup.tar.gz")
+	var names []string
+	for _, d := range srv.State().Devices {
+		names = append(names, d.Name)
+	}
+	assert.Equal(t, []string{"dev-2", "dev-3"}, names)
+
+	res = fioctl.Run("devices", "prune", "--inactive-days", "30", "--backup", "backup.tar.gz", "-y")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "Backup file exists")
+
+	res = fioctl.MustRun("devices", "restore-report", "backup.tar.gz")
+	assert.Contains(t, res.Stdout, "Inactive for:\t30 days")
+	assert.Regexp(t, `dev-1\s+uuid-1\s+alpha\s+acme-lmp-1\s+\S+\s+2\s+1`, res.Stdout)
+	assert.Regexp(t, `dev-4\s+uuid-4\s+0\s+0`, res.Stdout)
+
+	res = fioctl.MustRun("devices", "restore-report", "backup.tar.gz", "dev-1")
+	assert.Contains(t, res.Stdout, "2024-02-01: second")
+	assert.Contains(t, res.Stdout, "upd-1")
+
+	res = fioctl.MustRun("devices", "restore-report", "backup.tar.gz", "uuid-1", "-o", "json")
+	var saved map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(res.Stdout), &saved))
+	assert.Len(t, saved["config-history"], 2)
+
+	res = fioctl.Run("devices", "restore-report", "backup.tar.gz", "dev-2")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "not in the backup")
+}
+
+func TestKeysCaIssueDeviceCert(t *testing.T) {
+	srv, fioctl := newFactory(t, fakeapi.State{})
+	pki := filepath.Join(fioctl.Home, "pki")
+	require.Nil(t, os.Mkdir(pki, 0o700))
+	func() {
+		t.Chdir(pki)
+		fiox509.CreateFactoryCa("acme")
+		fiox509.CreateDeviceCa("fio-user", "acme")
+	}()
+	csr := func(cn, ou string) string {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.Nil(t, err)
+		req := &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn, OrganizationalUnit: []string{ou}}}
+		der, err := x509.CreateCertificateRequest(rand.Reader, req, key)
+		require.Nil(t, err)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	}
+	csrs := filepath.Join(fioctl.Home, "csrs")
+	require.Nil(t, os.Mkdir(csrs, 0o700))
+	require.Nil(t, os.WriteFile(filepath.Join(csrs, "a.csr"), []byte(csr("uuid-1", "acme")), 0o600))
+	require.Nil(t, os.WriteFile(filepath.Join(csrs, "b.csr"), []byte(csr("uuid-2", "acme")), 0o600))
+
+	res := fioctl.MustRun("keys", "ca", "issue-device-cert", "pki", "--csr", "csrs", "--uuid", "uuid-3", "-O", "out")
+	assert.Contains(t, res.Stdout, "Issued 3 device certificates for factory acme")
+	assert.Empty(t, srv.Requests())
+	for _, uuid := range []string{"uuid-1", "uuid-2", "uuid-3"} {
+		crtPem, err := os.ReadFile(filepath.Join(fioctl.Home, "out", uuid, "client.pem"))
+		require.Nil(t, err)
+		block, _ := pem.Decode(crtPem)
+		crt, err := x509.ParseCertificate(block.Bytes)
+		require.Nil(t, err)
+		assert.Equal(t, uuid, crt.Subject.CommonName)
+		assert.Equal(t, []string{"acme"}, crt.Subject.OrganizationalUnit)
+		assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, crt.ExtKeyUsage)
+		assert.FileExists(t, filepath.Join(fioctl.Home, "out", uuid, "root.crt"))
+	}
+	assert.NoFileExists(t, filepath.Join(fioctl.Home, "out", "uuid-1", "pkey.pem"))
+	assert.FileExists(t, filepath.Join(fioctl.Home, "out", "uuid-3", "pkey.pem"))
+
+	fioctl.Stdin = csr("uuid-4", "acme") + csr("uuid-5", "acme")
+	res = fioctl.MustRun("keys", "ca", "issue-device-cert", "pki", "--csr", "-", "-O", "out")
+	assert.Regexp(t, `uuid-4\s+stdin#1`, res.Stdout)
+	assert.Regexp(t, `uuid-5\s+stdin#2`, res.Stdout)
+
+	res = fioctl.Run("keys", "ca", "issue-device-cert", "pki", "--uuid", "uuid-1", "-O", "out")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "A bundle for device uuid-1 already exists")
+
+	fioctl.Stdin = csr("uuid-6", "other")
+	res = fioctl.Run("keys", "ca", "issue-device-cert", "pki", "--csr", "-", "--uuid", "uuid-7", "-O", "out")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "is for the factory other rather than acme")
+	assert.NoDirExists(t, filepath.Join(fioctl.Home, "out", "uuid-7"))
+
+	// A manufacturing line has neither credentials nor a factory configured
+	require.Nil(t, os.WriteFile(fioctl.Config, nil, 0o600))
+	res = fioctl.MustRun("keys", "ca", "issue-device-cert", "pki", "--uuid", "uuid-8", "-O", "out")
+	assert.Contains(t, res.Stdout, "Issued 1 device certificates for factory acme")
+	assert.FileExists(t, filepath.Join(fioctl.Home, "out", "uuid-8", "client.pem"))
+}
+
+func TestDevicesImport(t *testing.T) {
+	hardware := func(serial string) *json.RawMessage {
+		hw := json.RawMessage(`{"class": "system", "serial": "` + serial + `"}`)
+		return &hw
+	}
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", Hardware: hardware("S1")},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", GroupName: "alpha", Owner: "u-1", Hardware: hardware("S2")},
+			{Name: "dev-3", Uuid: "uuid-3", Factory: "acme", Hardware: hardware("S3")},
+		},
+		Groups: []client.DeviceGroup{{Id: 1, Name: "alpha"}},
+		Users:  []client.FactoryUser{{PolisId: "u-1", Name: "Alice"}, {PolisId: "u-2", Name: "Bob"}},
+	})
+	mapping := filepath.Join(fioctl.Home, "mapping.csv")
+	write := func(content string) {
+		require.Nil(t, os.WriteFile(mapping, []byte(content), 0o600))
+	}
+
+	write("serial,uuid,site,name,group,owner\nS1,uuid-1,Oslo,oslo-1,alpha,bob\nS9,uuid-9,Rome,rome-1,beta,carol\nS2,uuid-2,Oslo,dev-3,,\n")
+	res := fioctl.Run("devices", "import", "-F", "mapping.csv", "--columns", "serial:-,uuid,-,name,group,owner", "--skip-header")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, `Line 3: No device with the UUID "uuid-9"`)
+	assert.Contains(t, res.Stdout, "Line 4: The name dev-3 is used by the device uuid-3")
+	assert.Contains(t, res.Stdout, "Found 2 problems in mapping.csv, no devices were changed")
+
+	// The devices are found by their serial, which must agree with their UUID
+	write("S1,oslo-1\n")
+	res = fioctl.MustRun("devices", "import", "-f", "acme", "-F", "mapping.csv", "--columns", "serial,name", "--dry-run")
+	assert.Regexp(t, `dev-1\s+uuid-1\s+rename\s+dev-1\s+oslo-1`, res.Stdout)
+	write("S2,uuid-3\n")
+	res = fioctl.Run("devices", "import", "-F", "mapping.csv", "--columns", "serial,uuid", "--dry-run")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "Line 1: The uuid finds the device dev-3, but the serial finds dev-2")
+
+	// The header names the columns, and the other columns are ignored
+	write("\ufeffSerial,UUID,Site,Name,Group,Owner\nS1,uuid-1,Oslo,oslo-1,alpha,bob\nS3,uuid-3,Rome,rome-1,rome,\nS2,uuid-2,Oslo,,alpha,u-1\n")
+	res = fioctl.Run("devices", "import", "-F", "mapping.csv", "--dry-run")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, `Line 3: No device group named "rome", use --create-groups to create it`)
+
+	res = fioctl.MustRun("devices", "import", "-F", "mapping.csv", "--create-groups", "--dry-run")
+	assert.Regexp(t, `dev-1\s+uuid-1\s+rename\s+dev-1\s+oslo-1`, res.Stdout)
+	assert.Regexp(t, `dev-1\s+uuid-1\s+group\s+alpha`, res.Stdout)
+	assert.Regexp(t, `dev-1\s+uuid-1\s+owner\s+u-2`, res.Stdout)
+	assert.Regexp(t, `dev-3\s+uuid-3\s+group\s+rome`, res.Stdout)
+	assert.NotContains(t, res.Stdout, "uuid-2")
+	assert.Contains(t, res.Stdout, "Device groups to create: rome")
+	assert.Contains(t, res.Stdout, "2 renames, 2 group changes, 1 owner changes on 2 devices")
+
+	fioctl.Stdin = "n\n"
+	res = fioctl.MustRun("devices", "import", "-F", "mapping.csv", "--create-groups")
+	assert.Contains(t, res.Stdout, "No devices were changed")
+	assert.Len(t, srv.State().Groups, 1)
+
+	fioctl.Stdin = "y\n"
+	res = fioctl.MustRun("devices", "import", "-F", "mapping.csv", "--create-groups")
+	assert.Contains(t, res.Stdout, "Created device group rome")
+	assert.Contains(t, res.Stdout, "Changed 2 of 2 devices")
+	devices := srv.State().Devices
+	assert.Equal(t, "oslo-1", devices[0].Name)
+	assert.Equal(t, "alpha", devices[0].GroupName)
+	assert.Equal(t, "u-2", devices[0].Owner)
+	assert.Equal(t, "rome-1", devices[2].Name)
+	assert.Equal(t, "rome", devices[2].GroupName)
+
+	res = fioctl.MustRun("devices", "import", "-F", "mapping.csv")
+	assert.Contains(t, res.Stdout, "All 3 devices are up to date")
+}
+
+func TestDevicesConfigEffective(t *testing.T) {
+	handler := []string{"/usr/share/fioconfig/handlers/aktualizr-toml-update"}
+	_, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", GroupName: "beta"},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme"},
+		},
+		Groups: []client.DeviceGroup{{Id: 1, Name: "beta"}},
+		FactoryConfigs: []client.DeviceConfig{
+			{CreatedAt: "2024-03-01", Reason: "factory latest", Files: []client.ConfigFile{
+				{Name: "z-50-fioctl.toml", Value: "[pacman]\ntags = \"main\"\n", Unencrypted: true, OnChanged: handler},
+				{Name: "banner", Value: "acme", Unencrypted: true},
+				{Name: "motd", Value: "factory", Unencrypted: true},
+			}},
+			{CreatedAt: "2024-01-01", Reason: "factory old", Files: []client.ConfigFile{{Name: "old", Value: "x", Unencrypted: true}}},
+		},
+		GroupConfigs: map[string][]client.DeviceConfig{
+			"beta": {{CreatedAt: "2024-03-02", Reason: "beta tag", Files: []client.ConfigFile{
+				{Name: "z-50-fioctl.toml", Value: "[pacman]\ntags = \"beta\"\n", Unencrypted: true, OnChanged: handler},
+				{Name: "motd", Value: "group", Unencrypted: true},
+			}}},
+		},
+		DeviceConfigs: map[string][]client.DeviceConfig{
+			"uuid-1": {{CreatedAt: "2024-03-03", Reason: "secrets", Files: []client.ConfigFile{
+				{Name: "motd", Value: "device", Unencrypted: true},
+				{Name: "token", Value: "c2VjcmV0", OnChanged: []string{"/bin/true"}},
+			}}},
+		},
+	})
+
+	res := fioctl.MustRun("devices", "config", "effective", "dev-1")
+	assert.Regexp(t, `group beta 2024-03-02 - beta tag`, res.Stdout)
+	assert.Regexp(t, `motd\s+device\s+group, factory\s*\n`, res.Stdout)
+	assert.Regexp(t, `z-50-fioctl.toml\s+group\s+factory\s+/usr/share/fioconfig/handlers/aktualizr-toml-update`, res.Stdout)
+	assert.Contains(t, res.Stdout, "token:\n  | <encrypted>")
+	assert.Contains(t, res.Stdout, "z-50-fioctl.toml:\n  | [pacman]\n  | tags = \"beta\"")
+	assert.NotContains(t, res.Stdout, "old")
+
+	res = fioctl.MustRun("devices", "config", "effective", "dev-1", "-o", "json")
+	assert.NotContains(t, res.Stdout, "c2VjcmV0")
+	var effective struct {
+		Files []struct {
+			Name  string `json:"name"`
+			Layer string `json:"layer"`
+			Value string `json:"value"`
+		} `json:"files"`
+	}
+	require.Nil(t, json.Unmarshal([]byte(res.Stdout), &effective))
+	require.Len(t, effective.Files, 4)
+	assert.Equal(t, "device", effective.Files[1].Layer)
+	assert.Equal(t, "device", effective.Files[1].Value)
+
+	res = fioctl.MustRun("devices", "config", "effective", "dev-2", "--no-values")
+	assert.Contains(t, res.Stdout, "device   (no config)")
+	assert.Regexp(t, `motd\s+factory`, res.Stdout)
+	assert.NotContains(t, res.Stdout, "motd:")
+}
+
+func TestConfigDiffRollback(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.Nil(t, err)
+	pubkey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", PublicKey: pubkey}},
+		Groups:  []client.DeviceGroup{{Id: 1, Name: "beta"}},
+		FactoryConfigs: []client.DeviceConfig{
+			{CreatedAt: "2024-02-01", Reason: "second", Files: []client.ConfigFile{
+				{Name: "motd", Value: "hello\nworld\n", Unencrypted: true, OnChanged: []string{"/bin/true"}},
+				{Name: "b", Value: "2", Unencrypted: true},
+			}},
+			{CreatedAt: "2024-01-01", Reason: "first", Files: []client.ConfigFile{
+				{Name: "motd", Value: "hello\nthere\n", Unencrypted: true},
+				{Name: "a", Value: "1", Unencrypted: true},
+			}},
+		},
+		GroupConfigs: map[string][]client.DeviceConfig{
+			"beta": {
+				{CreatedAt: "2024-02-02", Reason: "beta 2", Files: []client.ConfigFile{{Name: "tag", Value: "beta", Unencrypted: true}}},
+				{CreatedAt: "2024-01-02", Reason: "beta 1", Files: []client.ConfigFile{{Name: "tag", Value: "main", Unencrypted: true}}},
+			},
+		},
+		DeviceConfigs: map[string][]client.DeviceConfig{
+			"uuid-1": {
+				{CreatedAt: "2024-02-03", Reason: "rotated", Files: []client.ConfigFile{
+					{Name: "token", Value: "bmV3"},
+					{Name: "cert", Value: "c2FtZQ=="},
+				}},
+				{CreatedAt: "2024-01-03", Reason: "initial", Files: []client.ConfigFile{
+					{Name: "token", Value: "b2xk"},
+					{Name: "cert", Value: "c2FtZQ=="},
+					{Name: "motd", Value: "device", Unencrypted: true},
+				}},
+			},
+		},
+	})
+
+	res := fioctl.MustRun("config", "log")
+	assert.NotContains(t, res.Stdout, "Entry:")
+	res = fioctl.MustRun("config", "log", "--numbered")
+	assert.Contains(t, res.Stdout, "Entry:         0")
+	assert.Contains(t, res.Stdout, "Entry:         1")
+
+	res = fioctl.MustRun("config", "diff", "1", "0")
+	assert.Contains(t, res.Stdout, "--- entry 1:")
+	assert.Contains(t, res.Stdout, "+++ entry 0:")
+	assert.Contains(t, res.Stdout, "a: removed")
+	assert.Contains(t, res.Stdout, "b: added")
+	assert.Contains(t, res.Stdout, "-there")
+	assert.Contains(t, res.Stdout, "+world")
+	assert.Contains(t, res.Stdout, "/bin/true")
+
+	res = fioctl.MustRun("config", "diff", "0", "0")
+	assert.Contains(t, res.Stdout, "No differences")
+
+	res = fioctl.Run("config", "diff", "0", "5")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+
+	res = fioctl.MustRun("config", "diff", "1", "0", "--group", "beta")
+	assert.Contains(t, res.Stdout, "-main")
+	assert.Contains(t, res.Stdout, "+beta")
+
+	res = fioctl.Run("config", "rollback", "0")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+
+	res = fioctl.MustRun("config", "rollback", "1", "--reason", "undo")
+	assert.Contains(t, res.Stdout, "Rolled back to changelog entry 1 of 2024-01-01")
+	cfgs := srv.State().FactoryConfigs
+	require.Len(t, cfgs, 3)
+	assert.Equal(t, "undo", cfgs[0].Reason)
+	assert.Equal(t, cfgs[2].Files, cfgs[0].Files)
+
+	fioctl.MustRun("config", "rollback", "1", "-g", "beta")
+	cfgs = srv.State().GroupConfigs["beta"]
+	require.Len(t, cfgs, 3)
+	assert.Equal(t, "Roll back to the config of 2024-01-02", cfgs[0].Reason)
+	assert.Equal(t, "main", cfgs[0].Files[0].Value)
+
+	res = fioctl.MustRun("devices", "config", "diff", "dev-1", "1", "0")
+	assert.Contains(t, res.Stdout, "token: encrypted value changed")
+	assert.Contains(t, res.Stdout, "motd: removed")
+	assert.NotContains(t, res.Stdout, "cert:")
+	assert.NotContains(t, res.Stdout, "b2xk")
+
+	res = fioctl.Run("devices", "config", "rollback", "dev-1", "1")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "Unable to roll back the encrypted files: token")
+	require.Len(t, srv.State().DeviceConfigs["uuid-1"], 2)
+
+	res = fioctl.Run("devices", "config", "rollback", "dev-1", "1", "--value", "other=x")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "The file other is not in changelog entry 1")
+
+	fioctl.MustRun("devices", "config", "rollback", "dev-1", "1", "--value", "token=old")
+	cfgs = srv.State().DeviceConfigs["uuid-1"]
+	require.Len(t, cfgs, 3)
+	files := make(map[string]client.ConfigFile)
+	for _, f := range cfgs[0].Files {
+		files[f.Name] = f
+	}
+	require.Len(t, files, 3)
+	assert.False(t, files["token"].Unencrypted)
+	assert.NotEqual(t, "b2xk", files["token"].Value)
+	assert.NotEqual(t, "old", files["token"].Value)
+	assert.Equal(t, "c2FtZQ==", files["cert"].Value)
+	assert.Equal(t, "device", files["motd"].Value)
+}
+
+func TestDevicesConfigSetTemplate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.Nil(t, err)
+	pubkey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Devices: []client.Device{
+			{Name: "dev-1", Uuid: "uuid-1", Factory: "acme", GroupName: "lab", PublicKey: pubkey},
+			{Name: "dev-2", Uuid: "uuid-2", Factory: "acme", GroupName: "lab", PublicKey: pubkey},
+			{Name: "dev-3", Uuid: "uuid-3", Factory: "acme"},
+		},
+		Groups:        []client.DeviceGroup{{Id: 1, Name: "lab"}},
+		DeviceConfigs: map[string][]client.DeviceConfig{},
+	})
+	tmpl := "name={{.Name}}\nuuid={{.Uuid}}\ngroup={{.GroupName}}\nssid={{.Vars.ssid}}\n"
+	require.Nil(t, os.WriteFile(filepath.Join(fioctl.Home, "wifi.conf.tmpl"), []byte(tmpl), 0o644))
+	require.Nil(t, os.WriteFile(filepath.Join(fioctl.Home, "motd.tmpl"), []byte("Hello {{.Name}}\n"), 0o644))
+	vars := "\ufeffdevice,ssid\ndev-1,lab-a\ndev-2,\"lab, b\"\n"
+	require.Nil(t, os.WriteFile(filepath.Join(fioctl.Home, "vars.csv"), []byte(vars), 0o644))
+	require.Nil(t, os.WriteFile(filepath.Join(fioctl.Home, "partial.csv"), []byte("uuid,ssid\nuuid-1,x\n"), 0o644))
+
+	res := fioctl.Run("devices", "config", "set", "dev-1", "motd=hi", "--render-only", filepath.Join(fioctl.Home, "out"))
+	assert.Equal(t, subcommands.ExitUsage, res.ExitCode)
+	assert.Contains(t, res.Stdout, "The --render-only flag can only be used with --template")
+	assert.Empty(t, srv.State().DeviceConfigs)
+
+	res = fioctl.Run("devices", "config", "set", "--template", "wifi.conf.tmpl")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "or select them with --where or --vars")
+
+	res = fioctl.MustRun("devices", "config", "set", "--template", "wifi.conf.tmpl", "--template", "banner=motd.tmpl",
+		"--vars", "vars.csv", "--render-only", "rendered")
+	assert.Contains(t, res.Stdout, "Rendered 4 files for 2 devices into rendered")
+	data, err := os.ReadFile(filepath.Join(fioctl.Home, "rendered", "dev-2", "wifi.conf"))
+	require.Nil(t, err)
+	assert.Equal(t, "name=dev-2\nuuid=uuid-2\ngroup=lab\nssid=lab, b\n", string(data))
+	data, err = os.ReadFile(filepath.Join(fioctl.Home, "rendered", "dev-1", "banner"))
+	require.Nil(t, err)
+	assert.Equal(t, "Hello dev-1\n", string(data))
+	assert.Empty(t, srv.State().DeviceConfigs)
+
+	// A device without variables fails the whole selection before anything is set
+	res = fioctl.Run("devices", "config", "set", "--template", "wifi.conf.tmpl", "--vars", "partial.csv", "--where", "group = lab")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "dev-2: Not in the variables file")
+	assert.Contains(t, res.Stdout, "no devices were changed")
+	res = fioctl.Run("devices", "config", "set", "--template", "wifi.conf.tmpl", "dev-1")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, `map has no entry for key "ssid"`)
+	assert.Empty(t, srv.State().DeviceConfigs)
+
+	res = fioctl.MustRun("devices", "config", "set", "--template", "wifi.conf.tmpl", "--vars", "vars.csv", "-m", "wifi")
+	assert.Contains(t, res.Stdout, "dev-1 (uuid-1): ok - files: wifi.conf")
+	assert.Contains(t, res.Stdout, "Configured 2 of 2 devices")
+	priv := ecies.ImportECDSA(key)
+	for _, uuid := range []string{"uuid-1", "uuid-2"} {
+		cfgs := srv.State().DeviceConfigs[uuid]
+		require.Len(t, cfgs, 1)
+		assert.Equal(t, "wifi", cfgs[0].Reason)
+		require.Len(t, cfgs[0].Files, 1)
+		f := cfgs[0].Files[0]
+		assert.False(t, f.Unencrypted)
+		enc, err := base64.StdEncoding.DecodeString(f.Value)
+		require.Nil(t, err)
+		plain, err := priv.Decrypt(rand.Reader, enc, nil, nil)
+		require.Nil(t, err)
+		assert.Contains(t, string(plain), "uuid="+uuid+"\n")
+	}
+
+	res = fioctl.Run("devices", "config", "set", "--template", "motd.tmpl", "dev-3")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "dev-3 (uuid-3): failed - files: motd - Device has no public key to encrypt with")
+	assert.Contains(t, res.Stdout, "Failed to configure: dev-3")
+}
+
+func TestConfigSetValidation(t *testing.T) {
+	srv, fioctl := newFactory(t, fakeapi.State{})
+
+	res := fioctl.Run("config", "set", "z-50-fioctl.toml=[pacman", "motd=hi")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "z-50-fioctl.toml: Invalid TOML")
+	assert.Contains(t, res.Stdout, "use --skip-validation to upload it anyway")
+	assert.Empty(t, srv.State().FactoryConfigs)
+
+	rules := "rules:\n  - name: no-debug\n    forbid: debug\n"
+	require.Nil(t, os.WriteFile(filepath.Join(fioctl.Home, subcommands.ConfigRulesFile), []byte(rules), 0o644))
+	res = fioctl.Run("config", "set", "motd=debug")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, `motd: The file matches "debug", forbidden by rule no-debug`)
+	assert.Empty(t, srv.State().FactoryConfigs)
+
+	fioctl.MustRun("config", "set", "z-50-fioctl.toml=[pacman", "--skip-validation")
+	require.Len(t, srv.State().FactoryConfigs, 1)
+}
+
+func TestPlanApply(t *testing.T) {
+	value := "v"
+	srv, fioctl := newFactory(t, fakeapi.State{
+		Groups: []client.DeviceGroup{{Id: 1, Name: "beta", Description: "old"}, {Id: 2, Name: "legacy"}},
+		FactoryConfigs: []client.DeviceConfig{{Files: []client.ConfigFile{
+			{Name: "motd", Value: "hello\n", Unencrypted: true},
+			{Name: "stale", Value: "x", Unencrypted: true},
+			{Name: "wireguard-server", Value: "endpoint=vpn:5555\nserver_address=10.0.0.1\npubkey=abc", Unencrypted: true,
+				OnChanged: []string{"/usr/share/fioconfig/handlers/factory-config-vpn"}},
+		}}},
+		EventQueues: []client.EventQueue{{Label: "old", Type: "push", PushUrl: "https://old"}},
+		Triggers: []client.ProjectTrigger{{Type: "simple", Id: 1, Secrets: []client.ProjectSecret{
+			{Name: "kept", Value: &value},
+		}}},
+	})
+
+	manifest := `factory: acme
+config:
+  - name: motd
+    value: "hello world\n"
+    unencrypted: true
+wireguard:
+  endpoint: vpn.acme:5555
+device-groups:
+  - name: beta
+    description: Beta devices
+    updates:
+      tag: beta
+  - name: prod
+event-queues:
+  - label: fleet
+    type: pull
+    creds-file: creds/fleet.json
+secrets:
+  - name: token
+    env: ACME_TOKEN
+`
+	path := filepath.Join(fioctl.Home, "factory.yml")
+	require.Nil(t, os.WriteFile(path, []byte(manifest), 0o644))
+
+	res := fioctl.Run("plan", "-F", path)
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "The secret token does not exist yet, and $ACME_TOKEN is not set")
+	fioctl.Env = append(fioctl.Env, "ACME_TOKEN=s3cret")
+
+	res = fioctl.MustRun("plan", "-F", path)
+	assert.Contains(t, res.Stdout, "Device groups:\n  ~ beta - description: \"old\" -> \"Beta devices\"\n  + prod\n")
+	assert.Contains(t, res.Stdout, "  ~ motd\n")
+	assert.Contains(t, res.Stdout, "+hello world")
+	assert.Contains(t, res.Stdout, "  ~ wireguard-server\n")
+	assert.Contains(t, res.Stdout, "+endpoint=vpn.acme:5555")
+	assert.Contains(t, res.Stdout, "Device group beta config:\n  + z-50-fioctl.toml\n")
+	assert.Contains(t, res.Stdout, "  + fleet - pull, credentials saved to creds/fleet.json\n")
+	assert.Contains(t, res.Stdout, "  + token - from $ACME_TOKEN\n")
+	assert.Contains(t, res.Stdout, "Plan: 4 to add, 3 to change, 0 to remove.\n")
+	assert.Contains(t, res.Stdout, "kept without --prune: device groups legacy, factory config stale, event queues old, secrets kept")
+	assert.Len(t, srv.State().Groups, 2)
+
+	fioctl.MustRun("apply", "-F", path, "--yes")
+	state := srv.State()
+	require.Len(t, state.Groups, 3)
+	assert.Equal(t, "Beta devices", state.Groups[0].Description)
+	files := state.FactoryConfigs[0].Files
+	require.Len(t, files, 3)
+	for _, f := range files {
+		switch f.Name {
+		case "motd":
+			assert.Equal(t, "hello world\n", f.Value)
+		case "wireguard-server":
+			assert.Equal(t, "endpoint=vpn.acme:5555\nserver_address=10.0.0.1\npubkey=abc", f.Value)
+		}
+	}
+	assert.Contains(t, state.GroupConfigs["beta"][0].Files[0].Value, `tags = "beta"`)
+	assert.Len(t, state.EventQueues, 2)
+	assert.FileExists(t, filepath.Join(fioctl.Home, "creds", "fleet.json"))
+	require.Len(t, state.Triggers[0].Secrets, 2)
+	assert.Equal(t, "s3cret", *state.Triggers[0].Secrets[1].Value)
+
+	res = fioctl.MustRun("plan", "-f", "acme", "-F", path)
+	assert.Contains(t, res.Stdout, "No changes, the Factory matches the manifest.")
+
+	fioctl.MustRun("apply", "-F", path, "--yes", "--prune")
+	state = srv.State()
+	assert.Len(t, state.Groups, 2)
+	assert.Len(t, state.FactoryConfigs[0].Files, 2)
+	require.Len(t, state.EventQueues, 1)
+	assert.Equal(t, "fleet", state.EventQueues[0].Label)
+	require.Len(t, state.Triggers[0].Secrets, 1)
+	assert.Equal(t, "token", state.Triggers[0].Secrets[0].Name)
+
+	res = fioctl.MustRun("plan", "-F", path, "--prune")
+	assert.Contains(t, res.Stdout, "No changes, the Factory matches the manifest.")
+	assert.NotContains(t, res.Stdout, "kept without --prune")
+
+	fioctl.Stdin = "device-groups:\n  - name: gamma\n"
+	res = fioctl.Run("apply", "-F", "-")
+	assert.Equal(t, subcommands.ExitError, res.ExitCode)
+	assert.Contains(t, res.Stdout, "use --yes to confirm the changes")
+	fioctl.Stdin = "device-groups:\n  - name: gamma\n"
+	fioctl.MustRun("apply", "-F", "-", "--yes")
+	assert.Len(t, srv.State().Groups, 3)
+}
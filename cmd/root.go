@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
+	"os/signal"
 	"strings"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/sirupsen/logrus"
@@ -24,6 +27,8 @@ import (
 	"github.com/foundriesio/fioctl/subcommands/keys"
 	"github.com/foundriesio/fioctl/subcommands/login"
 	"github.com/foundriesio/fioctl/subcommands/logout"
+	"github.com/foundriesio/fioctl/subcommands/manifest"
+	profilecmd "github.com/foundriesio/fioctl/subcommands/profile"
 	"github.com/foundriesio/fioctl/subcommands/secrets"
 	"github.com/foundriesio/fioctl/subcommands/status"
 	"github.com/foundriesio/fioctl/subcommands/targets"
@@ -35,8 +40,14 @@ import (
 
 var (
 	cfgFile string
+	profile string
 	config  client.Config
 	verbose bool
+	timeout time.Duration
+	record  string
+	replay  string
+
+	cancelTimeout context.CancelFunc = func() {}
 )
 
 var rootCmd = &cobra.Command{
@@ -63,18 +74,46 @@ func Execute() {
 		os.Exit(git.RunCredsHelper())
 	}
 
-	if err := rootCmd.Execute(); err != nil {
+	ctx, cancel := interruptContext()
+	defer cancel()
+	defer func() { cancelTimeout() }()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		// Commands handle their own errors via subcommands.DieNotNil.
+		// Those returned here are produced by cobra while parsing the command line.
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(subcommands.ExitUsage)
 	}
 }
 
+// interruptContext returns a context which is canceled on the first Ctrl-C, so that in-flight API
+// requests are aborted and partial downloads cleaned up. A second Ctrl-C terminates immediately.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		select {
+		case <-sigs:
+			signal.Stop(sigs)
+			fmt.Fprintln(os.Stderr, "Interrupted, aborting... (press Ctrl-C again to force exit)")
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(sigs)
+		}
+	}()
+	return ctx, cancel
+}
+
 func init() {
 	cobra.EnableTraverseRunHooks = true
 	cobra.OnInitialize(initConfig)
 
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is $HOME/.config/fioctl.yaml)")
+	rootCmd.PersistentFlags().StringVarP(&profile, "profile", "", "", "Use settings from this profile of the config file (default is the one set by \"fioctl profile use\")")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Print verbose logging")
+	rootCmd.PersistentFlags().StringVarP(&record, "record", "", "", "Record the API traffic of this command into a cassette file, with credentials redacted. An existing cassette is appended to")
+	rootCmd.PersistentFlags().StringVarP(&replay, "replay", "", "", "Replay the API responses from a cassette file made by --record without network access")
+	rootCmd.PersistentFlags().DurationVarP(&timeout, "timeout", "", 0, "Abort the command if it takes longer than this (e.g. 90s, 5m). Zero means no limit")
 
 	rootCmd.AddCommand(completionCmd)
 
@@ -88,6 +127,9 @@ func init() {
 	rootCmd.AddCommand(keys.NewCommand())
 	rootCmd.AddCommand(login.NewCommand())
 	rootCmd.AddCommand(logout.NewCommand())
+	rootCmd.AddCommand(manifest.NewApplyCommand())
+	rootCmd.AddCommand(manifest.NewPlanCommand())
+	rootCmd.AddCommand(profilecmd.NewCommand())
 	rootCmd.AddCommand(users.NewCommand())
 	rootCmd.AddCommand(teams.NewCommand())
 	rootCmd.AddCommand(secrets.NewCommand())
@@ -112,9 +154,35 @@ func rootArgValidation(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("Empty values or values containing only white space are not allowed for positional argument at %d\n", pos)
 		}
 	}
+	if err := subcommands.UseCassette(record, replay); err != nil {
+		return err
+	}
+	if timeout < 0 {
+		return fmt.Errorf("Invalid --timeout value: %s", timeout)
+	} else if timeout > 0 {
+		var ctx context.Context
+		ctx, cancelTimeout = context.WithTimeout(cmd.Context(), timeout)
+		cmd.SetContext(ctx)
+	}
+	subcommands.SetCommandContext(cmd.Context())
+	exitWhenDone(cmd.Context())
 	return nil
 }
 
+// Commands stop on their own once their context is done, but only where they pass it to the API
+// client. The others are given a moment to finish their current request, then terminated.
+const exitGracePeriod = 5 * time.Second
+
+func exitWhenDone(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		time.Sleep(exitGracePeriod)
+		err := ctx.Err()
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		os.Exit(subcommands.ExitCode(err))
+	}()
+}
+
 func getConfigDir() string {
 	config, err := homedir.Expand("~/.config")
 	if err != nil {
@@ -160,6 +228,22 @@ func initConfig() {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
+	// A profile can be selected by the --profile flag, FIOCTL_PROFILE env, or "fioctl profile use"
+	if len(profile) == 0 {
+		profile = viper.GetString("profile")
+	}
+	if len(profile) > 0 {
+		if err := subcommands.ActivateProfile(profile); err != nil {
+			fmt.Println("ERROR:", err)
+			os.Exit(subcommands.ExitUsage)
+		}
+	} else if current := viper.GetString("current_profile"); len(current) > 0 {
+		if err := subcommands.ActivateProfile(current); err != nil {
+			// Do not lock a user out of "fioctl profile use" by a hand-edited config
+			logrus.Warnf("%s, using the default profile", err)
+		}
+	}
+
 	if err := viper.Unmarshal(&config); err != nil {
 		panic(fmt.Sprintf("Unexpected failure parsing configuration: %s", err))
 	}
@@ -0,0 +1,64 @@
+package subcommands
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/client/cassette"
+)
+
+// A command can record its API traffic into a cassette for a bug report (--record),
+// or replay it from a cassette without network access (--replay).
+var (
+	recordPath string
+	recorder   *cassette.Recorder
+	replayer   *cassette.Replayer
+)
+
+// UseCassette makes the API clients of a command record their traffic to a cassette, or replay it
+// from one. At most one of the paths may be set.
+func UseCassette(record, replay string) error {
+	if len(record) > 0 && len(replay) > 0 {
+		return fmt.Errorf("The --record and --replay flags are mutually exclusive")
+	}
+	if len(replay) > 0 {
+		c, err := cassette.Load(replay)
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("Replaying %d API responses from %s", len(c.Interactions), replay)
+		replayer = cassette.NewReplayer(c)
+	}
+	recordPath = record
+	return nil
+}
+
+// Replaying tells if API responses come from a cassette rather than the server.
+func Replaying() bool {
+	return replayer != nil
+}
+
+func apiTransport(ca string) (http.RoundTripper, error) {
+	if replayer != nil {
+		return replayer, nil
+	}
+	if recorder != nil {
+		// All API clients of a command share the recorder to keep all traffic in one cassette
+		return recorder, nil
+	}
+	transport, err := client.NewTransport(Config.Transport, ca, Config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	if len(recordPath) == 0 {
+		return transport, nil
+	}
+	logrus.Debugf("Recording API traffic to %s", recordPath)
+	creds := Config.ClientCredentials
+	recorder = cassette.NewRecorder(transport, recordPath,
+		Config.Token, creds.AccessToken, creds.RefreshToken, creds.ClientSecret)
+	return recorder, nil
+}
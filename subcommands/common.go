@@ -1,21 +1,22 @@
 package subcommands
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io"
+	"iter"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/cheynewallace/tabby"
 	canonical "github.com/docker/go/canonical/json"
-	homedir "github.com/mitchellh/go-homedir"
 	"github.com/shurcooL/go/indentwriter"
-	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	yaml "gopkg.in/yaml.v2"
 
 	"github.com/foundriesio/fioctl/client"
 	"github.com/foundriesio/fioctl/subcommands/version"
@@ -30,8 +31,15 @@ func RequireFactory(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringP("token", "t", "", "API token from https://app.foundries.io/settings/tokens/")
 }
 
+// AddFileFlag adds the required --file flag of a command which reads its input from a file, or
+// from STDIN with "-". The -f shorthand is --factory on every command, so this one is -F.
+func AddFileFlag(cmd *cobra.Command, file *string, usage string) {
+	cmd.Flags().StringVarP(file, "file", "F", "", usage+". Use - to read it from STDIN")
+	_ = cmd.MarkFlagRequired("file")
+}
+
 func Login(cmd *cobra.Command) *client.Api {
-	ca := os.Getenv("CACERT")
+	ca := caCertPath()
 	DieNotNil(viper.BindPFlags(cmd.Flags()))
 	Config.Token = viper.GetString("token")
 	url := viper.GetString("server.url")
@@ -41,14 +49,22 @@ func Login(cmd *cobra.Command) *client.Api {
 	if viper.GetBool("server.insecure_skip_verify") {
 		Config.InsecureSkipVerify = true
 	}
-	if len(Config.Token) > 0 {
+	Config.Retries = client.RetryConfig{
+		MaxAttempts: viper.GetInt("server.retries.max_attempts"),
+		MinBackoff:  viper.GetDuration("server.retries.min_backoff"),
+		MaxBackoff:  viper.GetDuration("server.retries.max_backoff"),
+	}
+	Config.Transport = transportConfig()
+	// Replayed responses need no credentials, so that a cassette can be replayed offline anywhere
+	if len(Config.Token) > 0 || Replaying() {
 		if cmd.Flags().Lookup("factory") != nil && len(viper.GetString("factory")) == 0 {
 			DieNotNil(fmt.Errorf("Required flag \"factory\" not set"))
 		}
-		return client.NewApiClient(url, Config, ca, version.Commit)
+		return newApiClient(url, ca)
 	}
 
-	if len(Config.ClientCredentials.ClientId) == 0 {
+	LoadOauthSecrets()
+	if len(Config.ClientCredentials.ClientId) == 0 || len(Config.ClientCredentials.ClientSecret) == 0 {
 		DieNotNil(fmt.Errorf("Please run: \"fioctl login\" first"))
 	}
 	if cmd.Flags().Lookup("factory") != nil && len(viper.GetString("factory")) == 0 {
@@ -58,12 +74,13 @@ func Login(cmd *cobra.Command) *client.Api {
 	if viper.GetBool("server.insecure_skip_verify") {
 		creds.InsecureSSL = true
 	}
+	creds.Client = OauthHttpClient()
 
 	expired, err := creds.IsExpired()
 	DieNotNil(err)
 
 	if !expired && len(creds.Config.AccessToken) > 0 {
-		return client.NewApiClient(url, Config, ca, version.Commit)
+		return newApiClient(url, ca)
 	}
 
 	if len(creds.Config.AccessToken) == 0 {
@@ -75,7 +92,46 @@ func Login(cmd *cobra.Command) *client.Api {
 	}
 	SaveOauthConfig(creds.Config)
 	Config.ClientCredentials = creds.Config
-	return client.NewApiClient(url, Config, ca, version.Commit)
+	return newApiClient(url, ca)
+}
+
+func caCertPath() string {
+	if ca := os.Getenv("CACERT"); len(ca) > 0 {
+		return ca
+	}
+	return viper.GetString("server.cacert")
+}
+
+func transportConfig() client.TransportConfig {
+	return client.TransportConfig{
+		ConnectTimeout: viper.GetDuration("server.connect_timeout"),
+		ReadTimeout:    viper.GetDuration("server.read_timeout"),
+		IdleTimeout:    viper.GetDuration("server.idle_timeout"),
+		Proxy: client.ProxyConfig{
+			Http:    viper.GetString("server.proxy.http"),
+			Https:   viper.GetString("server.proxy.https"),
+			NoProxy: viper.GetString("server.proxy.no_proxy"),
+		},
+		ClientCert: viper.GetString("server.client_cert"),
+		ClientKey:  viper.GetString("server.client_key"),
+	}
+}
+
+// OauthHttpClient returns an HTTP client for the OAuth token requests with the same transport
+// settings (proxy, timeouts, certificates) as the API client.
+func OauthHttpClient() *http.Client {
+	transport, err := client.NewTransport(transportConfig(), caCertPath(), viper.GetBool("server.insecure_skip_verify"))
+	DieNotNil(err)
+	return &http.Client{Transport: transport}
+}
+
+func newApiClient(url, ca string) *client.Api {
+	transport, err := apiTransport(ca)
+	DieNotNil(err)
+	api, err := client.NewApiClientWithOptions(url,
+		client.WithConfig(Config), client.WithTransport(transport), client.WithVersion(version.Commit))
+	DieNotNil(err)
+	return api
 }
 
 func SaveOauthConfig(c client.OAuthConfig) {
@@ -88,37 +144,36 @@ func SaveOauthConfig(c client.OAuthConfig) {
 	viper.Set("clientcredentials.expires_in", c.ExpiresIn)
 	viper.Set("clientcredentials.created", c.Created)
 	viper.Set("clientcredentials.url", c.URL)
+	viper.Set("clientcredentials.store", c.Store)
 
 	// viper.WriteConfig isn't so great for this. It doesn't just write
 	// these values but any other flags that were present when this runs.
 	// This gets run automatically when "logging in". So you sometimes
 	// accidentally write CLI flags viper finds to the file, that you
 	// don't intend to be saved. So we do it the hard way:
-	name := viper.ConfigFileUsed()
-	if len(name) == 0 {
-		logrus.Debug("Guessing config file from path")
-		path, err := homedir.Expand("~/.config")
-		DieNotNil(err)
-		name = filepath.Join(path, "fioctl.yaml")
-	}
-	// Try to read in config
-	cfg := make(map[string]interface{})
-	buf, err := os.ReadFile(name)
-	if err == nil {
-		DieNotNil(yaml.Unmarshal(buf, &cfg), "Unable unmarshal configuration:")
-	}
-	val := viper.Get("clientcredentials")
-	cfg["clientcredentials"] = val
+	file, err := ReadConfigFile()
+	DieNotNil(err)
+	// Credentials belong to the profile they were obtained for
+	cfg, ok := file.Profile(ActiveProfile)
+	if !ok {
+		DieNotNil(fmt.Errorf("Profile %s not found in %s", ActiveProfile, file.Path))
+	}
+	prev, _ := cfg["clientcredentials"].(map[interface{}]interface{})
+	DieNotNil(storeOauthSecrets(file.Path, prev, c), "Unable to save oauth credentials:")
+	cfg["clientcredentials"] = oauthConfigMap(c)
 	if len(c.DefaultOrg) > 0 {
 		cfg["factory"] = c.DefaultOrg
 	}
-	cfg["server"] = map[string]interface{}{
-		"insecure_skip_verify": viper.GetBool("server.insecure_skip_verify"),
-		"url":                  viper.GetString("server.url"),
+	// Keep any other server settings (e.g. retries) a user may have configured
+	server, ok := cfg["server"].(map[interface{}]interface{})
+	if !ok {
+		server = make(map[interface{}]interface{})
 	}
-	buf, err = yaml.Marshal(cfg)
-	DieNotNil(err, "Unable to marshall oauth config:")
-	DieNotNil(os.WriteFile(name, buf, os.FileMode(0644)), "Unable to update config: ")
+	server["insecure_skip_verify"] = viper.GetBool("server.insecure_skip_verify")
+	server["url"] = viper.GetString("server.url")
+	cfg["server"] = server
+	file.SetProfile(ActiveProfile, cfg)
+	DieNotNil(file.Write(), "Unable to save oauth config:")
 }
 
 // An os.Exit exits immediately, skipping all deferred functions
@@ -142,7 +197,20 @@ func DieNotNil(err error, message ...string) {
 		for _, w := range onLastWill {
 			w()
 		}
-		os.Exit(1)
+		os.Exit(ExitCode(err))
+	}
+}
+
+// DieOnIterError adapts an API iterator for places which cannot handle errors, e.g. table printers.
+// The first error terminates the program just like DieNotNil does.
+func DieOnIterError[T any](seq iter.Seq2[T, error]) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for item, err := range seq {
+			DieNotNil(err)
+			if !yield(item) {
+				return
+			}
+		}
 	}
 }
 
@@ -172,6 +240,18 @@ func MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// Confirm asks a yes or no question, and returns false unless it is answered with yes.
+func Confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		fmt.Println()
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+	return answer == "y" || answer == "yes"
+}
+
 func AssertWritable(path string) {
 	st, err := os.Stat(path)
 	DieNotNil(err)
@@ -7,12 +7,15 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
 	toml "github.com/pelletier/go-toml"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
 
 	"github.com/foundriesio/fioctl/client"
 )
@@ -26,22 +29,48 @@ const (
 )
 
 type SetConfigOptions struct {
-	Reason      string
-	FileArgs    []string
-	IsRawFile   bool
-	SetFunc     func(client.ConfigCreateRequest) error
-	EncryptFunc func(string) string
+	Reason         string
+	FileArgs       []string
+	IsRawFile      bool
+	SkipValidation bool
+	SetFunc        func(client.ConfigCreateRequest) error
+	EncryptFunc    func(string) string
 }
 
 func SetConfig(opts *SetConfigOptions) {
-	cfg := client.ConfigCreateRequest{Reason: opts.Reason}
-	if opts.IsRawFile {
-		if len(opts.FileArgs) != 1 {
+	cfg := NewConfigRequest(opts.Reason, opts.FileArgs, opts.IsRawFile)
+	if !opts.SkipValidation {
+		ValidateConfig(cfg.Files, opts.EncryptFunc != nil)
+	}
+	if opts.EncryptFunc != nil {
+		for i := range cfg.Files {
+			file := &cfg.Files[i]
+			if !file.Unencrypted {
+				file.Value = opts.EncryptFunc(file.Value)
+			}
+		}
+	}
+
+	DieNotNil(opts.SetFunc(cfg))
+}
+
+// AddSkipValidationFlag adds the flag to upload a config without checking it first.
+func AddSkipValidationFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolP("skip-validation", "", false,
+		"Upload the config without checking the syntax of its files, their handlers, and for unencrypted secrets")
+}
+
+// NewConfigRequest builds a config change from file=content arguments, or from a raw config file.
+// The file contents are not encrypted yet.
+func NewConfigRequest(reason string, fileArgs []string, isRawFile bool) client.ConfigCreateRequest {
+	cfg := client.ConfigCreateRequest{Reason: reason}
+	if isRawFile {
+		if len(fileArgs) != 1 {
 			DieNotNil(fmt.Errorf("Raw file only accepts one file argument"))
 		}
-		ReadConfig(opts.FileArgs[0], &cfg)
+		ReadConfig(fileArgs[0], &cfg)
 	} else {
-		for _, keyval := range opts.FileArgs {
+		for _, keyval := range fileArgs {
 			parts := strings.SplitN(keyval, "=", 2)
 			if len(parts) != 2 {
 				DieNotNil(fmt.Errorf("Invalid file=content argument: %s", keyval))
@@ -57,60 +86,249 @@ func SetConfig(opts *SetConfigOptions) {
 			cfg.Files = append(cfg.Files, client.ConfigFile{Name: parts[0], Value: content})
 		}
 	}
-
-	if opts.EncryptFunc != nil {
-		for i := range cfg.Files {
-			file := &cfg.Files[i]
-			if !file.Unencrypted {
-				file.Value = opts.EncryptFunc(file.Value)
-			}
-		}
-	}
-
-	DieNotNil(opts.SetFunc(cfg))
+	return cfg
 }
 
 type LogConfigsOptions struct {
 	UserLookup    map[string]client.FactoryUser
 	Limit         int
 	ShowAppliedAt bool
+	ShowEntries   bool // Number the entries as the diff and rollback commands refer to them
 	ListFunc      func() (*client.DeviceConfigList, error)
 	ListContFunc  func(string) (*client.DeviceConfigList, error)
+	// Cmd, if set, allows the -o flag of a command to select a machine-readable output
+	Cmd *cobra.Command
 }
 
 func LogConfigs(opts *LogConfigsOptions) {
-	var dcl *client.DeviceConfigList
 	listLimit := opts.Limit
-	for {
-		var err error
-		if dcl == nil {
-			dcl, err = opts.ListFunc()
-		} else {
-			if dcl.Next != nil {
-				dcl, err = opts.ListContFunc(*dcl.Next)
+	if opts.Cmd != nil && OutputSelected(opts.Cmd) {
+		var configs []client.DeviceConfig
+		for cfg, err := range client.Paginate(opts.ListFunc, opts.ListContFunc) {
+			DieNotNil(err)
+			configs = append(configs, cfg)
+			if listLimit -= 1; listLimit == 0 {
+				break
+			}
+		}
+		PrintOutput(opts.Cmd, configs)
+		return
+	}
+	entry := 0
+	for cfg, err := range client.Paginate(opts.ListFunc, opts.ListContFunc) {
+		DieNotNil(err)
+		if opts.ShowEntries {
+			color.Yellow("Entry:         %d", entry)
+		}
+		entry += 1
+		if len(cfg.CreatedBy) > 0 {
+			if v, ok := opts.UserLookup[cfg.CreatedBy]; ok {
+				cfg.CreatedBy = fmt.Sprintf("%s / %s", v.PolisId, v.Name)
 			} else {
-				return
+				cfg.CreatedBy = fmt.Sprintf("%s / ?", cfg.CreatedBy)
 			}
 		}
+		PrintConfig(&cfg, opts.ShowAppliedAt, true, "")
+		if listLimit -= 1; listLimit == 0 {
+			return
+		} else {
+			fmt.Println("")
+		}
+	}
+}
+
+// ParseConfigEntry parses the number of a changelog entry, from 0 for the config in effect.
+func ParseConfigEntry(arg string) int {
+	entry, err := strconv.Atoi(arg)
+	if err != nil || entry < 0 {
+		DieNotNil(fmt.Errorf("Invalid changelog entry %q: must be 0 for the latest config, 1 for the one before, and so on", arg))
+	}
+	return entry
+}
+
+// GetConfigEntries returns the changelog entries with the given numbers.
+func GetConfigEntries(
+	listFunc func() (*client.DeviceConfigList, error),
+	listContFunc func(string) (*client.DeviceConfigList, error),
+	entries ...int,
+) []client.DeviceConfig {
+	last := 0
+	for _, e := range entries {
+		last = max(last, e)
+	}
+	var configs []client.DeviceConfig
+	for cfg, err := range client.Paginate(listFunc, listContFunc) {
 		DieNotNil(err)
-		for _, cfg := range dcl.Configs {
-			if len(cfg.CreatedBy) > 0 {
-				if v, ok := opts.UserLookup[cfg.CreatedBy]; ok {
-					cfg.CreatedBy = fmt.Sprintf("%s / %s", v.PolisId, v.Name)
-				} else {
-					cfg.CreatedBy = fmt.Sprintf("%s / ?", cfg.CreatedBy)
-				}
+		configs = append(configs, cfg)
+		if len(configs) > last {
+			break
+		}
+	}
+	if len(configs) <= last {
+		DieNotNil(fmt.Errorf("There is no changelog entry %d, the changelog has %d entries", last, len(configs)))
+	}
+	found := make([]client.DeviceConfig, len(entries))
+	for i, e := range entries {
+		found[i] = configs[e]
+	}
+	return found
+}
+
+type DiffConfigsOptions struct {
+	From    int
+	To      int
+	Context int
+	// Encrypted tells that the files not marked as unencrypted have encrypted values, which is
+	// only the case for device configs.
+	Encrypted    bool
+	ListFunc     func() (*client.DeviceConfigList, error)
+	ListContFunc func(string) (*client.DeviceConfigList, error)
+}
+
+// DiffConfigs prints the differences between two changelog entries file by file. The values of
+// encrypted files are only compared, as they cannot be decrypted.
+func DiffConfigs(opts *DiffConfigsOptions) {
+	configs := GetConfigEntries(opts.ListFunc, opts.ListContFunc, opts.From, opts.To)
+	from, to := configs[0], configs[1]
+	color.Red("--- entry %d: %s %s", opts.From, from.CreatedAt, from.Reason)
+	color.Green("+++ entry %d: %s %s", opts.To, to.CreatedAt, to.Reason)
+
+	fromFiles := make(map[string]client.ConfigFile)
+	for _, f := range from.Files {
+		fromFiles[f.Name] = f
+	}
+	toFiles := make(map[string]client.ConfigFile)
+	var names []string
+	for _, f := range to.Files {
+		toFiles[f.Name] = f
+		names = append(names, f.Name)
+	}
+	for name := range fromFiles {
+		if _, ok := toFiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changed := 0
+	for _, name := range names {
+		a, inFrom := fromFiles[name]
+		b, inTo := toFiles[name]
+		if !inTo {
+			fmt.Printf("%s: removed\n", name)
+			changed += 1
+			continue
+		}
+		var details []string
+		if !inFrom {
+			details = append(details, "added")
+		} else {
+			if a.Unencrypted != b.Unencrypted {
+				details = append(details, fmt.Sprintf("unencrypted %v -> %v", a.Unencrypted, b.Unencrypted))
 			}
-			PrintConfig(&cfg, opts.ShowAppliedAt, true, "")
-			if listLimit -= 1; listLimit == 0 {
-				return
+			if strings.Join(a.OnChanged, " ") != strings.Join(b.OnChanged, " ") {
+				details = append(details, fmt.Sprintf("on-changed %v -> %v", a.OnChanged, b.OnChanged))
+			}
+		}
+		var hunks [][]string
+		if a.Value != b.Value {
+			if opts.Encrypted && (!b.Unencrypted || (inFrom && !a.Unencrypted)) {
+				if inFrom {
+					details = append(details, "encrypted value changed")
+				}
 			} else {
-				fmt.Println("")
+				hunks = DiffHunks(DiffLines(configLines(a.Value), configLines(b.Value)), opts.Context)
 			}
 		}
+		if len(details) == 0 && len(hunks) == 0 {
+			continue
+		}
+		changed += 1
+		if len(details) == 0 {
+			details = append(details, "changed")
+		}
+		fmt.Printf("%s: %s\n", name, strings.Join(details, ", "))
+		PrintDiffHunks(hunks, "  ")
+	}
+	if changed == 0 {
+		fmt.Println("No differences")
 	}
 }
 
+func configLines(value string) []string {
+	if len(value) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(value, "\n"), "\n")
+}
+
+type RollbackConfigOptions struct {
+	Entry        int
+	Reason       string
+	ListFunc     func() (*client.DeviceConfigList, error)
+	ListContFunc func(string) (*client.DeviceConfigList, error)
+	SetFunc      func(client.ConfigCreateRequest) error
+	// EncryptFunc, if set, encrypts the values of device config files. The old values of encrypted
+	// files cannot be decrypted, so they are taken from Values, in the file=content format.
+	EncryptFunc func(string) string
+	Values      []string
+}
+
+// RollbackConfig replaces the config in effect with the files of a changelog entry.
+func RollbackConfig(opts *RollbackConfigOptions) {
+	if opts.Entry == 0 {
+		DieNotNil(fmt.Errorf("Changelog entry 0 is the config in effect, there is nothing to roll back"))
+	}
+	configs := GetConfigEntries(opts.ListFunc, opts.ListContFunc, 0, opts.Entry)
+	current, target := configs[0], configs[1]
+	reason := opts.Reason
+	if len(reason) == 0 {
+		reason = fmt.Sprintf("Roll back to the config of %s", target.CreatedAt)
+	}
+	cfg := client.ConfigCreateRequest{Reason: reason, Files: append([]client.ConfigFile{}, target.Files...)}
+
+	values := make(map[string]string)
+	for _, f := range NewConfigRequest("", opts.Values, false).Files {
+		values[f.Name] = f.Value
+	}
+	if opts.EncryptFunc == nil && len(values) > 0 {
+		DieNotNil(fmt.Errorf("Values can only be given for the encrypted files of a device config"))
+	}
+	if opts.EncryptFunc != nil {
+		currentValues := make(map[string]string)
+		for _, f := range current.Files {
+			currentValues[f.Name] = f.Value
+		}
+		var missing []string
+		for i := range cfg.Files {
+			file := &cfg.Files[i]
+			if value, ok := values[file.Name]; ok {
+				if file.Unencrypted {
+					DieNotNil(fmt.Errorf("The file %s is not encrypted, its value is already in the changelog entry", file.Name))
+				}
+				file.Value = opts.EncryptFunc(value)
+				delete(values, file.Name)
+			} else if !file.Unencrypted && currentValues[file.Name] != file.Value {
+				// A value unchanged since then is kept, as the device already has it. Another value
+				// might be encrypted with a previous key of the device.
+				missing = append(missing, file.Name)
+			}
+		}
+		for name := range values {
+			DieNotNil(fmt.Errorf("The file %s is not in changelog entry %d", name, opts.Entry))
+		}
+		if len(missing) > 0 {
+			DieNotNil(fmt.Errorf(`Unable to roll back the encrypted files: %s
+Their old values can only be decrypted by the device, and the device key may have
+changed since. Give their values to encrypt with the current device key with:
+  --value <file>=<content> or --value <file>==<path>`, strings.Join(missing, ", ")))
+		}
+	}
+
+	DieNotNil(opts.SetFunc(cfg))
+	fmt.Printf("Rolled back to changelog entry %d of %s\n", opts.Entry, target.CreatedAt)
+}
+
 func ReadConfig(configFile string, cfg *client.ConfigCreateRequest) {
 	var content []byte
 	var err error
@@ -220,77 +438,120 @@ func SetUpdatesConfig(opts *SetUpdatesConfigOptions, reportedTag string, reporte
 		return
 	}
 
+	printf := func(format string, a ...interface{}) { fmt.Printf(format, a...) }
+	changed, err := updateSotaConfig(sota, opts.UpdateApps, opts.UpdateTag, reportedTag, reportedApps, printf)
+	DieNotNil(err)
+
+	if !changed {
+		fmt.Println("No changes found. Device is already configured with the specified options.")
+		os.Exit(0)
+	}
+
+	cfg, err := updatesConfigRequest(sota)
+	DieNotNil(err)
+	if opts.IsDryRun {
+		fmt.Println(cfg.Files[0].Value)
+	} else {
+		DieNotNil(opts.SetFunc(cfg, opts.IsForced))
+	}
+}
+
+// UpdatesConfigChange returns the config change which makes a device follow the tag and run the
+// apps, given its config changelog. It returns nil if the device is already configured with them.
+// The tag and apps have the same special values as for the "config updates" commands.
+func UpdatesConfigChange(dcl *client.DeviceConfigList, updateTag, updateApps string, isForced bool) (*client.ConfigCreateRequest, error) {
+	if err := ValidateUpdatesConfig(updateTag, updateApps); err != nil {
+		return nil, err
+	}
+	sota, err := loadSotaConfig(dcl)
+	if err != nil && !isForced {
+		return nil, fmt.Errorf("Invalid FIO toml file (override with --force): %w", err)
+	}
+	changed, err := updateSotaConfig(sota, updateApps, updateTag, "", nil, func(string, ...interface{}) {})
+	if err != nil || !changed {
+		return nil, err
+	}
+	cfg, err := updatesConfigRequest(sota)
+	return &cfg, err
+}
+
+// updateSotaConfig sets the apps and tag overrides in the aktualizr-lite config.
+// Each step is described with printf, along with the apps and tag reported by the device.
+func updateSotaConfig(
+	sota *toml.Tree, updateApps, updateTag, reportedTag string, reportedApps []string,
+	printf func(string, ...interface{}),
+) (bool, error) {
 	configuredApps := sota.GetDefault("pacman.docker_apps", "").(string)
 	configuredTag := sota.GetDefault("pacman.tags", "").(string)
 
 	changed := false
-	if opts.UpdateApps != "" && configuredApps != opts.UpdateApps {
-		if strings.TrimSpace(opts.UpdateApps) == "," {
-			opts.UpdateApps = ""
+	if updateApps != "" && configuredApps != updateApps {
+		if strings.TrimSpace(updateApps) == "," {
+			updateApps = ""
 		}
-		fmt.Printf("Currently configured apps: [%s]\n", configuredApps)
+		printf("Currently configured apps: [%s]\n", configuredApps)
 		if reportedApps != nil {
-			fmt.Printf("Apps reported as installed on device: [%s]\n", strings.Join(reportedApps, ","))
+			printf("Apps reported as installed on device: [%s]\n", strings.Join(reportedApps, ","))
 		}
-		if strings.TrimSpace(opts.UpdateApps) == "-" {
-			fmt.Printf("Setting apps to system default.\n")
+		if strings.TrimSpace(updateApps) == "-" {
+			printf("Setting apps to system default.\n")
 			if sota.Has("pacman.docker_apps") {
-				DieNotNil(sota.Delete("pacman.docker_apps"))
+				if err := sota.Delete("pacman.docker_apps"); err != nil {
+					return false, err
+				}
 			}
 			if sota.Has("pacman.compose_apps") {
-				DieNotNil(sota.Delete("pacman.compose_apps"))
+				if err := sota.Delete("pacman.compose_apps"); err != nil {
+					return false, err
+				}
 			}
 		} else {
-			fmt.Printf("Setting apps to [%s]\n", opts.UpdateApps)
-			sota.Set("pacman.docker_apps", opts.UpdateApps)
-			sota.Set("pacman.compose_apps", opts.UpdateApps)
+			printf("Setting apps to [%s]\n", updateApps)
+			sota.Set("pacman.docker_apps", updateApps)
+			sota.Set("pacman.compose_apps", updateApps)
 		}
 		changed = true
 	}
-	if opts.UpdateTag != "" && configuredTag != opts.UpdateTag {
-		if strings.TrimSpace(opts.UpdateTag) == "," {
-			opts.UpdateTag = ""
+	if updateTag != "" && configuredTag != updateTag {
+		if strings.TrimSpace(updateTag) == "," {
+			updateTag = ""
 		}
-		fmt.Printf("Currently configured tag: %s\n", configuredTag)
+		printf("Currently configured tag: %s\n", configuredTag)
 		if len(reportedTag) > 0 {
-			fmt.Printf("Tag reported by device: %s\n", reportedTag)
+			printf("Tag reported by device: %s\n", reportedTag)
 		}
-		if strings.TrimSpace(opts.UpdateTag) == "-" {
-			fmt.Printf("Setting tag to system default.\n")
+		if strings.TrimSpace(updateTag) == "-" {
+			printf("Setting tag to system default.\n")
 			if sota.Has("pacman.tags") {
-				DieNotNil(sota.Delete("pacman.tags"))
+				if err := sota.Delete("pacman.tags"); err != nil {
+					return false, err
+				}
 			}
 		} else {
-			fmt.Printf("Setting tag to %s\n", opts.UpdateTag)
-			sota.Set("pacman.tags", opts.UpdateTag)
+			printf("Setting tag to %s\n", updateTag)
+			sota.Set("pacman.tags", updateTag)
 		}
 		changed = true
 	}
+	return changed, nil
+}
 
-	if !changed {
-		fmt.Println("No changes found. Device is already configured with the specified options.")
-		os.Exit(0)
-	}
-
+func updatesConfigRequest(sota *toml.Tree) (client.ConfigCreateRequest, error) {
 	newToml, err := sota.ToTomlString()
-	DieNotNil(err, "Unable to encode toml:")
-
-	cfg := client.ConfigCreateRequest{
+	if err != nil {
+		return client.ConfigCreateRequest{}, fmt.Errorf("Unable to encode toml: %w", err)
+	}
+	return client.ConfigCreateRequest{
 		Reason: "Override aktualizr-lite update configuration ",
 		Files: []client.ConfigFile{
 			{
 				Name:        FIO_TOML_NAME,
 				Unencrypted: true,
-				OnChanged:   []string{"/usr/share/fioconfig/handlers/aktualizr-toml-update"},
+				OnChanged:   []string{FIO_TOML_ONCHANGED},
 				Value:       newToml,
 			},
 		},
-	}
-	if opts.IsDryRun {
-		fmt.Println(newToml)
-	} else {
-		DieNotNil(opts.SetFunc(cfg, opts.IsForced))
-	}
+	}, nil
 }
 
 func loadSotaConfig(dcl *client.DeviceConfigList) (sota *toml.Tree, err error) {
@@ -319,6 +580,11 @@ func loadSotaConfig(dcl *client.DeviceConfigList) (sota *toml.Tree, err error) {
 	return
 }
 
+// ValidateUpdatesConfig checks the tag and apps given to the "config updates" commands.
+func ValidateUpdatesConfig(updateTag, updateApps string) error {
+	return validateUpdateArgs(&SetUpdatesConfigOptions{UpdateTag: updateTag, UpdateApps: updateApps})
+}
+
 func validateUpdateArgs(opts *SetUpdatesConfigOptions) error {
 	// Validate the inputs: Must be alphanumeric, a dash, underscore, or comma
 	pattern := `^[a-zA-Z0-9-_,]+$`
@@ -18,11 +18,13 @@ func init() {
 	}
 	cmd.AddCommand(groupCmd)
 
-	groupCmd.AddCommand(&cobra.Command{
+	listCmd := &cobra.Command{
 		Use:   "list",
 		Short: "Show available device groups",
 		Run:   doListDeviceGroup,
-	})
+	}
+	groupCmd.AddCommand(listCmd)
+	subcommands.AddOutputFlag(listCmd)
 	groupCmd.AddCommand(&cobra.Command{
 		Use:   "create <name> [<description>]",
 		Short: "Create a new device group",
@@ -53,6 +55,9 @@ func doListDeviceGroup(cmd *cobra.Command, args []string) {
 
 	lst, err := api.FactoryListDeviceGroup(factory)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, *lst) {
+		return
+	}
 
 	t := tabby.New()
 	t.AddHeader("NAME", "DESCRIPTION", "CREATED AT", "UPDATED AT")
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	diffCmd := &cobra.Command{
+		Use:   "diff <entry> <entry>",
+		Short: "Show the differences between two configuration changelog entries",
+		Run:   doConfigDiff,
+		Args:  cobra.ExactArgs(2),
+		Long: `Show the differences between two entries of the configuration changelog, file
+by file. Entries are numbered as shown by "fioctl config log --numbered", from 0
+for the config in effect.`,
+		Example: `
+  # Show what the latest config change did:
+  fioctl config diff 1 0
+
+  # Compare the config of a device group with the one from 3 changes ago:
+  fioctl config diff 3 0 --group beta`,
+	}
+	cmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringP("group", "g", "", "Device group to use")
+	diffCmd.Flags().IntP("unified", "U", 3, "Number of unchanged lines to show around each difference")
+}
+
+func doConfigDiff(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	group, _ := cmd.Flags().GetString("group")
+	context, _ := cmd.Flags().GetInt("unified")
+	opts := subcommands.DiffConfigsOptions{
+		From:    subcommands.ParseConfigEntry(args[0]),
+		To:      subcommands.ParseConfigEntry(args[1]),
+		Context: max(context, 0),
+	}
+
+	if group == "" {
+		logrus.Debugf("Showing config diff for %s", factory)
+		opts.ListFunc = func() (*client.DeviceConfigList, error) {
+			return api.FactoryListConfig(factory)
+		}
+		opts.ListContFunc = api.FactoryListConfigCont
+	} else {
+		logrus.Debugf("Showing config diff for %s group %s", factory, group)
+		opts.ListFunc = func() (*client.DeviceConfigList, error) {
+			return api.GroupListConfig(factory, group)
+		}
+		opts.ListContFunc = api.GroupListConfigCont
+	}
+	subcommands.DiffConfigs(&opts)
+}
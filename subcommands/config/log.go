@@ -18,12 +18,17 @@ func init() {
 	cmd.AddCommand(logCmd)
 	logCmd.Flags().StringP("group", "g", "", "Device group to use")
 	logCmd.Flags().IntP("limit", "n", 0, "Limit the number of results displayed")
+	logCmd.Flags().Bool("all", false, "Display the entries from all pages. This is the default unless --limit is set, which it cannot be combined with.")
+	logCmd.MarkFlagsMutuallyExclusive("all", "limit")
+	logCmd.Flags().Bool("numbered", false, "Number the entries, from 0 for the config in effect, as used by the diff and rollback commands")
+	subcommands.AddOutputFlag(logCmd)
 }
 
 func doConfigLog(cmd *cobra.Command, args []string) {
 	factory := viper.GetString("factory")
 	listLimit, _ := cmd.Flags().GetInt("limit")
 	group, _ := cmd.Flags().GetString("group")
+	numbered, _ := cmd.Flags().GetBool("numbered")
 
 	lookups, err := api.UsersGetLookups(factory)
 	subcommands.DieNotNil(err)
@@ -31,22 +36,26 @@ func doConfigLog(cmd *cobra.Command, args []string) {
 	if group == "" {
 		logrus.Debugf("Showing config history for %s", factory)
 		subcommands.LogConfigs(&subcommands.LogConfigsOptions{
-			Limit: listLimit,
+			Limit:       listLimit,
+			ShowEntries: numbered,
 			ListFunc: func() (*client.DeviceConfigList, error) {
 				return api.FactoryListConfig(factory)
 			},
 			ListContFunc: api.FactoryListConfigCont,
 			UserLookup:   lookups,
+			Cmd:          cmd,
 		})
 	} else {
 		logrus.Debugf("Showing config history for %s group %s", factory, group)
 		subcommands.LogConfigs(&subcommands.LogConfigsOptions{
-			Limit: listLimit,
+			Limit:       listLimit,
+			ShowEntries: numbered,
 			ListFunc: func() (*client.DeviceConfigList, error) {
 				return api.GroupListConfig(factory, group)
 			},
 			ListContFunc: api.GroupListConfigCont,
 			UserLookup:   lookups,
+			Cmd:          cmd,
 		})
 	}
 }
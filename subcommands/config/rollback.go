@@ -0,0 +1,59 @@
+package config
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	rollbackCmd := &cobra.Command{
+		Use:   "rollback <entry>",
+		Short: "Restore the configuration of a changelog entry",
+		Run:   doConfigRollback,
+		Args:  cobra.ExactArgs(1),
+		Long: `Replace the configuration in effect with the files of an entry of the
+configuration changelog. Entries are numbered as shown by "fioctl config log
+--numbered", from 0 for the config in effect. The rollback is a new changelog entry.`,
+		Example: `
+  # Undo the latest config change:
+  fioctl config rollback 1 --reason "Revert the broken proxy setting"
+
+  # Restore the config a device group had 3 changes ago:
+  fioctl config rollback 3 --group beta`,
+	}
+	cmd.AddCommand(rollbackCmd)
+	rollbackCmd.Flags().StringP("group", "g", "", "Device group to use")
+	rollbackCmd.Flags().StringP("reason", "m", "", "Add a message to store as the \"reason\" for this change")
+}
+
+func doConfigRollback(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	group, _ := cmd.Flags().GetString("group")
+	reason, _ := cmd.Flags().GetString("reason")
+	opts := subcommands.RollbackConfigOptions{Entry: subcommands.ParseConfigEntry(args[0]), Reason: reason}
+
+	if group == "" {
+		logrus.Debugf("Rolling back config for %s", factory)
+		opts.ListFunc = func() (*client.DeviceConfigList, error) {
+			return api.FactoryListConfig(factory)
+		}
+		opts.ListContFunc = api.FactoryListConfigCont
+		opts.SetFunc = func(cfg client.ConfigCreateRequest) error {
+			return api.FactoryCreateConfig(factory, cfg)
+		}
+	} else {
+		logrus.Debugf("Rolling back config for %s group %s", factory, group)
+		opts.ListFunc = func() (*client.DeviceConfigList, error) {
+			return api.GroupListConfig(factory, group)
+		}
+		opts.ListContFunc = api.GroupListConfigCont
+		opts.SetFunc = func(cfg client.ConfigCreateRequest) error {
+			return api.GroupCreateConfig(factory, group, cfg)
+		}
+	}
+	subcommands.RollbackConfig(&opts)
+}
@@ -16,7 +16,13 @@ func init() {
 		Long: `Creates a Factory wide configuration. The fioconfig daemon running on
 each device will then be able to grab and apply the latest version of the configuration
 and the device's configuration. Use the --group parameter to 
-create a device group wide configuration instead.`,
+create a device group wide configuration instead.
+
+The files are checked before they are uploaded: the syntax of .toml, .json,
+and .yaml files, the fioconfig on-changed handlers, the size of the config,
+and secrets in unencrypted files. More rules can be added in a
+.fioctl-config-rules.yml file in the working directory or a parent directory
+of the git repository. Use --skip-validation to upload the config anyway.`,
 		Example: `
   # Basic use
   fioctl config set npmtok="root" githubtok="1234" readme.md==./readme.md
@@ -62,6 +68,7 @@ create a device group wide configuration instead.`,
 	setCmd.Flags().StringP("reason", "m", "", "Add a message to store as the \"reason\" for this change")
 	setCmd.Flags().BoolP("raw", "", false, "Use raw configuration file")
 	setCmd.Flags().BoolP("create", "", false, "Replace the whole config with these values. Default is to merge these values with the existing config values")
+	subcommands.AddSkipValidationFlag(setCmd)
 }
 
 func doConfigSet(cmd *cobra.Command, args []string) {
@@ -70,7 +77,8 @@ func doConfigSet(cmd *cobra.Command, args []string) {
 	reason, _ := cmd.Flags().GetString("reason")
 	isRaw, _ := cmd.Flags().GetBool("raw")
 	shouldCreate, _ := cmd.Flags().GetBool("create")
-	opts := subcommands.SetConfigOptions{FileArgs: args, Reason: reason, IsRawFile: isRaw}
+	skipValidation, _ := cmd.Flags().GetBool("skip-validation")
+	opts := subcommands.SetConfigOptions{FileArgs: args, Reason: reason, IsRawFile: isRaw, SkipValidation: skipValidation}
 
 	if group == "" {
 		logrus.Debugf("Creating new config for %s", factory)
@@ -0,0 +1,298 @@
+package subcommands
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+// Config files are checked before they are uploaded, as a mistake otherwise only shows up as a
+// broken device. The checks can be extended with a rules file, looked up in the working directory
+// and its parents up to the root of a git repository, e.g.:
+//
+//	max-file-size: 65536
+//	handlers:
+//	  - /usr/share/fioconfig/handlers/acme-restart-agent
+//	secrets:
+//	  - 'ACME-[0-9A-F]{32}'
+//	rules:
+//	  - name: no-debug
+//	    files: '*.toml'
+//	    forbid: 'debug\s*=\s*true'
+//	    message: Debug logging must not be enabled on devices
+//	  - name: pinned-tag
+//	    files: z-50-fioctl.toml
+//	    require: 'tags\s*='
+const ConfigRulesFile = ".fioctl-config-rules.yml"
+
+const (
+	// The API rejects configs larger than this
+	MaxConfigSize = 1024 * 1024
+
+	// Device config files are encrypted with ECIES on the P-256 curve: the ephemeral public key,
+	// the AES IV, and the HMAC-SHA256 tag are added to each value, which is then base64 encoded.
+	eciesOverhead = 65 + 16 + 32
+
+	fioconfigHandlersDir = "/usr/share/fioconfig/handlers/"
+)
+
+// The on-changed handlers shipped with fioconfig
+var knownConfigHandlers = []string{
+	FIO_TOML_ONCHANGED,
+	fioconfigHandlersDir + "factory-config-vpn",
+	fioconfigHandlersDir + "renew-client-cert",
+}
+
+// Patterns of secrets which must not be in files readable from the API
+var knownSecrets = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"private key", regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY-----`)},
+	{"AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{40,})\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"password or secret", regexp.MustCompile(`(?i)\b(password|passwd|secret|api[_-]?key|access[_-]?token)\s*[:=]\s*["']?[^\s"']{8,}`)},
+}
+
+type ConfigRules struct {
+	MaxFileSize int          `yaml:"max-file-size"`
+	Handlers    []string     `yaml:"handlers"`
+	Secrets     []string     `yaml:"secrets"`
+	Rules       []ConfigRule `yaml:"rules"`
+
+	secrets []*regexp.Regexp
+}
+
+// ConfigRule requires the files matching a file name pattern to match, or not to match, a
+// regular expression.
+type ConfigRule struct {
+	Name    string `yaml:"name"`
+	Files   string `yaml:"files"`
+	Forbid  string `yaml:"forbid"`
+	Require string `yaml:"require"`
+	Message string `yaml:"message"`
+
+	forbid  *regexp.Regexp
+	require *regexp.Regexp
+}
+
+// LoadConfigRules reads the rules file closest to the working directory, or returns the default
+// rules if there is none.
+func LoadConfigRules() (*ConfigRules, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		file := filepath.Join(dir, ConfigRulesFile)
+		if _, err := os.Stat(file); err == nil {
+			return ReadConfigRules(file)
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return &ConfigRules{}, nil
+}
+
+func ReadConfigRules(file string) (*ConfigRules, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules ConfigRules
+	if err := yaml.UnmarshalStrict(buf, &rules); err != nil {
+		return nil, fmt.Errorf("Invalid config rules %s: %w", file, err)
+	}
+	for _, expr := range rules.Secrets {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid secret pattern in %s: %w", file, err)
+		}
+		rules.secrets = append(rules.secrets, re)
+	}
+	for i := range rules.Rules {
+		r := &rules.Rules[i]
+		if len(r.Name) == 0 {
+			r.Name = fmt.Sprint(i + 1)
+		}
+		if len(r.Forbid) == 0 && len(r.Require) == 0 {
+			return nil, fmt.Errorf("Rule %s in %s has neither forbid nor require", r.Name, file)
+		}
+		if _, err := path.Match(r.Files, ""); err != nil {
+			return nil, fmt.Errorf("Invalid files pattern of rule %s in %s: %w", r.Name, file, err)
+		}
+		if len(r.Forbid) > 0 {
+			if r.forbid, err = regexp.Compile(r.Forbid); err != nil {
+				return nil, fmt.Errorf("Invalid forbid pattern of rule %s in %s: %w", r.Name, file, err)
+			}
+		}
+		if len(r.Require) > 0 {
+			if r.require, err = regexp.Compile(r.Require); err != nil {
+				return nil, fmt.Errorf("Invalid require pattern of rule %s in %s: %w", r.Name, file, err)
+			}
+		}
+	}
+	logrus.Debugf("Using config rules from %s", file)
+	return &rules, nil
+}
+
+// Validate returns the problems found in the files of a config, before they are encrypted. The
+// size of a device config is checked as it is uploaded, i.e. with its files encrypted.
+func (r *ConfigRules) Validate(files []client.ConfigFile, encrypted bool) []error {
+	var errs []error
+	fail := func(name, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", name, fmt.Sprintf(format, args...)))
+	}
+	seen := make(map[string]bool)
+	size := 0
+	for _, f := range files {
+		if len(f.Name) == 0 {
+			errs = append(errs, errors.New("A config file has no name"))
+			continue
+		}
+		if seen[f.Name] {
+			fail(f.Name, "The file is given more than once")
+		}
+		seen[f.Name] = true
+		if encrypted && !f.Unencrypted {
+			size += EncryptedSize(len(f.Value))
+		} else {
+			size += len(f.Value)
+		}
+		if r.MaxFileSize > 0 && len(f.Value) > r.MaxFileSize {
+			fail(f.Name, "The file is %d bytes, the limit is %d bytes", len(f.Value), r.MaxFileSize)
+		}
+		if err := checkConfigSyntax(f); err != nil {
+			fail(f.Name, "%s", err)
+		}
+		if err := r.checkHandler(f); err != nil {
+			fail(f.Name, "%s", err)
+		}
+		if f.Unencrypted {
+			if secret := r.findSecret(f.Value); len(secret) > 0 {
+				fail(f.Name, "The file looks like it contains a %s, but is not encrypted and can be read from the API", secret)
+			}
+		}
+		for _, rule := range r.Rules {
+			if err := rule.check(f); err != nil {
+				fail(f.Name, "%s", err)
+			}
+		}
+	}
+	if size > MaxConfigSize && encrypted {
+		errs = append(errs, fmt.Errorf("The config is %d bytes once encrypted, the limit is %d bytes", size, MaxConfigSize))
+	} else if size > MaxConfigSize {
+		errs = append(errs, fmt.Errorf("The config is %d bytes, the limit is %d bytes", size, MaxConfigSize))
+	}
+	return errs
+}
+
+// EncryptedSize returns the size of a device config value once encrypted.
+func EncryptedSize(size int) int {
+	return base64.StdEncoding.EncodedLen(size + eciesOverhead)
+}
+
+func checkConfigSyntax(f client.ConfigFile) error {
+	var err error
+	var v interface{}
+	switch strings.ToLower(path.Ext(f.Name)) {
+	case ".toml":
+		_, err = toml.Load(f.Value)
+	case ".json":
+		err = json.Unmarshal([]byte(f.Value), &v)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal([]byte(f.Value), &v)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid %s: %w", strings.ToUpper(path.Ext(f.Name)[1:]), err)
+	}
+	return nil
+}
+
+func (r *ConfigRules) checkHandler(f client.ConfigFile) error {
+	if len(f.OnChanged) == 0 {
+		return nil
+	}
+	handler := f.OnChanged[0]
+	if !path.IsAbs(handler) {
+		return fmt.Errorf("The on-changed command %q must be an absolute path", handler)
+	}
+	if strings.HasPrefix(handler, fioconfigHandlersDir) {
+		if slices.Contains(knownConfigHandlers, handler) || slices.Contains(r.Handlers, handler) {
+			return nil
+		}
+		return fmt.Errorf("Unknown fioconfig handler %s", handler)
+	}
+	return nil
+}
+
+// findSecret returns the kind of the first secret found in a value. The secret itself is not
+// returned, so that it does not end up in a terminal or a CI log.
+func (r *ConfigRules) findSecret(value string) string {
+	for _, secret := range knownSecrets {
+		if secret.re.MatchString(value) {
+			return secret.kind
+		}
+	}
+	for _, re := range r.secrets {
+		if re.MatchString(value) {
+			return "secret matching " + re.String()
+		}
+	}
+	return ""
+}
+
+func (r ConfigRule) check(f client.ConfigFile) error {
+	if len(r.Files) > 0 {
+		if ok, _ := path.Match(r.Files, f.Name); !ok {
+			return nil
+		}
+	}
+	failed := (r.forbid != nil && r.forbid.MatchString(f.Value)) ||
+		(r.require != nil && !r.require.MatchString(f.Value))
+	if !failed {
+		return nil
+	}
+	if len(r.Message) > 0 {
+		return fmt.Errorf("%s (rule %s)", r.Message, r.Name)
+	}
+	if r.forbid != nil && r.forbid.MatchString(f.Value) {
+		return fmt.Errorf("The file matches %q, forbidden by rule %s", r.Forbid, r.Name)
+	}
+	return fmt.Errorf("The file does not match %q, required by rule %s", r.Require, r.Name)
+}
+
+// ValidateConfig checks the files of a config with the rules in use, and exits with all the
+// problems found. Encrypted tells if the files are encrypted for a device before being uploaded.
+func ValidateConfig(files []client.ConfigFile, encrypted bool) {
+	rules, err := LoadConfigRules()
+	DieNotNil(err)
+	if errs := rules.Validate(files, encrypted); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		DieNotNil(fmt.Errorf("Found %d problems in the config, use --skip-validation to upload it anyway", len(errs)))
+	}
+}
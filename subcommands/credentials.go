@@ -0,0 +1,99 @@
+package subcommands
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/client/credstore"
+)
+
+var oauthSecretsLoaded bool
+
+// LoadOauthSecrets fills the secrets of Config.ClientCredentials from the credential store they are
+// kept in. It is called on demand by commands which need OAuth credentials, so that other commands
+// never touch the keyring or ask for a passphrase.
+func LoadOauthSecrets() {
+	c := &Config.ClientCredentials
+	store := oauthStore(c.Store)
+	if oauthSecretsLoaded || store == credstore.Plain || len(c.ClientId) == 0 {
+		return
+	}
+	oauthSecretsLoaded = true
+
+	path, err := configFilePath()
+	DieNotNil(err)
+	st, err := credstore.Open(store, path)
+	DieNotNil(err)
+	secrets, err := st.Get(c.ClientId)
+	if errors.Is(err, credstore.ErrNotFound) {
+		logrus.Warnf("Credentials for the client %s are missing in the %s store", c.ClientId, store)
+		return
+	}
+	DieNotNil(err, "Unable to load oauth credentials:")
+	c.ClientSecret = secrets.ClientSecret
+	c.AccessToken = secrets.AccessToken
+	c.RefreshToken = secrets.RefreshToken
+}
+
+func oauthStore(name string) string {
+	if len(name) == 0 {
+		return credstore.Plain
+	}
+	return name
+}
+
+// storeOauthSecrets saves the secrets into the store selected by the config. The secrets are then
+// removed from a previously used store: when they are moved to another store, or when the
+// credentials are replaced by ones with another client ID (e.g. on logout).
+func storeOauthSecrets(configFile string, prev map[interface{}]interface{}, c client.OAuthConfig) error {
+	store := oauthStore(c.Store)
+	if store != credstore.Plain && len(c.ClientId) > 0 {
+		st, err := credstore.Open(store, configFile)
+		if err != nil {
+			return err
+		}
+		secrets := credstore.Secrets{
+			ClientSecret: c.ClientSecret,
+			AccessToken:  c.AccessToken,
+			RefreshToken: c.RefreshToken,
+		}
+		if err := st.Set(c.ClientId, secrets); err != nil {
+			return err
+		}
+	}
+
+	prevStore, _ := prev["store"].(string)
+	prevStore = oauthStore(prevStore)
+	prevId, _ := prev["client_id"].(string)
+	if prevStore != credstore.Plain && len(prevId) > 0 && (prevStore != store || prevId != c.ClientId) {
+		logrus.Debugf("Removing credentials for the client %s from the %s store", prevId, prevStore)
+		st, err := credstore.Open(prevStore, configFile)
+		if err != nil {
+			return err
+		}
+		return st.Delete(prevId)
+	}
+	return nil
+}
+
+// oauthConfigMap returns the credentials as saved in the config file. Secrets are only included
+// when they are not kept in a separate store.
+func oauthConfigMap(c client.OAuthConfig) map[string]interface{} {
+	m := map[string]interface{}{
+		"client_id":  c.ClientId,
+		"token_type": c.TokenType,
+		"expires_in": c.ExpiresIn,
+		"created":    c.Created,
+		"url":        c.URL,
+	}
+	if store := oauthStore(c.Store); store == credstore.Plain {
+		m["client_secret"] = c.ClientSecret
+		m["access_token"] = c.AccessToken
+		m["refresh_token"] = c.RefreshToken
+	} else {
+		m["store"] = store
+	}
+	return m
+}
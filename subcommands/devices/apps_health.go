@@ -0,0 +1,420 @@
+package devices
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+type appsHealthOptions struct {
+	byTag       string
+	byGroup     string
+	where       whereValue
+	app         string
+	parallel    int
+	maxOutliers int
+}
+
+var appsHealthOpts appsHealthOptions
+
+func init() {
+	appsHealthCmd := &cobra.Command{
+		Use:   "apps-health",
+		Short: "Show the health of Apps across many devices",
+		Run:   doAppsHealth,
+		Args:  cobra.NoArgs,
+		Long: `Show the health of the Apps of the selected devices, based on the latest
+App states they reported. For each App, and each of its services, this shows
+how many devices run it, how many of them are unhealthy or restarting, and
+which images they run.
+
+The Apps and images a device runs are compared with those of its Target. The
+devices with a problem are listed as outliers:
+
+  * unhealthy - the App or a service of it is unhealthy
+  * restarting - a service keeps restarting
+  * missing - the Target has the App, but the device does not run it
+  * unexpected - the device runs an App which is not in its Target
+  * outdated - the device runs another version of the App than its Target has
+  * wrong-image - a service runs another image than the App of its Target has`,
+		Example: `
+  # Show the health of all Apps of production devices:
+  fioctl devices apps-health --where is-prod
+
+  # Show the health of an App for a device group:
+  fioctl devices apps-health --app shellhttpd --by-group beta`,
+	}
+	cmd.AddCommand(appsHealthCmd)
+	appsHealthCmd.Flags().StringVarP(&appsHealthOpts.byTag, "by-tag", "", "", "Only check devices configured with the given tag")
+	appsHealthCmd.Flags().StringVarP(&appsHealthOpts.byGroup, "by-group", "g", "", "Only check devices belonging to this group")
+	appsHealthCmd.Flags().VarP(&appsHealthOpts.where, "where", "", "Only check devices matching an expression, see \"fioctl devices list --help\"")
+	appsHealthCmd.Flags().StringVarP(&appsHealthOpts.app, "app", "", "", "Only show this App")
+	appsHealthCmd.Flags().IntVarP(&appsHealthOpts.parallel, "parallel", "j", 8, "Number of devices to fetch App states for at the same time")
+	appsHealthCmd.Flags().IntVarP(&appsHealthOpts.maxOutliers, "max-outliers", "", 20, "Maximum number of outliers to list for each App. 0 lists all of them")
+	subcommands.AddOutputFlag(appsHealthCmd)
+}
+
+type appsHealthReport struct {
+	Devices     int         `json:"devices"`
+	NoReport    []string    `json:"no-report"`
+	Apps        []appHealth `json:"apps"`
+	FetchErrors []string    `json:"fetch-errors"`
+}
+
+type appHealth struct {
+	App        string          `json:"app"`
+	Devices    int             `json:"devices"`
+	Unhealthy  int             `json:"unhealthy"`
+	Missing    int             `json:"missing"`
+	Unexpected int             `json:"unexpected"`
+	Outdated   int             `json:"outdated"`
+	Services   []serviceHealth `json:"services"`
+	Outliers   []appOutlier    `json:"outliers"`
+}
+
+type serviceHealth struct {
+	Service    string        `json:"service"`
+	Devices    int           `json:"devices"`
+	Unhealthy  int           `json:"unhealthy"`
+	Restarting int           `json:"restarting"`
+	Images     []imageDevice `json:"images"`
+}
+
+type imageDevice struct {
+	Image    string `json:"image"`
+	Devices  int    `json:"devices"`
+	Expected bool   `json:"expected"`
+}
+
+type appOutlier struct {
+	Device  string `json:"device"`
+	Uuid    string `json:"uuid"`
+	Service string `json:"service"`
+	Problem string `json:"problem"`
+	Details string `json:"details"`
+}
+
+// deviceApps is what a device runs, and what its Target expects it to run.
+type deviceApps struct {
+	device   client.Device
+	state    *client.AppsState
+	expected map[string]string // App name to URI
+}
+
+func doAppsHealth(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	opts := appsHealthOpts
+	if opts.parallel < 1 {
+		subcommands.DieNotNil(fmt.Errorf("Invalid value for --parallel: %d, must be at least 1", opts.parallel))
+	}
+	logrus.Debugf("Checking the health of apps for %s", factory)
+
+	filterBy := map[string]string{"factory": factory, "match_tag": opts.byTag, "group": opts.byGroup}
+	selected, err := selectFilteredDevices(filterBy, opts.where.filter)
+	subcommands.DieNotNil(err)
+	var devices []deviceApps
+	for _, device := range selected {
+		devices = append(devices, deviceApps{device: device})
+	}
+
+	targetApps := targetComposeApps(factory)
+	report := appsHealthReport{Devices: len(devices), NoReport: []string{}, FetchErrors: []string{}}
+	var lock sync.Mutex
+	forEachParallel(opts.parallel, len(devices), func(idx int) {
+		d := &devices[idx]
+		d.expected = expectedApps(d.device, targetApps)
+		dapi := api.DeviceApiByUuid(factory, d.device.Uuid)
+		states, err := dapi.GetAppsStates()
+		if err == nil && len(states.States) > 0 {
+			d.state = &states.States[0]
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			report.FetchErrors = append(report.FetchErrors, fmt.Sprintf("%s: %s", d.device.Name, err))
+		} else {
+			report.NoReport = append(report.NoReport, d.device.Name)
+		}
+	})
+	sort.Strings(report.NoReport)
+	sort.Strings(report.FetchErrors)
+
+	images := newExpectedImages(factory)
+	report.Apps = aggregateAppsHealth(devices, images, opts.app)
+	if subcommands.PrintOutput(cmd, report) {
+		return
+	}
+	printAppsHealth(report, opts.maxOutliers)
+	if len(report.FetchErrors) > 0 {
+		subcommands.DieNotNil(fmt.Errorf("Unable to fetch the App states of %d devices", len(report.FetchErrors)))
+	}
+}
+
+// targetComposeApps returns the Apps of each Target by its name. The Apps of a device can still
+// be checked for health without them, so a failure is only logged.
+func targetComposeApps(factory string) map[string]map[string]client.ComposeApp {
+	apps := make(map[string]map[string]client.ComposeApp)
+	targets, err := api.TargetsList(factory)
+	if err != nil {
+		logrus.Warnf("Unable to list Targets, Apps are not compared with them: %s", err)
+		return apps
+	}
+	for name, target := range targets {
+		if target.Custom == nil {
+			continue
+		}
+		custom, err := api.TargetCustom(target)
+		if err != nil {
+			logrus.Debugf("Unable to parse Target %s: %s", name, err)
+			continue
+		}
+		apps[name] = custom.ComposeApps
+	}
+	return apps
+}
+
+// expectedApps returns the Apps of the Target of a device, limited to those configured for
+// the device if it does not run all Apps.
+func expectedApps(device client.Device, targetApps map[string]map[string]client.ComposeApp) map[string]string {
+	apps, ok := targetApps[device.TargetName]
+	if !ok {
+		return nil
+	}
+	expected := make(map[string]string)
+	for name, app := range apps {
+		if len(device.DockerApps) == 0 || slices.Contains(device.DockerApps, name) {
+			expected[name] = app.Uri
+		}
+	}
+	return expected
+}
+
+// expectedImages caches the images of the services of an App version, as they are only known
+// from its compose spec.
+type expectedImages struct {
+	factory string
+	byUri   map[string]map[string]string
+}
+
+func newExpectedImages(factory string) *expectedImages {
+	return &expectedImages{factory: factory, byUri: make(map[string]map[string]string)}
+}
+
+// get returns the image of each service of an App of a Target, or nil if it is not known.
+func (e *expectedImages) get(target, app, uri string) map[string]string {
+	if images, ok := e.byUri[uri]; ok {
+		return images
+	}
+	var images map[string]string
+	bundle, err := api.TargetComposeApp(e.factory, target, app)
+	if err != nil {
+		logrus.Debugf("Unable to fetch App %s of Target %s: %s", app, target, err)
+	} else if services, ok := bundle.Content.ComposeSpec["services"].(map[string]interface{}); ok {
+		images = make(map[string]string)
+		for name, svc := range services {
+			if spec, ok := svc.(map[string]interface{}); ok {
+				if image, ok := spec["image"].(string); ok {
+					images[name] = image
+				}
+			}
+		}
+	}
+	e.byUri[uri] = images
+	return images
+}
+
+func aggregateAppsHealth(devices []deviceApps, images *expectedImages, only string) []appHealth {
+	apps := make(map[string]*appHealth)
+	services := make(map[string]map[string]*serviceHealth)
+	serviceImages := make(map[string]map[string]map[string]*imageDevice)
+	getApp := func(name string) *appHealth {
+		if _, ok := apps[name]; !ok {
+			apps[name] = &appHealth{App: name, Services: []serviceHealth{}, Outliers: []appOutlier{}}
+			services[name] = make(map[string]*serviceHealth)
+			serviceImages[name] = make(map[string]map[string]*imageDevice)
+		}
+		return apps[name]
+	}
+	outlier := func(app *appHealth, d client.Device, service, problem, details string) {
+		app.Outliers = append(app.Outliers, appOutlier{
+			Device: d.Name, Uuid: d.Uuid, Service: service, Problem: problem, Details: details,
+		})
+	}
+
+	for _, d := range devices {
+		if d.state == nil {
+			continue
+		}
+		for name, uri := range d.expected {
+			if _, ok := d.state.Apps[name]; !ok && (len(only) == 0 || name == only) {
+				app := getApp(name)
+				app.Missing += 1
+				outlier(app, d.device, "", "missing", shortDigest(uri))
+			}
+		}
+		for name, state := range d.state.Apps {
+			if len(only) > 0 && name != only {
+				continue
+			}
+			app := getApp(name)
+			app.Devices += 1
+			if len(state.State) > 0 && state.State != "healthy" {
+				app.Unhealthy += 1
+				outlier(app, d.device, "", "unhealthy", state.State)
+			}
+
+			var expectedImage map[string]string
+			if d.expected != nil {
+				uri, ok := d.expected[name]
+				switch {
+				case !ok:
+					app.Unexpected += 1
+					outlier(app, d.device, "", "unexpected", state.Uri)
+				case len(state.Uri) > 0 && state.Uri != uri:
+					app.Outdated += 1
+					outlier(app, d.device, "", "outdated", fmt.Sprintf("%s, expected %s", shortDigest(state.Uri), shortDigest(uri)))
+				default:
+					expectedImage = images.get(d.device.TargetName, name, uri)
+				}
+			}
+
+			for _, s := range state.Services {
+				svc, ok := services[name][s.Name]
+				if !ok {
+					svc = &serviceHealth{Service: s.Name, Images: []imageDevice{}}
+					services[name][s.Name] = svc
+					serviceImages[name][s.Name] = make(map[string]*imageDevice)
+				}
+				svc.Devices += 1
+				if s.Health == "unhealthy" {
+					svc.Unhealthy += 1
+					outlier(app, d.device, s.Name, "unhealthy", s.Status)
+				}
+				if s.State == "restarting" {
+					svc.Restarting += 1
+					outlier(app, d.device, s.Name, "restarting", s.Status)
+				}
+				img, ok := serviceImages[name][s.Name][s.ImageUri]
+				if !ok {
+					img = &imageDevice{Image: s.ImageUri}
+					serviceImages[name][s.Name][s.ImageUri] = img
+				}
+				img.Devices += 1
+				if want, ok := expectedImage[s.Name]; ok {
+					if want == s.ImageUri {
+						img.Expected = true
+					} else {
+						outlier(app, d.device, s.Name, "wrong-image", fmt.Sprintf("%s, expected %s", shortDigest(s.ImageUri), shortDigest(want)))
+					}
+				}
+			}
+		}
+	}
+
+	var result []appHealth
+	for name, app := range apps {
+		for svcName, svc := range services[name] {
+			for _, img := range serviceImages[name][svcName] {
+				svc.Images = append(svc.Images, *img)
+			}
+			sort.Slice(svc.Images, func(i, j int) bool {
+				if svc.Images[i].Devices != svc.Images[j].Devices {
+					return svc.Images[i].Devices > svc.Images[j].Devices
+				}
+				return svc.Images[i].Image < svc.Images[j].Image
+			})
+			app.Services = append(app.Services, *svc)
+		}
+		sort.Slice(app.Services, func(i, j int) bool { return app.Services[i].Service < app.Services[j].Service })
+		sort.SliceStable(app.Outliers, func(i, j int) bool {
+			a, b := app.Outliers[i], app.Outliers[j]
+			if a.Device != b.Device {
+				return a.Device < b.Device
+			}
+			return a.Service < b.Service
+		})
+		result = append(result, *app)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].App < result[j].App })
+	if result == nil {
+		result = []appHealth{}
+	}
+	return result
+}
+
+// shortDigest shortens an image or App URI to its digest, which is what differs between versions.
+func shortDigest(uri string) string {
+	if _, digest, ok := strings.Cut(uri, "@sha256:"); ok {
+		if len(digest) > 12 {
+			digest = digest[:12]
+		}
+		return "sha256:" + digest
+	}
+	return uri
+}
+
+func printAppsHealth(report appsHealthReport, maxOutliers int) {
+	fmt.Printf("Apps of %d devices", report.Devices)
+	if len(report.NoReport) > 0 {
+		fmt.Printf(", %d did not report App states: %s", len(report.NoReport), strings.Join(report.NoReport, ", "))
+	}
+	fmt.Println()
+	for _, e := range report.FetchErrors {
+		fmt.Println("ERROR:", e)
+	}
+	if len(report.Apps) == 0 {
+		return
+	}
+
+	fmt.Println()
+	t := tabby.New()
+	t.AddHeader("APP", "DEVICES", "UNHEALTHY", "MISSING", "UNEXPECTED", "OUTDATED")
+	for _, app := range report.Apps {
+		t.AddLine(app.App, app.Devices, app.Unhealthy, app.Missing, app.Unexpected, app.Outdated)
+	}
+	t.Print()
+
+	for _, app := range report.Apps {
+		fmt.Printf("\n%s:\n", app.App)
+		if len(app.Services) > 0 {
+			t = tabby.New()
+			t.AddHeader("SERVICE", "DEVICES", "UNHEALTHY", "RESTARTING", "IMAGES")
+			for _, svc := range app.Services {
+				var images []string
+				for _, img := range svc.Images {
+					text := fmt.Sprintf("%s (%d", shortDigest(img.Image), img.Devices)
+					if img.Expected {
+						text += ", expected"
+					}
+					images = append(images, text+")")
+				}
+				t.AddLine(svc.Service, svc.Devices, svc.Unhealthy, svc.Restarting, strings.Join(images, ", "))
+			}
+			t.Print()
+		}
+		if len(app.Outliers) == 0 {
+			continue
+		}
+		fmt.Println("  Outliers:")
+		t = tabby.New()
+		for idx, o := range app.Outliers {
+			if maxOutliers > 0 && idx == maxOutliers {
+				t.AddLine("    ...", fmt.Sprintf("and %d more", len(app.Outliers)-maxOutliers))
+				break
+			}
+			t.AddLine("    "+o.Device, o.Service, o.Problem, o.Details)
+		}
+		t.Print()
+	}
+}
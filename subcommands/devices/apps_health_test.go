@@ -0,0 +1,138 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func TestAggregateAppsHealth(t *testing.T) {
+	const (
+		appV1   = "hub.foundries.io/acme/shellhttpd@sha256:111111111111aaaa"
+		appV2   = "hub.foundries.io/acme/shellhttpd@sha256:222222222222bbbb"
+		imageV1 = "hub.foundries.io/acme/httpd@sha256:333333333333cccc"
+		imageV2 = "hub.foundries.io/acme/httpd@sha256:444444444444dddd"
+	)
+	images := &expectedImages{byUri: map[string]map[string]string{
+		appV1: {"httpd": imageV1},
+	}}
+	running := func(state string, services ...client.AppServiceState) client.AppState {
+		return client.AppState{State: state, Uri: appV1, Services: services}
+	}
+	service := func(image, state, health string) client.AppServiceState {
+		return client.AppServiceState{Name: "httpd", ImageUri: image, State: state, Health: health, Status: state}
+	}
+	device := func(name string, expected map[string]string, apps map[string]client.AppState) deviceApps {
+		d := deviceApps{device: client.Device{Name: name, Uuid: name + "-uuid"}, expected: expected}
+		if apps != nil {
+			d.state = &client.AppsState{Apps: apps}
+		}
+		return d
+	}
+	expectV1 := map[string]string{"shellhttpd": appV1}
+
+	tests := []struct {
+		name    string
+		devices []deviceApps
+		only    string
+		apps    []appHealth
+	}{
+		{
+			"no App states",
+			[]deviceApps{device("dev-1", expectV1, nil)},
+			"",
+			[]appHealth{},
+		},
+		{
+			"healthy",
+			[]deviceApps{
+				device("dev-1", expectV1, map[string]client.AppState{"shellhttpd": running("healthy", service(imageV1, "running", "healthy"))}),
+				device("dev-2", expectV1, map[string]client.AppState{"shellhttpd": running("healthy", service(imageV1, "running", "healthy"))}),
+			},
+			"",
+			[]appHealth{{
+				App:     "shellhttpd",
+				Devices: 2,
+				Services: []serviceHealth{{
+					Service: "httpd", Devices: 2, Images: []imageDevice{{Image: imageV1, Devices: 2, Expected: true}},
+				}},
+				Outliers: []appOutlier{},
+			}},
+		},
+		{
+			"missing, unexpected and outdated",
+			[]deviceApps{
+				device("dev-2", expectV1, map[string]client.AppState{}),
+				device("dev-1", map[string]string{}, map[string]client.AppState{"shellhttpd": running("healthy")}),
+				device("dev-3", expectV1, map[string]client.AppState{"shellhttpd": {State: "healthy", Uri: appV2}}),
+			},
+			"",
+			[]appHealth{{
+				App:        "shellhttpd",
+				Devices:    2,
+				Missing:    1,
+				Unexpected: 1,
+				Outdated:   1,
+				Services:   []serviceHealth{},
+				Outliers: []appOutlier{
+					{Device: "dev-1", Uuid: "dev-1-uuid", Problem: "unexpected", Details: appV1},
+					{Device: "dev-2", Uuid: "dev-2-uuid", Problem: "missing", Details: "sha256:111111111111"},
+					{Device: "dev-3", Uuid: "dev-3-uuid", Problem: "outdated", Details: "sha256:222222222222, expected sha256:111111111111"},
+				},
+			}},
+		},
+		{
+			"unhealthy, restarting and wrong image",
+			[]deviceApps{
+				device("dev-1", expectV1, map[string]client.AppState{"shellhttpd": running("unhealthy", service(imageV1, "running", "unhealthy"))}),
+				device("dev-2", expectV1, map[string]client.AppState{"shellhttpd": running("healthy", service(imageV2, "restarting", ""))}),
+				device("dev-3", expectV1, map[string]client.AppState{"shellhttpd": running("healthy", service(imageV2, "running", "healthy"))}),
+			},
+			"",
+			[]appHealth{{
+				App:       "shellhttpd",
+				Devices:   3,
+				Unhealthy: 1,
+				Services: []serviceHealth{{
+					Service:    "httpd",
+					Devices:    3,
+					Unhealthy:  1,
+					Restarting: 1,
+					Images: []imageDevice{
+						{Image: imageV2, Devices: 2},
+						{Image: imageV1, Devices: 1, Expected: true},
+					},
+				}},
+				Outliers: []appOutlier{
+					{Device: "dev-1", Uuid: "dev-1-uuid", Problem: "unhealthy", Details: "unhealthy"},
+					{Device: "dev-1", Uuid: "dev-1-uuid", Service: "httpd", Problem: "unhealthy", Details: "running"},
+					{Device: "dev-2", Uuid: "dev-2-uuid", Service: "httpd", Problem: "restarting", Details: "restarting"},
+					{Device: "dev-2", Uuid: "dev-2-uuid", Service: "httpd", Problem: "wrong-image", Details: "sha256:444444444444, expected sha256:333333333333"},
+					{Device: "dev-3", Uuid: "dev-3-uuid", Service: "httpd", Problem: "wrong-image", Details: "sha256:444444444444, expected sha256:333333333333"},
+				},
+			}},
+		},
+		{
+			"only one App",
+			[]deviceApps{
+				device("dev-1", map[string]string{"shellhttpd": appV1, "fluentd": "fluentd@sha256:55"}, map[string]client.AppState{
+					"shellhttpd": running("healthy"),
+				}),
+			},
+			"shellhttpd",
+			[]appHealth{{
+				App:      "shellhttpd",
+				Devices:  1,
+				Services: []serviceHealth{},
+				Outliers: []appOutlier{},
+			}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.apps, aggregateAppsHealth(tc.devices, images, tc.only))
+		})
+	}
+}
@@ -22,12 +22,19 @@ func init() {
 	}
 	cmd.AddCommand(appsStatesCmd)
 	appsStatesCmd.Flags().IntVarP(&asListLimit, "limit", "n", 1, "Limit the number of App states to display.")
+	subcommands.AddOutputFlag(appsStatesCmd)
 }
 
 func doListStates(cmd *cobra.Command, args []string) {
 	d := getDeviceApi(cmd, args[0])
 	states, err := d.GetAppsStates()
 	subcommands.DieNotNil(err)
+	if limit := max(asListLimit, 0); len(states.States) > limit {
+		states.States = states.States[:limit]
+	}
+	if subcommands.PrintOutput(cmd, states.States) {
+		return
+	}
 
 	printAppsState := func(appsState map[string]client.AppState, stateFilter string, filterIn bool) {
 		for name, state := range appsState {
@@ -0,0 +1,319 @@
+package devices
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+type bulkOptions struct {
+	byTag        string
+	byGroup      string
+	byTarget     string
+	offlineHours int
+	namePattern  string
+	uuidsFile    string
+	all          bool
+	parallel     int
+	dryRun       bool
+	yes          bool
+	report       string
+	reportFormat string
+}
+
+var bulkOpts bulkOptions
+
+var bulkCmd = &cobra.Command{
+	Use:   "bulk",
+	Short: "Perform an action on many devices at once",
+	Long: `Perform an action on all devices matching a selection.
+
+Devices are selected with the same filters as "fioctl devices list", a name
+pattern, or a file with a device UUID per line. Filters can be combined, in
+which case a device must match all of them. At least one selector, or --all,
+is required to protect against changing the whole Factory by accident.
+
+The action runs on several devices in parallel. The result for each device is
+printed as it completes, and can be saved into a JSON or CSV report. The
+command exits with an error if the action failed on any device.`,
+	Example: `
+  # Preview which devices would be moved to the "beta" group:
+  fioctl devices bulk set-group beta --by-tag devel --dry-run
+
+  # Move devices listed in a file, and save a report of the results:
+  fioctl devices bulk set-group beta --uuids-file uuids.txt --report results.csv --report-format csv
+
+  # Delete devices that have not been seen for 30 days:
+  fioctl devices bulk delete --name 'test-*' --offline-hours 720
+
+  # Make devices of a group follow a tag:
+  fioctl devices bulk config updates --by-group beta --tag beta`,
+}
+
+var bulkConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Change the configuration of many devices",
+}
+
+func init() {
+	cmd.AddCommand(bulkCmd)
+	bulkCmd.AddCommand(bulkConfigCmd)
+
+	flags := bulkCmd.PersistentFlags()
+	flags.StringVarP(&bulkOpts.byTag, "by-tag", "", "", "Select devices configured with the given tag")
+	flags.StringVarP(&bulkOpts.byGroup, "by-group", "g", "", "Select devices belonging to this group")
+	flags.StringVarP(&bulkOpts.byTarget, "by-target", "", "", "Select devices updated to the given target name")
+	flags.IntVarP(&bulkOpts.offlineHours, "offline-hours", "", 0, "Select devices not seen in the last X hours")
+	flags.StringVarP(&bulkOpts.namePattern, "name", "", "", "Select devices with names matching a filepath style pattern, e.g. device-*")
+	flags.StringVarP(&bulkOpts.uuidsFile, "uuids-file", "", "", "Select devices with UUIDs listed in a file, one per line. Use - to read from STDIN")
+	flags.BoolVarP(&bulkOpts.all, "all", "", false, "Select all devices of the Factory")
+	flags.IntVarP(&bulkOpts.parallel, "parallel", "j", 8, "Number of devices to act on at the same time")
+	flags.BoolVarP(&bulkOpts.dryRun, "dry-run", "", false, "Only show what would be done")
+	flags.StringVarP(&bulkOpts.report, "report", "", "", "Save the result for each device into a file")
+	flags.StringVarP(&bulkOpts.reportFormat, "report-format", "", "json", "Format of the report: json or csv")
+}
+
+// errNoChange is returned by a bulk action when a device already has the requested state.
+var errNoChange = errors.New("No changes needed")
+
+// bulkAction changes a single device, or only checks what it would change in a dry run.
+// It returns a description of the change for the report.
+type bulkAction func(device client.Device, dapi client.DeviceApi, dryRun bool) (string, error)
+
+const (
+	bulkStatusOk        = "ok"
+	bulkStatusDryRun    = "dry-run"
+	bulkStatusUnchanged = "unchanged"
+	bulkStatusFailed    = "failed"
+	bulkStatusSkipped   = "skipped"
+)
+
+type bulkResult struct {
+	Uuid    string `json:"uuid"`
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Details string `json:"details"`
+	Error   string `json:"error"`
+}
+
+func (o bulkOptions) validate() error {
+	if !o.all && len(o.byTag) == 0 && len(o.byGroup) == 0 && len(o.byTarget) == 0 &&
+		o.offlineHours == 0 && len(o.namePattern) == 0 && len(o.uuidsFile) == 0 {
+		return fmt.Errorf("Select devices with a filter, or explicitly select --all devices")
+	}
+	if o.parallel < 1 {
+		return fmt.Errorf("Invalid value for --parallel: %d, must be at least 1", o.parallel)
+	}
+	if o.offlineHours < 0 {
+		return fmt.Errorf("Invalid value for --offline-hours: %d", o.offlineHours)
+	}
+	if o.reportFormat != "json" && o.reportFormat != "csv" {
+		return fmt.Errorf("Invalid report format %q: must be json or csv", o.reportFormat)
+	}
+	return nil
+}
+
+func readUuids(path string) (map[string]bool, error) {
+	f := os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, fmt.Errorf("Unable to read UUIDs: %w", err)
+		}
+		defer f.Close()
+	}
+	uuids := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			uuids[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read UUIDs: %w", err)
+	}
+	return uuids, nil
+}
+
+// selectDevices returns the devices matching all selectors. The server does the filtering except
+// for the UUIDs file and the offline hours. UUIDs from the file which match no device are
+// reported as failures, so that they are not silently lost.
+func selectDevices(factory string, opts bulkOptions) ([]client.Device, []bulkResult) {
+	var uuids map[string]bool
+	if len(opts.uuidsFile) > 0 {
+		var err error
+		uuids, err = readUuids(opts.uuidsFile)
+		subcommands.DieNotNil(err)
+	}
+
+	filterBy := map[string]string{
+		"factory":     factory,
+		"group":       opts.byGroup,
+		"match_tag":   opts.byTag,
+		"target_name": opts.byTarget,
+		"name":        opts.namePattern,
+	}
+	if len(uuids) == 1 {
+		for uuid := range uuids {
+			filterBy["uuid"] = uuid
+		}
+	}
+
+	var selected []client.Device
+	found := make(map[string]bool)
+	for device, err := range api.DeviceListAll(filterBy, "name", 1000) {
+		subcommands.DieNotNil(err)
+		if uuids != nil && !uuids[device.Uuid] {
+			continue
+		}
+		found[device.Uuid] = true
+		if opts.offlineHours > 0 && device.Online(opts.offlineHours) {
+			continue
+		}
+		selected = append(selected, device)
+	}
+
+	var missing []bulkResult
+	for uuid := range uuids {
+		if !found[uuid] {
+			missing = append(missing, bulkResult{
+				Uuid: uuid, Status: bulkStatusFailed, Error: "Device not found or does not match the filters",
+			})
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].Uuid < missing[j].Uuid })
+	return selected, missing
+}
+
+// runBulk runs the action on the selected devices with at most opts.parallel at a time. Unless
+// the question is empty, the selected devices are listed and the question, formatted with their
+// count, must be answered with yes first.
+func runBulk(ctx context.Context, question string, action bulkAction) {
+	subcommands.DieNotNil(bulkOpts.validate())
+	var report *os.File
+	if len(bulkOpts.report) > 0 {
+		// Fail before making any changes if the report cannot be saved
+		var err error
+		report, err = os.Create(bulkOpts.report)
+		subcommands.DieNotNil(err, "Unable to create report:")
+	}
+	factory := viper.GetString("factory")
+	devices, results := selectDevices(factory, bulkOpts)
+	logrus.Debugf("Running a bulk action on %d devices", len(devices))
+	if len(devices) == 0 && len(results) == 0 {
+		fmt.Println("No devices match the selection")
+	}
+	if len(question) > 0 && len(devices) > 0 && !bulkOpts.dryRun && !bulkOpts.yes && !confirmBulk(question, devices) {
+		if report != nil {
+			report.Close()
+			os.Remove(bulkOpts.report)
+		}
+		fmt.Println("No devices were changed")
+		return
+	}
+
+	var lock sync.Mutex
+	devResults := make([]bulkResult, len(devices))
+	forEachParallel(bulkOpts.parallel, len(devices), func(idx int) {
+		res := runBulkAction(ctx, factory, devices[idx], action, bulkOpts.dryRun)
+		devResults[idx] = res
+		lock.Lock()
+		defer lock.Unlock()
+		printBulkResult(res)
+	})
+	for _, res := range results {
+		printBulkResult(res)
+	}
+	results = append(devResults, results...)
+
+	counts := make(map[string]int)
+	for _, res := range results {
+		counts[res.Status] += 1
+	}
+	fmt.Println()
+	for _, status := range []string{bulkStatusOk, bulkStatusDryRun, bulkStatusUnchanged, bulkStatusFailed, bulkStatusSkipped} {
+		if counts[status] > 0 {
+			fmt.Printf("%-10s %d\n", status+":", counts[status])
+		}
+	}
+
+	if report != nil {
+		err := subcommands.WriteOutput(report, bulkOpts.reportFormat, results)
+		if closeErr := report.Close(); err == nil {
+			err = closeErr
+		}
+		subcommands.DieNotNil(err, "Unable to save report:")
+		fmt.Println("Report saved to", bulkOpts.report)
+	}
+	if counts[bulkStatusFailed] > 0 || counts[bulkStatusSkipped] > 0 {
+		os.Exit(subcommands.ExitError)
+	}
+}
+
+func confirmBulk(question string, devices []client.Device) bool {
+	t := tabby.New()
+	t.AddHeader("NAME", "UUID", "GROUP", "LAST SEEN")
+	for _, d := range devices {
+		t.AddLine(d.Name, d.Uuid, d.GroupName, d.LastSeen)
+	}
+	t.Print()
+	fmt.Printf("\n%d devices selected\n", len(devices))
+	if bulkOpts.uuidsFile == "-" {
+		// The standard input was used up by the UUIDs
+		subcommands.DieNotNil(errors.New("The UUIDs are read from STDIN, so use --yes to confirm the change"))
+	}
+	return subcommands.Confirm(fmt.Sprintf(question, len(devices)))
+}
+
+func runBulkAction(ctx context.Context, factory string, device client.Device, action bulkAction, dryRun bool) bulkResult {
+	res := bulkResult{Uuid: device.Uuid, Name: device.Name}
+	// An interrupted or timed out command does not start new changes
+	if err := ctx.Err(); err != nil {
+		res.Status = bulkStatusSkipped
+		res.Error = err.Error()
+		return res
+	}
+	details, err := action(device, api.DeviceApiByUuid(factory, device.Uuid), dryRun)
+	res.Details = details
+	switch {
+	case errors.Is(err, errNoChange):
+		res.Status = bulkStatusUnchanged
+	case err != nil:
+		res.Status = bulkStatusFailed
+		res.Error = err.Error()
+	case dryRun:
+		res.Status = bulkStatusDryRun
+	default:
+		res.Status = bulkStatusOk
+	}
+	return res
+}
+
+func printBulkResult(res bulkResult) {
+	name := res.Name
+	if len(name) == 0 {
+		name = "?"
+	}
+	line := fmt.Sprintf("%s (%s): %s", name, res.Uuid, res.Status)
+	if len(res.Details) > 0 {
+		line += " - " + res.Details
+	}
+	if len(res.Error) > 0 {
+		line += " - " + res.Error
+	}
+	fmt.Println(line)
+}
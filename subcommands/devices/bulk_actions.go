@@ -0,0 +1,223 @@
+package devices
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	setGroupCmd := &cobra.Command{
+		Use:   "set-group [<group>]",
+		Short: "Assign the selected devices to an existing Factory device group",
+		Run:   doBulkSetGroup,
+		Args:  cobra.MaximumNArgs(1),
+	}
+	setGroupCmd.Flags().Bool("unset", false, "Unset an associated device group")
+	bulkCmd.AddCommand(setGroupCmd)
+
+	bulkCmd.AddCommand(&cobra.Command{
+		Use:   "chown <new-owner-id>",
+		Short: "Change the owner of the selected devices",
+		Run:   doBulkChown,
+		Args:  cobra.ExactArgs(1),
+		Long: `Change the owner of the selected devices. This command can only be run by
+Factory admins and owners. The new owner-id can be found by running 'fioctl users'`,
+	})
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete the selected devices",
+		Long: `Delete the selected devices. The devices are listed, and only deleted once
+confirmed, unless --yes is given.`,
+		Run:  doBulkDelete,
+		Args: cobra.NoArgs,
+	}
+	bulkCmd.AddCommand(deleteCmd)
+	deleteCmd.Flags().BoolVarP(&bulkOpts.yes, "yes", "y", false, "Delete the devices without asking for a confirmation")
+
+	setConfigCmd := &cobra.Command{
+		Use:   "set <file1=content> <file2=content ...>",
+		Short: "Create a secure configuration for the selected devices",
+		Long: `Creates a secure configuration for each selected device, encrypting the
+contents of each file using the device's public key. The arguments are the same
+as for "fioctl devices config set".`,
+		Run:  doBulkConfigSet,
+		Args: cobra.MinimumNArgs(1),
+	}
+	setConfigCmd.Flags().StringP("reason", "m", "", "Add a message to store as the \"reason\" for this change")
+	setConfigCmd.Flags().BoolP("raw", "", false, "Use raw configuration file")
+	setConfigCmd.Flags().BoolP("create", "", false, "Replace the whole config with these values. Default is to merge these values with the existing config values")
+	subcommands.AddSkipValidationFlag(setConfigCmd)
+	bulkConfigCmd.AddCommand(setConfigCmd)
+
+	configUpdatesCmd := &cobra.Command{
+		Use:   "updates",
+		Short: "Configure aktualizr-lite settings for how updates are applied to the selected devices",
+		Long: `Change configuration parameters used by aktualizr-lite for updating the selected
+devices. The values are the same as for "fioctl devices config updates".`,
+		Run:  doBulkConfigUpdates,
+		Args: cobra.NoArgs,
+	}
+	configUpdatesCmd.Flags().StringP("tag", "", "", "Target tag for devices to follow")
+	configUpdatesCmd.Flags().StringP("apps", "", "", "comma,separate,list")
+	configUpdatesCmd.Flags().BoolP("force", "", false, "DANGER: For a config on a device that might result in corruption")
+	bulkConfigCmd.AddCommand(configUpdatesCmd)
+}
+
+func doBulkSetGroup(cmd *cobra.Command, args []string) {
+	unset, _ := cmd.Flags().GetBool("unset")
+	var group string
+	if unset {
+		if len(args) == 1 {
+			subcommands.DieNotNil(fmt.Errorf("Cannot assign and unset a device group in one command"))
+		}
+	} else {
+		if len(args) == 0 {
+			subcommands.DieNotNil(fmt.Errorf("Either device group or --unset option must be provided"))
+		}
+		group = args[0]
+		groups, err := api.FactoryListDeviceGroup(viper.GetString("factory"))
+		subcommands.DieNotNil(err)
+		found := false
+		for _, g := range *groups {
+			found = found || g.Name == group
+		}
+		if !found {
+			subcommands.DieNotNil(fmt.Errorf("Device group %s does not exist", group))
+		}
+	}
+
+	runBulk(cmd.Context(), "", func(device client.Device, dapi client.DeviceApi, dryRun bool) (string, error) {
+		if device.GroupName == group {
+			return "", errNoChange
+		}
+		details := fmt.Sprintf("group: %q -> %q", device.GroupName, group)
+		if dryRun {
+			return details, nil
+		}
+		return details, dapi.SetGroup(group)
+	})
+}
+
+func doBulkChown(cmd *cobra.Command, args []string) {
+	owner := args[0]
+	users, err := api.UsersList(viper.GetString("factory"))
+	subcommands.DieNotNil(err)
+	found := false
+	for _, u := range users {
+		found = found || u.PolisId == owner
+	}
+	if !found {
+		subcommands.DieNotNil(fmt.Errorf("User %s is not a member of the Factory", owner))
+	}
+
+	runBulk(cmd.Context(), "", func(device client.Device, dapi client.DeviceApi, dryRun bool) (string, error) {
+		if device.Owner == owner {
+			return "", errNoChange
+		}
+		details := fmt.Sprintf("owner: %q -> %q", device.Owner, owner)
+		if dryRun {
+			return details, nil
+		}
+		return details, dapi.Chown(owner)
+	})
+}
+
+func doBulkDelete(cmd *cobra.Command, args []string) {
+	runBulk(cmd.Context(), "Delete these %d devices?", func(device client.Device, dapi client.DeviceApi, dryRun bool) (string, error) {
+		if dryRun {
+			return "delete", nil
+		}
+		return "delete", dapi.Delete()
+	})
+}
+
+func doBulkConfigSet(cmd *cobra.Command, args []string) {
+	reason, _ := cmd.Flags().GetString("reason")
+	isRaw, _ := cmd.Flags().GetBool("raw")
+	shouldCreate, _ := cmd.Flags().GetBool("create")
+	skipValidation, _ := cmd.Flags().GetBool("skip-validation")
+	cfg := subcommands.NewConfigRequest(reason, args, isRaw)
+	if !skipValidation {
+		subcommands.ValidateConfig(cfg.Files, true)
+	}
+
+	var names []string
+	for _, f := range cfg.Files {
+		names = append(names, f.Name)
+	}
+	details := "files: " + strings.Join(names, ", ")
+
+	runBulk(cmd.Context(), "", func(device client.Device, dapi client.DeviceApi, dryRun bool) (string, error) {
+		// Files are encrypted with the public key of each device, which is only returned by Get
+		d, err := dapi.Get()
+		if err != nil {
+			return details, err
+		}
+		if len(d.PublicKey) == 0 {
+			return details, fmt.Errorf("Device has no public key to encrypt with")
+		}
+		pubkey, err := parseEciesPub(d.PublicKey)
+		if err != nil {
+			return details, err
+		}
+		devCfg := client.ConfigCreateRequest{Reason: cfg.Reason}
+		for _, f := range cfg.Files {
+			if !f.Unencrypted {
+				if f.Value, err = encryptEcies(f.Value, pubkey); err != nil {
+					return details, err
+				}
+			}
+			devCfg.Files = append(devCfg.Files, f)
+		}
+		if dryRun {
+			return details, nil
+		}
+		if shouldCreate {
+			return details, dapi.CreateConfig(devCfg)
+		}
+		return details, dapi.PatchConfig(devCfg, false)
+	})
+}
+
+func doBulkConfigUpdates(cmd *cobra.Command, args []string) {
+	updateApps, _ := cmd.Flags().GetString("apps")
+	updateTag, _ := cmd.Flags().GetString("tag")
+	isForced, _ := cmd.Flags().GetBool("force")
+	if len(updateApps) == 0 && len(updateTag) == 0 {
+		subcommands.DieNotNil(fmt.Errorf("At least one of --tag or --apps must be provided"))
+	}
+	subcommands.DieNotNil(subcommands.ValidateUpdatesConfig(updateTag, updateApps))
+
+	var changes []string
+	if len(updateTag) > 0 {
+		changes = append(changes, "tag: "+updateTag)
+	}
+	if len(updateApps) > 0 {
+		changes = append(changes, "apps: "+updateApps)
+	}
+	details := strings.Join(changes, ", ")
+
+	runBulk(cmd.Context(), "", func(device client.Device, dapi client.DeviceApi, dryRun bool) (string, error) {
+		dcl, err := dapi.ListConfig()
+		if err != nil && !isForced {
+			return details, fmt.Errorf("Failed to fetch existing config changelog (override with --force): %w", err)
+		}
+		cfg, err := subcommands.UpdatesConfigChange(dcl, updateTag, updateApps, isForced)
+		if err != nil {
+			return details, err
+		} else if cfg == nil {
+			return "", errNoChange
+		}
+		if dryRun {
+			return details, nil
+		}
+		return details, dapi.PatchConfig(*cfg, isForced)
+	})
+}
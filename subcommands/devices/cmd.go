@@ -2,6 +2,7 @@ package devices
 
 import (
 	"fmt"
+	"sync"
 
 	"golang.org/x/exp/slices"
 
@@ -54,6 +55,9 @@ fioctl devices updates <device> <update-id>
 
 # Show the most recent update with bash help:
 fioctl devices updates <device> $(fioctl devices updates <device> -n1 | tail -n1 | cut -f1 -d\ )
+
+# Group the failed updates of all devices in the last 7 days by their cause:
+fioctl devices updates failures --since 7d
 `,
 }
 
@@ -61,6 +65,9 @@ func NewCommand() *cobra.Command {
 	subcommands.RequireFactory(cmd)
 
 	updatesCmd.Flags().IntVarP(&listLimit, "limit", "n", 0, "Limit the number of updates displayed.")
+	updatesCmd.Flags().Bool("all", false, "Display the updates from all pages. This is the default unless --limit is set, which it cannot be combined with.")
+	updatesCmd.MarkFlagsMutuallyExclusive("all", "limit")
+	subcommands.AddOutputFlag(updatesCmd)
 
 	cmd.AddCommand(configCmd)
 	cmd.AddCommand(updatesCmd)
@@ -71,11 +78,14 @@ func NewCommand() *cobra.Command {
 }
 
 func addUuidFlagToChildren(c *cobra.Command) {
-	ignores := []string{"list-denied", "list", "delete-denied"}
+	// These commands act on many devices, which they select with their own flags
+	ignores := []string{"list-denied", "list", "delete-denied", "bulk", "watch", "export", "snapshot", "failures", "apps-health", "prune", "restore-report", "import"}
 	for _, child := range c.Commands() {
-		if child.HasSubCommands() {
+		if slices.Contains(ignores, child.Name()) {
+			continue
+		} else if child.HasSubCommands() {
 			addUuidFlagToChildren(child)
-		} else if !slices.Contains(ignores, child.Name()) {
+		} else {
 			child.Flags().BoolP("by-uuid", "u", false, "Look up device by UUID rather than name")
 		}
 	}
@@ -98,3 +108,21 @@ func getDevice(cmd *cobra.Command, name string) *client.Device {
 	subcommands.DieNotNil(err)
 	return d
 }
+
+// forEachParallel calls fn with each index below count, with at most parallel calls at a time.
+func forEachParallel(parallel, count int, fn func(idx int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for idx := 0; idx < count; idx++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(idx)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,41 @@
+package devices
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	diffConfigCmd := &cobra.Command{
+		Use:   "diff <device> <entry> <entry>",
+		Short: "Show the differences between two entries of the device's configuration changelog",
+		Run:   doConfigDiff,
+		Args:  cobra.ExactArgs(3),
+		Long: `Show the differences between two entries of the device's configuration
+changelog, file by file. Entries are numbered as shown by "fioctl devices config
+log --numbered", from 0 for the config in effect. Encrypted values can only be decrypted
+by the device, so only whether they changed is shown.`,
+	}
+	configCmd.AddCommand(diffConfigCmd)
+	diffConfigCmd.Flags().IntP("unified", "U", 3, "Number of unchanged lines to show around each difference")
+}
+
+func doConfigDiff(cmd *cobra.Command, args []string) {
+	context, _ := cmd.Flags().GetInt("unified")
+	logrus.Debugf("Showing device config diff for %s", args[0])
+
+	d := getDeviceApi(cmd, args[0])
+	subcommands.DiffConfigs(&subcommands.DiffConfigsOptions{
+		From:      subcommands.ParseConfigEntry(args[1]),
+		To:        subcommands.ParseConfigEntry(args[2]),
+		Context:   max(context, 0),
+		Encrypted: true,
+		ListFunc: func() (*client.DeviceConfigList, error) {
+			return d.ListConfig()
+		},
+		ListContFunc: api.DeviceListConfigCont,
+	})
+}
@@ -0,0 +1,183 @@
+package devices
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	effectiveCmd := &cobra.Command{
+		Use:   "effective <device>",
+		Short: "Show the configuration a device ends up with",
+		Run:   doConfigEffective,
+		Args:  cobra.ExactArgs(1),
+		Long: `Show the configuration a device ends up with after merging the latest config
+of its Factory, of its device group, and of the device itself.
+
+A file of the device config replaces the file with the same name in the group
+and Factory configs, and a file of the group config replaces the file with the
+same name in the Factory config. Each file is shown with the layer it comes
+from, the lower layers it shadows, and its on-changed handler.
+
+The values of encrypted files are not shown, as only the device can decrypt them.`,
+	}
+	configCmd.AddCommand(effectiveCmd)
+	effectiveCmd.Flags().BoolP("no-values", "", false, "Only show the files, and not their values")
+	subcommands.AddOutputFlag(effectiveCmd)
+}
+
+const (
+	layerFactory = "factory"
+	layerGroup   = "group"
+	layerDevice  = "device"
+)
+
+// configLayer is the latest config of a layer, or nil if the layer has no config. Only the
+// merged files are output, so that encrypted values are not.
+type configLayer struct {
+	Layer     string               `json:"layer"`
+	Name      string               `json:"name"`
+	CreatedAt string               `json:"created-at,omitempty"`
+	CreatedBy string               `json:"created-by,omitempty"`
+	Reason    string               `json:"reason,omitempty"`
+	Config    *client.DeviceConfig `json:"-"`
+}
+
+type effectiveFile struct {
+	Name        string   `json:"name"`
+	Layer       string   `json:"layer"`
+	Shadows     []string `json:"shadows,omitempty"`
+	OnChanged   []string `json:"on-changed,omitempty"`
+	Unencrypted bool     `json:"unencrypted"`
+	Value       string   `json:"value,omitempty"`
+}
+
+type effectiveConfig struct {
+	Device string          `json:"device"`
+	Uuid   string          `json:"uuid"`
+	Layers []configLayer   `json:"layers"`
+	Files  []effectiveFile `json:"files"`
+}
+
+func doConfigEffective(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	noValues, _ := cmd.Flags().GetBool("no-values")
+	logrus.Debugf("Showing effective config of %s", args[0])
+
+	dapi := getDeviceApi(cmd, args[0])
+	device, err := dapi.Get()
+	subcommands.DieNotNil(err)
+	group := device.GroupName
+	if len(group) == 0 && device.Group != nil {
+		group = device.Group.Name
+	}
+
+	cfgs, err := api.FactoryListConfig(factory)
+	subcommands.DieNotNil(err)
+	layers := []configLayer{newConfigLayer(layerFactory, factory, cfgs)}
+	if len(group) > 0 {
+		cfgs, err = api.GroupListConfig(factory, group)
+		subcommands.DieNotNil(err)
+		layers = append(layers, newConfigLayer(layerGroup, group, cfgs))
+	}
+	cfgs, err = dapi.ListConfig()
+	subcommands.DieNotNil(err)
+	layers = append(layers, newConfigLayer(layerDevice, device.Name, cfgs))
+
+	effective := effectiveConfig{Device: device.Name, Uuid: device.Uuid, Layers: layers, Files: mergeConfigLayers(layers)}
+	if subcommands.PrintOutput(cmd, effective) {
+		return
+	}
+
+	fmt.Println("Layers:")
+	for _, l := range layers {
+		name := l.Layer
+		if l.Layer == layerGroup {
+			name += " " + l.Name
+		}
+		if l.Config == nil {
+			fmt.Printf("  %-8s (no config)\n", name)
+		} else {
+			fmt.Printf("  %-8s %s - %s\n", name, l.Config.CreatedAt, l.Config.Reason)
+		}
+	}
+	fmt.Println()
+	if len(effective.Files) == 0 {
+		fmt.Println("The device has no config files")
+		return
+	}
+
+	t := tabby.New()
+	t.AddHeader("FILE", "LAYER", "SHADOWS", "ON-CHANGED")
+	for _, f := range effective.Files {
+		t.AddLine(f.Name, f.Layer, strings.Join(f.Shadows, ", "), strings.Join(f.OnChanged, " "))
+	}
+	t.Print()
+	if noValues {
+		return
+	}
+	for _, f := range effective.Files {
+		fmt.Printf("\n%s:\n", f.Name)
+		if !f.Unencrypted {
+			fmt.Println("  | <encrypted>")
+			continue
+		}
+		fmt.Println("  | " + strings.ReplaceAll(strings.TrimRight(f.Value, "\n"), "\n", "\n  | "))
+	}
+}
+
+// newConfigLayer returns a layer with the config in effect, which is the most recent one.
+func newConfigLayer(layer, name string, cfgs *client.DeviceConfigList) configLayer {
+	l := configLayer{Layer: layer, Name: name}
+	if len(cfgs.Configs) > 0 {
+		l.Config = &cfgs.Configs[0]
+		l.CreatedAt = l.Config.CreatedAt
+		l.CreatedBy = l.Config.CreatedBy
+		l.Reason = l.Config.Reason
+	}
+	return l
+}
+
+// mergeConfigLayers merges the layers from the lowest to the highest, the way fioconfig does on
+// the device: a file of a higher layer replaces the whole file of a lower layer, including its
+// on-changed handler. The values of encrypted files are left out.
+func mergeConfigLayers(layers []configLayer) []effectiveFile {
+	files := make(map[string]*effectiveFile)
+	for _, l := range layers {
+		if l.Config == nil {
+			continue
+		}
+		for _, f := range l.Config.Files {
+			var shadows []string
+			if prev, ok := files[f.Name]; ok {
+				shadows = append([]string{prev.Layer}, prev.Shadows...)
+			}
+			merged := &effectiveFile{
+				Name:        f.Name,
+				Layer:       l.Layer,
+				Shadows:     shadows,
+				OnChanged:   f.OnChanged,
+				Unencrypted: f.Unencrypted,
+			}
+			if f.Unencrypted {
+				merged.Value = f.Value
+			}
+			files[f.Name] = merged
+		}
+	}
+	var merged []effectiveFile
+	for _, f := range files {
+		merged = append(merged, *f)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name < merged[j].Name })
+	return merged
+}
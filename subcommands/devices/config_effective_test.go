@@ -0,0 +1,62 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func TestMergeConfigLayers(t *testing.T) {
+	layer := func(name string, files ...client.ConfigFile) configLayer {
+		return configLayer{Layer: name, Config: &client.DeviceConfig{Files: files}}
+	}
+	plain := func(name, value string) client.ConfigFile {
+		return client.ConfigFile{Name: name, Value: value, Unencrypted: true}
+	}
+
+	tests := []struct {
+		name   string
+		layers []configLayer
+		files  []effectiveFile
+	}{
+		{"no configs", []configLayer{{Layer: layerFactory}, {Layer: layerDevice}}, nil},
+		{
+			"one layer",
+			[]configLayer{layer(layerFactory, plain("npmrc", "x"), plain("fio-updates", "y"))},
+			[]effectiveFile{
+				{Name: "fio-updates", Layer: layerFactory, Unencrypted: true, Value: "y"},
+				{Name: "npmrc", Layer: layerFactory, Unencrypted: true, Value: "x"},
+			},
+		},
+		{
+			"higher layers replace whole files",
+			[]configLayer{
+				layer(layerFactory, client.ConfigFile{Name: "npmrc", Value: "x", Unencrypted: true, OnChanged: []string{"/usr/bin/reload"}}),
+				layer(layerGroup, plain("npmrc", "group")),
+				{Layer: layerGroup, Name: "unconfigured"},
+				layer(layerDevice, plain("npmrc", "device"), plain("wifi", "ssid")),
+			},
+			[]effectiveFile{
+				{Name: "npmrc", Layer: layerDevice, Shadows: []string{layerGroup, layerFactory}, Unencrypted: true, Value: "device"},
+				{Name: "wifi", Layer: layerDevice, Unencrypted: true, Value: "ssid"},
+			},
+		},
+		{
+			"encrypted values are left out",
+			[]configLayer{
+				layer(layerFactory, plain("token", "plain")),
+				layer(layerDevice, client.ConfigFile{Name: "token", Value: "ciphertext", OnChanged: []string{"/bin/true"}}),
+			},
+			[]effectiveFile{
+				{Name: "token", Layer: layerDevice, Shadows: []string{layerFactory}, OnChanged: []string{"/bin/true"}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.files, mergeConfigLayers(tc.layers))
+		})
+	}
+}
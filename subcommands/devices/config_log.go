@@ -18,12 +18,17 @@ func init() {
 	}
 	configCmd.AddCommand(logConfigCmd)
 	logConfigCmd.Flags().IntP("limit", "n", 0, "Limit the number of results displayed.")
+	logConfigCmd.Flags().Bool("all", false, "Display the entries from all pages. This is the default unless --limit is set, which it cannot be combined with.")
+	logConfigCmd.MarkFlagsMutuallyExclusive("all", "limit")
+	logConfigCmd.Flags().Bool("numbered", false, "Number the entries, from 0 for the config in effect, as used by the diff and rollback commands")
+	subcommands.AddOutputFlag(logConfigCmd)
 }
 
 func doConfigLog(cmd *cobra.Command, args []string) {
 	factory := viper.GetString("factory")
 	device := args[0]
 	listLimit, _ := cmd.Flags().GetInt("limit")
+	numbered, _ := cmd.Flags().GetBool("numbered")
 	logrus.Debugf("Showing device config log for %s", device)
 
 	lookups, err := api.UsersGetLookups(factory)
@@ -34,10 +39,12 @@ func doConfigLog(cmd *cobra.Command, args []string) {
 	subcommands.LogConfigs(&subcommands.LogConfigsOptions{
 		Limit:         listLimit,
 		ShowAppliedAt: true,
+		ShowEntries:   numbered,
 		ListFunc: func() (*client.DeviceConfigList, error) {
 			return d.ListConfig()
 		},
 		ListContFunc: api.DeviceListConfigCont,
 		UserLookup:   lookups,
+		Cmd:          cmd,
 	})
 }
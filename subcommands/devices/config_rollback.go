@@ -0,0 +1,64 @@
+package devices
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	rollbackConfigCmd := &cobra.Command{
+		Use:   "rollback <device> <entry>",
+		Short: "Restore the device's configuration of a changelog entry",
+		Run:   doConfigRollback,
+		Args:  cobra.ExactArgs(2),
+		Long: `Replace the device's configuration in effect with the files of an entry of its
+configuration changelog. Entries are numbered as shown by "fioctl devices config
+log --numbered", from 0 for the config in effect. The rollback is a new changelog entry.
+
+The old values of encrypted files can only be decrypted by the device, and may
+be encrypted with a key the device no longer has. An encrypted file is only
+restored as it was if its value did not change since. Otherwise, its value
+must be given with --value, and is encrypted with the current device key.`,
+		Example: `
+  # Undo the latest config change of a device:
+  fioctl devices config rollback my-device 1 --reason "Revert the broken proxy setting"
+
+  # Restore an older config, giving the values of the encrypted files changed since:
+  fioctl devices config rollback my-device 3 --value npmtok=root --value cert.pem==./cert.pem`,
+	}
+	configCmd.AddCommand(rollbackConfigCmd)
+	rollbackConfigCmd.Flags().StringP("reason", "m", "", "Add a message to store as the \"reason\" for this change")
+	rollbackConfigCmd.Flags().StringArray("value", nil,
+		"The value of an encrypted file as file=content or file==/path/to/file. Can be given many times")
+}
+
+func doConfigRollback(cmd *cobra.Command, args []string) {
+	reason, _ := cmd.Flags().GetString("reason")
+	values, _ := cmd.Flags().GetStringArray("value")
+	logrus.Debugf("Rolling back device config for %s", args[0])
+
+	device := getDevice(cmd, args[0])
+	subcommands.RollbackConfig(&subcommands.RollbackConfigOptions{
+		Entry:  subcommands.ParseConfigEntry(args[1]),
+		Reason: reason,
+		Values: values,
+		ListFunc: func() (*client.DeviceConfigList, error) {
+			return device.Api.ListConfig()
+		},
+		ListContFunc: api.DeviceListConfigCont,
+		SetFunc: func(cfg client.ConfigCreateRequest) error {
+			return device.Api.CreateConfig(cfg)
+		},
+		EncryptFunc: func(value string) string {
+			if len(device.PublicKey) == 0 {
+				subcommands.DieNotNil(fmt.Errorf("Device has no public key to encrypt with"))
+			}
+			return eciesEncrypt(value, loadEciesPub(device.PublicKey))
+		},
+	})
+}
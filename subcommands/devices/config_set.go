@@ -23,7 +23,17 @@ func init() {
 		Long: `Creates a secure configuration for the device, encrypting the contents of each
 file using the device's public key. The fioconfig daemon running
 on each device will then be able to grab the latest version of the
-device's configuration and apply it. The maximum size of a config is 1Mb.`,
+device's configuration and apply it. The maximum size of a config is 1Mb.
+
+The files are checked before they are uploaded: the syntax of .toml, .json,
+and .yaml files, the fioconfig on-changed handlers, the size of the config,
+and secrets in unencrypted files. More rules can be added in a
+.fioctl-config-rules.yml file in the working directory or a parent directory
+of the git repository. Use --skip-validation to upload the config anyway.
+
+With --template, the files are rendered from Go templates for each device
+instead, and the arguments are the devices to configure. See the templates
+section below.`,
 		Example: `  
   # Basic use can be done with command line arguments:
   fioctl device config set my-device npmtok="root" githubtok="1234" readme.md==./readme.md
@@ -61,42 +71,109 @@ device's configuration and apply it. The maximum size of a config is 1Mb.`,
   # fioctl will read in tmp.json, encrypt its contents, and upload it
   # to the OTA server. Instead of using ./tmp.json, the command can take
   # a "-" and will read the content from STDIN instead of a file.
+
+Templates:
+  A template is a Go text/template, rendered with the fields of the device,
+  e.g. {{.Name}}, {{.Uuid}}, {{.GroupName}}, or {{.Tag}}, and the variables
+  of the device from the --vars CSV file, e.g. {{.Vars.site}}. The CSV file
+  has a header row, and a uuid or device column with the device UUIDs or names.
+  The other columns are the variables.
+
+  The config file is named after the template file without its .tmpl suffix,
+  or explicitly with --template <name>=<path>. Devices are given as arguments,
+  selected with --where, or are the devices listed in the --vars file.
+
+  # Render a file for each device listed in a CSV file:
+  fioctl devices config set --template wifi.conf.tmpl --vars sites.csv
+
+  # Check the rendered files of the production devices of a group first:
+  fioctl devices config set --template motd=./motd.tmpl --vars sites.csv \
+    --where 'group = lab and is-prod' --render-only rendered/
 `,
 		Run:  doConfigSet,
-		Args: cobra.MinimumNArgs(2),
+		Args: configSetArgs,
 	}
 	configCmd.AddCommand(setConfigCmd)
 	setConfigCmd.Flags().StringP("reason", "m", "", "Add a message to store as the \"reason\" for this change")
 	setConfigCmd.Flags().BoolP("raw", "", false, "Use raw configuration file")
 	setConfigCmd.Flags().BoolP("create", "", false, "Replace the whole config with these values. Default is to merge these values with the existing config values")
+	// Template options are defined in config_template.go
+	setConfigCmd.Flags().StringArrayVarP(&configTemplateOpts.templates, "template", "", nil,
+		"Render a config file from a Go template for each device, as <path> or <name>=<path>. Can be given many times")
+	setConfigCmd.Flags().StringVarP(&configTemplateOpts.vars, "vars", "", "",
+		"A CSV file with the template variables of each device")
+	setConfigCmd.Flags().VarP(&configTemplateOpts.where, "where", "",
+		"Configure the devices matching an expression, see \"fioctl devices list --help\"")
+	setConfigCmd.Flags().StringVarP(&configTemplateOpts.renderOnly, "render-only", "", "",
+		"Only write the rendered files into this directory, under a directory per device")
+	setConfigCmd.Flags().IntVarP(&configTemplateOpts.parallel, "parallel", "j", 8, "Number of devices to configure at the same time")
+	setConfigCmd.MarkFlagsMutuallyExclusive("template", "raw")
+	subcommands.AddSkipValidationFlag(setConfigCmd)
+}
+
+// configSetArgs requires file arguments, unless the files are rendered from templates. The
+// template options are rejected without templates, rather than silently uploading the files.
+func configSetArgs(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed("template") {
+		return nil
+	}
+	for _, flag := range []string{"vars", "where", "render-only", "parallel"} {
+		if cmd.Flags().Changed(flag) {
+			return fmt.Errorf("The --%s flag can only be used with --template", flag)
+		}
+	}
+	return cobra.MinimumNArgs(2)(cmd, args)
 }
 
 func loadEciesPub(pubkey string) *ecies.PublicKey {
+	pub, err := parseEciesPub(pubkey)
+	subcommands.DieNotNil(err)
+	return pub
+}
+
+func parseEciesPub(pubkey string) (*ecies.PublicKey, error) {
 	block, _ := pem.Decode([]byte(pubkey))
 	if block == nil {
-		subcommands.DieNotNil(fmt.Errorf("Failed to parse certificate PEM"))
-		return nil // return for go linter
+		return nil, fmt.Errorf("Failed to parse certificate PEM")
 	}
 
 	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-	subcommands.DieNotNil(err, "Failed to parse DER encoded public key:")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse DER encoded public key: %w", err)
+	}
 
-	ecpub := pub.(*ecdsa.PublicKey)
-	return ecies.ImportECDSAPublic(ecpub)
+	ecpub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Device public key is not an ECDSA key")
+	}
+	return ecies.ImportECDSAPublic(ecpub), nil
 }
 
 func eciesEncrypt(content string, pubkey *ecies.PublicKey) string {
+	enc, err := encryptEcies(content, pubkey)
+	subcommands.DieNotNil(err)
+	return enc
+}
+
+func encryptEcies(content string, pubkey *ecies.PublicKey) (string, error) {
 	message := []byte(content)
 	enc, err := ecies.Encrypt(rand.Reader, pubkey, message, nil, nil)
-	subcommands.DieNotNil(err, "Failed to encrypt:")
-	return base64.StdEncoding.EncodeToString(enc)
+	if err != nil {
+		return "", fmt.Errorf("Failed to encrypt: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(enc), nil
 }
 
 func doConfigSet(cmd *cobra.Command, args []string) {
+	if len(configTemplateOpts.templates) > 0 {
+		doConfigSetTemplate(cmd, args)
+		return
+	}
 	name := args[0]
 	reason, _ := cmd.Flags().GetString("reason")
 	isRaw, _ := cmd.Flags().GetBool("raw")
 	shouldCreate, _ := cmd.Flags().GetBool("create")
+	skipValidation, _ := cmd.Flags().GetBool("skip-validation")
 
 	logrus.Debugf("Creating new device config for %s", name)
 	// Ensure the device has a public key we can encrypt with
@@ -107,9 +184,10 @@ func doConfigSet(cmd *cobra.Command, args []string) {
 	pubkey := loadEciesPub(device.PublicKey)
 
 	subcommands.SetConfig(&subcommands.SetConfigOptions{
-		FileArgs:  args[1:],
-		Reason:    reason,
-		IsRawFile: isRaw,
+		FileArgs:       args[1:],
+		Reason:         reason,
+		IsRawFile:      isRaw,
+		SkipValidation: skipValidation,
 		SetFunc: func(cfg client.ConfigCreateRequest) error {
 			if shouldCreate {
 				return device.Api.CreateConfig(cfg)
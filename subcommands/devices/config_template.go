@@ -0,0 +1,349 @@
+package devices
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+// Flags of "devices config set" for templates, registered in config_set.go
+type configTemplateOptions struct {
+	templates  []string
+	vars       string
+	where      whereValue
+	renderOnly string
+	parallel   int
+}
+
+var configTemplateOpts configTemplateOptions
+
+// configTemplate is a config file rendered for each device.
+type configTemplate struct {
+	name string
+	tmpl *template.Template
+}
+
+// configTemplateData is what a template is rendered with: the fields of the device, such as
+// {{.Name}}, and its variables from the CSV file, such as {{.Vars.site}}.
+type configTemplateData struct {
+	client.Device
+	Vars map[string]string
+}
+
+// renderedConfig holds the files rendered for a device, before they are encrypted.
+type renderedConfig struct {
+	device client.Device
+	files  []client.ConfigFile
+}
+
+func doConfigSetTemplate(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	opts := configTemplateOpts
+	reason, _ := cmd.Flags().GetString("reason")
+	shouldCreate, _ := cmd.Flags().GetBool("create")
+	skipValidation, _ := cmd.Flags().GetBool("skip-validation")
+	if opts.parallel < 1 {
+		subcommands.DieNotNil(fmt.Errorf("Invalid value for --parallel: %d, must be at least 1", opts.parallel))
+	}
+	if len(args) == 0 && opts.where.filter == nil && len(opts.vars) == 0 {
+		subcommands.DieNotNil(errors.New("Give the devices to configure as arguments, or select them with --where or --vars"))
+	}
+	if len(args) > 0 && opts.where.filter != nil {
+		subcommands.DieNotNil(errors.New("Devices can either be given as arguments or selected with --where"))
+	}
+
+	templates := loadConfigTemplates(opts.templates)
+	var vars map[string]map[string]string
+	var varsKey string
+	if len(opts.vars) > 0 {
+		var err error
+		varsKey, vars, err = readTemplateVars(opts.vars)
+		subcommands.DieNotNil(err, "Unable to read "+opts.vars+":")
+	}
+
+	devices := selectTemplateDevices(cmd, factory, args, opts.where.filter, varsKey, vars)
+	logrus.Debugf("Rendering %d config templates for %d devices", len(templates), len(devices))
+
+	// All files are rendered and validated first, so that a missing variable does not leave a
+	// partially configured set of devices behind.
+	var rules *subcommands.ConfigRules
+	if !skipValidation {
+		var err error
+		rules, err = subcommands.LoadConfigRules()
+		subcommands.DieNotNil(err)
+	}
+	var configs []renderedConfig
+	invalid := 0
+	for _, device := range devices {
+		cfg, errs := renderConfigTemplates(device, templates, varsKey, vars)
+		if len(errs) == 0 && rules != nil {
+			errs = rules.Validate(cfg.files, true)
+		}
+		for _, err := range errs {
+			fmt.Printf("%s: %s\n", device.Name, err)
+		}
+		if len(errs) > 0 {
+			invalid += 1
+		}
+		configs = append(configs, cfg)
+	}
+	if invalid > 0 {
+		subcommands.DieNotNil(fmt.Errorf("Unable to render a valid config for %d devices, no devices were changed", invalid))
+	}
+	if len(configs) == 0 {
+		fmt.Println("No devices match the selection")
+		return
+	}
+
+	if len(opts.renderOnly) > 0 {
+		writeRenderedConfigs(opts.renderOnly, configs)
+		return
+	}
+
+	var names []string
+	for _, t := range templates {
+		names = append(names, t.name)
+	}
+	details := "files: " + strings.Join(names, ", ")
+	var lock sync.Mutex
+	var failed []string
+	forEachParallel(opts.parallel, len(configs), func(idx int) {
+		cfg := configs[idx]
+		res := runBulkAction(cmd.Context(), factory, cfg.device, func(device client.Device, dapi client.DeviceApi, dryRun bool) (string, error) {
+			return details, setRenderedConfig(dapi, cfg, reason, shouldCreate)
+		}, false)
+		lock.Lock()
+		defer lock.Unlock()
+		printBulkResult(res)
+		if res.Status != bulkStatusOk {
+			failed = append(failed, cfg.device.Name)
+		}
+	})
+	fmt.Printf("\nConfigured %d of %d devices\n", len(configs)-len(failed), len(configs))
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		fmt.Printf("Failed to configure: %s\n", strings.Join(failed, ", "))
+		os.Exit(subcommands.ExitError)
+	}
+}
+
+// renderConfigTemplates renders the files of a device, returning an error for each file which
+// cannot be rendered.
+func renderConfigTemplates(
+	device client.Device, templates []configTemplate, key string, vars map[string]map[string]string,
+) (renderedConfig, []error) {
+	cfg := renderedConfig{device: device}
+	data := configTemplateData{Device: device, Vars: map[string]string{}}
+	if vars != nil {
+		if data.Vars = templateVarsOf(device, key, vars); data.Vars == nil {
+			return cfg, []error{errors.New("Not in the variables file")}
+		}
+	}
+	var errs []error
+	for _, t := range templates {
+		var buf bytes.Buffer
+		if err := t.tmpl.Execute(&buf, data); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cfg.files = append(cfg.files, client.ConfigFile{Name: t.name, Value: buf.String()})
+	}
+	return cfg, errs
+}
+
+// loadConfigTemplates parses the templates given as <path> or <name>=<path>. A missing variable
+// is an error rather than an empty value, which would be easy to miss in a config file.
+func loadConfigTemplates(args []string) []configTemplate {
+	var templates []configTemplate
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		name, path, found := strings.Cut(arg, "=")
+		if !found {
+			path = arg
+			name = strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		}
+		if len(name) == 0 || strings.ContainsAny(name, "/\\") {
+			subcommands.DieNotNil(fmt.Errorf("Invalid --template %q: invalid config file name %q", arg, name))
+		}
+		if seen[name] {
+			subcommands.DieNotNil(fmt.Errorf("The config file %s is given by more than one template", name))
+		}
+		seen[name] = true
+		data, err := os.ReadFile(path)
+		subcommands.DieNotNil(err, "Unable to read template:")
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(data))
+		subcommands.DieNotNil(err, "Invalid template "+path+":")
+		templates = append(templates, configTemplate{name: name, tmpl: tmpl})
+	}
+	return templates
+}
+
+// readTemplateVars reads the variables of each device from a CSV file with a header row. It
+// returns the column finding the devices, uuid or device, and the variables keyed by its values.
+func readTemplateVars(path string) (string, map[string]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+	return parseTemplateVars(file)
+}
+
+func parseTemplateVars(in io.Reader) (string, map[string]map[string]string, error) {
+	reader := csv.NewReader(in)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(records) == 0 {
+		return "", nil, errors.New("The file has no header row with the variable names")
+	}
+	header := records[0]
+	// Spreadsheets often start their CSV exports with a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	keyIdx := -1
+	var key string
+	for i, col := range header {
+		col = strings.TrimSpace(col)
+		header[i] = col
+		if lower := strings.ToLower(col); lower == "uuid" || lower == "device" {
+			if keyIdx >= 0 {
+				return "", nil, errors.New("Only one of the uuid and device columns can find the devices")
+			}
+			keyIdx, key = i, lower
+		}
+	}
+	if keyIdx < 0 {
+		return "", nil, errors.New("A uuid or device column is required to find the devices")
+	}
+
+	vars := make(map[string]map[string]string)
+	for i, record := range records[1:] {
+		device := strings.TrimSpace(record[keyIdx])
+		if len(device) == 0 {
+			continue
+		}
+		if _, dup := vars[device]; dup {
+			return "", nil, fmt.Errorf("Line %d: device %s is given more than once", i+2, device)
+		}
+		values := make(map[string]string)
+		for idx, value := range record {
+			if idx != keyIdx {
+				values[header[idx]] = value
+			}
+		}
+		vars[device] = values
+	}
+	return key, vars, nil
+}
+
+// templateVarsOf returns the variables of a device, or nil if it is not in the file.
+func templateVarsOf(device client.Device, key string, vars map[string]map[string]string) map[string]string {
+	if key == "uuid" {
+		return vars[device.Uuid]
+	}
+	return vars[device.Name]
+}
+
+// selectTemplateDevices returns the devices given as arguments, the devices matching the filter,
+// or else all the devices in the variables file.
+func selectTemplateDevices(
+	cmd *cobra.Command, factory string, args []string, filter *deviceFilter, key string, vars map[string]map[string]string,
+) []client.Device {
+	var devices []client.Device
+	if len(args) > 0 {
+		for _, name := range args {
+			device := getDevice(cmd, name)
+			if len(device.GroupName) == 0 && device.Group != nil {
+				device.GroupName = device.Group.Name
+			}
+			devices = append(devices, *device)
+		}
+		return devices
+	}
+
+	selected, err := selectFilteredDevices(map[string]string{"factory": factory}, filter)
+	subcommands.DieNotNil(err)
+	found := make(map[string]bool)
+	for _, device := range selected {
+		if filter == nil {
+			// Only the devices listed in the variables file are selected
+			if templateVarsOf(device, key, vars) == nil {
+				continue
+			}
+			found[device.Uuid] = true
+			found[device.Name] = true
+		}
+		devices = append(devices, device)
+	}
+	if filter == nil {
+		var missing []string
+		for device := range vars {
+			if !found[device] {
+				missing = append(missing, device)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			subcommands.DieNotNil(fmt.Errorf("No such devices in the Factory: %s", strings.Join(missing, ", ")))
+		}
+	}
+	return devices
+}
+
+// setRenderedConfig encrypts the rendered files with the public key of the device, which is only
+// returned by Get, and sets them.
+func setRenderedConfig(dapi client.DeviceApi, cfg renderedConfig, reason string, create bool) error {
+	d, err := dapi.Get()
+	if err != nil {
+		return err
+	}
+	if len(d.PublicKey) == 0 {
+		return errors.New("Device has no public key to encrypt with")
+	}
+	pubkey, err := parseEciesPub(d.PublicKey)
+	if err != nil {
+		return err
+	}
+	req := client.ConfigCreateRequest{Reason: reason}
+	for _, f := range cfg.files {
+		if f.Value, err = encryptEcies(f.Value, pubkey); err != nil {
+			return err
+		}
+		req.Files = append(req.Files, f)
+	}
+	if create {
+		return dapi.CreateConfig(req)
+	}
+	return dapi.PatchConfig(req, false)
+}
+
+// writeRenderedConfigs saves the rendered files unencrypted under a directory per device, so
+// that they can be reviewed before being set.
+func writeRenderedConfigs(dir string, configs []renderedConfig) {
+	for _, cfg := range configs {
+		devDir := filepath.Join(dir, cfg.device.Name)
+		subcommands.DieNotNil(os.MkdirAll(devDir, 0o700))
+		for _, f := range cfg.files {
+			// The files are usually encrypted on the server, so they are only readable by the user
+			subcommands.DieNotNil(os.WriteFile(filepath.Join(devDir, f.Name), []byte(f.Value), 0o600))
+		}
+	}
+	fmt.Printf("Rendered %d files for %d devices into %s\n", len(configs)*len(configs[0].files), len(configs), dir)
+}
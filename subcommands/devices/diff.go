@@ -0,0 +1,155 @@
+package devices
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+var diffContext int
+
+func init() {
+	diffCmd := &cobra.Command{
+		Use:   "diff <device-a> <device-b>",
+		Short: "Show the differences between two devices",
+		Run:   doDiff,
+		Args:  cobra.ExactArgs(2),
+		Long: `Show the differences between two devices as a unified diff. The devices are
+compared by their Target, ostree hash, LmP version, apps and the states of
+their services, device group, tag, config files, and aktualizr-lite config.
+
+Each line of the diff names the field it shows, e.g.:
+
+  -target-name: acme-lmp-118
+  +target-name: acme-lmp-119
+  -apps-state.shellhttpd.httpd: state=running health=unhealthy
+  +apps-state.shellhttpd.httpd: state=running health=healthy
+
+The values of encrypted config files differ for each device, so only their
+names and on-changed handlers are compared.`,
+		Example: `
+  # Compare a failing device with a working one:
+  fioctl devices diff gateway-17 gateway-18
+
+  # Show all fields rather than only the differences:
+  fioctl devices diff gateway-17 gateway-18 -U 1000`,
+	}
+	cmd.AddCommand(diffCmd)
+	diffCmd.Flags().IntVarP(&diffContext, "unified", "U", 3, "Number of unchanged lines to show around each difference")
+}
+
+func doDiff(cmd *cobra.Command, args []string) {
+	if diffContext < 0 {
+		subcommands.DieNotNil(fmt.Errorf("Invalid value for --unified: %d", diffContext))
+	}
+	a := getDiffDevice(cmd, args[0])
+	b := getDiffDevice(cmd, args[1])
+
+	diff := subcommands.DiffLines(deviceDiffLines(a), deviceDiffLines(b))
+	hunks := subcommands.DiffHunks(diff, diffContext)
+	if len(hunks) == 0 {
+		fmt.Printf("No differences between %s and %s\n", a.Name, b.Name)
+		return
+	}
+	color.Red("--- %s (%s)", a.Name, a.Uuid)
+	color.Green("+++ %s (%s)", b.Name, b.Uuid)
+	subcommands.PrintDiffHunks(hunks, "")
+}
+
+// getDiffDevice returns a device with its active config and current apps state,
+// which are fetched separately when the device API does not include them.
+func getDiffDevice(cmd *cobra.Command, name string) *client.Device {
+	dapi := getDeviceApi(cmd, name)
+	d, err := dapi.Get()
+	subcommands.DieNotNil(err)
+	if d.ActiveConfig == nil {
+		dcl, err := dapi.ListConfig()
+		subcommands.DieNotNil(err)
+		if len(dcl.Configs) > 0 {
+			d.ActiveConfig = &dcl.Configs[0]
+		}
+	}
+	if d.AppsState == nil {
+		states, err := dapi.GetAppsStates()
+		subcommands.DieNotNil(err)
+		if len(states.States) > 0 {
+			d.AppsState = &states.States[0]
+		}
+	}
+	return d
+}
+
+// deviceDiffLines flattens the compared fields of a device into sorted lines, each prefixed with
+// the field name, so that a difference is clear without the surrounding lines.
+func deviceDiffLines(d *client.Device) []string {
+	group := d.GroupName
+	if len(group) == 0 && d.Group != nil {
+		group = d.Group.Name
+	}
+	lines := []string{
+		"target-name: " + d.TargetName,
+		"ostree-hash: " + d.OstreeHash,
+		"lmp-ver: " + d.LmpVer,
+		"device-group: " + group,
+		"tag: " + d.Tag,
+		"docker-apps: " + strings.Join(d.DockerApps, ", "),
+	}
+
+	if d.AppsState != nil {
+		var apps []string
+		for name := range d.AppsState.Apps {
+			apps = append(apps, name)
+		}
+		sort.Strings(apps)
+		for _, name := range apps {
+			app := d.AppsState.Apps[name]
+			lines = append(lines, fmt.Sprintf("apps-state.%s: %s", name, app.State))
+			services := append([]client.AppServiceState{}, app.Services...)
+			sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+			for _, s := range services {
+				lines = append(lines, fmt.Sprintf("apps-state.%s.%s: %s", name, s.Name, serviceState(s)))
+			}
+		}
+	}
+
+	if d.ActiveConfig != nil {
+		files := append([]client.ConfigFile{}, d.ActiveConfig.Files...)
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+		for _, f := range files {
+			prefix := "config." + f.Name
+			if len(f.OnChanged) > 0 {
+				lines = append(lines, prefix+".on-changed: "+strings.Join(f.OnChanged, " "))
+			}
+			if !f.Unencrypted {
+				lines = append(lines, prefix+".value: <encrypted>")
+				continue
+			}
+			for _, line := range strings.Split(strings.TrimRight(f.Value, "\n"), "\n") {
+				lines = append(lines, prefix+".value: "+line)
+			}
+		}
+	}
+
+	if len(d.AktualizrToml) > 0 {
+		for _, line := range strings.Split(strings.TrimRight(d.AktualizrToml, "\n"), "\n") {
+			lines = append(lines, "aktualizr-toml: "+line)
+		}
+	}
+	return lines
+}
+
+func serviceState(s client.AppServiceState) string {
+	var parts []string
+	for _, kv := range [][2]string{{"state", s.State}, {"status", s.Status}, {"health", s.Health}, {"hash", s.Hash}} {
+		if len(kv[1]) > 0 {
+			parts = append(parts, kv[0]+"="+kv[1])
+		}
+	}
+	return strings.Join(parts, " ")
+}
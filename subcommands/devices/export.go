@@ -0,0 +1,459 @@
+package devices
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	// The pure Go driver keeps fioctl buildable without cgo
+	_ "modernc.org/sqlite"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+type exportOptions struct {
+	format    string
+	where     whereValue
+	parallel  int
+	noDetails bool
+}
+
+var exportOpts exportOptions
+
+func init() {
+	exportCmd := &cobra.Command{
+		Use:   "export <file>",
+		Short: "Export all devices of a Factory into a file",
+		Run:   doExport,
+		Args:  cobra.ExactArgs(1),
+		Long: `Export all devices of a Factory, including their hardware and network info,
+active configuration, aktualizr-lite configuration, apps state, and secondary
+ECUs, into a file.
+
+The format is taken from the file extension unless --format is given:
+
+  * jsonl - a device per line, as returned by the API (.jsonl, .json)
+  * csv - a device per row, nested values are written as JSON (.csv)
+  * sqlite - a "devices" table with a column per field (.db, .sqlite, .sqlite3)
+
+Use "-" to write JSON lines or CSV to STDOUT. An export can be compared with
+another one by "fioctl devices snapshot diff".`,
+		Example: `
+  # Export all devices into a SQLite database:
+  fioctl devices export devices.db
+
+  # Export production devices as JSON lines:
+  fioctl devices export --where is-prod prod.jsonl
+
+  # Query an export:
+  sqlite3 devices.db "select name, target_name from devices where up_to_date = 0"`,
+	}
+	cmd.AddCommand(exportCmd)
+	addExportFlags(exportCmd)
+	exportCmd.Flags().StringVarP(&exportOpts.format, "format", "", "", "Format of the file: jsonl, csv, or sqlite. Default is to guess from the file extension")
+	exportCmd.Flags().VarP(&exportOpts.where, "where", "", "Only export devices matching an expression, see \"fioctl devices list --help\"")
+}
+
+func addExportFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&exportOpts.parallel, "parallel", "j", 8, "Number of devices to fetch details for at the same time")
+	cmd.Flags().BoolVarP(&exportOpts.noDetails, "no-details", "", false, "Only export the fields returned by the device list, which is much faster")
+}
+
+// exportRecord is a device as saved by an export. The time of the export is needed to tell which
+// devices were online when comparing exports later.
+type exportRecord struct {
+	client.Device
+	ExportedAt string `json:"exported-at"`
+}
+
+func doExport(cmd *cobra.Command, args []string) {
+	path := args[0]
+	format, err := exportFormat(path, exportOpts.format)
+	subcommands.DieNotNil(err)
+	if path == "-" && format == "sqlite" {
+		subcommands.DieNotNil(fmt.Errorf("A SQLite export cannot be written to STDOUT"))
+	}
+	factory := viper.GetString("factory")
+	logrus.Debugf("Exporting devices for %s as %s", factory, format)
+
+	records, err := exportDevices(cmd.Context(), factory, exportOpts.where.filter)
+	subcommands.DieNotNil(err)
+	subcommands.DieNotNil(writeExport(path, format, records), "Unable to save export:")
+	if path != "-" {
+		fmt.Printf("Exported %d devices to %s\n", len(records), path)
+	}
+}
+
+// exportFormat returns the format of an export file, guessing it from the extension if needed.
+func exportFormat(path, format string) (string, error) {
+	if len(format) == 0 {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".jsonl", ".json":
+			format = "jsonl"
+		case ".csv":
+			format = "csv"
+		case ".db", ".sqlite", ".sqlite3":
+			format = "sqlite"
+		default:
+			if path == "-" {
+				return "jsonl", nil
+			}
+			return "", fmt.Errorf("Unable to guess the format of %s, use --format", path)
+		}
+	}
+	if format != "jsonl" && format != "csv" && format != "sqlite" {
+		return "", fmt.Errorf("Invalid format %q: must be jsonl, csv, or sqlite", format)
+	}
+	return format, nil
+}
+
+// exportDevices lists the devices of a factory and fetches the details of each of them with at
+// most exportOpts.parallel requests at a time.
+func exportDevices(ctx context.Context, factory string, filter *deviceFilter) ([]exportRecord, error) {
+	if exportOpts.parallel < 1 {
+		return nil, fmt.Errorf("Invalid value for --parallel: %d, must be at least 1", exportOpts.parallel)
+	}
+	exportedAt := time.Now().UTC().Format(time.RFC3339)
+	devices, err := selectFilteredDevices(map[string]string{"factory": factory}, filter)
+	if err != nil {
+		return nil, err
+	}
+	var records []exportRecord
+	for _, device := range devices {
+		records = append(records, exportRecord{Device: device, ExportedAt: exportedAt})
+	}
+	if exportOpts.noDetails {
+		return records, nil
+	}
+
+	var once sync.Once
+	var fetchErr error
+	forEachParallel(exportOpts.parallel, len(records), func(idx int) {
+		if err := fetchDeviceDetails(ctx, factory, &records[idx].Device); err != nil {
+			once.Do(func() { fetchErr = err })
+		}
+	})
+	return records, fetchErr
+}
+
+// fetchDeviceDetails replaces a device from the list API with the full device from the get API.
+func fetchDeviceDetails(ctx context.Context, factory string, device *client.Device) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dapi := api.DeviceApiByUuid(factory, device.Uuid)
+	full, err := dapi.Get()
+	if err != nil {
+		return fmt.Errorf("Unable to fetch device %s: %w", device.Name, err)
+	}
+	// The get API returns the group object rather than its name. Only keep the name, as a "group"
+	// column holding the object would be read as the new group of the device by devices import.
+	if len(full.GroupName) == 0 && full.Group != nil {
+		full.GroupName = full.Group.Name
+	}
+	full.Group = nil
+	if full.AppsState == nil {
+		states, err := dapi.GetAppsStates()
+		if err != nil {
+			return fmt.Errorf("Unable to fetch apps state of %s: %w", device.Name, err)
+		}
+		if len(states.States) > 0 {
+			full.AppsState = &states.States[0]
+		}
+	}
+	*device = *full
+	return nil
+}
+
+func writeExport(path, format string, records []exportRecord) error {
+	if format == "sqlite" {
+		return writeSqliteExport(path, records)
+	}
+	out := os.Stdout
+	if path != "-" {
+		var err error
+		if out, err = os.Create(path); err != nil {
+			return err
+		}
+	}
+	var err error
+	if format == "csv" {
+		if len(records) == 0 {
+			// Keep the header so that an empty export can still be read back
+			err = writeCsvHeader(out)
+		} else {
+			err = subcommands.WriteOutput(out, "csv", records)
+		}
+	} else {
+		err = writeJsonl(out, records)
+	}
+	if path != "-" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func writeJsonl(w io.Writer, records []exportRecord) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCsvHeader(w io.Writer) error {
+	out := csv.NewWriter(w)
+	names, _ := exportFields()
+	if err := out.Write(names); err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// exportFields returns the JSON names of the fields of an export record in their order, and
+// whether each of them is a string. Reading a CSV export needs the latter, as a string cell may
+// look like a JSON number or boolean.
+func exportFields() ([]string, map[string]bool) {
+	var names []string
+	isString := make(map[string]bool)
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous {
+				collect(f.Type)
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if len(name) > 0 && name != "-" {
+				names = append(names, name)
+				isString[name] = f.Type.Kind() == reflect.String
+			}
+		}
+	}
+	collect(reflect.TypeOf(exportRecord{}))
+	return names, isString
+}
+
+const sqliteSchema = `
+CREATE TABLE snapshot (
+	factory TEXT NOT NULL,
+	exported_at TEXT NOT NULL
+);
+CREATE TABLE devices (
+	uuid TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	owner TEXT,
+	factory TEXT,
+	device_group TEXT,
+	tag TEXT,
+	target_name TEXT,
+	ostree_hash TEXT,
+	lmp_ver TEXT,
+	last_seen TEXT,
+	status TEXT,
+	up_to_date INTEGER,
+	is_prod INTEGER,
+	is_wave INTEGER,
+	apps TEXT,
+	network_info TEXT,
+	hardware_info TEXT,
+	active_config TEXT,
+	aktualizr_toml TEXT,
+	apps_state TEXT,
+	secondaries TEXT,
+	record TEXT NOT NULL
+);
+CREATE INDEX devices_name ON devices(name);
+`
+
+// writeSqliteExport saves the devices with a column per field, so that they can be queried with
+// SQL. Nested values are saved as JSON, and the whole device is saved in the record column.
+func writeSqliteExport(path string, records []exportRecord) (err error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if _, err = tx.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	exportedAt := time.Now().UTC().Format(time.RFC3339)
+	if len(records) > 0 {
+		exportedAt = records[0].ExportedAt
+	}
+	if _, err = tx.Exec("INSERT INTO snapshot VALUES (?, ?)", viper.GetString("factory"), exportedAt); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO devices VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		d := r.Device
+		var record []byte
+		if record, err = json.Marshal(r); err != nil {
+			return err
+		}
+		_, err = stmt.Exec(d.Uuid, d.Name, d.Owner, d.Factory, d.GroupName, d.Tag, d.TargetName,
+			d.OstreeHash, d.LmpVer, d.LastSeen, d.Status, d.UpToDate, d.IsProd, d.IsWave,
+			sqliteJson(d.DockerApps), sqliteJson(d.Network), sqliteJson(d.Hardware),
+			sqliteJson(d.ActiveConfig), d.AktualizrToml, sqliteJson(d.AppsState),
+			sqliteJson(d.Secondaries), string(record))
+		if err != nil {
+			return fmt.Errorf("Unable to save device %s: %w", d.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// sqliteJson returns a nested value as JSON, or NULL if it is not set.
+func sqliteJson(val interface{}) interface{} {
+	if v := reflect.ValueOf(val); !v.IsValid() || (v.Kind() == reflect.Pointer || v.Kind() == reflect.Slice) && v.IsNil() {
+		return nil
+	}
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return nil
+	}
+	return string(buf)
+}
+
+// readExport reads the devices of an export in any of its formats.
+func readExport(path string) ([]exportRecord, error) {
+	format, err := exportFormat(path, "")
+	if err != nil {
+		return nil, err
+	}
+	if format == "sqlite" {
+		return readSqliteExport(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if format == "csv" {
+		return readCsvExport(f)
+	}
+
+	var records []exportRecord
+	scanner := bufio.NewScanner(f)
+	// Devices with a large config or hardware info do not fit into the default buffer
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var r exportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+func readCsvExport(r io.Reader) ([]exportRecord, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	} else if len(rows) == 0 {
+		return nil, nil
+	}
+	_, fields := exportFields()
+	header := rows[0]
+	var records []exportRecord
+	for i, row := range rows[1:] {
+		obj := make(map[string]json.RawMessage)
+		for col, cell := range row {
+			name := header[col]
+			isString, known := fields[name]
+			if !known {
+				continue
+			}
+			if isString {
+				obj[name], _ = json.Marshal(cell)
+			} else if len(cell) > 0 {
+				obj[name] = json.RawMessage(cell)
+			}
+		}
+		buf, err := json.Marshal(obj)
+		if err != nil {
+			return nil, fmt.Errorf("Row %d: %w", i+2, err)
+		}
+		var rec exportRecord
+		if err := json.Unmarshal(buf, &rec); err != nil {
+			return nil, fmt.Errorf("Row %d: %w", i+2, err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func readSqliteExport(path string) ([]exportRecord, error) {
+	// Opening a missing file would create an empty database
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT record FROM devices ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %w", path, err)
+	}
+	defer rows.Close()
+	var records []exportRecord
+	for rows.Next() {
+		var record string
+		if err := rows.Scan(&record); err != nil {
+			return nil, err
+		}
+		var r exportRecord
+		if err := json.Unmarshal([]byte(record), &r); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
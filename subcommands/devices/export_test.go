@@ -0,0 +1,72 @@
+package devices
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func TestExportCsvRoundTrip(t *testing.T) {
+	hardware := json.RawMessage(`{"cpu":"imx8"}`)
+	plain := exportRecord{
+		Device: client.Device{
+			Uuid: "uuid-1", Name: "dev-1", Owner: "user-1", Factory: "acme", GroupName: "alpha",
+			LastSeen: "2024-05-06T12:00:00Z", TargetName: "lmp-42", UpToDate: true,
+		},
+		ExportedAt: "2024-05-07T12:00:00Z",
+	}
+	detailed := exportRecord{
+		Device: client.Device{
+			// Cells which look like numbers, booleans or JSON stay strings
+			Uuid: "uuid-2", Name: "42", Tag: "true", Status: `{"a":1}`,
+			Hardware:     &hardware,
+			ActiveConfig: &client.DeviceConfig{Files: []client.ConfigFile{{Name: "npmrc", Value: "a,b\n\"c\"", Unencrypted: true}}},
+			AppsState:    &client.AppsState{Apps: map[string]client.AppState{"shellhttpd": {State: "healthy"}}},
+		},
+		ExportedAt: "2024-05-07T12:00:00Z",
+	}
+
+	tests := []struct {
+		name    string
+		records []exportRecord
+	}{
+		{"empty", nil},
+		{"plain", []exportRecord{plain}},
+		{"details", []exportRecord{plain, detailed}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "devices.csv")
+			require.Nil(t, writeExport(path, "csv", tc.records))
+			records, err := readExport(path)
+			require.Nil(t, err)
+			assert.Equal(t, tc.records, records)
+		})
+	}
+}
+
+func TestImportExportedCsv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.csv")
+	require.Nil(t, writeExport(path, "csv", []exportRecord{
+		{Device: client.Device{Uuid: "uuid-1", Name: "dev-1", Owner: "user-1", GroupName: "alpha"}},
+		{Device: client.Device{Uuid: "uuid-2", Name: "dev-2"}},
+	}))
+	f, err := os.Open(path)
+	require.Nil(t, err)
+	defer f.Close()
+
+	// The columns of an export which are not imported are skipped, and its empty group column
+	// leaves the groups unchanged
+	rows, err := readImportRows(f, nil, false)
+	require.Nil(t, err)
+	assert.Equal(t, []importRow{
+		{line: 2, values: map[string]string{"uuid": "uuid-1", "name": "dev-1", "group": "", "owner": "user-1"}},
+		{line: 3, values: map[string]string{"uuid": "uuid-2", "name": "dev-2", "group": "", "owner": ""}},
+	}, rows)
+}
@@ -0,0 +1,464 @@
+package devices
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+type importOptions struct {
+	file         string
+	columns      []string
+	skipHeader   bool
+	createGroups bool
+	dryRun       bool
+	yes          bool
+	parallel     int
+}
+
+var importOpts importOptions
+
+func init() {
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Rename, move and chown devices as listed in a CSV file",
+		Run:   doImport,
+		Args:  cobra.NoArgs,
+		Long: `Apply a CSV file which maps devices to their names, device groups and owners,
+e.g. a spreadsheet received after a production batch.
+
+A device is found by its UUID, its hardware serial, or its current name. The
+columns of the file are given with --columns, in their order. Columns which are
+not imported are given as "-", or as "<label>:-" to tell what they are. Without
+--columns, the first row of the file must be a header with the column names,
+and the columns with other names are not imported. The columns are:
+
+ * uuid   - The UUID of the device.
+ * serial - The serial number in the hardware info of the device.
+ * device - The current name of the device.
+ * name   - The new name of the device.
+ * group  - The device group of the device.
+ * owner  - The owner of the device, as a user ID or a unique user name.
+
+A row needs at least one of uuid, serial, and device, which must all find the
+same device when a row has several of them.
+
+An empty cell leaves the device unchanged. All rows are checked against the
+devices, device groups, and users of the Factory before anything is changed.
+The changes are then shown as a plan, and made once it is confirmed.`,
+		Example: `
+  # Show what a mapping would change:
+  fioctl devices import -F mapping.csv --columns uuid,name,group,owner --dry-run
+
+  # Apply a spreadsheet with a header and extra columns, creating missing groups:
+  fioctl devices import -F batch-42.csv --columns serial,uuid,site:-,name,group --skip-header --create-groups`,
+	}
+	cmd.AddCommand(importCmd)
+	subcommands.AddFileFlag(importCmd, &importOpts.file, "The CSV file to import")
+	importCmd.Flags().StringSliceVarP(&importOpts.columns, "columns", "", nil,
+		"The columns of the file: uuid, serial, device, name, group, owner, or - to skip a column")
+	importCmd.Flags().BoolVarP(&importOpts.skipHeader, "skip-header", "", false, "Skip the first row of the file when --columns is given")
+	importCmd.Flags().BoolVarP(&importOpts.createGroups, "create-groups", "", false, "Create the device groups which do not exist")
+	importCmd.Flags().BoolVarP(&importOpts.dryRun, "dry-run", "", false, "Only show the changes which would be made")
+	importCmd.Flags().BoolVarP(&importOpts.yes, "yes", "y", false, "Make the changes without asking for a confirmation")
+	importCmd.Flags().IntVarP(&importOpts.parallel, "parallel", "j", 8, "Number of devices to change at the same time")
+}
+
+var importColumns = []string{"uuid", "serial", "device", "name", "group", "owner"}
+
+// The columns which find the device of a row
+var importLocators = []string{"uuid", "serial", "device"}
+
+// importRow is a row of the file, with its line number for the error messages.
+type importRow struct {
+	line   int
+	values map[string]string
+}
+
+// importChange is the plan for a device. An empty field is not changed.
+type importChange struct {
+	device client.Device
+	name   string
+	group  string
+	owner  string
+}
+
+func doImport(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	opts := importOpts
+	if opts.parallel < 1 {
+		subcommands.DieNotNil(fmt.Errorf("Invalid value for --parallel: %d, must be at least 1", opts.parallel))
+	}
+	in := os.Stdin
+	if opts.file != "-" {
+		file, err := os.Open(opts.file)
+		subcommands.DieNotNil(err)
+		defer file.Close()
+		in = file
+	}
+	rows, err := readImportRows(in, opts.columns, opts.skipHeader)
+	subcommands.DieNotNil(err, "Unable to read "+opts.file+":")
+
+	changes, newGroups, errs := planImport(factory, rows, opts.createGroups, opts.parallel)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		subcommands.DieNotNil(fmt.Errorf("Found %d problems in %s, no devices were changed", len(errs), opts.file))
+	}
+
+	if len(changes) == 0 && len(newGroups) == 0 {
+		fmt.Printf("All %d devices are up to date\n", len(rows))
+		return
+	}
+	printImportPlan(changes, newGroups)
+	if opts.dryRun {
+		return
+	}
+	if !opts.yes && !subcommands.Confirm("Make these changes?") {
+		fmt.Println("No devices were changed")
+		return
+	}
+
+	for _, group := range newGroups {
+		_, err := api.FactoryCreateDeviceGroup(factory, group, nil)
+		subcommands.DieNotNil(err, "Unable to create device group "+group+", no devices were changed:")
+		fmt.Println("Created device group", group)
+	}
+
+	var lock sync.Mutex
+	var failed []string
+	forEachParallel(opts.parallel, len(changes), func(idx int) {
+		c := changes[idx]
+		err := applyImportChange(cmd.Context(), api.DeviceApiByUuid(factory, c.device.Uuid), c)
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			fmt.Printf("%s (%s): failed - %s\n", c.device.Name, c.device.Uuid, err)
+			failed = append(failed, c.device.Name)
+		}
+	})
+	fmt.Printf("\nChanged %d of %d devices\n", len(changes)-len(failed), len(changes))
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		fmt.Printf("Failed to change: %s\n", strings.Join(failed, ", "))
+		os.Exit(subcommands.ExitError)
+	}
+}
+
+// readImportRows reads the rows of a CSV file, with the columns named by the header row when
+// the columns are not given. Columns named "-", or "<anything>:-", are skipped.
+func readImportRows(in io.Reader, columns []string, skipHeader bool) ([]importRow, error) {
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && len(records[0]) > 0 {
+		// Spreadsheets often start their CSV exports with a byte order mark
+		records[0][0] = strings.TrimPrefix(records[0][0], "\ufeff")
+	}
+	first := 1
+	fromHeader := len(columns) == 0
+	if fromHeader {
+		if len(records) == 0 {
+			return nil, errors.New("The file has no header row with the column names")
+		}
+		columns = records[0]
+		first = 2
+		records = records[1:]
+	} else if skipHeader && len(records) > 0 {
+		first = 2
+		records = records[1:]
+	}
+
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if col == "-" || strings.HasSuffix(col, ":-") {
+			continue
+		}
+		if !slices.Contains(importColumns, col) {
+			if fromHeader {
+				// Other columns of a spreadsheet with a header are not imported
+				continue
+			}
+			return nil, fmt.Errorf("Invalid column %q: must be one of %s, or -", col, strings.Join(importColumns, ", "))
+		}
+		if slices.Contains(names, col) {
+			return nil, fmt.Errorf("The column %s is given twice", col)
+		}
+		names[i] = col
+	}
+	if !slices.ContainsFunc(names, func(name string) bool { return slices.Contains(importLocators, name) }) {
+		return nil, errors.New("A uuid, serial, or device column is required to find the devices")
+	}
+
+	var rows []importRow
+	for i, record := range records {
+		row := importRow{line: first + i, values: make(map[string]string)}
+		empty := true
+		for idx, value := range record {
+			value = strings.TrimSpace(value)
+			empty = empty && len(value) == 0
+			if idx < len(names) && len(names[idx]) > 0 {
+				row.values[names[idx]] = value
+			}
+		}
+		if !empty {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// planImport checks all rows against the Factory, and returns the changes to make and the
+// groups to create. It returns an error for each invalid row rather than stopping at the first.
+func planImport(factory string, rows []importRow, createGroups bool, parallel int) ([]importChange, []string, []error) {
+	devices, err := selectFilteredDevices(map[string]string{"factory": factory}, nil)
+	subcommands.DieNotNil(err)
+	byUuid := make(map[string]client.Device)
+	byName := make(map[string]client.Device)
+	for _, device := range devices {
+		byUuid[device.Uuid] = device
+		byName[device.Name] = device
+	}
+	var bySerial map[string][]client.Device
+	if slices.ContainsFunc(rows, func(row importRow) bool { return len(row.values["serial"]) > 0 }) {
+		bySerial = importSerials(factory, devices, parallel)
+	}
+	groups, err := api.FactoryListDeviceGroup(factory)
+	subcommands.DieNotNil(err)
+	knownGroups := make(map[string]bool)
+	for _, g := range *groups {
+		knownGroups[g.Name] = true
+	}
+	users, err := api.UsersList(factory)
+	subcommands.DieNotNil(err)
+
+	var errs []error
+	fail := func(row importRow, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("Line %d: %s", row.line, fmt.Sprintf(format, args...)))
+	}
+	var changes []importChange
+	var newGroups []string
+	seen := make(map[string]int)
+	newNames := make(map[string]string)
+	for _, row := range rows {
+		device, err := findImportDevice(row, byUuid, bySerial, byName)
+		if err != nil {
+			fail(row, "%s", err)
+			continue
+		}
+		if line, dup := seen[device.Uuid]; dup {
+			fail(row, "Device %s is already on line %d", device.Name, line)
+			continue
+		}
+		seen[device.Uuid] = row.line
+
+		c := importChange{device: device}
+		if name := row.values["name"]; len(name) > 0 && name != device.Name {
+			if other, taken := byName[name]; taken {
+				fail(row, "The name %s is used by the device %s", name, other.Uuid)
+			} else if other, taken := newNames[name]; taken {
+				fail(row, "The name %s is also given to the device %s", name, other)
+			} else {
+				newNames[name] = device.Uuid
+				c.name = name
+			}
+		}
+		if group := row.values["group"]; len(group) > 0 && group != device.GroupName {
+			if !knownGroups[group] {
+				if !createGroups {
+					fail(row, "No device group named %q, use --create-groups to create it", group)
+				} else if !slices.Contains(newGroups, group) {
+					newGroups = append(newGroups, group)
+				}
+			}
+			c.group = group
+		}
+		if owner := row.values["owner"]; len(owner) > 0 {
+			polisId, err := findImportOwner(users, owner)
+			if err != nil {
+				fail(row, "%s", err)
+			} else if polisId != device.Owner {
+				c.owner = polisId
+			}
+		}
+		if len(c.name) > 0 || len(c.group) > 0 || len(c.owner) > 0 {
+			changes = append(changes, c)
+		}
+	}
+	sort.Strings(newGroups)
+	return changes, newGroups, errs
+}
+
+// findImportDevice returns the device found by all the uuid, serial, and device cells of a row.
+func findImportDevice(
+	row importRow, byUuid map[string]client.Device, bySerial map[string][]client.Device, byName map[string]client.Device,
+) (client.Device, error) {
+	var device client.Device
+	var foundBy string
+	for _, col := range importLocators {
+		value := row.values[col]
+		if len(value) == 0 {
+			continue
+		}
+		var found client.Device
+		var ok bool
+		switch col {
+		case "uuid":
+			if found, ok = byUuid[value]; !ok {
+				return device, fmt.Errorf("No device with the UUID %q", value)
+			}
+		case "serial":
+			matches := bySerial[value]
+			if len(matches) == 0 {
+				return device, fmt.Errorf("No device with the serial %q", value)
+			} else if len(matches) > 1 {
+				return device, fmt.Errorf("Several devices have the serial %q", value)
+			}
+			found = matches[0]
+		case "device":
+			if found, ok = byName[value]; !ok {
+				return device, fmt.Errorf("No device named %q", value)
+			}
+		}
+		if len(foundBy) > 0 && found.Uuid != device.Uuid {
+			return device, fmt.Errorf("The %s finds the device %s, but the %s finds %s", foundBy, device.Name, col, found.Name)
+		}
+		device, foundBy = found, col
+	}
+	if len(foundBy) == 0 {
+		return device, errors.New("No uuid, serial, or device to find the device by")
+	}
+	return device, nil
+}
+
+// importSerials maps the hardware serials to the devices. The hardware info is fetched for the
+// devices which were listed without it.
+func importSerials(factory string, devices []client.Device, parallel int) map[string][]client.Device {
+	var lock sync.Mutex
+	var firstErr error
+	bySerial := make(map[string][]client.Device)
+	forEachParallel(parallel, len(devices), func(idx int) {
+		device := devices[idx]
+		if device.Hardware == nil {
+			dapi := api.DeviceApiByUuid(factory, device.Uuid)
+			full, err := dapi.Get()
+			if err != nil {
+				lock.Lock()
+				defer lock.Unlock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("Unable to fetch the hardware info of %s: %w", device.Name, err)
+				}
+				return
+			}
+			device.Hardware = full.Hardware
+		}
+		if serial := hardwareSerial(device); len(serial) > 0 {
+			lock.Lock()
+			defer lock.Unlock()
+			bySerial[serial] = append(bySerial[serial], device)
+		}
+	})
+	subcommands.DieNotNil(firstErr)
+	return bySerial
+}
+
+// hardwareSerial returns the serial number of the hardware info reported by a device.
+func hardwareSerial(device client.Device) string {
+	var hw struct {
+		Serial string `json:"serial"`
+	}
+	if device.Hardware == nil || json.Unmarshal(*device.Hardware, &hw) != nil {
+		return ""
+	}
+	return hw.Serial
+}
+
+// findImportOwner returns the ID of a Factory user given by their ID or unique name.
+func findImportOwner(users []client.FactoryUser, owner string) (string, error) {
+	var matches []string
+	for _, u := range users {
+		if u.PolisId == owner {
+			return u.PolisId, nil
+		}
+		if strings.EqualFold(u.Name, owner) {
+			matches = append(matches, u.PolisId)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("User %s is not a member of the Factory", owner)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("Several users are named %s, use one of their IDs: %s", owner, strings.Join(matches, ", "))
+	}
+}
+
+func printImportPlan(changes []importChange, newGroups []string) {
+	counts := make(map[string]int)
+	t := tabby.New()
+	t.AddHeader("DEVICE", "UUID", "CHANGE", "FROM", "TO")
+	for _, c := range changes {
+		for _, change := range [][3]string{
+			{"rename", c.device.Name, c.name},
+			{"group", c.device.GroupName, c.group},
+			{"owner", c.device.Owner, c.owner},
+		} {
+			if len(change[2]) > 0 {
+				counts[change[0]] += 1
+				t.AddLine(c.device.Name, c.device.Uuid, change[0], change[1], change[2])
+			}
+		}
+	}
+	t.Print()
+	fmt.Println()
+	if len(newGroups) > 0 {
+		fmt.Printf("Device groups to create: %s\n", strings.Join(newGroups, ", "))
+	}
+	fmt.Printf("%d renames, %d group changes, %d owner changes on %d devices\n",
+		counts["rename"], counts["group"], counts["owner"], len(changes))
+}
+
+// applyImportChange changes a device, stopping at the first change that fails.
+func applyImportChange(ctx context.Context, dapi client.DeviceApi, c importChange) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(c.group) > 0 {
+		if err := dapi.SetGroup(c.group); err != nil {
+			return fmt.Errorf("Unable to set group: %w", err)
+		}
+	}
+	if len(c.owner) > 0 {
+		if err := dapi.Chown(c.owner); err != nil {
+			return fmt.Errorf("Unable to change owner: %w", err)
+		}
+	}
+	if len(c.name) > 0 {
+		if err := dapi.Rename(c.name); err != nil {
+			return fmt.Errorf("Unable to rename: %w", err)
+		}
+	}
+	return nil
+}
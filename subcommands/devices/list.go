@@ -2,7 +2,9 @@ package devices
 
 import (
 	"fmt"
+	"iter"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -25,6 +27,8 @@ var (
 	deviceByGroup       string
 	deviceInactiveHours int
 	deviceUuid          string
+	deviceListAll       bool
+	deviceWhere         whereValue
 	showColumns         []string
 	showPage            uint64
 	paginationLimit     uint64
@@ -126,37 +130,87 @@ func appendSortFlagValue(sortBy []string, cmd *cobra.Command, flag string, field
 	return sortBy
 }
 
-func init() {
+// addListFilterFlags adds the flags selecting and showing devices which "devices list" shares
+// with other commands listing devices.
+func addListFilterFlags(cmd *cobra.Command) {
 	var defCols = []string{
 		"name", "target", "status", "apps", "up-to-date", "is-prod",
 	}
+	cmd.Flags().BoolVarP(&deviceMine, "just-mine", "", false, "Only include devices owned by you")
+	cmd.Flags().BoolVarP(&deviceOnlyProd, "only-prod", "", false, "Only include production devices")
+	cmd.Flags().BoolVarP(&deviceOnlyNonProd, "only-non-prod", "", false, "Only include non-production devices")
+	cmd.Flags().StringVarP(&deviceByTag, "by-tag", "", "", "Only list devices configured with the given tag")
+	cmd.Flags().StringVarP(&deviceByTarget, "by-target", "", "", "Only list devices updated to the given target name")
+	cmd.Flags().StringVarP(&deviceByGroup, "by-group", "g", "", "Only list devices belonging to this group (Factory is mandatory)")
+	cmd.Flags().IntVarP(&deviceInactiveHours, "offline-threshold", "", 4, "List the device as 'OFFLINE' if not seen in the last X hours")
+	cmd.Flags().StringVarP(&deviceUuid, "uuid", "", "", "Find device with the given UUID")
+	cmd.Flags().StringSliceVarP(&showColumns, "columns", "", defCols, "Specify which columns to display")
+	cmd.MarkFlagsMutuallyExclusive("only-prod", "only-non-prod")
+}
+
+// listFilters returns the device list API filters selected by the flags of addListFilterFlags.
+func listFilters(factory string, args []string) map[string]string {
+	filterBy := map[string]string{
+		"factory":     factory,
+		"group":       deviceByGroup,
+		"match_tag":   deviceByTag,
+		"target_name": deviceByTarget,
+		"uuid":        deviceUuid,
+	}
+	if len(args) == 1 {
+		filterBy["name"] = args[0]
+	}
+	if deviceMine {
+		filterBy["mine"] = "1"
+	}
+	if deviceOnlyProd {
+		filterBy["prod"] = "1"
+	} else if deviceOnlyNonProd {
+		filterBy["prod"] = "0"
+	}
+	return filterBy
+}
+
+func availableColumns() []string {
 	allCols := make([]string, 0, len(Columns))
 	for k := range Columns {
 		allCols = append(allCols, k)
 	}
 	sort.Strings(allCols)
+	return allCols
+}
+
+func init() {
+	allCols := availableColumns()
 	listCmd := &cobra.Command{
 		Use:   "list [pattern]",
 		Short: "List devices registered to Factories. Optionally, include filepath style patterns to limit to device names. e.g. device-*",
 		Run:   doList,
 		Args:  cobra.MaximumNArgs(1),
 		Long:  "Available columns for display:\n\n  * " + strings.Join(allCols, "\n  * "),
+		Example: `
+  # List production devices on a Target before 120, which run shellhttpd,
+  # and were not seen for more than 2 days:
+  fioctl devices list --where 'is-prod and target < 120 and apps contains shellhttpd and last-seen > 2d'
+
+  # Fields are named as in "fioctl devices show -o json", nested ones are joined with dots:
+  fioctl devices list --where 'lmp-ver >= 90 and (ip ~ "10.0.*" or hardware-info.cpu.cores > 2)'
+
+  # Expressions support: = != < <= > >= contains ~ (pattern) !~ and or not ( )
+  # A duration like 30m, 4h, 2d, or 1w compares the age of a timestamp.
+  # A number compares the number at the end of a text, e.g. of a Target name.`,
 	}
 	cmd.AddCommand(listCmd)
-	listCmd.Flags().BoolVarP(&deviceMine, "just-mine", "", false, "Only include devices owned by you")
-	listCmd.Flags().BoolVarP(&deviceOnlyProd, "only-prod", "", false, "Only include production devices")
-	listCmd.Flags().BoolVarP(&deviceOnlyNonProd, "only-non-prod", "", false, "Only include non-production devices")
-	listCmd.Flags().StringVarP(&deviceByTag, "by-tag", "", "", "Only list devices configured with the given tag")
-	listCmd.Flags().StringVarP(&deviceByTarget, "by-target", "", "", "Only list devices updated to the given target name")
-	listCmd.Flags().StringVarP(&deviceByGroup, "by-group", "g", "", "Only list devices belonging to this group (Factory is mandatory)")
-	listCmd.Flags().IntVarP(&deviceInactiveHours, "offline-threshold", "", 4, "List the device as 'OFFLINE' if not seen in the last X hours")
-	listCmd.Flags().StringVarP(&deviceUuid, "uuid", "", "", "Find device with the given UUID")
-	listCmd.Flags().StringSliceVarP(&showColumns, "columns", "", defCols, "Specify which columns to display")
+	addListFilterFlags(listCmd)
+	listCmd.Flags().VarP(&deviceWhere, "where", "", "Only list devices matching an expression, e.g. 'is-prod and last-seen > 2d'. Implies --all")
 	addPaginationFlags(listCmd)
+	listCmd.Flags().BoolVarP(&deviceListAll, "all", "", false, "List devices from all pages. The --limit sets how many devices are fetched per request")
 	addSortFlag(listCmd, "sort-by-name", "", "Sort by name (asc, desc); default sort is by owner and name")
 	addSortFlag(listCmd, "sort-by-last-seen", "", "Sort by last-seen (asc, desc); default sort is by owner and name")
-	listCmd.MarkFlagsMutuallyExclusive("only-prod", "only-non-prod")
 	listCmd.MarkFlagsMutuallyExclusive("sort-by-name", "sort-by-last-seen")
+	listCmd.MarkFlagsMutuallyExclusive("all", "page")
+	listCmd.MarkFlagsMutuallyExclusive("where", "page")
+	subcommands.AddOutputFlag(listCmd)
 }
 
 func assertPagination() {
@@ -169,7 +223,7 @@ func assertPagination() {
 	subcommands.DieNotNil(fmt.Errorf("Invalid limit: %d", paginationLimit))
 }
 
-func showDeviceList(dl *client.DeviceList, showColumns []string) {
+func showDeviceList(devices iter.Seq[client.Device], showColumns []string) {
 	t := tabby.New()
 	var cols = make([]interface{}, len(showColumns))
 	for idx, c := range showColumns {
@@ -182,7 +236,7 @@ func showDeviceList(dl *client.DeviceList, showColumns []string) {
 	t.AddHeader(cols...)
 
 	row := make([]interface{}, len(showColumns))
-	for _, device := range dl.Devices {
+	for device := range devices {
 		if len(device.TargetName) == 0 {
 			device.TargetName = "???"
 		}
@@ -193,7 +247,6 @@ func showDeviceList(dl *client.DeviceList, showColumns []string) {
 		t.AddLine(row...)
 	}
 	t.Print()
-	subcommands.ShowPages(showPage, dl.Next)
 }
 
 func doList(cmd *cobra.Command, args []string) {
@@ -204,26 +257,70 @@ func doList(cmd *cobra.Command, args []string) {
 	sortBy = appendSortFlagValue(sortBy, cmd, "sort-by-last-seen", "last_seen")
 	sortBy = appendSortFlagValue(sortBy, cmd, "sort-by-name", "name")
 
-	filterBy := map[string]string{
-		"factory":     factory,
-		"group":       deviceByGroup,
-		"match_tag":   deviceByTag,
-		"target_name": deviceByTarget,
-		"uuid":        deviceUuid,
+	filterBy := listFilters(factory, args)
+	if filter := deviceWhere.filter; filter != nil {
+		// Filters the server does not support are applied to each page as it arrives
+		filter.Pushdown(filterBy)
+		devices := subcommands.DieOnIterError(filterDevices(
+			api.DeviceListAllCtx(cmd.Context(), filterBy, strings.Join(sortBy, ","), paginationLimit), filter))
+		if subcommands.OutputSelected(cmd) {
+			subcommands.PrintOutput(cmd, slices.Collect(devices))
+			return
+		}
+		showDeviceList(devices, showColumns)
+		return
 	}
-	if len(args) == 1 {
-		filterBy["name"] = args[0]
+
+	if deviceListAll {
+		devices := subcommands.DieOnIterError(api.DeviceListAllCtx(cmd.Context(), filterBy, strings.Join(sortBy, ","), paginationLimit))
+		if subcommands.OutputSelected(cmd) {
+			subcommands.PrintOutput(cmd, slices.Collect(devices))
+			return
+		}
+		showDeviceList(devices, showColumns)
+		return
 	}
-	if deviceMine {
-		filterBy["mine"] = "1"
+	dl, err := api.DeviceListCtx(cmd.Context(), filterBy, strings.Join(sortBy, ","), showPage, paginationLimit)
+	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, dl.Devices) {
+		return
 	}
-	if deviceOnlyProd {
-		filterBy["prod"] = "1"
-	} else if deviceOnlyNonProd {
-		filterBy["prod"] = "0"
+	showDeviceList(slices.Values(dl.Devices), showColumns)
+	subcommands.ShowPages(showPage, dl.Next)
+}
+
+// selectFilteredDevices returns the devices matching both the server side filters, and the
+// --where filter unless it is nil. The terms of the filter the server supports are pushed down.
+func selectFilteredDevices(filterBy map[string]string, filter *deviceFilter) ([]client.Device, error) {
+	if filter != nil {
+		filter.Pushdown(filterBy)
 	}
+	seq := api.DeviceListAll(filterBy, "name", 1000)
+	if filter != nil {
+		seq = filterDevices(seq, filter)
+	}
+	var devices []client.Device
+	for device, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
 
-	dl, err := api.DeviceList(filterBy, strings.Join(sortBy, ","), showPage, paginationLimit)
-	subcommands.DieNotNil(err)
-	showDeviceList(dl, showColumns)
+func filterDevices(devices iter.Seq2[client.Device, error], filter *deviceFilter) iter.Seq2[client.Device, error] {
+	return func(yield func(client.Device, error) bool) {
+		for device, err := range devices {
+			if err == nil {
+				var match bool
+				if match, err = filter.Match(device); err == nil && !match {
+					continue
+				}
+			}
+			if !yield(device, err) {
+				return
+			}
+		}
+	}
 }
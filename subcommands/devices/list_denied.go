@@ -1,6 +1,8 @@
 package devices
 
 import (
+	"slices"
+
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -20,6 +22,7 @@ re-created.`,
 	}
 	cmd.AddCommand(listCmd)
 	addPaginationFlags(listCmd)
+	subcommands.AddOutputFlag(listCmd)
 }
 
 func doListDenied(cmd *cobra.Command, args []string) {
@@ -29,5 +32,9 @@ func doListDenied(cmd *cobra.Command, args []string) {
 
 	dl, err := api.DeviceListDenied(factory, showPage, paginationLimit)
 	subcommands.DieNotNil(err)
-	showDeviceList(dl, []string{"uuid", "name", "owner"})
+	if subcommands.PrintOutput(cmd, dl.Devices) {
+		return
+	}
+	showDeviceList(slices.Values(dl.Devices), []string{"uuid", "name", "owner"})
+	subcommands.ShowPages(showPage, dl.Next)
 }
@@ -0,0 +1,401 @@
+package devices
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+type pruneOptions struct {
+	inactiveDays int
+	byGroup      string
+	excludeProd  bool
+	dryRun       bool
+	backup       string
+	yes          bool
+	parallel     int
+}
+
+var pruneOpts pruneOptions
+
+func init() {
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete devices which have not been seen for a long time",
+		Run:   doPrune,
+		Args:  cobra.NoArgs,
+		Long: `Delete the devices which have not been seen for a number of days, e.g. those of
+scrapped prototypes. A device which never connected is selected by when it was
+created.
+
+Before deleting anything, the record, config history, and update history of
+each device are saved into a backup archive. The devices are only deleted once
+the backup is complete, and after a confirmation. Use "fioctl devices
+restore-report" to see what a backup contains.`,
+		Example: `
+  # Show which devices have not been seen for 90 days:
+  fioctl devices prune --inactive-days 90 --dry-run
+
+  # Delete the non-production devices of a group not seen for 30 days:
+  fioctl devices prune --inactive-days 30 --by-group prototypes --exclude-prod`,
+	}
+	cmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().IntVarP(&pruneOpts.inactiveDays, "inactive-days", "", 0, "Select devices not seen for this many days")
+	_ = pruneCmd.MarkFlagRequired("inactive-days")
+	pruneCmd.Flags().StringVarP(&pruneOpts.byGroup, "by-group", "g", "", "Only select devices belonging to this group")
+	pruneCmd.Flags().BoolVarP(&pruneOpts.excludeProd, "exclude-prod", "", false, "Never select production devices")
+	pruneCmd.Flags().BoolVarP(&pruneOpts.dryRun, "dry-run", "", false, "Only show which devices would be deleted")
+	pruneCmd.Flags().StringVarP(&pruneOpts.backup, "backup", "", "", "Path of the backup archive. Default is devices-backup-<factory>-<time>.tar.gz")
+	pruneCmd.Flags().BoolVarP(&pruneOpts.yes, "yes", "y", false, "Delete the devices without asking for a confirmation")
+	pruneCmd.Flags().IntVarP(&pruneOpts.parallel, "parallel", "j", 8, "Number of devices to back up and delete at the same time")
+
+	restoreReportCmd := &cobra.Command{
+		Use:   "restore-report <backup> [<device>]",
+		Short: "Show the devices saved in a backup by \"fioctl devices prune\"",
+		Run:   doRestoreReport,
+		Args:  cobra.RangeArgs(1, 2),
+		Long: `Show the devices saved in a backup by "fioctl devices prune", which were deleted
+unless the prune reported an error for them. Give the name or UUID of a device
+to show all that was saved for it.`,
+	}
+	cmd.AddCommand(restoreReportCmd)
+	subcommands.AddOutputFlag(restoreReportCmd)
+}
+
+// pruneManifest describes a backup archive. The archive has it as manifest.json, followed by
+// a devices/<uuid>.json file for each device.
+type pruneManifest struct {
+	Factory      string        `json:"factory"`
+	CreatedAt    string        `json:"created-at"`
+	InactiveDays int           `json:"inactive-days"`
+	Devices      []prunedEntry `json:"devices"`
+}
+
+type prunedEntry struct {
+	Uuid     string `json:"uuid"`
+	Name     string `json:"name"`
+	LastSeen string `json:"last-seen"`
+}
+
+type prunedDevice struct {
+	Device        client.Device         `json:"device"`
+	ConfigHistory []client.DeviceConfig `json:"config-history"`
+	UpdateHistory []client.Update       `json:"update-history"`
+}
+
+func doPrune(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	opts := pruneOpts
+	if opts.inactiveDays < 1 {
+		subcommands.DieNotNil(fmt.Errorf("Invalid value for --inactive-days: %d, must be at least 1", opts.inactiveDays))
+	}
+	if opts.parallel < 1 {
+		subcommands.DieNotNil(fmt.Errorf("Invalid value for --parallel: %d, must be at least 1", opts.parallel))
+	}
+	now := time.Now().UTC()
+	if len(opts.backup) == 0 {
+		opts.backup = fmt.Sprintf("devices-backup-%s-%s.tar.gz", factory, now.Format("20060102T150405Z"))
+	}
+
+	devices := pruneCandidates(factory, opts, now)
+	if len(devices) == 0 {
+		fmt.Printf("No devices were seen more than %d days ago\n", opts.inactiveDays)
+		return
+	}
+	t := tabby.New()
+	t.AddHeader("NAME", "UUID", "GROUP", "PROD", "LAST SEEN")
+	for _, d := range devices {
+		lastSeen := d.LastSeen
+		if len(lastSeen) == 0 {
+			lastSeen = "never, created " + d.ChangeMeta.CreatedAt
+		}
+		t.AddLine(d.Name, d.Uuid, d.GroupName, d.IsProd, lastSeen)
+	}
+	t.Print()
+	fmt.Println()
+	if opts.dryRun {
+		fmt.Printf("Would back up and delete %d devices\n", len(devices))
+		return
+	}
+
+	if _, err := os.Stat(opts.backup); err == nil {
+		subcommands.DieNotNil(fmt.Errorf("Backup file exists: %s", opts.backup))
+	}
+	backups, err := fetchPruneBackups(cmd.Context(), factory, devices, opts.parallel)
+	subcommands.DieNotNil(err, "Unable to back up devices, none were deleted:")
+	manifest := pruneManifest{Factory: factory, CreatedAt: now.Format(time.RFC3339), InactiveDays: opts.inactiveDays}
+	for _, d := range devices {
+		manifest.Devices = append(manifest.Devices, prunedEntry{Uuid: d.Uuid, Name: d.Name, LastSeen: d.LastSeen})
+	}
+	subcommands.DieNotNil(writePruneBackup(opts.backup, manifest, backups), "Unable to back up devices, none were deleted:")
+	fmt.Printf("Saved a backup of %d devices to %s\n", len(devices), opts.backup)
+
+	if !opts.yes && !subcommands.Confirm(fmt.Sprintf("Delete these %d devices?", len(devices))) {
+		fmt.Println("No devices were deleted")
+		return
+	}
+
+	var lock sync.Mutex
+	var failed []string
+	forEachParallel(opts.parallel, len(devices), func(idx int) {
+		d := devices[idx]
+		dapi := api.DeviceApiByUuid(factory, d.Uuid)
+		err := cmd.Context().Err()
+		if err == nil {
+			err = dapi.Delete()
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			fmt.Printf("%s (%s): failed - %s\n", d.Name, d.Uuid, err)
+			failed = append(failed, d.Name)
+		} else {
+			logrus.Debugf("Deleted %s", d.Name)
+		}
+	})
+
+	fmt.Printf("\nDeleted %d of %d devices, backup saved to %s\n", len(devices)-len(failed), len(devices), opts.backup)
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		fmt.Printf("Failed to delete: %s\n", strings.Join(failed, ", "))
+		os.Exit(subcommands.ExitError)
+	}
+}
+
+// pruneCandidates returns the devices not seen for the inactive days. A device which never
+// connected is inactive once it was created that long ago.
+func pruneCandidates(factory string, opts pruneOptions, now time.Time) []client.Device {
+	cutoff := now.Add(-time.Duration(opts.inactiveDays) * 24 * time.Hour)
+	filterBy := map[string]string{"factory": factory, "group": opts.byGroup}
+	if opts.excludeProd {
+		filterBy["prod"] = "0"
+	}
+	var devices []client.Device
+	for device, err := range api.DeviceListAll(filterBy, "name", 1000) {
+		subcommands.DieNotNil(err)
+		if opts.excludeProd && device.IsProd {
+			continue
+		}
+		seen := device.LastSeen
+		if len(seen) == 0 {
+			seen = device.ChangeMeta.CreatedAt
+		}
+		if t, ok := parseTimestamp(seen); ok && t.Before(cutoff) {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+func fetchPruneBackups(ctx context.Context, factory string, devices []client.Device, parallel int) ([]prunedDevice, error) {
+	backups := make([]prunedDevice, len(devices))
+	var once sync.Once
+	var fetchErr error
+	forEachParallel(parallel, len(devices), func(idx int) {
+		if err := fetchPruneBackup(ctx, factory, devices[idx], &backups[idx]); err != nil {
+			once.Do(func() { fetchErr = fmt.Errorf("%s: %w", devices[idx].Name, err) })
+		}
+	})
+	return backups, fetchErr
+}
+
+func fetchPruneBackup(ctx context.Context, factory string, device client.Device, backup *prunedDevice) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	dapi := api.DeviceApiByUuid(factory, device.Uuid)
+	full, err := dapi.Get()
+	if err != nil {
+		return err
+	}
+	backup.Device = *full
+	backup.ConfigHistory = []client.DeviceConfig{}
+	for cfg, err := range dapi.ListConfigAll() {
+		if err != nil {
+			return err
+		}
+		backup.ConfigHistory = append(backup.ConfigHistory, cfg)
+	}
+	backup.UpdateHistory = []client.Update{}
+	for update, err := range dapi.ListUpdatesAll() {
+		if err != nil {
+			return err
+		}
+		backup.UpdateHistory = append(backup.UpdateHistory, update)
+	}
+	return nil
+}
+
+func writePruneBackup(backupPath string, manifest pruneManifest, backups []prunedDevice) (err error) {
+	file, err := os.OpenFile(backupPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(backupPath)
+		}
+	}()
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	modTime, _ := time.Parse(time.RFC3339, manifest.CreatedAt)
+	add := func(name string, data interface{}) error {
+		buf, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{Name: name, Size: int64(len(buf)), Mode: 0o600, ModTime: modTime}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = tarWriter.Write(buf)
+		return err
+	}
+	if err := add("manifest.json", manifest); err != nil {
+		return err
+	}
+	for _, b := range backups {
+		if err := add(path.Join("devices", b.Device.Uuid+".json"), b); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+func readPruneBackup(backupPath string) (*pruneManifest, []prunedDevice, error) {
+	file, err := os.Open(backupPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Not a backup archive: %w", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	var manifest *pruneManifest
+	var devices []prunedDevice
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if hdr.Name == "manifest.json" {
+			manifest = &pruneManifest{}
+			if err := json.NewDecoder(tarReader).Decode(manifest); err != nil {
+				return nil, nil, fmt.Errorf("Invalid manifest: %w", err)
+			}
+		} else if strings.HasPrefix(hdr.Name, "devices/") {
+			var d prunedDevice
+			if err := json.NewDecoder(tarReader).Decode(&d); err != nil {
+				return nil, nil, fmt.Errorf("Invalid %s: %w", hdr.Name, err)
+			}
+			devices = append(devices, d)
+		}
+	}
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("Not a backup archive: it has no manifest")
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Device.Name < devices[j].Device.Name })
+	return manifest, devices, nil
+}
+
+func doRestoreReport(cmd *cobra.Command, args []string) {
+	manifest, devices, err := readPruneBackup(args[0])
+	subcommands.DieNotNil(err)
+
+	if len(args) == 2 {
+		for _, d := range devices {
+			if d.Device.Name == args[1] || d.Device.Uuid == args[1] {
+				if !subcommands.PrintOutput(cmd, d) {
+					printPrunedDevice(d)
+				}
+				return
+			}
+		}
+		subcommands.DieNotNil(fmt.Errorf("Device %s is not in the backup", args[1]))
+	}
+
+	if subcommands.PrintOutput(cmd, devices) {
+		return
+	}
+	fmt.Printf("Factory:\t%s\n", manifest.Factory)
+	fmt.Printf("Pruned at:\t%s\n", manifest.CreatedAt)
+	fmt.Printf("Inactive for:\t%d days\n", manifest.InactiveDays)
+	fmt.Printf("Devices:\t%d\n\n", len(devices))
+	t := tabby.New()
+	t.AddHeader("NAME", "UUID", "GROUP", "TARGET", "LAST SEEN", "CONFIGS", "UPDATES")
+	for _, d := range devices {
+		t.AddLine(d.Device.Name, d.Device.Uuid, prunedGroup(d.Device), d.Device.TargetName,
+			d.Device.LastSeen, len(d.ConfigHistory), len(d.UpdateHistory))
+	}
+	t.Print()
+}
+
+func prunedGroup(d client.Device) string {
+	if len(d.GroupName) == 0 && d.Group != nil {
+		return d.Group.Name
+	}
+	return d.GroupName
+}
+
+func printPrunedDevice(d prunedDevice) {
+	fmt.Printf("UUID:\t\t%s\n", d.Device.Uuid)
+	fmt.Printf("Name:\t\t%s\n", d.Device.Name)
+	fmt.Printf("Owner:\t\t%s\n", d.Device.Owner)
+	fmt.Printf("Group:\t\t%s\n", prunedGroup(d.Device))
+	fmt.Printf("Production:\t%v\n", d.Device.IsProd)
+	fmt.Printf("Target:\t\t%s\n", d.Device.TargetName)
+	fmt.Printf("Created at:\t%s\n", d.Device.ChangeMeta.CreatedAt)
+	fmt.Printf("Last seen:\t%s\n", d.Device.LastSeen)
+	if len(d.Device.Tag) > 0 {
+		fmt.Printf("Tag:\t\t%s\n", d.Device.Tag)
+	}
+
+	fmt.Println("\nConfig history:")
+	for _, cfg := range d.ConfigHistory {
+		var names []string
+		for _, f := range cfg.Files {
+			names = append(names, f.Name)
+		}
+		by := ""
+		if len(cfg.CreatedBy) > 0 {
+			by = " by " + cfg.CreatedBy
+		}
+		fmt.Printf("  %s%s: %s\n", cfg.CreatedAt, by, cfg.Reason)
+		fmt.Printf("    files: %s\n", strings.Join(names, ", "))
+	}
+	fmt.Println("\nUpdate history:")
+	t := tabby.New()
+	t.AddHeader("  ID", "TIME", "VERSION", "TARGET")
+	for _, u := range d.UpdateHistory {
+		t.AddLine("  "+u.CorrelationId, u.Time, u.Version, u.Target)
+	}
+	t.Print()
+}
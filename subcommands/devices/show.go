@@ -26,11 +26,15 @@ func init() {
 	cmd.AddCommand(showCmd)
 	showCmd.Flags().BoolVarP(&showHWInfo, "hwinfo", "i", false, "Show HW Information")
 	showCmd.Flags().BoolVarP(&showAkToml, "aktoml", "", false, "Show aktualizr-lite toml config")
+	subcommands.AddOutputFlag(showCmd)
 }
 
 func doShow(cmd *cobra.Command, args []string) {
 	logrus.Debug("Showing device")
 	device := getDevice(cmd, args[0])
+	if subcommands.PrintOutput(cmd, device) {
+		return
+	}
 
 	fmt.Printf("UUID:\t\t%s\n", device.Uuid)
 	fmt.Printf("Name:\t\t%s\n", device.Name)
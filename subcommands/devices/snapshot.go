@@ -0,0 +1,257 @@
+package devices
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+var snapshotOfflineHours int
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Save and compare exports of the devices of a Factory",
+	Long: `Snapshots are device exports kept in the fioctl config directory, so that the
+state of a Factory can be compared over time, e.g. before and after a rollout.`,
+}
+
+func init() {
+	cmd.AddCommand(snapshotCmd)
+
+	saveCmd := &cobra.Command{
+		Use:   "save [<name>]",
+		Short: "Save a snapshot of the devices of a Factory",
+		Run:   doSnapshotSave,
+		Args:  cobra.MaximumNArgs(1),
+		Long: `Save a snapshot of the devices of a Factory. The default name is the current
+time, e.g. 20260102T150405Z.`,
+	}
+	addExportFlags(saveCmd)
+	snapshotCmd.AddCommand(saveCmd)
+
+	snapshotCmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the saved snapshots of a Factory",
+		Run:   doSnapshotList,
+		Args:  cobra.NoArgs,
+	})
+
+	diffCmd := &cobra.Command{
+		Use:   "diff <a> <b>",
+		Short: "Show how devices changed between two snapshots",
+		Run:   doSnapshotDiff,
+		Args:  cobra.ExactArgs(2),
+		Long: `Show how devices changed between two snapshots. Each of them is the name of a
+saved snapshot, or the path of a file created by "fioctl devices export".
+
+The changes reported are:
+
+  * added - a device only in <b>
+  * removed - a device only in <a>
+  * moved-group - a device in another device group
+  * retargeted - a device running another Target
+  * offline - a device online in <a> but offline in <b>
+
+A device is online if it was seen within the offline threshold before its
+snapshot was taken.`,
+		Example: `
+  # Compare the devices before and after a rollout:
+  fioctl devices snapshot save before-rollout
+  fioctl devices snapshot save after-rollout
+  fioctl devices snapshot diff before-rollout after-rollout
+
+  # Compare an export with a saved snapshot:
+  fioctl devices snapshot diff devices.db after-rollout`,
+	}
+	diffCmd.Flags().IntVarP(&snapshotOfflineHours, "offline-threshold", "", 4, "Consider a device offline if not seen in X hours before its snapshot")
+	subcommands.AddOutputFlag(diffCmd)
+	snapshotCmd.AddCommand(diffCmd)
+}
+
+func snapshotDir(factory string) (string, error) {
+	dir, err := subcommands.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "snapshots", factory), nil
+}
+
+func doSnapshotSave(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	name := time.Now().UTC().Format("20060102T150405Z")
+	if len(args) == 1 {
+		name = args[0]
+	}
+	if len(name) == 0 || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		subcommands.DieNotNil(fmt.Errorf("Invalid snapshot name: %q", name))
+	}
+	dir, err := snapshotDir(factory)
+	subcommands.DieNotNil(err)
+	path := filepath.Join(dir, name+".jsonl")
+	if _, err := os.Stat(path); err == nil {
+		subcommands.DieNotNil(fmt.Errorf("Snapshot %s already exists", name))
+	}
+	subcommands.DieNotNil(os.MkdirAll(dir, 0o700))
+
+	records, err := exportDevices(cmd.Context(), factory, nil)
+	subcommands.DieNotNil(err)
+	subcommands.DieNotNil(writeExport(path, "jsonl", records), "Unable to save snapshot:")
+	fmt.Printf("Saved snapshot %s of %d devices\n", name, len(records))
+}
+
+func doSnapshotList(cmd *cobra.Command, args []string) {
+	dir, err := snapshotDir(viper.GetString("factory"))
+	subcommands.DieNotNil(err)
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	subcommands.DieNotNil(err)
+	if len(paths) == 0 {
+		fmt.Println("No snapshots saved")
+		return
+	}
+
+	t := tabby.New()
+	t.AddHeader("NAME", "DEVICES", "TAKEN AT")
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".jsonl")
+		records, err := readExport(path)
+		if err != nil {
+			t.AddLine(name, "?", "Unable to read: "+err.Error())
+			continue
+		}
+		takenAt := ""
+		if len(records) > 0 {
+			takenAt = records[0].ExportedAt
+		}
+		t.AddLine(name, len(records), takenAt)
+	}
+	t.Print()
+}
+
+// snapshotChange is a difference of a device between two snapshots.
+type snapshotChange struct {
+	Change string `json:"change"`
+	Uuid   string `json:"uuid"`
+	Name   string `json:"name"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+var snapshotChangeKinds = []string{"added", "removed", "moved-group", "retargeted", "offline"}
+
+func doSnapshotDiff(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	a, err := loadSnapshot(factory, args[0])
+	subcommands.DieNotNil(err)
+	b, err := loadSnapshot(factory, args[1])
+	subcommands.DieNotNil(err)
+
+	changes := diffSnapshots(a, b, snapshotOfflineHours)
+	if subcommands.PrintOutput(cmd, changes) {
+		return
+	}
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+	t := tabby.New()
+	t.AddHeader("CHANGE", "NAME", "UUID", "FROM", "TO")
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[c.Change] += 1
+		t.AddLine(c.Change, c.Name, c.Uuid, c.From, c.To)
+	}
+	t.Print()
+	fmt.Println()
+	for _, kind := range snapshotChangeKinds {
+		if counts[kind] > 0 {
+			fmt.Printf("%-12s %d\n", kind+":", counts[kind])
+		}
+	}
+}
+
+// loadSnapshot reads a saved snapshot by its name, or an export by its path.
+func loadSnapshot(factory, nameOrPath string) ([]exportRecord, error) {
+	path := nameOrPath
+	if _, err := os.Stat(path); err != nil {
+		dir, err := snapshotDir(factory)
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, nameOrPath+".jsonl")
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("No snapshot or export file named %s", nameOrPath)
+		}
+	}
+	records, err := readExport(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %w", nameOrPath, err)
+	}
+	return records, nil
+}
+
+func diffSnapshots(a, b []exportRecord, offlineHours int) []snapshotChange {
+	before := make(map[string]exportRecord, len(a))
+	for _, r := range a {
+		before[r.Uuid] = r
+	}
+	var changes []snapshotChange
+	add := func(kind string, r exportRecord, from, to string) {
+		changes = append(changes, snapshotChange{Change: kind, Uuid: r.Uuid, Name: r.Name, From: from, To: to})
+	}
+	for _, r := range b {
+		prev, ok := before[r.Uuid]
+		if !ok {
+			add("added", r, "", "")
+			continue
+		}
+		delete(before, r.Uuid)
+		if prev.GroupName != r.GroupName {
+			add("moved-group", r, prev.GroupName, r.GroupName)
+		}
+		if prev.TargetName != r.TargetName {
+			add("retargeted", r, prev.TargetName, r.TargetName)
+		}
+		if onlineAt(prev, offlineHours) && !onlineAt(r, offlineHours) {
+			add("offline", r, prev.LastSeen, r.LastSeen)
+		}
+	}
+	for _, r := range before {
+		add("removed", r, "", "")
+	}
+
+	order := make(map[string]int)
+	for i, kind := range snapshotChangeKinds {
+		order[kind] = i
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return order[changes[i].Change] < order[changes[j].Change]
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// onlineAt tells if a device was online when its snapshot was taken.
+func onlineAt(r exportRecord, offlineHours int) bool {
+	seen, err := time.Parse(time.RFC3339, r.LastSeen)
+	if err != nil {
+		return false
+	}
+	at, err := time.Parse(time.RFC3339, r.ExportedAt)
+	if err != nil {
+		// An export from another tool has no time, so assume it is recent
+		at = time.Now()
+	}
+	return at.Sub(seen).Hours() <= float64(offlineHours)
+}
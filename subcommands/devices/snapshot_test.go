@@ -0,0 +1,77 @@
+package devices
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	record := func(name, group, target, lastSeen, exportedAt string) exportRecord {
+		return exportRecord{
+			Device: client.Device{
+				Uuid: name + "-uuid", Name: name, GroupName: group, TargetName: target, LastSeen: lastSeen,
+			},
+			ExportedAt: exportedAt,
+		}
+	}
+	const (
+		monday  = "2024-05-06T12:00:00Z"
+		tuesday = "2024-05-07T12:00:00Z"
+	)
+
+	tests := []struct {
+		name    string
+		a, b    []exportRecord
+		changes []snapshotChange
+	}{
+		{
+			"unchanged",
+			[]exportRecord{record("dev-1", "alpha", "lmp-1", monday, monday)},
+			[]exportRecord{record("dev-1", "alpha", "lmp-1", tuesday, tuesday)},
+			nil,
+		},
+		{
+			"added and removed",
+			[]exportRecord{record("dev-2", "", "", "", monday), record("dev-1", "", "", "", monday)},
+			[]exportRecord{record("dev-3", "", "", "", tuesday)},
+			[]snapshotChange{
+				{Change: "added", Uuid: "dev-3-uuid", Name: "dev-3"},
+				{Change: "removed", Uuid: "dev-1-uuid", Name: "dev-1"},
+				{Change: "removed", Uuid: "dev-2-uuid", Name: "dev-2"},
+			},
+		},
+		{
+			"moved and retargeted",
+			[]exportRecord{record("dev-1", "alpha", "lmp-1", monday, monday)},
+			[]exportRecord{record("dev-1", "beta", "lmp-2", tuesday, tuesday)},
+			[]snapshotChange{
+				{Change: "moved-group", Uuid: "dev-1-uuid", Name: "dev-1", From: "alpha", To: "beta"},
+				{Change: "retargeted", Uuid: "dev-1-uuid", Name: "dev-1", From: "lmp-1", To: "lmp-2"},
+			},
+		},
+		{
+			"went offline",
+			[]exportRecord{
+				record("dev-1", "", "", monday, monday),
+				record("dev-2", "", "", monday, monday),
+				record("dev-3", "", "", "", monday),
+			},
+			[]exportRecord{
+				record("dev-1", "", "", monday, tuesday),
+				record("dev-2", "", "", "2024-05-07T11:00:00Z", tuesday),
+				record("dev-3", "", "", "", tuesday),
+			},
+			[]snapshotChange{
+				{Change: "offline", Uuid: "dev-1-uuid", Name: "dev-1", From: monday, To: monday},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.changes, diffSnapshots(tc.a, tc.b, 4))
+		})
+	}
+}
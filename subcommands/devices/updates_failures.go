@@ -0,0 +1,251 @@
+package devices
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+type failuresOptions struct {
+	since    ageValue
+	byTag    string
+	byGroup  string
+	where    whereValue
+	parallel int
+}
+
+var failuresOpts = failuresOptions{since: ageValue{text: "7d", age: 7 * 24 * time.Hour}}
+
+func init() {
+	failuresCmd := &cobra.Command{
+		Use:   "failures",
+		Short: "Show the failed updates of many devices grouped by their cause",
+		Run:   doUpdateFailures,
+		Args:  cobra.NoArgs,
+		Long: `Find the updates which failed on the selected devices, and group them by the
+Targets they updated from and to, and by their error. A large group tells that
+a problem of a rollout is systemic rather than specific to a few devices.
+
+The Target a device updated from is the Target of its previous successful
+update.`,
+		Example: `
+  # Show the failed updates of the last 7 days:
+  fioctl devices updates failures
+
+  # Show the failed updates of the last day for devices in a group:
+  fioctl devices updates failures --since 1d --by-group beta`,
+	}
+	updatesCmd.AddCommand(failuresCmd)
+	failuresCmd.Flags().VarP(&failuresOpts.since, "since", "", "Only check updates done in this period, e.g. 12h, 7d, or 2w")
+	failuresCmd.Flags().StringVarP(&failuresOpts.byTag, "by-tag", "", "", "Only check devices configured with the given tag")
+	failuresCmd.Flags().StringVarP(&failuresOpts.byGroup, "by-group", "g", "", "Only check devices belonging to this group")
+	failuresCmd.Flags().VarP(&failuresOpts.where, "where", "", "Only check devices matching an expression, see \"fioctl devices list --help\"")
+	failuresCmd.Flags().IntVarP(&failuresOpts.parallel, "parallel", "j", 8, "Number of devices to check at the same time")
+	subcommands.AddOutputFlag(failuresCmd)
+}
+
+// ageValue is a flag for a period which may be given in days or weeks.
+type ageValue struct {
+	text string
+	age  time.Duration
+}
+
+func (v *ageValue) String() string {
+	return v.text
+}
+
+func (v *ageValue) Set(text string) error {
+	age, ok := parseAge(text)
+	if !ok {
+		return fmt.Errorf("Invalid period %q: must be a number followed by s, m, h, d, or w", text)
+	}
+	v.text = text
+	v.age = age
+	return nil
+}
+
+func (v *ageValue) Type() string {
+	return "period"
+}
+
+type updateFailure struct {
+	Device   string `json:"device"`
+	Uuid     string `json:"uuid"`
+	UpdateId string `json:"update-id"`
+	Time     string `json:"time"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Error    string `json:"error"`
+}
+
+type updateFailureGroup struct {
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Error    string          `json:"error"`
+	Count    int             `json:"count"`
+	Failures []updateFailure `json:"failures"`
+}
+
+func doUpdateFailures(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	opts := failuresOpts
+	if opts.parallel < 1 {
+		subcommands.DieNotNil(fmt.Errorf("Invalid value for --parallel: %d, must be at least 1", opts.parallel))
+	}
+	cutoff := time.Now().Add(-opts.since.age)
+	logrus.Debugf("Finding update failures since %s", cutoff)
+
+	filterBy := map[string]string{"factory": factory, "match_tag": opts.byTag, "group": opts.byGroup}
+	devices, err := selectFilteredDevices(filterBy, opts.where.filter)
+	subcommands.DieNotNil(err)
+
+	var lock sync.Mutex
+	var firstErr error
+	var failures []updateFailure
+	forEachParallel(opts.parallel, len(devices), func(idx int) {
+		device := devices[idx]
+		found, err := deviceUpdateFailures(api.DeviceApiByUuid(factory, device.Uuid), device, cutoff)
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Unable to check updates of %s: %w", device.Name, err)
+		}
+		failures = append(failures, found...)
+	})
+	subcommands.DieNotNil(firstErr)
+
+	groups := groupUpdateFailures(failures)
+	if subcommands.PrintOutput(cmd, groups) {
+		return
+	}
+	failedDevices := make(map[string]bool)
+	for _, f := range failures {
+		failedDevices[f.Uuid] = true
+	}
+	fmt.Printf("%d failed updates on %d of %d devices since %s\n",
+		len(failures), len(failedDevices), len(devices), cutoff.UTC().Format(time.RFC3339))
+	if len(groups) == 0 {
+		return
+	}
+	fmt.Println()
+	t := tabby.New()
+	t.AddHeader("COUNT", "FROM", "TO", "ERROR", "DEVICES")
+	for _, g := range groups {
+		var names []string
+		for _, f := range g.Failures {
+			if !slices.Contains(names, f.Device) {
+				names = append(names, f.Device)
+			}
+		}
+		if len(names) > 3 {
+			names = append(names[:3], fmt.Sprintf("and %d more", len(names)-3))
+		}
+		t.AddLine(g.Count, g.From, g.To, g.Error, strings.Join(names, ", "))
+	}
+	t.Print()
+}
+
+// deviceUpdateFailures returns the failed updates of a device since the cutoff. The updates are
+// listed from the most recent, so the listing stops at the first update before the cutoff. This
+// update tells which Target the device was running at the start of the period.
+func deviceUpdateFailures(dapi client.DeviceApi, device client.Device, cutoff time.Time) ([]updateFailure, error) {
+	var updates []client.Update
+	for update, err := range dapi.ListUpdatesAll() {
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, update)
+		if t, ok := parseTimestamp(update.Time); ok && t.Before(cutoff) {
+			break
+		}
+	}
+
+	var failures []updateFailure
+	from := ""
+	for i := len(updates) - 1; i >= 0; i-- {
+		update := updates[i]
+		if t, ok := parseTimestamp(update.Time); ok && t.Before(cutoff) {
+			// Updates before the period are not checked, and assumed to have succeeded
+			from = update.Target
+			continue
+		}
+		events, err := dapi.UpdateEvents(update.CorrelationId)
+		if err != nil {
+			return nil, err
+		}
+		failed := -1
+		for idx, e := range events {
+			if isFailedEvent(e) {
+				failed = idx
+				break
+			}
+		}
+		if failed < 0 {
+			from = update.Target
+			continue
+		}
+		msg := firstLine(events[failed].Detail.Details)
+		if len(msg) == 0 {
+			msg = events[failed].Type.Id + " failed"
+		}
+		failures = append(failures, updateFailure{
+			Device:   device.Name,
+			Uuid:     device.Uuid,
+			UpdateId: update.CorrelationId,
+			Time:     update.Time,
+			From:     from,
+			To:       update.Target,
+			Error:    msg,
+		})
+	}
+	return failures, nil
+}
+
+// groupUpdateFailures groups failures by their Targets and error, the largest groups first.
+func groupUpdateFailures(failures []updateFailure) []updateFailureGroup {
+	index := make(map[[3]string]int)
+	var groups []updateFailureGroup
+	for _, f := range failures {
+		key := [3]string{f.From, f.To, f.Error}
+		idx, ok := index[key]
+		if !ok {
+			idx = len(groups)
+			index[key] = idx
+			groups = append(groups, updateFailureGroup{From: f.From, To: f.To, Error: f.Error})
+		}
+		groups[idx].Count += 1
+		groups[idx].Failures = append(groups[idx].Failures, f)
+	}
+	for _, g := range groups {
+		sort.Slice(g.Failures, func(i, j int) bool {
+			if g.Failures[i].Device != g.Failures[j].Device {
+				return g.Failures[i].Device < g.Failures[j].Device
+			}
+			return g.Failures[i].Time < g.Failures[j].Time
+		})
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		if groups[i].To != groups[j].To {
+			return groups[i].To < groups[j].To
+		}
+		if groups[i].From != groups[j].From {
+			return groups[i].From < groups[j].From
+		}
+		return groups[i].Error < groups[j].Error
+	})
+	return groups
+}
@@ -11,32 +11,23 @@ import (
 
 func doListUpdates(cmd *cobra.Command, args []string) {
 	logrus.Debug("Showing device updates")
-	t := tabby.New()
-	t.AddHeader("ID", "TIME", "VERSION", "TARGET")
 	d := getDeviceApi(cmd, args[0])
-	var ul *client.UpdateList
-	for {
-		var err error
-		if ul == nil {
-			ul, err = d.ListUpdates()
-		} else {
-			if ul.Next != nil {
-				ul, err = d.ListUpdatesCont(*ul.Next)
-			} else {
-				break
-			}
-		}
+	var updates []client.Update
+	for update, err := range d.ListUpdatesAll() {
 		subcommands.DieNotNil(err)
-		for _, update := range ul.Updates {
-			t.AddLine(update.CorrelationId, update.Time, update.Version, update.Target)
-			listLimit -= 1
-			if listLimit == 0 {
-				break
-			}
-		}
+		updates = append(updates, update)
+		listLimit -= 1
 		if listLimit == 0 {
 			break
 		}
 	}
+	if subcommands.PrintOutput(cmd, updates) {
+		return
+	}
+	t := tabby.New()
+	t.AddHeader("ID", "TIME", "VERSION", "TARGET")
+	for _, update := range updates {
+		t.AddLine(update.CorrelationId, update.Time, update.Version, update.Target)
+	}
 	t.Print()
 }
@@ -1,24 +1,32 @@
 package devices
 
 import (
+	"bytes"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/foundriesio/fioctl/client"
 	"github.com/foundriesio/fioctl/subcommands"
 )
 
 func init() {
-	updatesCmd.AddCommand(&cobra.Command{
+	showCmd := &cobra.Command{
 		Use:    "show <name> <update-id>",
 		Short:  "[DEPRECATED] Please use: fioctl devices updates <device> <update-id>",
 		Hidden: true,
 		Run:    doShowUpdate,
 		Args:   cobra.ExactArgs(2),
-	})
+	}
+	updatesCmd.AddCommand(showCmd)
+	subcommands.AddOutputFlag(showCmd)
 }
 
 func doShowUpdate(cmd *cobra.Command, args []string) {
@@ -27,21 +35,214 @@ func doShowUpdate(cmd *cobra.Command, args []string) {
 	d := api.DeviceApiByName(factory, args[0])
 	events, err := d.UpdateEvents(args[1])
 	subcommands.DieNotNil(err)
-	for _, event := range events {
-		fmt.Printf("%s : %s(%s)", event.Time, event.Type.Id, event.Detail.TargetName)
-		if event.Detail.Success != nil {
-			if *event.Detail.Success {
-				fmt.Println(" -> Succeed")
+	if subcommands.PrintOutput(cmd, events) {
+		return
+	}
+	for i, ecu := range groupEventsByEcu(events) {
+		if i > 0 {
+			fmt.Println()
+		}
+		printEcuTimeline(ecu)
+	}
+}
+
+// updatePhase is a step of an update, from its start event until the first of its end events.
+type updatePhase struct {
+	name  string
+	start string
+	ends  []string
+}
+
+// updatePhases are the steps of an update as reported by aktualizr-lite. An update which needs
+// no reboot completes with its installation, the others are applied first, see rebootPhases.
+var updatePhases = []updatePhase{
+	{"download", "EcuDownloadStarted", []string{"EcuDownloadCompleted"}},
+	{"install", "EcuInstallationStarted", []string{"EcuInstallationApplied", "EcuInstallationCompleted"}},
+}
+
+const (
+	phaseOk         = "ok"
+	phaseFailed     = "failed"
+	phaseIncomplete = "incomplete"
+)
+
+type phaseResult struct {
+	name     string
+	started  string
+	duration string
+	status   string
+	details  string
+}
+
+type ecuEvents struct {
+	ecu    string
+	events []client.UpdateEvent
+}
+
+// groupEventsByEcu returns the events of each ECU sorted by time. ECUs are in the order in
+// which they started their update. An event with a timestamp which does not parse stays right
+// after the event it follows in the API response.
+func groupEventsByEcu(events []client.UpdateEvent) []ecuEvents {
+	times := make([]time.Time, len(events))
+	var last time.Time
+	for i, e := range events {
+		if t, ok := parseTimestamp(e.Time); ok {
+			last = t
+		}
+		times[i] = last
+	}
+	order := make([]int, len(events))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return times[order[i]].Before(times[order[j]])
+	})
+	var ecus []ecuEvents
+	index := make(map[string]int)
+	for _, i := range order {
+		e := events[i]
+		idx, ok := index[e.Detail.Ecu]
+		if !ok {
+			idx = len(ecus)
+			index[e.Detail.Ecu] = idx
+			ecus = append(ecus, ecuEvents{ecu: e.Detail.Ecu})
+		}
+		ecus[idx].events = append(ecus[idx].events, e)
+	}
+	return ecus
+}
+
+// updatePhaseResults times the phases an ECU went through, and tells which of them failed.
+func updatePhaseResults(events []client.UpdateEvent) []phaseResult {
+	var results []phaseResult
+	for _, phase := range updatePhases {
+		start := -1
+		for i, e := range events {
+			if e.Type.Id == phase.start {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			continue
+		}
+		res := phaseResult{name: phase.name, started: events[start].Time, duration: "-", status: phaseIncomplete}
+		for _, e := range events[start+1:] {
+			if !slices.Contains(phase.ends, e.Type.Id) {
+				continue
+			}
+			res.duration = eventsDuration(events[start], e)
+			res.status = phaseOk
+			if isFailedEvent(e) {
+				res.status = phaseFailed
+				res.details = firstLine(e.Detail.Details)
+			}
+			break
+		}
+		results = append(results, res)
+	}
+	return append(results, rebootPhases(events)...)
+}
+
+// rebootPhases times the reboot of an applied update, and tells whether its apps started.
+// aktualizr-lite reports the update as completed once the device rebooted into the new Target
+// and started its apps, with no event in between. So the reboot lasts until the device reported
+// back, and the apps phase has the result of the completion, but no duration of its own.
+func rebootPhases(events []client.UpdateEvent) []phaseResult {
+	applied := slices.IndexFunc(events, func(e client.UpdateEvent) bool {
+		return e.Type.Id == "EcuInstallationApplied"
+	})
+	if applied < 0 || isFailedEvent(events[applied]) {
+		// A failed installation is not applied, so the device does not reboot
+		return nil
+	}
+	reboot := phaseResult{name: "reboot", started: events[applied].Time, duration: "-", status: phaseIncomplete}
+	for _, e := range events[applied+1:] {
+		if e.Type.Id != "EcuInstallationCompleted" {
+			continue
+		}
+		reboot.duration = eventsDuration(events[applied], e)
+		reboot.status = phaseOk
+		apps := phaseResult{name: "apps", started: e.Time, duration: "-", status: phaseOk}
+		if isFailedEvent(e) {
+			apps.status = phaseFailed
+			apps.details = firstLine(e.Detail.Details)
+		}
+		return []phaseResult{reboot, apps}
+	}
+	return []phaseResult{reboot}
+}
+
+func printEcuTimeline(ecu ecuEvents) {
+	name := ecu.ecu
+	if len(name) == 0 {
+		name = "(unknown)"
+	}
+	var target string
+	for _, e := range ecu.events {
+		if len(e.Detail.TargetName) > 0 {
+			target = e.Detail.TargetName
+		}
+	}
+	fmt.Printf("ECU %s, Target %s:\n", name, target)
+
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	for _, e := range ecu.events {
+		line := fmt.Sprintf("  %s\t+%s\t%s", e.Time, eventsDuration(ecu.events[0], e), e.Type.Id)
+		if e.Detail.Success != nil {
+			if *e.Detail.Success {
+				line += "\tsucceeded"
 			} else {
-				fmt.Println(" -> Failed!")
+				line += "\tFAILED"
 			}
-		} else {
-			fmt.Println()
 		}
-		if len(event.Detail.Details) > 0 {
-			fmt.Println(" Details:")
-			indented := " | " + strings.ReplaceAll(event.Detail.Details, "\n", "\n | ")
-			fmt.Println(indented)
+		fmt.Fprintln(tw, line)
+		if len(e.Detail.Details) > 0 {
+			fmt.Fprintln(tw, "   | "+strings.ReplaceAll(e.Detail.Details, "\n", "\n   | "))
+		}
+	}
+	_ = tw.Flush()
+	fmt.Print(buf.String())
+
+	phases := updatePhaseResults(ecu.events)
+	if len(phases) == 0 {
+		return
+	}
+	fmt.Println()
+	buf.Reset()
+	tw = tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  PHASE\tSTARTED\tDURATION\tRESULT")
+	for _, p := range phases {
+		result := p.status
+		if len(p.details) > 0 {
+			result += ": " + p.details
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", p.name, p.started, p.duration, result)
+	}
+	_ = tw.Flush()
+	fmt.Print(buf.String())
+}
+
+func isFailedEvent(e client.UpdateEvent) bool {
+	return e.Detail.Success != nil && !*e.Detail.Success
+}
+
+func eventsDuration(from, to client.UpdateEvent) string {
+	a, okA := parseTimestamp(from.Time)
+	b, okB := parseTimestamp(to.Time)
+	if !okA || !okB {
+		return "?"
+	}
+	return b.Sub(a).Round(time.Second).String()
+}
+
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); len(line) > 0 {
+			return line
 		}
 	}
+	return ""
 }
@@ -0,0 +1,141 @@
+package devices
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func updateEvent(id string, at string, ecu string, success *bool, details string) client.UpdateEvent {
+	return client.UpdateEvent{
+		Time:   at,
+		Type:   client.EventType{Id: id},
+		Detail: client.EventDetail{Ecu: ecu, Success: success, Details: details},
+	}
+}
+
+func TestGroupEventsByEcu(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(secs int) string {
+		return start.Add(time.Duration(secs) * time.Second).Format(time.RFC3339)
+	}
+	events := []client.UpdateEvent{
+		updateEvent("EcuInstallationStarted", at(20), "main", nil, ""),
+		// A timestamp which does not parse stays after the event it follows
+		updateEvent("EcuInstallationApplied", "garbage", "main", nil, ""),
+		updateEvent("EcuDownloadStarted", at(5), "mcu", nil, ""),
+		updateEvent("EcuDownloadStarted", at(0), "main", nil, ""),
+		updateEvent("EcuDownloadCompleted", at(10), "main", nil, ""),
+	}
+
+	ecus := groupEventsByEcu(events)
+	var got [][]string
+	for _, ecu := range ecus {
+		ids := []string{ecu.ecu}
+		for _, e := range ecu.events {
+			ids = append(ids, e.Type.Id)
+		}
+		got = append(got, ids)
+	}
+	assert.Equal(t, [][]string{
+		{"main", "EcuDownloadStarted", "EcuDownloadCompleted", "EcuInstallationStarted", "EcuInstallationApplied"},
+		{"mcu", "EcuDownloadStarted"},
+	}, got)
+}
+
+func TestUpdatePhaseResults(t *testing.T) {
+	ok, failed := true, false
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	event := func(id string, secs int, success *bool, details string) client.UpdateEvent {
+		return updateEvent(id, start.Add(time.Duration(secs)*time.Second).Format(time.RFC3339), "main", success, details)
+	}
+	type phase struct{ name, duration, status, details string }
+
+	tests := []struct {
+		name   string
+		events []client.UpdateEvent
+		phases []phase
+	}{
+		{
+			"rebooted",
+			[]client.UpdateEvent{
+				event("EcuDownloadStarted", 0, nil, ""),
+				event("EcuDownloadCompleted", 12, &ok, ""),
+				event("EcuInstallationStarted", 15, nil, ""),
+				event("EcuInstallationApplied", 75, &ok, ""),
+				event("EcuInstallationCompleted", 195, &ok, ""),
+			},
+			[]phase{
+				{"download", "12s", phaseOk, ""},
+				{"install", "1m0s", phaseOk, ""},
+				{"reboot", "2m0s", phaseOk, ""},
+				{"apps", "-", phaseOk, ""},
+			},
+		},
+		{
+			"apps failed after the reboot",
+			[]client.UpdateEvent{
+				event("EcuInstallationStarted", 0, nil, ""),
+				event("EcuInstallationApplied", 30, &ok, ""),
+				event("EcuInstallationCompleted", 90, &failed, "\nApps failed to start\nshellhttpd: exited"),
+			},
+			[]phase{
+				{"install", "30s", phaseOk, ""},
+				{"reboot", "1m0s", phaseOk, ""},
+				{"apps", "-", phaseFailed, "Apps failed to start"},
+			},
+		},
+		{
+			"waiting for the reboot",
+			[]client.UpdateEvent{
+				event("EcuInstallationStarted", 0, nil, ""),
+				event("EcuInstallationApplied", 30, &ok, ""),
+			},
+			[]phase{
+				{"install", "30s", phaseOk, ""},
+				{"reboot", "-", phaseIncomplete, ""},
+			},
+		},
+		{
+			"failed installation",
+			[]client.UpdateEvent{
+				event("EcuInstallationStarted", 0, nil, ""),
+				event("EcuInstallationApplied", 5, &failed, "No space left on device"),
+			},
+			[]phase{
+				{"install", "5s", phaseFailed, "No space left on device"},
+			},
+		},
+		{
+			"no reboot needed",
+			[]client.UpdateEvent{
+				event("EcuInstallationStarted", 0, nil, ""),
+				event("EcuInstallationCompleted", 8, &ok, ""),
+			},
+			[]phase{
+				{"install", "8s", phaseOk, ""},
+			},
+		},
+		{
+			"download in progress",
+			[]client.UpdateEvent{
+				event("EcuDownloadStarted", 0, nil, ""),
+			},
+			[]phase{
+				{"download", "-", phaseIncomplete, ""},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []phase
+			for _, p := range updatePhaseResults(tc.events) {
+				got = append(got, phase{p.name, p.duration, p.status, p.details})
+			}
+			assert.Equal(t, tc.phases, got)
+		})
+	}
+}
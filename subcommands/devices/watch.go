@@ -0,0 +1,267 @@
+package devices
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+var (
+	watchInterval time.Duration
+	watchCount    int
+	watchLog      string
+	watchWhere    whereValue
+)
+
+func init() {
+	watchCmd := &cobra.Command{
+		Use:   "watch [pattern]",
+		Short: "Show a live view of devices, highlighting the ones which changed",
+		Run:   doWatch,
+		Args:  cobra.MaximumNArgs(1),
+		Long: `Polls the devices of a Factory and redraws their table at an interval. Devices
+are selected and shown with the same flags as "fioctl devices list".
+
+A device is highlighted, and marked with "*", when its status, Target,
+up-to-date, or online state changed since the previous poll. These transitions
+can be appended to a JSON lines file to keep a record of a rollout.
+
+Available columns for display:
+
+  * ` + strings.Join(availableColumns(), "\n  * "),
+		Example: `
+  # Watch production devices in a group while a wave is rolled out:
+  fioctl devices watch --only-prod --by-group beta --interval 1m --log rollout.jsonl
+
+  # Poll three times and exit, e.g. in a script:
+  fioctl devices watch --count 3 --interval 10s`,
+	}
+	cmd.AddCommand(watchCmd)
+	addListFilterFlags(watchCmd)
+	watchCmd.Flags().VarP(&watchWhere, "where", "", "Only show devices matching an expression, see \"fioctl devices list --help\"")
+	watchCmd.Flags().DurationVarP(&watchInterval, "interval", "i", 30*time.Second, "How often to poll the devices")
+	watchCmd.Flags().IntVarP(&watchCount, "count", "", 0, "Exit after this many polls. Default is to run until interrupted")
+	watchCmd.Flags().StringVarP(&watchLog, "log", "", "", "Append device transitions to this file as JSON lines")
+}
+
+// watchFields are the device states which are tracked for changes.
+var watchFields = []string{"status", "target-name", "up-to-date", "online"}
+
+type watchState map[string]string
+
+func newWatchState(d client.Device) watchState {
+	return watchState{
+		"name":        d.Name,
+		"status":      d.Status,
+		"target-name": d.TargetName,
+		"up-to-date":  fmt.Sprint(d.UpToDate),
+		"online":      fmt.Sprint(d.Online(deviceInactiveHours)),
+	}
+}
+
+// watchTransition is a change of a device state between two polls, as saved to the log.
+type watchTransition struct {
+	Time  string `json:"time"`
+	Uuid  string `json:"uuid"`
+	Name  string `json:"name"`
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+type deviceWatcher struct {
+	states      map[string]watchState
+	polls       int
+	log         *os.File
+	interactive bool
+}
+
+func doWatch(cmd *cobra.Command, args []string) {
+	factory := viper.GetString("factory")
+	logrus.Debugf("Watching devices for: %s", factory)
+	for _, c := range showColumns {
+		if _, ok := Columns[c]; !ok {
+			subcommands.DieNotNil(fmt.Errorf("Invalid column name: %s", c))
+		}
+	}
+	if watchInterval < time.Second {
+		subcommands.DieNotNil(fmt.Errorf("The interval must be at least 1s"))
+	}
+
+	w := deviceWatcher{
+		states:      make(map[string]watchState),
+		interactive: term.IsTerminal(int(os.Stdout.Fd())),
+	}
+	if len(watchLog) > 0 {
+		var err error
+		w.log, err = os.OpenFile(watchLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		subcommands.DieNotNil(err, "Unable to open log:")
+		defer w.log.Close()
+	}
+
+	filterBy := listFilters(factory, args)
+	ctx := cmd.Context()
+	for {
+		devices, err := w.fetch(filterBy)
+		if ctx.Err() != nil {
+			// Ctrl-C is the normal way to stop watching
+			return
+		}
+		w.show(devices, err)
+		if watchCount > 0 && w.polls >= watchCount {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchInterval):
+		}
+	}
+}
+
+func (w *deviceWatcher) fetch(filterBy map[string]string) ([]client.Device, error) {
+	devices, err := selectFilteredDevices(filterBy, watchWhere.filter)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
+
+// show prints the devices, highlighting those which changed since the previous poll.
+// A failed poll is reported and retried at the next interval, keeping the previous states.
+func (w *deviceWatcher) show(devices []client.Device, pollErr error) {
+	now := time.Now().UTC()
+	if pollErr != nil {
+		w.clear()
+		fmt.Printf("%s: Unable to poll devices: %s\n", now.Format(time.RFC3339), pollErr)
+		return
+	}
+	w.polls += 1
+
+	var transitions []watchTransition
+	changed := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, d := range devices {
+		seen[d.Uuid] = true
+		state := newWatchState(d)
+		prev, known := w.states[d.Uuid]
+		w.states[d.Uuid] = state
+		if !known {
+			if w.polls > 1 {
+				transitions = append(transitions, newTransition(now, d, "device", "", "added"))
+				changed[d.Uuid] = true
+			}
+			continue
+		}
+		for _, field := range watchFields {
+			if prev[field] != state[field] {
+				transitions = append(transitions, newTransition(now, d, field, prev[field], state[field]))
+				changed[d.Uuid] = true
+			}
+		}
+	}
+	var removed []client.Device
+	for uuid, state := range w.states {
+		if !seen[uuid] {
+			delete(w.states, uuid)
+			removed = append(removed, client.Device{Uuid: uuid, Name: state["name"]})
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Name < removed[j].Name })
+	for _, d := range removed {
+		transitions = append(transitions, newTransition(now, d, "device", "present", "removed"))
+	}
+
+	w.clear()
+	w.printTable(devices, changed)
+	fmt.Printf("\n%s: %d devices, %d changed", now.Format(time.RFC3339), len(devices), len(changed))
+	if watchCount == 0 || w.polls < watchCount {
+		fmt.Printf(". Next poll in %s", watchInterval)
+	}
+	fmt.Println()
+	for _, t := range transitions {
+		fmt.Printf("  %s %s: %q -> %q\n", t.Name, t.Field, t.From, t.To)
+	}
+	if err := w.saveTransitions(transitions); err != nil {
+		fmt.Println("ERROR: Unable to write log:", err)
+	}
+}
+
+func newTransition(now time.Time, d client.Device, field, from, to string) watchTransition {
+	return watchTransition{
+		Time: now.Format(time.RFC3339), Uuid: d.Uuid, Name: d.Name, Field: field, From: from, To: to,
+	}
+}
+
+func (w *deviceWatcher) clear() {
+	if w.interactive {
+		fmt.Print("\033[H\033[2J")
+	} else if w.polls > 1 {
+		fmt.Println()
+	}
+}
+
+// printTable aligns the table before coloring it, as color codes would break the alignment.
+func (w *deviceWatcher) printTable(devices []client.Device, changed map[string]bool) {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	header := []string{" "}
+	for _, c := range showColumns {
+		header = append(header, strings.ToUpper(c))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, d := range devices {
+		if len(d.TargetName) == 0 {
+			d.TargetName = "???"
+		}
+		row := []string{" "}
+		if changed[d.Uuid] {
+			row[0] = "*"
+		}
+		for _, c := range showColumns {
+			row = append(row, Columns[c].Formatter(&d))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	_ = tw.Flush()
+
+	highlight := color.New(color.FgYellow, color.Bold)
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if strings.HasPrefix(line, "*") {
+			highlight.Println(line)
+		} else {
+			fmt.Println(line)
+		}
+	}
+}
+
+func (w *deviceWatcher) saveTransitions(transitions []watchTransition) error {
+	if w.log == nil {
+		return nil
+	}
+	for _, t := range transitions {
+		buf, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		if _, err := w.log.Write(append(buf, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
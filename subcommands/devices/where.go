@@ -0,0 +1,578 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+// A --where expression selects devices by any of their fields, e.g.
+//
+//	is-prod and target < 120 and apps contains shellhttpd and last-seen > 2d
+//
+// Fields are named as in the JSON output of "devices show", and nested fields are selected with
+// dots, e.g. network-info.local_ipv4 or hardware-info.cpu.cores. A few shorter aliases exist, see
+// whereAliases. Terms are combined with "and", "or", "not", and parentheses. The operators are:
+//
+//	= !=             equality; a bare boolean field is the same as "field = true"
+//	< <= > >=        numbers, or versions in names like lmp-ver and target-name
+//	contains         an item of a list, or a substring of a text
+//	~ !~             a filepath style pattern, e.g. name ~ 'gateway-*'
+//
+// A duration like 30m, 4h, 2d, or 1w compares the age of a timestamp, so "last-seen > 2d" means
+// not seen for over two days. A number compares the number at the end of a text, so
+// "target-name < 120" matches acme-lmp-119. Values with spaces or operators must be quoted.
+
+var whereAliases = map[string]string{
+	"apps":     "docker-apps",
+	"target":   "target-name",
+	"group":    "device-group",
+	"hostname": "network-info.hostname",
+	"ip":       "network-info.local_ipv4",
+	"mac":      "network-info.mac",
+	"hardware": "hardware-info",
+}
+
+// Terms of the form "field = value" which the server can filter by, mapped to the query parameters
+// of the device list API.
+var wherePushdown = map[string]string{
+	"name":         "name",
+	"uuid":         "uuid",
+	"device-group": "group",
+	"tag":          "match_tag",
+	"target-name":  "target_name",
+}
+
+type deviceFilter struct {
+	expr whereNode
+}
+
+// whereValue parses the --where flag along with other flags, so a syntax error is a usage error.
+type whereValue struct {
+	expr   string
+	filter *deviceFilter
+}
+
+func (v *whereValue) String() string {
+	return v.expr
+}
+
+func (v *whereValue) Set(expr string) error {
+	filter, err := parseWhere(expr)
+	if err != nil {
+		return err
+	}
+	v.expr = expr
+	v.filter = filter
+	return nil
+}
+
+func (v *whereValue) Type() string {
+	return "expression"
+}
+
+// parseWhere parses a --where expression.
+func parseWhere(expr string) (*deviceFilter, error) {
+	tokens, err := whereTokens(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := whereParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("Unexpected %q in --where expression", p.tokens[p.pos].text)
+	}
+	return &deviceFilter{expr: node}, nil
+}
+
+// Pushdown adds the terms the server can filter by to the device list query. Only terms which
+// must all match, i.e. joined with "and" at the top level, are pushed down. They are still
+// evaluated locally as well, which is cheap and keeps the results exact.
+func (f *deviceFilter) Pushdown(filterBy map[string]string) {
+	terms := []whereNode{f.expr}
+	if and, ok := f.expr.(*whereAnd); ok {
+		terms = and.terms
+	}
+	for _, term := range terms {
+		key, val := "", ""
+		switch t := term.(type) {
+		case *whereCompare:
+			if whereInteger.MatchString(t.value) || whereDuration.MatchString(t.value) {
+				// These have a special meaning which the server does not know about
+				continue
+			}
+			if t.field == "is-prod" {
+				if t.op == "" {
+					key, val = "prod", "1"
+				} else if b, err := strconv.ParseBool(t.value); t.op == "=" && err == nil {
+					key, val = "prod", "0"
+					if b {
+						val = "1"
+					}
+				}
+			} else if t.op == "=" || (t.op == "~" && t.field == "name") {
+				key, val = wherePushdown[t.field], t.value
+			}
+		case *whereNot:
+			if cmp, ok := t.term.(*whereCompare); ok && cmp.field == "is-prod" && cmp.op == "" {
+				key, val = "prod", "0"
+			}
+		}
+		if len(key) > 0 && len(filterBy[key]) == 0 {
+			filterBy[key] = val
+		}
+	}
+}
+
+// Match tells if a device matches the expression.
+func (f *deviceFilter) Match(d client.Device) (bool, error) {
+	buf, err := json.Marshal(d)
+	if err != nil {
+		return false, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(buf, &fields); err != nil {
+		return false, err
+	}
+	return f.expr.eval(fields)
+}
+
+type whereNode interface {
+	eval(fields map[string]interface{}) (bool, error)
+}
+
+type whereAnd struct{ terms []whereNode }
+type whereOr struct{ terms []whereNode }
+type whereNot struct{ term whereNode }
+
+type whereCompare struct {
+	field string
+	op    string // Empty for a bare boolean field
+	value string
+}
+
+func (n *whereAnd) eval(fields map[string]interface{}) (bool, error) {
+	for _, term := range n.terms {
+		if ok, err := term.eval(fields); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (n *whereOr) eval(fields map[string]interface{}) (bool, error) {
+	for _, term := range n.terms {
+		if ok, err := term.eval(fields); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (n *whereNot) eval(fields map[string]interface{}) (bool, error) {
+	ok, err := n.term.eval(fields)
+	return !ok, err
+}
+
+func (n *whereCompare) eval(fields map[string]interface{}) (bool, error) {
+	var val interface{} = fields
+	for _, part := range strings.Split(n.field, ".") {
+		if m, ok := val.(map[string]interface{}); ok {
+			val = m[part]
+		} else {
+			val = nil
+			break
+		}
+	}
+
+	switch v := val.(type) {
+	case nil:
+		if n.op == "" {
+			return false, nil
+		}
+		return compareText("", n.op, n.value)
+	case bool:
+		if n.op == "" {
+			return v, nil
+		}
+		b, err := strconv.ParseBool(n.value)
+		if err != nil {
+			return false, fmt.Errorf("Field %s is a boolean, not %q", n.field, n.value)
+		}
+		switch n.op {
+		case "=":
+			return v == b, nil
+		case "!=":
+			return v != b, nil
+		}
+		return false, fmt.Errorf("Operator %s is not supported for boolean field %s", n.op, n.field)
+	case float64:
+		if n.op == "" {
+			return v != 0, nil
+		}
+		num, err := strconv.ParseFloat(n.value, 64)
+		if err != nil {
+			return false, fmt.Errorf("Field %s is a number, not %q", n.field, n.value)
+		}
+		return compareOrdered(n.op, compareNumbers(v, num))
+	case string:
+		if n.op == "" {
+			return len(v) > 0, nil
+		}
+		return compareText(v, n.op, n.value)
+	case []interface{}:
+		if n.op == "" {
+			return len(v) > 0, nil
+		}
+		if n.op != "contains" && n.op != "~" && n.op != "!~" {
+			return false, fmt.Errorf("Only contains, ~ and !~ are supported for list field %s", n.field)
+		}
+		for _, item := range v {
+			text := fmt.Sprint(item)
+			match := text == n.value
+			if n.op != "contains" {
+				var err error
+				if match, err = path.Match(n.value, text); err != nil {
+					return false, fmt.Errorf("Invalid pattern %q: %w", n.value, err)
+				}
+			}
+			if match {
+				return n.op != "!~", nil
+			}
+		}
+		return n.op == "!~", nil
+	}
+	return false, fmt.Errorf("Field %s is an object, select one of its fields with a dot", n.field)
+}
+
+var (
+	whereDuration = regexp.MustCompile(`^(\d+(?:\.\d+)?)([smhdw])$`)
+	whereInteger  = regexp.MustCompile(`^\d+$`)
+	trailingInt   = regexp.MustCompile(`(\d+)$`)
+	durationUnits = map[string]time.Duration{
+		"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour,
+	}
+)
+
+func compareText(text, op, value string) (bool, error) {
+	switch op {
+	case "contains":
+		return strings.Contains(text, value), nil
+	case "~", "!~":
+		ok, err := path.Match(value, text)
+		if err != nil {
+			return false, fmt.Errorf("Invalid pattern %q: %w", value, err)
+		}
+		return ok == (op == "~"), nil
+	}
+
+	if limit, ok := parseAge(value); ok {
+		if age, ok := timestampAge(text); ok {
+			return compareOrdered(op, compareNumbers(float64(age), float64(limit)))
+		}
+	}
+	if whereInteger.MatchString(value) {
+		if m := trailingInt.FindStringSubmatch(text); m != nil {
+			have, _ := strconv.ParseFloat(m[1], 64)
+			want, _ := strconv.ParseFloat(value, 64)
+			return compareOrdered(op, compareNumbers(have, want))
+		}
+	}
+	if op == "=" || op == "!=" {
+		return (text == value) == (op == "="), nil
+	}
+	return compareOrdered(op, compareVersions(text, value))
+}
+
+// parseAge parses a duration which, unlike time.ParseDuration, may be in days or weeks, e.g. 7d.
+func parseAge(value string) (time.Duration, bool) {
+	m := whereDuration.FindStringSubmatch(value)
+	if m == nil {
+		return 0, false
+	}
+	num, _ := strconv.ParseFloat(m[1], 64)
+	return time.Duration(num * float64(durationUnits[m[2]])), true
+}
+
+// parseTimestamp parses the timestamps of the API, which are not all in the same format.
+func parseTimestamp(text string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// timestampAge returns how long ago a timestamp was. An empty timestamp, e.g. the last-seen of a
+// device which never connected, is infinitely old.
+func timestampAge(text string) (time.Duration, bool) {
+	if len(text) == 0 {
+		return time.Duration(math.MaxInt64), true
+	}
+	if t, ok := parseTimestamp(text); ok {
+		return time.Since(t), true
+	}
+	return 0, false
+}
+
+func compareNumbers(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareVersions compares texts with numbers in them naturally, so that lmp-95 < lmp-100.
+func compareVersions(a, b string) int {
+	for len(a) > 0 && len(b) > 0 {
+		aNum, bNum := unicode.IsDigit(rune(a[0])), unicode.IsDigit(rune(b[0]))
+		aPart, bPart := leadingRun(a, aNum), leadingRun(b, bNum)
+		a, b = a[len(aPart):], b[len(bPart):]
+		if aNum && bNum {
+			aPart, bPart = strings.TrimLeft(aPart, "0"), strings.TrimLeft(bPart, "0")
+			if len(aPart) != len(bPart) {
+				return compareNumbers(float64(len(aPart)), float64(len(bPart)))
+			}
+		}
+		if c := strings.Compare(aPart, bPart); c != 0 {
+			return c
+		}
+	}
+	return compareNumbers(float64(len(a)), float64(len(b)))
+}
+
+func leadingRun(s string, digits bool) string {
+	for i, r := range s {
+		if unicode.IsDigit(r) != digits {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+func compareOrdered(op string, c int) (bool, error) {
+	switch op {
+	case "=":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("Operator %s is not supported here", op)
+}
+
+type whereToken struct {
+	text   string
+	quoted bool
+}
+
+func (t whereToken) is(texts ...string) bool {
+	if t.quoted {
+		return false
+	}
+	for _, text := range texts {
+		if strings.EqualFold(t.text, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func whereTokens(expr string) ([]whereToken, error) {
+	var tokens []whereToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, whereToken{text: string(c)})
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated quote in --where expression: %s", expr[i:])
+			}
+			tokens = append(tokens, whereToken{text: expr[i+1 : i+1+end], quoted: true})
+			i += end + 2
+		case strings.IndexByte("=!<>~", c) >= 0:
+			op := string(c)
+			if i+1 < len(expr) && (expr[i+1] == '=' || (c == '!' && expr[i+1] == '~')) {
+				op += string(expr[i+1])
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("Invalid operator ! in --where expression, use \"not\" or !=")
+			}
+			tokens = append(tokens, whereToken{text: op})
+			i += len(op)
+		default:
+			start := i
+			for i < len(expr) && strings.IndexByte(" \t\n()'\"=!<>~", expr[i]) < 0 {
+				i++
+			}
+			tokens = append(tokens, whereToken{text: expr[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) peek() (whereToken, bool) {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos], true
+	}
+	return whereToken{}, false
+}
+
+func (p *whereParser) parseOr() (whereNode, error) {
+	var terms []whereNode
+	for {
+		term, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+		if tok, ok := p.peek(); !ok || !tok.is("or") {
+			break
+		}
+		p.pos++
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return &whereOr{terms}, nil
+}
+
+func (p *whereParser) parseAnd() (whereNode, error) {
+	var terms []whereNode
+	for {
+		term, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+		if tok, ok := p.peek(); !ok || !tok.is("and") {
+			break
+		}
+		p.pos++
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return &whereAnd{terms}, nil
+}
+
+func (p *whereParser) parseNot() (whereNode, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("Unexpected end of --where expression")
+	}
+	if tok.is("not") {
+		p.pos++
+		term, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &whereNot{term}, nil
+	}
+	if tok.is("(") {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, ok := p.peek(); !ok || !tok.is(")") {
+			return nil, fmt.Errorf("Missing ) in --where expression")
+		}
+		p.pos++
+		return node, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *whereParser) parseCompare() (whereNode, error) {
+	tok := p.tokens[p.pos]
+	if tok.quoted || tok.is(")", "and", "or", "contains") || isWhereOperator(tok) {
+		return nil, fmt.Errorf("Expected a field name in --where expression, got %q", tok.text)
+	}
+	field, err := whereField(tok.text)
+	if err != nil {
+		return nil, err
+	}
+	p.pos++
+
+	cmp := &whereCompare{field: field}
+	if op, ok := p.peek(); ok && (isWhereOperator(op) || op.is("contains")) {
+		p.pos++
+		cmp.op = strings.ToLower(op.text)
+		if cmp.op == "==" {
+			cmp.op = "="
+		}
+		val, ok := p.peek()
+		if !ok || (!val.quoted && (val.is("(", ")") || isWhereOperator(val))) {
+			return nil, fmt.Errorf("Expected a value after %s %s in --where expression", tok.text, op.text)
+		}
+		cmp.value = val.text
+		p.pos++
+	}
+	return cmp, nil
+}
+
+func isWhereOperator(tok whereToken) bool {
+	return tok.is("=", "==", "!=", "<", "<=", ">", ">=", "~", "!~")
+}
+
+// whereField checks that a field exists, and resolves its alias.
+func whereField(name string) (string, error) {
+	fields := deviceJsonFields()
+	top, rest, nested := strings.Cut(name, ".")
+	if alias, ok := whereAliases[name]; ok {
+		name = alias
+	} else if alias, ok := whereAliases[top]; ok && nested && !fields[top] {
+		name = alias + "." + rest
+	}
+	top, _, _ = strings.Cut(name, ".")
+	if !fields[top] {
+		return "", fmt.Errorf("Unknown device field %q in --where expression", top)
+	}
+	return name, nil
+}
+
+func deviceJsonFields() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(client.Device{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if len(name) > 0 && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
@@ -0,0 +1,110 @@
+package devices
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func TestWhereMatch(t *testing.T) {
+	hw := json.RawMessage(`{"cpu": {"cores": 4, "model": "imx8"}}`)
+	device := client.Device{
+		Name:       "gateway-1",
+		TargetName: "acme-lmp-119",
+		LmpVer:     "95",
+		DockerApps: []string{"shellhttpd", "fluentd"},
+		IsProd:     true,
+		LastSeen:   time.Now().Add(-3 * 24 * time.Hour).UTC().Format(time.RFC3339),
+		Network:    &client.NetInfo{Hostname: "gw1", Ipv4: "10.0.1.7"},
+		Hardware:   &hw,
+	}
+
+	for expr, expected := range map[string]bool{
+		"is-prod":                                    true,
+		"not is-prod":                                false,
+		"is-prod = false":                            false,
+		"up-to-date":                                 false,
+		"target < 120":                               true,
+		"target-name >= 120":                         false,
+		"target = acme-lmp-119":                      true,
+		"lmp-ver > 90 and lmp-ver <= 95":             true,
+		"lmp-ver < 100":                              true,
+		"apps contains shellhttpd":                   true,
+		"apps contains shell":                        false,
+		"apps ~ 'fluent*'":                           true,
+		"apps !~ 'fluent*'":                          false,
+		"name contains way":                          true,
+		"name ~ 'gateway-*'":                         true,
+		"name !~ 'gateway-*' or ip ~ '10.0.*'":       true,
+		"last-seen > 2d":                             true,
+		"last-seen > 1w":                             false,
+		"last-seen < 72h":                            false,
+		"hostname = gw1 and network-info.mac = ''":   true,
+		"hardware-info.cpu.cores > 2":                true,
+		"hardware.cpu.model = \"imx8\"":              true,
+		"hardware-info.missing.field = 1":            false,
+		"(is-prod or is-wave) and not (tag = devel)": true,
+		"tag": false,
+	} {
+		filter, err := parseWhere(expr)
+		require.Nil(t, err, expr)
+		match, err := filter.Match(device)
+		require.Nil(t, err, expr)
+		assert.Equal(t, expected, match, expr)
+	}
+
+	// A device which never connected is infinitely old
+	filter, err := parseWhere("last-seen > 52w")
+	require.Nil(t, err)
+	match, err := filter.Match(client.Device{})
+	require.Nil(t, err)
+	assert.True(t, match)
+}
+
+func TestWhereErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"bogus = 1",
+		"is-prod and",
+		"(is-prod",
+		"name = 'unterminated",
+		"name =",
+		"= 1",
+		"is-prod is-wave",
+		"! is-prod",
+	} {
+		_, err := parseWhere(expr)
+		assert.NotNil(t, err, expr)
+	}
+
+	for _, expr := range []string{"is-prod > 1", "apps = x", "network-info = x", "up-to-date = maybe"} {
+		filter, err := parseWhere(expr)
+		require.Nil(t, err, expr)
+		_, err = filter.Match(client.Device{DockerApps: []string{"a"}, Network: &client.NetInfo{}})
+		assert.NotNil(t, err, expr)
+	}
+}
+
+func TestWherePushdown(t *testing.T) {
+	filter, err := parseWhere("name ~ 'gw-*' and not is-prod and tag = devel and target < 120 and (group = a or group = b)")
+	require.Nil(t, err)
+	filterBy := map[string]string{"factory": "acme", "match_tag": "prod"}
+	filter.Pushdown(filterBy)
+	assert.Equal(t, map[string]string{
+		"factory":   "acme",
+		"name":      "gw-*",
+		"prod":      "0",
+		"match_tag": "prod", // A flag given explicitly is kept
+	}, filterBy)
+
+	filter, err = parseWhere("is-prod = true or uuid = x")
+	require.Nil(t, err)
+	filterBy = map[string]string{}
+	filter.Pushdown(filterBy)
+	assert.Empty(t, filterBy)
+}
@@ -0,0 +1,107 @@
+package subcommands
+
+import (
+	"fmt"
+
+	"github.com/fatih/color"
+)
+
+// DiffLines returns a diff of two lists of lines, with each line prefixed by " ", "-", or "+".
+// It uses the longest common subsequence of the lines after trimming their common prefix and
+// suffix, which is plenty fast for the few hundred lines of a device or config file.
+func DiffLines(a, b []string) []string {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var diff []string
+	for _, line := range a[:prefix] {
+		diff = append(diff, " "+line)
+	}
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:]
+	lcs := make([][]int, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(midA) || j < len(midB) {
+		switch {
+		case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+			diff = append(diff, " "+midA[i])
+			i++
+			j++
+		case j == len(midB) || (i < len(midA) && lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "-"+midA[i])
+			i++
+		default:
+			diff = append(diff, "+"+midB[j])
+			j++
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		diff = append(diff, " "+line)
+	}
+	return diff
+}
+
+// DiffHunks splits a diff into groups of changed lines with up to context unchanged lines
+// around them. A diff without changes has no hunks.
+func DiffHunks(diff []string, context int) [][]string {
+	keep := make([]bool, len(diff))
+	for i, line := range diff {
+		if line[0] == ' ' {
+			continue
+		}
+		for j := max(i-context, 0); j <= min(i+context, len(diff)-1); j++ {
+			keep[j] = true
+		}
+	}
+	var hunks [][]string
+	var hunk []string
+	for i, line := range diff {
+		if keep[i] {
+			hunk = append(hunk, line)
+		} else if hunk != nil {
+			hunks = append(hunks, hunk)
+			hunk = nil
+		}
+	}
+	if hunk != nil {
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+// PrintDiffHunks prints the hunks of a diff with their changes in color, each line prefixed by
+// the indent.
+func PrintDiffHunks(hunks [][]string, indent string) {
+	for _, hunk := range hunks {
+		color.Cyan(indent + "@@")
+		for _, line := range hunk {
+			switch line[0] {
+			case '-':
+				color.Red(indent + line)
+			case '+':
+				color.Green(indent + line)
+			default:
+				fmt.Println(indent + line)
+			}
+		}
+	}
+}
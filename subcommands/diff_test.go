@@ -0,0 +1,27 @@
+package subcommands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"target", "group", "x1", "x2", "c1", "c2", "c3", "k1", "k2"}
+	b := []string{"target", "group", "c1", "c3", "c4", "k1", "k2"}
+	assert.Equal(t, []string{
+		" target", " group", "-x1", "-x2", " c1", "-c2", " c3", "+c4", " k1", " k2",
+	}, DiffLines(a, b))
+
+	assert.Equal(t, []string{" a", " b"}, DiffLines([]string{"a", "b"}, []string{"a", "b"}))
+	assert.Equal(t, []string{"+a"}, DiffLines(nil, []string{"a"}))
+	assert.Equal(t, []string{"-a", "+b"}, DiffLines([]string{"a"}, []string{"b"}))
+}
+
+func TestDiffHunks(t *testing.T) {
+	diff := []string{" 1", "-2", "+2", " 3", " 4", " 5", " 6", "+7", " 8"}
+	assert.Equal(t, [][]string{{" 1", "-2", "+2", " 3"}, {" 6", "+7", " 8"}}, DiffHunks(diff, 1))
+	assert.Equal(t, [][]string{diff}, DiffHunks(diff, 2))
+	assert.Equal(t, [][]string{{"-2", "+2"}, {"+7"}}, DiffHunks(diff, 0))
+	assert.Empty(t, DiffHunks([]string{" 1", " 2"}, 3))
+}
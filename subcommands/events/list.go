@@ -9,12 +9,14 @@ import (
 )
 
 func init() {
-	cmd.AddCommand(&cobra.Command{
+	listCmd := &cobra.Command{
 		Use:     "list",
 		Aliases: []string{"ls"},
 		Short:   "List configured event queues",
 		Run:     doList,
-	})
+	}
+	cmd.AddCommand(listCmd)
+	subcommands.AddOutputFlag(listCmd)
 }
 
 func doList(cmd *cobra.Command, args []string) {
@@ -23,6 +25,9 @@ func doList(cmd *cobra.Command, args []string) {
 
 	queues, err := api.EventQueuesList(factory)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, queues) {
+		return
+	}
 
 	t := tabby.New()
 	t.AddHeader("LABEL", "TYPE", "PUSH URL")
@@ -0,0 +1,69 @@
+package subcommands
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+// Exit codes are a part of the fioctl interface: scripts may rely on them, so they must not change.
+// Keep this table in sync with the "Exit codes" section of the README.
+const (
+	ExitOk           = 0
+	ExitError        = 1   // A generic error, not covered by any code below
+	ExitUsage        = 2   // Invalid command line arguments or flags
+	ExitUnauthorized = 3   // The API rejected the credentials (HTTP 401), e.g. an expired token
+	ExitForbidden    = 4   // The credentials lack permission for the operation (HTTP 403)
+	ExitNotFound     = 5   // The requested resource does not exist (HTTP 404)
+	ExitConflict     = 6   // A conflict with the current state of a resource (HTTP 409)
+	ExitRateLimited  = 7   // Too many requests, retry later (HTTP 429)
+	ExitServerError  = 8   // The API failed to handle a request (HTTP 5xx)
+	ExitNetwork      = 9   // The API could not be reached, e.g. a DNS, connection, or TLS failure
+	ExitTimeout      = 10  // The --timeout was reached
+	ExitInterrupted  = 130 // The command was interrupted by Ctrl-C
+)
+
+// commandErr returns the error of the command's context, see SetCommandContext.
+var commandErr = func() error { return nil }
+
+// SetCommandContext sets the context which is done when the command is interrupted by Ctrl-C, or
+// its --timeout is reached. A server or a transport timeout may also fail with
+// context.DeadlineExceeded, so ExitCode only returns ExitTimeout when this context timed out.
+func SetCommandContext(ctx context.Context) {
+	commandErr = ctx.Err
+}
+
+// ExitCode returns the exit code for an error, as documented by the table above.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOk
+	}
+	if cmdErr := commandErr(); cmdErr != nil && errors.Is(err, cmdErr) {
+		if errors.Is(cmdErr, context.DeadlineExceeded) {
+			return ExitTimeout
+		}
+		return ExitInterrupted
+	}
+	switch {
+	case errors.Is(err, client.ErrUnauthorized):
+		return ExitUnauthorized
+	case errors.Is(err, client.ErrForbidden):
+		return ExitForbidden
+	case errors.Is(err, client.ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, client.ErrConflict):
+		return ExitConflict
+	case errors.Is(err, client.ErrRateLimited):
+		return ExitRateLimited
+	case errors.Is(err, client.ErrServer):
+		return ExitServerError
+	}
+	// The HTTP client returns all transport level failures as *url.Error
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return ExitNetwork
+	}
+	return ExitError
+}
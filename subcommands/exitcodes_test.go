@@ -0,0 +1,44 @@
+package subcommands
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+func TestExitCode(t *testing.T) {
+	timedOut, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-timedOut.Done()
+	interrupted, cancel := context.WithCancel(context.Background())
+	cancel()
+	serverTimeout := &url.Error{Op: "Get", URL: "https://api", Err: context.DeadlineExceeded}
+	defer SetCommandContext(context.Background())
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		code int
+	}{
+		{"nil", context.Background(), nil, ExitOk},
+		{"not found", context.Background(), fmt.Errorf("get: %w", client.ErrNotFound), ExitNotFound},
+		{"server timeout", context.Background(), serverTimeout, ExitNetwork},
+		{"command timeout", timedOut, serverTimeout, ExitTimeout},
+		{"command timeout, other error", timedOut, client.ErrNotFound, ExitNotFound},
+		{"interrupted", interrupted, &url.Error{Op: "Get", URL: "https://api", Err: context.Canceled}, ExitInterrupted},
+		{"interrupted, server timeout", interrupted, serverTimeout, ExitNetwork},
+		{"other", context.Background(), fmt.Errorf("boom"), ExitError},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			SetCommandContext(tc.ctx)
+			assert.Equal(t, tc.code, ExitCode(tc.err))
+		})
+	}
+}
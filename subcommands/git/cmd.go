@@ -88,6 +88,9 @@ func doGitCreds(cmd *cobra.Command, args []string) {
 	}
 
 	helper := fmt.Sprintf("%s git-credential-helper -c %s", self, cfgFile)
+	if subcommands.ActiveProfile != subcommands.DefaultProfile {
+		helper += " --profile " + subcommands.ActiveProfile
+	}
 	gitUsernameCommandArgs := []string{"config", "--global", fmt.Sprintf("credential.https://%s.username", sourceUrl), "fio-oauth2"}
 	gitHelperCommandArgs := []string{"config", "--global", fmt.Sprintf("credential.https://%s.helper", sourceUrl), helper}
 
@@ -106,6 +109,7 @@ func doGitCreds(cmd *cobra.Command, args []string) {
 }
 
 func RunCredsHelper() int {
+	subcommands.LoadOauthSecrets()
 	if subcommands.Config.ClientCredentials.ClientSecret == "" {
 		msg := "ERROR: Your fioctl configuration does not appear to include oauth2 credentials. Please run `fioctl login` to configure and then try again.\n"
 		os.Stderr.WriteString(msg)
@@ -0,0 +1,252 @@
+package keys
+
+import (
+	"bufio"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/subcommands"
+	"github.com/foundriesio/fioctl/x509"
+)
+
+var (
+	issueCsrs       string
+	issueUuids      []string
+	issueUuidsFile  string
+	issueOutput     string
+	issueProduction bool
+	issueHsmLabel   string
+)
+
+func init() {
+	cmd := &cobra.Command{
+		Use:   "issue-device-cert <PKI Directory>",
+		Short: "Issue device client certificates with a local device CA",
+		Run:   doIssueDeviceCert,
+		Args:  cobra.ExactArgs(1),
+		// Certificates are issued offline, e.g. on a manufacturing line, so there is no login
+		Annotations: map[string]string{offlineCmdAnnotation: "true"},
+		Long: `Issue device client certificates with a local device CA created by
+"fioctl keys ca create" or "fioctl keys ca add-device-ca". The device gateway
+trusts these certificates, so devices can connect without registering first.
+This command works without network access.
+
+Certificates are issued either for the CSRs generated on devices, or for
+device keys generated by this command. The common name of a certificate is the
+device UUID, and its organizational unit is the factory.
+
+Each device gets a bundle under the output directory, named after its UUID,
+with the files expected under /var/sota on the device:
+
+ * client.pem - The device client certificate.
+ * pkey.pem   - The device key, when generated by this command.
+ * root.crt   - The factory root CA, which the device gateway TLS cert chains to.
+
+The key of the local CA is read from a file next to its certificate, or from
+a PKCS#11 compatible HSM.`,
+		Example: `
+  # Sign all CSRs in a directory:
+  fioctl keys ca issue-device-cert /path/to/pki --csr /path/to/csrs -O bundles
+
+  # Sign CSRs concatenated into the standard input:
+  cat *.csr | fioctl keys ca issue-device-cert /path/to/pki --csr -
+
+  # Generate keys and certificates for production devices listed in a file:
+  fioctl keys ca issue-device-cert /path/to/pki --uuids-file uuids.txt --production
+
+  # Use a local CA key held by an HSM:
+  fioctl keys ca issue-device-cert /path/to/pki --uuid 4f5b1c9e-0b8a-4c3b-9d1e-7a2f3e6b5c4d \
+    --hsm-module /usr/lib/softhsm/libsofthsm2.so --hsm-pin 1234 --hsm-token-label line-1`,
+	}
+	caCmd.AddCommand(cmd)
+	cmd.Flags().StringVarP(&issueCsrs, "csr", "", "",
+		"A CSR file, a directory of .csr files, or - to read CSRs from the standard input")
+	cmd.Flags().StringSliceVarP(&issueUuids, "uuid", "", nil,
+		"Generate a key and certificate for this device UUID. Can be given many times")
+	cmd.Flags().StringVarP(&issueUuidsFile, "uuids-file", "", "",
+		"Generate a key and certificate for each device UUID in this file, one per line, or - for the standard input")
+	cmd.Flags().StringVarP(&issueOutput, "output-dir", "O", "device-certs", "Directory to write the device bundles into")
+	cmd.Flags().BoolVarP(&issueProduction, "production", "", false, "Issue certificates for production devices")
+	cmd.Flags().StringP("local-ca-filename", "", x509.DeviceCaCertFile, "A file name of the local CA in the PKI directory")
+	_ = cmd.MarkFlagFilename("local-ca-filename")
+	// HSM variables defined in ca_create.go
+	cmd.Flags().StringVarP(&hsmModule, "hsm-module", "", "", "Load the local CA key from a PKCS#11 compatible HSM using this module")
+	cmd.Flags().StringVarP(&hsmPin, "hsm-pin", "", "", "The PKCS#11 PIN to log into the HSM")
+	cmd.Flags().StringVarP(&hsmTokenLabel, "hsm-token-label", "", "", "The label of the HSM token containing the local CA key")
+	cmd.Flags().StringVarP(&issueHsmLabel, "hsm-key-label", "", x509.DeviceCaKeyLabel, "The label of the local CA key on the HSM")
+}
+
+// deviceCertRequest is a device to issue a certificate for, either from its CSR, or for a key
+// generated by fioctl when there is no CSR.
+type deviceCertRequest struct {
+	uuid   string
+	source string
+	csrPem string
+}
+
+func doIssueDeviceCert(cmd *cobra.Command, args []string) {
+	localCaFilename, _ := cmd.Flags().GetString("local-ca-filename")
+	assertFileName("--local-ca-filename", localCaFilename)
+	if len(issueCsrs) == 0 && len(issueUuids) == 0 && len(issueUuidsFile) == 0 {
+		subcommands.DieNotNil(errors.New("At least one of --csr, --uuid, or --uuids-file is required"))
+	}
+	if issueCsrs == "-" && issueUuidsFile == "-" {
+		subcommands.DieNotNil(errors.New("Only one of --csr and --uuids-file can read the standard input"))
+	}
+	hsm, err := x509.ValidateHsmArgs(
+		hsmModule, hsmPin, hsmTokenLabel, "--hsm-module", "--hsm-pin", "--hsm-token-label")
+	subcommands.DieNotNil(err)
+
+	// Inputs and outputs are relative to the working directory, not the PKI directory
+	outDir, err := filepath.Abs(issueOutput)
+	subcommands.DieNotNil(err)
+	var requests []deviceCertRequest
+	if len(issueCsrs) > 0 {
+		requests = append(requests, readDeviceCsrs(issueCsrs)...)
+	}
+	uuids := issueUuids
+	if len(issueUuidsFile) > 0 {
+		uuids = append(uuids, readDeviceUuids(issueUuidsFile)...)
+	}
+	for _, uuid := range uuids {
+		requests = append(requests, deviceCertRequest{uuid: uuid, source: "generated"})
+	}
+
+	subcommands.DieNotNil(os.Chdir(args[0]))
+	localCa := x509.LoadCertFromFile(localCaFilename)
+	if len(localCa.Subject.OrganizationalUnit) != 1 {
+		subcommands.DieNotNil(fmt.Errorf("The local CA %s has no factory as its organizational unit", localCaFilename))
+	}
+	factory := localCa.Subject.OrganizationalUnit[0]
+	if f := viper.GetString("factory"); len(f) > 0 && f != factory {
+		subcommands.DieNotNil(fmt.Errorf("The local CA %s belongs to the factory %s rather than %s", localCaFilename, factory, f))
+	}
+	rootCrt, err := os.ReadFile(x509.FactoryCaCertFile)
+	subcommands.DieNotNil(err, "Unable to read the factory root CA:")
+	x509.InitDeviceCa(localCaFilename, hsm, issueHsmLabel)
+
+	// All requests are checked before issuing anything, so that a bad input does not leave a
+	// partially provisioned batch behind.
+	seen := make(map[string]string)
+	for i, req := range requests {
+		if len(req.csrPem) > 0 {
+			uuid, err := x509.CheckDeviceCsr(req.csrPem, factory)
+			subcommands.DieNotNil(err, "Invalid CSR "+req.source+":")
+			requests[i].uuid = uuid
+		}
+		uuid := requests[i].uuid
+		if uuid == "." || uuid == ".." || strings.ContainsAny(uuid, "/\\ \t") {
+			subcommands.DieNotNil(fmt.Errorf("Invalid device UUID %q from %s", uuid, req.source))
+		}
+		if other, ok := seen[uuid]; ok {
+			subcommands.DieNotNil(fmt.Errorf("Device %s is both in %s and %s", uuid, other, req.source))
+		}
+		seen[uuid] = req.source
+		if _, err := os.Stat(filepath.Join(outDir, uuid)); err == nil {
+			subcommands.DieNotNil(fmt.Errorf("A bundle for device %s already exists in %s", uuid, outDir))
+		}
+	}
+
+	t := tabby.New()
+	t.AddHeader("UUID", "SOURCE", "BUNDLE")
+	for _, req := range requests {
+		var keyPem, crtPem string
+		if len(req.csrPem) > 0 {
+			crtPem = x509.SignDeviceCsr(req.csrPem, factory, issueProduction)
+		} else {
+			keyPem, crtPem = x509.CreateDeviceCert(req.uuid, factory, issueProduction)
+		}
+		bundle := filepath.Join(outDir, req.uuid)
+		subcommands.DieNotNil(os.MkdirAll(bundle, 0o700))
+		subcommands.DieNotNil(os.WriteFile(filepath.Join(bundle, "client.pem"), []byte(crtPem), 0o644))
+		subcommands.DieNotNil(os.WriteFile(filepath.Join(bundle, "root.crt"), rootCrt, 0o644))
+		if len(keyPem) > 0 {
+			subcommands.DieNotNil(os.WriteFile(filepath.Join(bundle, "pkey.pem"), []byte(keyPem), 0o600))
+		}
+		t.AddLine(req.uuid, req.source, bundle)
+	}
+	t.Print()
+	fmt.Printf("\nIssued %d device certificates for factory %s\n", len(requests), factory)
+}
+
+// readDeviceCsrs reads the PEM encoded CSRs from a file, the .csr files of a directory, or the
+// standard input. A file may contain many CSRs.
+func readDeviceCsrs(source string) []deviceCertRequest {
+	if source == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		subcommands.DieNotNil(err)
+		return splitDeviceCsrs("stdin", data)
+	}
+	info, err := os.Stat(source)
+	subcommands.DieNotNil(err)
+	files := []string{source}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(source, "*.csr"))
+		subcommands.DieNotNil(err)
+		sort.Strings(files)
+		if len(files) == 0 {
+			subcommands.DieNotNil(fmt.Errorf("No .csr files found in %s", source))
+		}
+	}
+	var requests []deviceCertRequest
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		subcommands.DieNotNil(err)
+		requests = append(requests, splitDeviceCsrs(file, data)...)
+	}
+	return requests
+}
+
+func splitDeviceCsrs(source string, data []byte) []deviceCertRequest {
+	var requests []deviceCertRequest
+	for idx := 1; ; idx++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			subcommands.DieNotNil(fmt.Errorf("%s contains a %s rather than a CSR", source, block.Type))
+		}
+		name := source
+		if idx > 1 || len(strings.TrimSpace(string(data))) > 0 {
+			name = fmt.Sprintf("%s#%d", source, idx)
+		}
+		requests = append(requests, deviceCertRequest{source: name, csrPem: string(pem.EncodeToMemory(block))})
+	}
+	if len(requests) == 0 {
+		subcommands.DieNotNil(fmt.Errorf("No CSRs found in %s", source))
+	}
+	return requests
+}
+
+// readDeviceUuids reads one device UUID per line, skipping empty lines and # comments.
+func readDeviceUuids(source string) []string {
+	in := os.Stdin
+	if source != "-" {
+		file, err := os.Open(source)
+		subcommands.DieNotNil(err)
+		defer file.Close()
+		in = file
+	}
+	var uuids []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			uuids = append(uuids, line)
+		}
+	}
+	subcommands.DieNotNil(scanner.Err())
+	return uuids
+}
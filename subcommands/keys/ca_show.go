@@ -38,6 +38,7 @@ func init() {
 	justShowFlags.Add(cmd, justShowRoot, "Only show the Factory root CA certificate")
 	justShowFlags.Add(cmd, justShowTls, "Only show the device-gateway TLS certificate")
 	justShowFlags.Add(cmd, justShowCas, "Only show device authenticate certificates trusted by the device-gateway")
+	subcommands.AddOutputFlag(cmd)
 }
 
 func doShowCA(cmd *cobra.Command, args []string) {
@@ -46,6 +47,9 @@ func doShowCA(cmd *cobra.Command, args []string) {
 
 	resp, err := api.FactoryGetCA(factory)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, resp) {
+		return
+	}
 
 	flag, err := justShowFlags.GetFlag()
 	subcommands.DieNotNil(err)
@@ -2,6 +2,7 @@ package keys
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
 	"github.com/foundriesio/fioctl/client"
 	"github.com/foundriesio/fioctl/subcommands"
@@ -11,10 +12,17 @@ var (
 	api *client.Api
 )
 
+// Commands with this annotation work without network access, so they do not log in
+const offlineCmdAnnotation = "offline"
+
 var cmd = &cobra.Command{
 	Use:   "keys",
 	Short: "Manage keys in use by your Factory fleet",
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if len(cmd.Annotations[offlineCmdAnnotation]) > 0 {
+			subcommands.DieNotNil(viper.BindPFlags(cmd.Flags()))
+			return
+		}
 		api = subcommands.Login(cmd)
 	},
 }
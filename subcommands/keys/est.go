@@ -20,6 +20,7 @@ func init() {
 	}
 	estCmd.AddCommand(cmd)
 	cmd.Flags().BoolVarP(&prettyFormat, "pretty", "", false, "Display human readable output of each certificate")
+	subcommands.AddOutputFlag(cmd)
 
 	cmd = &cobra.Command{
 		Use:   "authorize <PKI directory>",
@@ -45,6 +46,9 @@ func doShowEst(cmd *cobra.Command, args []string) {
 
 	cert, err := api.FactoryGetCA(factory)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, client.CaCerts{EstCrt: cert.EstCrt}) {
+		return
+	}
 	if len(cert.EstCrt) == 0 {
 		fmt.Println("EST TLS certificate has not been configured for this Factory.")
 	} else if prettyFormat {
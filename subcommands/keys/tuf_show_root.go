@@ -20,6 +20,7 @@ func init() {
 		Run:   doShowRoot,
 	}
 	show.Flags().BoolVarP(&showProd, "prod", "", false, "Show the production version")
+	subcommands.AddOutputFlag(show)
 	tufCmd.AddCommand(show)
 
 	legacyShow := &cobra.Command{
@@ -46,6 +47,9 @@ func doShowRoot(cmd *cobra.Command, args []string) {
 		root, err = api.TufRootGet(factory)
 	}
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, root) {
+		return
+	}
 	bytes, err := subcommands.MarshalIndent(root, "", "  ")
 	subcommands.DieNotNil(err)
 	fmt.Println(string(bytes))
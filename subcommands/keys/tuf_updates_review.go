@@ -25,6 +25,8 @@ func init() {
 	review.Flags().BoolP("diff", "", false, "Show the unified diff between current and staged root.json")
 	review.MarkFlagsMutuallyExclusive("raw", "diff")
 	review.Flags().BoolP("prod", "", false, "Show the production root.json")
+	subcommands.AddOutputFlag(review)
+	review.MarkFlagsMutuallyExclusive("raw", "diff", "output")
 	tufUpdatesCmd.AddCommand(review)
 }
 
@@ -43,6 +45,9 @@ func doTufUpdatesReview(cmd *cobra.Command, args []string) {
 	subcommands.DieNotNil(err)
 
 	oldCiRoot, newCiRoot, newProdRoot := checkTufRootUpdatesStatus(updates, false)
+	if subcommands.PrintOutput(cmd, updates) {
+		return
+	}
 
 	if showRaw || showDiff {
 		if updates.Status == client.TufRootUpdatesStatusNone {
@@ -12,6 +12,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/client/credstore"
 	"github.com/foundriesio/fioctl/subcommands"
 )
 
@@ -19,6 +20,7 @@ var (
 	refreshToken bool
 	authURL      string
 	insecure     bool
+	store        string
 )
 
 func NewCommand() *cobra.Command {
@@ -31,17 +33,25 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&authURL, "oauth-url", "", client.OauthURL, "OAuth URL to authenticate with")
 	cmd.Flags().BoolVarP(&insecure, "insecure-ssl", "", false, "Ignore TLS certificates from API servers.")
 	_ = cmd.Flags().MarkHidden("insecure-ssl")
+	cmd.Flags().StringVarP(&store, "store", "", "",
+		"Where to keep the client secret and tokens: keyring (OS keyring), file (passphrase-encrypted file), or plain (fioctl config file). "+
+			"Existing credentials are moved to a new store. The default is to keep the current store, or plain for new logins.")
 	return cmd
 }
 
 func doLogin(cmd *cobra.Command, args []string) {
 	logrus.Debug("Executing login command")
+	if len(store) > 0 {
+		subcommands.DieNotNil(credstore.Valid(store))
+	}
+	subcommands.LoadOauthSecrets()
 
 	if refreshToken {
 		creds := client.NewClientCredentials(subcommands.Config.ClientCredentials)
 		// Change ExpiresIn to basically "now". This will cause fioctl to
 		// get a new token with fresh scopes
 		creds.Config.ExpiresIn = 1
+		setStore(&creds.Config)
 		subcommands.SaveOauthConfig(creds.Config)
 		return
 	}
@@ -72,6 +82,7 @@ func doLogin(cmd *cobra.Command, args []string) {
 		viper.Set("server.insecure_skip_verify", true)
 		creds.InsecureSSL = true
 	}
+	creds.Client = subcommands.OauthHttpClient()
 
 	if creds.Config.ClientId == "" || creds.Config.ClientSecret == "" {
 		fmt.Println("Cannot execute login without client ID or client secret.")
@@ -86,14 +97,33 @@ func doLogin(cmd *cobra.Command, args []string) {
 	} else if creds.Config.AccessToken == "" {
 		subcommands.DieNotNil(creds.Get())
 	} else {
+		if setStore(&creds.Config) {
+			// This is a migration path for existing configs, e.g. from plain to keyring
+			subcommands.SaveOauthConfig(creds.Config)
+			fmt.Printf("Your credentials were moved to the %s store.\n", store)
+		}
 		fmt.Println("You are already logged in to Foundries.io services.")
 		os.Exit(0)
 	}
 
+	setStore(&creds.Config)
 	subcommands.SaveOauthConfig(creds.Config)
 	fmt.Println("You are now logged in to Foundries.io services.")
 }
 
+// setStore applies the --store flag to the credentials, returning true if the store changes.
+func setStore(c *client.OAuthConfig) bool {
+	if len(store) == 0 {
+		return false
+	}
+	prev := c.Store
+	if len(prev) == 0 {
+		prev = credstore.Plain
+	}
+	c.Store = store
+	return prev != store
+}
+
 func promptForCreds(credsUrl string) (string, string) {
 	logrus.Debug("Reading client ID/secret from stdin")
 
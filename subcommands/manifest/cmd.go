@@ -0,0 +1,181 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+var api *client.Api
+
+const manifestHelp = `The manifest is a YAML file describing the settings of a Factory:
+
+  factory: acme
+  reason: Managed by acme/factory-settings
+  config:
+    - name: acme.toml
+      file: config/acme.toml
+      on-changed: ["/usr/share/fioconfig/handlers/acme-restart"]
+      unencrypted: true
+  wireguard:
+    enabled: true
+    endpoint: vpn.acme.example:5555
+    address: 10.42.42.1
+    public-key: "6YpZ...="
+  device-groups:
+    - name: beta
+      description: Devices testing the next release
+      updates:
+        tag: beta
+        apps: shellhttpd
+      config:
+        - name: beta.json
+          value: '{"verbose": true}'
+  event-queues:
+    - label: ci
+      type: push
+      url: https://ci.acme.example/fio-events
+    - label: fleet
+      type: pull
+      creds-file: fleet-creds.json
+  secrets:
+    - name: acme-registry-token
+      env: ACME_REGISTRY_TOKEN
+
+A section left out of the manifest is not managed, so a Factory can be moved to
+a manifest one section at a time. Within a managed section, resources missing
+from the manifest are kept, unless --prune is given. Files are read relative to
+the directory of the manifest, or the working directory when the manifest is
+read from STDIN.
+
+Secret values cannot be read back from the API. A secret is only created when
+it does not exist yet, with its value read from the env variable or the file it
+names. The credentials of a pull event queue are only returned when it is
+created, and are saved into its creds-file.`
+
+type manifestOptions struct {
+	file           string
+	prune          bool
+	skipValidation bool
+	yes            bool
+}
+
+var opts manifestOptions
+
+func NewPlanCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan -F <manifest>",
+		Short: "Show the changes it takes for a Factory to match a manifest",
+		Long: `Show the changes it takes for a Factory to match a manifest. Nothing is changed,
+use "fioctl apply" to make the changes.
+
+` + manifestHelp,
+		Example: `
+  # Review the changes of a pull request to the manifest:
+  fioctl plan -F factory.yaml
+
+  # Include the removal of the resources which are not in the manifest:
+  fioctl plan -F factory.yaml --prune`,
+		Run:  doPlan,
+		Args: cobra.NoArgs,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			api = subcommands.Login(cmd)
+		},
+	}
+	subcommands.RequireFactory(cmd)
+	addManifestFlags(cmd)
+	return cmd
+}
+
+func NewApplyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply -F <manifest>",
+		Short: "Change a Factory to match a manifest",
+		Long: `Change a Factory to match a manifest. The changes are shown, as with
+"fioctl plan", and made once confirmed.
+
+` + manifestHelp,
+		Example: `
+  # Apply a manifest from a CI job, once its pull request is merged:
+  fioctl apply -F factory.yaml --yes
+
+  # Apply a manifest generated by another tool:
+  generate-factory-manifest | fioctl apply -F - --yes`,
+		Run:  doApply,
+		Args: cobra.NoArgs,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			api = subcommands.Login(cmd)
+		},
+	}
+	subcommands.RequireFactory(cmd)
+	addManifestFlags(cmd)
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "Make the changes without asking for confirmation")
+	return cmd
+}
+
+func addManifestFlags(cmd *cobra.Command) {
+	subcommands.AddFileFlag(cmd, &opts.file, "The manifest file")
+	cmd.Flags().BoolVarP(&opts.prune, "prune", "", false, "Remove the resources of the managed sections which are not in the manifest")
+	cmd.Flags().BoolVarP(&opts.skipValidation, "skip-validation", "", false, "Do not check the config files before uploading them")
+}
+
+func loadPlan() *plan {
+	path := opts.file
+	factory := viper.GetString("factory")
+	m, err := Load(path)
+	subcommands.DieNotNil(err)
+	if len(m.Factory) > 0 && m.Factory != factory {
+		subcommands.DieNotNil(fmt.Errorf("The manifest is for the Factory %s, not %s", m.Factory, factory))
+	}
+	var rules *subcommands.ConfigRules
+	if !opts.skipValidation {
+		rules, err = subcommands.LoadConfigRules()
+		subcommands.DieNotNil(err)
+	}
+	logrus.Debugf("Planning %s for %s", path, factory)
+	return newPlan(factory, m, opts.prune, rules)
+}
+
+func doPlan(cmd *cobra.Command, args []string) {
+	loadPlan().print()
+}
+
+func doApply(cmd *cobra.Command, args []string) {
+	p := loadPlan()
+	p.print()
+	if add, chg, remove, _ := p.counts(); add+chg+remove == 0 {
+		return
+	}
+	fmt.Println()
+	if !opts.yes && opts.file == "-" {
+		// The standard input was used up by the manifest
+		subcommands.DieNotNil(errors.New("The manifest is read from STDIN, so use --yes to confirm the changes"))
+	}
+	if !opts.yes && !subcommands.Confirm("Apply these changes?") {
+		fmt.Println("Nothing was changed")
+		return
+	}
+	for _, s := range p.sections {
+		for _, a := range s.actions {
+			fmt.Println(a.desc)
+			subcommands.DieNotNil(a.run(), "Unable to apply the manifest, run \"fioctl plan\" to see what is left to change:")
+		}
+	}
+	fmt.Println("The Factory matches the manifest")
+}
+
+// writeCredsFile saves the credentials of a pull event queue, which grant access to its events.
+func writeCredsFile(path string, creds []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, creds, 0o600)
+}
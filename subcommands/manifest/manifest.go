@@ -0,0 +1,215 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/foundriesio/fioctl/client"
+)
+
+// Manifest describes the desired settings of a Factory. A section which is left out is not
+// managed, so that a Factory can be moved to a manifest one section at a time. Files are read
+// relative to the directory of the manifest, or the working directory if it is read from STDIN.
+type Manifest struct {
+	Factory      string        `yaml:"factory"`
+	Reason       string        `yaml:"reason"`
+	Config       []ConfigFile  `yaml:"config"`
+	Wireguard    *Wireguard    `yaml:"wireguard"`
+	DeviceGroups []DeviceGroup `yaml:"device-groups"`
+	EventQueues  []EventQueue  `yaml:"event-queues"`
+	Secrets      []Secret      `yaml:"secrets"`
+
+	path string
+}
+
+type ConfigFile struct {
+	Name        string   `yaml:"name"`
+	Value       *string  `yaml:"value"`
+	File        string   `yaml:"file"`
+	OnChanged   []string `yaml:"on-changed"`
+	Unencrypted bool     `yaml:"unencrypted"`
+}
+
+// Wireguard overrides the settings of the Factory wireguard server which are given.
+type Wireguard struct {
+	Enabled   *bool   `yaml:"enabled"`
+	Endpoint  *string `yaml:"endpoint"`
+	Address   *string `yaml:"address"`
+	PublicKey *string `yaml:"public-key"`
+}
+
+type DeviceGroup struct {
+	Name        string       `yaml:"name"`
+	Description *string      `yaml:"description"`
+	Config      []ConfigFile `yaml:"config"`
+	Updates     *Updates     `yaml:"updates"`
+}
+
+// Updates has the same values as "fioctl config updates --group".
+type Updates struct {
+	Tag  string `yaml:"tag"`
+	Apps string `yaml:"apps"`
+}
+
+type EventQueue struct {
+	Label     string `yaml:"label"`
+	Type      string `yaml:"type"`
+	Url       string `yaml:"url"`
+	CredsFile string `yaml:"creds-file"`
+}
+
+// Secret is a Factory secret. Its value is never in the manifest, but read from an environment
+// variable or a file when the secret has to be created.
+type Secret struct {
+	Name string `yaml:"name"`
+	Env  string `yaml:"env"`
+	File string `yaml:"file"`
+}
+
+// Load reads a manifest file, or STDIN if the path is "-".
+func Load(path string) (*Manifest, error) {
+	var buf []byte
+	var err error
+	m := Manifest{path: path}
+	if path == "-" {
+		buf, err = io.ReadAll(os.Stdin)
+		m.path = "" // Files are relative to the working directory
+	} else {
+		buf, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(buf, &m); err != nil {
+		return nil, fmt.Errorf("Invalid manifest %s: %w", path, err)
+	}
+	if err := m.check(); err != nil {
+		return nil, fmt.Errorf("Invalid manifest %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+func (m *Manifest) check() error {
+	if err := checkConfigFiles("config", m.Config); err != nil {
+		return err
+	}
+	groups := make(map[string]bool)
+	for _, g := range m.DeviceGroups {
+		if len(g.Name) == 0 {
+			return errors.New("A device group has no name")
+		}
+		if groups[g.Name] {
+			return fmt.Errorf("The device group %s is given more than once", g.Name)
+		}
+		groups[g.Name] = true
+		if err := checkConfigFiles("device group "+g.Name+" config", g.Config); err != nil {
+			return err
+		}
+	}
+	queues := make(map[string]bool)
+	for i, q := range m.EventQueues {
+		if len(q.Label) == 0 {
+			return errors.New("An event queue has no label")
+		}
+		if queues[q.Label] {
+			return fmt.Errorf("The event queue %s is given more than once", q.Label)
+		}
+		queues[q.Label] = true
+		if len(q.Type) == 0 {
+			m.EventQueues[i].Type = "push"
+		}
+		switch m.EventQueues[i].Type {
+		case "push":
+			if len(q.Url) == 0 || len(q.CredsFile) > 0 {
+				return fmt.Errorf("The push event queue %s needs a url, and no creds-file", q.Label)
+			}
+		case "pull":
+			if len(q.CredsFile) == 0 || len(q.Url) > 0 {
+				return fmt.Errorf("The pull event queue %s needs a creds-file to save its credentials into, and no url", q.Label)
+			}
+		default:
+			return fmt.Errorf("Invalid type %q of the event queue %s: must be push or pull", q.Type, q.Label)
+		}
+	}
+	secrets := make(map[string]bool)
+	for _, s := range m.Secrets {
+		if len(s.Name) == 0 {
+			return errors.New("A secret has no name")
+		}
+		if secrets[s.Name] {
+			return fmt.Errorf("The secret %s is given more than once", s.Name)
+		}
+		secrets[s.Name] = true
+		if len(s.Env) > 0 && len(s.File) > 0 {
+			return fmt.Errorf("The secret %s can be read from either an env or a file", s.Name)
+		}
+	}
+	return nil
+}
+
+func checkConfigFiles(section string, files []ConfigFile) error {
+	names := make(map[string]bool)
+	for _, f := range files {
+		if len(f.Name) == 0 {
+			return fmt.Errorf("A file of the %s has no name", section)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("The file %s is given more than once in the %s", f.Name, section)
+		}
+		names[f.Name] = true
+		if (f.Value == nil) == (len(f.File) == 0) {
+			return fmt.Errorf("The file %s of the %s needs either a value or a file", f.Name, section)
+		}
+	}
+	return nil
+}
+
+// resolve returns a path relative to the directory of the manifest.
+func (m *Manifest) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(m.path), path)
+}
+
+// configFiles returns the config files with their values read.
+func (m *Manifest) configFiles(files []ConfigFile) ([]client.ConfigFile, error) {
+	var cfgFiles []client.ConfigFile
+	for _, f := range files {
+		cfgFile := client.ConfigFile{Name: f.Name, OnChanged: f.OnChanged, Unencrypted: f.Unencrypted}
+		if f.Value != nil {
+			cfgFile.Value = *f.Value
+		} else {
+			buf, err := os.ReadFile(m.resolve(f.File))
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read the config file %s: %w", f.Name, err)
+			}
+			cfgFile.Value = string(buf)
+		}
+		cfgFiles = append(cfgFiles, cfgFile)
+	}
+	return cfgFiles, nil
+}
+
+// secretValue reads the value of a secret, or returns an error explaining where it is missing.
+func (m *Manifest) secretValue(s Secret) (string, error) {
+	switch {
+	case len(s.Env) > 0:
+		if value := os.Getenv(s.Env); len(value) > 0 {
+			return value, nil
+		}
+		return "", fmt.Errorf("The secret %s does not exist yet, and $%s is not set", s.Name, s.Env)
+	case len(s.File) > 0:
+		buf, err := os.ReadFile(m.resolve(s.File))
+		if err != nil {
+			return "", fmt.Errorf("The secret %s does not exist yet: %w", s.Name, err)
+		}
+		return string(buf), nil
+	}
+	return "", fmt.Errorf("The secret %s does not exist yet, and has no env or file to read its value from", s.Name)
+}
@@ -0,0 +1,50 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	load := func(content string) (*Manifest, error) {
+		path := filepath.Join(dir, "factory.yml")
+		require.Nil(t, os.WriteFile(path, []byte(content), 0o644))
+		return Load(path)
+	}
+
+	m, err := load("device-groups:\n  - name: beta\nevent-queues:\n  - label: ci\n    url: https://ci\n")
+	require.Nil(t, err)
+	assert.Nil(t, m.Config, "A section left out is not managed")
+	assert.Nil(t, m.Secrets)
+	assert.Equal(t, "push", m.EventQueues[0].Type)
+
+	m, err = load("config: []\n")
+	require.Nil(t, err)
+	assert.NotNil(t, m.Config, "An empty section is managed")
+
+	for content, msg := range map[string]string{
+		"groups: []\n": "field groups not found",
+		"device-groups:\n  - name: a\n  - name: a\n":                                                           "The device group a is given more than once",
+		"config:\n  - name: a\n":                                                                               "The file a of the config needs either a value or a file",
+		"config:\n  - name: a\n    value: x\n    file: a\n":                                                    "The file a of the config needs either a value or a file",
+		"event-queues:\n  - label: q\n    type: pull\n":                                                        "The pull event queue q needs a creds-file",
+		"event-queues:\n  - label: q\n    type: poll\n":                                                        `Invalid type "poll" of the event queue q`,
+		"secrets:\n  - name: s\n    env: S\n    file: s\n":                                                     "The secret s can be read from either an env or a file",
+		"device-groups:\n  - name: b\n    config:\n      - {name: x, value: a}\n      - {name: x, value: b}\n": "The file x is given more than once in the device group b config",
+	} {
+		_, err := load(content)
+		require.NotNil(t, err, content)
+		assert.Contains(t, err.Error(), msg)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	m := Manifest{path: "/srv/factory/factory.yml"}
+	assert.Equal(t, "/srv/factory/config/a.toml", m.resolve("config/a.toml"))
+	assert.Equal(t, "/etc/a.toml", m.resolve("/etc/a.toml"))
+}
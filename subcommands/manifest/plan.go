@@ -0,0 +1,471 @@
+package manifest
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+
+	"github.com/foundriesio/fioctl/client"
+	"github.com/foundriesio/fioctl/subcommands"
+	cfgcmd "github.com/foundriesio/fioctl/subcommands/config"
+)
+
+const (
+	opAdd    = "+"
+	opChange = "~"
+	opRemove = "-"
+
+	wireguardFile    = "wireguard-server"
+	wireguardHandler = "/usr/share/fioconfig/handlers/factory-config-vpn"
+)
+
+// change is a resource to add, change, or remove, with a description of what changes.
+type change struct {
+	op      string
+	name    string
+	details []string
+	diff    []string // Value changes of a config file, as returned by DiffLines
+}
+
+// action makes one or several of the changes of a section.
+type action struct {
+	desc string
+	run  func() error
+}
+
+type section struct {
+	title   string
+	changes []change
+	kept    []string // Resources which are not in the manifest, but are only removed with --prune
+	actions []action
+}
+
+// plan is what it takes to make a Factory match a manifest. Problems are found while planning,
+// such as a missing secret value or an invalid config file, and prevent the plan from being applied.
+type plan struct {
+	sections []*section
+	problems []error
+}
+
+type planner struct {
+	factory  string
+	manifest *Manifest
+	prune    bool
+	rules    *subcommands.ConfigRules // nil to skip the validation
+	plan     plan
+}
+
+func newPlan(factory string, m *Manifest, prune bool, rules *subcommands.ConfigRules) *plan {
+	p := planner{factory: factory, manifest: m, prune: prune, rules: rules}
+	reason := m.Reason
+	if len(reason) == 0 && len(m.path) > 0 {
+		reason = "Apply " + filepath.Base(m.path)
+	} else if len(reason) == 0 {
+		reason = "Apply a manifest"
+	}
+
+	groups, err := api.FactoryListDeviceGroup(factory)
+	subcommands.DieNotNil(err)
+	liveGroups := make(map[string]client.DeviceGroup)
+	for _, g := range *groups {
+		liveGroups[g.Name] = g
+	}
+	if m.DeviceGroups != nil {
+		p.planDeviceGroups(*groups)
+	}
+
+	if m.Config != nil || m.Wireguard != nil {
+		logrus.Debugf("Planning the config of %s", factory)
+		dcl, err := api.FactoryListConfig(factory)
+		subcommands.DieNotNil(err)
+		desired := p.configFiles(m.Config)
+		exempt := ""
+		if m.Wireguard == nil {
+			exempt = wireguardFile
+		} else if slices.ContainsFunc(desired, func(f client.ConfigFile) bool { return f.Name == wireguardFile }) {
+			p.problem("The %s config file is set by the wireguard section, and cannot be in the config", wireguardFile)
+		} else {
+			desired = append(desired, desiredWireguard(dcl, m.Wireguard))
+		}
+		p.planConfig("Factory config", latestFiles(dcl), desired, exempt, reason,
+			func(cfg client.ConfigCreateRequest) error { return api.FactoryCreateConfig(factory, cfg) },
+			func(cfg client.ConfigCreateRequest) error { return api.FactoryPatchConfig(factory, cfg, false) },
+		)
+	}
+
+	for _, g := range m.DeviceGroups {
+		if g.Config == nil && g.Updates == nil {
+			continue
+		}
+		logrus.Debugf("Planning the config of %s group %s", factory, g.Name)
+		dcl := &client.DeviceConfigList{}
+		if _, ok := liveGroups[g.Name]; ok {
+			dcl, err = api.GroupListConfig(factory, g.Name)
+			subcommands.DieNotNil(err)
+		}
+		desired := p.configFiles(g.Config)
+		exempt := ""
+		if g.Updates == nil {
+			exempt = subcommands.FIO_TOML_NAME
+		} else if slices.ContainsFunc(desired, func(f client.ConfigFile) bool { return f.Name == subcommands.FIO_TOML_NAME }) {
+			p.problem("The %s config file of the device group %s is set by its updates, and cannot be in its config",
+				subcommands.FIO_TOML_NAME, g.Name)
+		} else if cfg, err := subcommands.UpdatesConfigChange(dcl, g.Updates.Tag, g.Updates.Apps, false); err != nil {
+			p.problem("Invalid updates of the device group %s: %s", g.Name, err)
+		} else if cfg != nil {
+			desired = append(desired, cfg.Files[0])
+		} else if idx := slices.IndexFunc(latestFiles(dcl), func(f client.ConfigFile) bool { return f.Name == subcommands.FIO_TOML_NAME }); idx >= 0 {
+			desired = append(desired, latestFiles(dcl)[idx])
+		}
+		group := g.Name
+		p.planConfig("Device group "+group+" config", latestFiles(dcl), desired, exempt, reason,
+			func(cfg client.ConfigCreateRequest) error { return api.GroupCreateConfig(factory, group, cfg) },
+			func(cfg client.ConfigCreateRequest) error { return api.GroupPatchConfig(factory, group, cfg, false) },
+		)
+	}
+
+	if m.EventQueues != nil {
+		p.planEventQueues()
+	}
+	if m.Secrets != nil {
+		p.planSecrets()
+	}
+	return &p.plan
+}
+
+func (p *planner) problem(format string, args ...interface{}) {
+	p.plan.problems = append(p.plan.problems, fmt.Errorf(format, args...))
+}
+
+func (p *planner) configFiles(files []ConfigFile) []client.ConfigFile {
+	cfgFiles, err := p.manifest.configFiles(files)
+	if err != nil {
+		p.problem("%s", err)
+	}
+	return cfgFiles
+}
+
+func (p *planner) planDeviceGroups(live []client.DeviceGroup) {
+	s := &section{title: "Device groups"}
+	for _, g := range p.manifest.DeviceGroups {
+		idx := slices.IndexFunc(live, func(lg client.DeviceGroup) bool { return lg.Name == g.Name })
+		if idx < 0 {
+			c := change{op: opAdd, name: g.Name}
+			if g.Description != nil {
+				c.details = append(c.details, fmt.Sprintf("description: %q", *g.Description))
+			}
+			s.changes = append(s.changes, c)
+			s.actions = append(s.actions, action{"Creating device group " + g.Name, func() error {
+				_, err := api.FactoryCreateDeviceGroup(p.factory, g.Name, g.Description)
+				return err
+			}})
+		} else if g.Description != nil && *g.Description != live[idx].Description {
+			s.changes = append(s.changes, change{op: opChange, name: g.Name, details: []string{
+				fmt.Sprintf("description: %q -> %q", live[idx].Description, *g.Description),
+			}})
+			s.actions = append(s.actions, action{"Updating device group " + g.Name, func() error {
+				return api.FactoryPatchDeviceGroup(p.factory, g.Name, nil, g.Description)
+			}})
+		}
+	}
+	for _, lg := range live {
+		if slices.ContainsFunc(p.manifest.DeviceGroups, func(g DeviceGroup) bool { return g.Name == lg.Name }) {
+			continue
+		}
+		if !p.prune {
+			s.kept = append(s.kept, lg.Name)
+			continue
+		}
+		s.changes = append(s.changes, change{op: opRemove, name: lg.Name})
+		s.actions = append(s.actions, action{"Deleting device group " + lg.Name, func() error {
+			return api.FactoryDeleteDeviceGroup(p.factory, lg.Name)
+		}})
+	}
+	p.plan.sections = append(p.plan.sections, s)
+}
+
+// planConfig compares the files of the config in effect with the desired ones. A file which is
+// not in the manifest is only removed with --prune, unless it is the exempt file, which is set by
+// a section of the manifest that is left out. Removing files takes a new config with all the
+// files, while other changes are patched into the config in effect.
+func (p *planner) planConfig(
+	title string, live, desired []client.ConfigFile, exempt, reason string,
+	createFunc, patchFunc func(client.ConfigCreateRequest) error,
+) {
+	s := &section{title: title}
+	var changed, kept []client.ConfigFile
+	for _, d := range desired {
+		idx := slices.IndexFunc(live, func(f client.ConfigFile) bool { return f.Name == d.Name })
+		if idx < 0 {
+			s.changes = append(s.changes, change{op: opAdd, name: d.Name, diff: subcommands.DiffLines(nil, lines(d.Value))})
+			changed = append(changed, d)
+			continue
+		}
+		l := live[idx]
+		c := change{op: opChange, name: d.Name}
+		if l.Unencrypted != d.Unencrypted {
+			c.details = append(c.details, fmt.Sprintf("unencrypted: %v -> %v", l.Unencrypted, d.Unencrypted))
+		}
+		if !slices.Equal(l.OnChanged, d.OnChanged) {
+			c.details = append(c.details, fmt.Sprintf("on-changed: %v -> %v", l.OnChanged, d.OnChanged))
+		}
+		if l.Value != d.Value {
+			c.diff = subcommands.DiffLines(lines(l.Value), lines(d.Value))
+		}
+		if len(c.details) > 0 || len(c.diff) > 0 {
+			s.changes = append(s.changes, c)
+			changed = append(changed, d)
+		}
+	}
+	removed := false
+	for _, l := range live {
+		if slices.ContainsFunc(desired, func(f client.ConfigFile) bool { return f.Name == l.Name }) {
+			continue
+		}
+		if l.Name == exempt || !p.prune {
+			if l.Name != exempt {
+				s.kept = append(s.kept, l.Name)
+			}
+			kept = append(kept, l)
+			continue
+		}
+		s.changes = append(s.changes, change{op: opRemove, name: l.Name})
+		removed = true
+	}
+
+	if p.rules != nil {
+		for _, err := range p.rules.Validate(changed, false) {
+			p.problem("%s: %s", title, err)
+		}
+	}
+	if removed {
+		cfg := client.ConfigCreateRequest{Reason: reason, Files: append(desired, kept...)}
+		s.actions = append(s.actions, action{"Setting the " + strings.ToLower(title[:1]) + title[1:], func() error {
+			return createFunc(cfg)
+		}})
+	} else if len(changed) > 0 {
+		cfg := client.ConfigCreateRequest{Reason: reason, Files: changed}
+		s.actions = append(s.actions, action{"Updating the " + strings.ToLower(title[:1]) + title[1:], func() error {
+			return patchFunc(cfg)
+		}})
+	}
+	p.plan.sections = append(p.plan.sections, s)
+}
+
+// desiredWireguard returns the wireguard server config file with the settings of the manifest.
+func desiredWireguard(dcl *client.DeviceConfigList, wg *Wireguard) client.ConfigFile {
+	wsc := cfgcmd.WireguardServerConfig{Enabled: true}
+	if idx := slices.IndexFunc(latestFiles(dcl), func(f client.ConfigFile) bool { return f.Name == wireguardFile }); idx >= 0 {
+		wsc.Unmarshall(latestFiles(dcl)[idx].Value)
+	}
+	if wg.Enabled != nil {
+		wsc.Enabled = *wg.Enabled
+	}
+	if wg.Endpoint != nil {
+		wsc.Endpoint = *wg.Endpoint
+	}
+	if wg.Address != nil {
+		wsc.VpnAddress = *wg.Address
+	}
+	if wg.PublicKey != nil {
+		wsc.PublicKey = *wg.PublicKey
+	}
+	return client.ConfigFile{
+		Name:        wireguardFile,
+		Value:       wsc.Marshall(),
+		Unencrypted: true,
+		OnChanged:   []string{wireguardHandler},
+	}
+}
+
+func (p *planner) planEventQueues() {
+	s := &section{title: "Event queues"}
+	live, err := api.EventQueuesList(p.factory)
+	subcommands.DieNotNil(err)
+	for _, q := range p.manifest.EventQueues {
+		queue := client.EventQueue{Label: q.Label, Type: q.Type, PushUrl: q.Url}
+		create := action{"Creating event queue " + q.Label, func() error {
+			return p.createEventQueue(queue, q.CredsFile)
+		}}
+		idx := slices.IndexFunc(live, func(lq client.EventQueue) bool { return lq.Label == q.Label })
+		if idx < 0 {
+			s.changes = append(s.changes, change{op: opAdd, name: q.Label, details: []string{queueDetails(queue, q.CredsFile)}})
+			s.actions = append(s.actions, create)
+		} else if lq := live[idx]; lq.Type != queue.Type || lq.PushUrl != queue.PushUrl {
+			// Queues cannot be changed, so they are replaced
+			s.changes = append(s.changes, change{op: opChange, name: q.Label, details: []string{
+				"replaced: " + queueDetails(lq, "") + " -> " + queueDetails(queue, q.CredsFile),
+			}})
+			s.actions = append(s.actions, action{"Deleting event queue " + q.Label, func() error {
+				return api.EventQueuesDelete(p.factory, q.Label)
+			}}, create)
+		}
+	}
+	for _, lq := range live {
+		if slices.ContainsFunc(p.manifest.EventQueues, func(q EventQueue) bool { return q.Label == lq.Label }) {
+			continue
+		}
+		if !p.prune {
+			s.kept = append(s.kept, lq.Label)
+			continue
+		}
+		s.changes = append(s.changes, change{op: opRemove, name: lq.Label})
+		s.actions = append(s.actions, action{"Deleting event queue " + lq.Label, func() error {
+			return api.EventQueuesDelete(p.factory, lq.Label)
+		}})
+	}
+	p.plan.sections = append(p.plan.sections, s)
+}
+
+func queueDetails(q client.EventQueue, credsFile string) string {
+	if q.Type == "push" {
+		return "push to " + q.PushUrl
+	}
+	if len(credsFile) > 0 {
+		return "pull, credentials saved to " + credsFile
+	}
+	return q.Type
+}
+
+// createEventQueue creates a queue. The credentials of a pull queue are only returned when it
+// is created, so they are saved into a file.
+func (p *planner) createEventQueue(queue client.EventQueue, credsFile string) error {
+	creds, err := api.EventQueuesCreate(p.factory, queue)
+	if err != nil || queue.Type != "pull" {
+		return err
+	}
+	return writeCredsFile(p.manifest.resolve(credsFile), creds)
+}
+
+func (p *planner) planSecrets() {
+	s := &section{title: "Secrets"}
+	triggers, err := api.FactoryTriggers(p.factory)
+	subcommands.DieNotNil(err)
+	trigger := client.ProjectTrigger{Type: "simple"}
+	if len(triggers) == 1 {
+		trigger = triggers[0]
+	} else if len(triggers) > 1 {
+		subcommands.DieNotNil(fmt.Errorf("Factory configuration issue. Factory has unexpected number of triggers."))
+	}
+
+	var secrets []client.ProjectSecret
+	for _, secret := range p.manifest.Secrets {
+		if slices.ContainsFunc(trigger.Secrets, func(ls client.ProjectSecret) bool { return ls.Name == secret.Name }) {
+			// Values cannot be read back, so existing secrets are left as they are
+			continue
+		}
+		value, err := p.manifest.secretValue(secret)
+		if err != nil {
+			p.problem("%s", err)
+			continue
+		}
+		source := "from $" + secret.Env
+		if len(secret.File) > 0 {
+			source = "from " + secret.File
+		}
+		s.changes = append(s.changes, change{op: opAdd, name: secret.Name, details: []string{source}})
+		secrets = append(secrets, client.ProjectSecret{Name: secret.Name, Value: &value})
+	}
+	for _, ls := range trigger.Secrets {
+		if slices.ContainsFunc(p.manifest.Secrets, func(s Secret) bool { return s.Name == ls.Name }) {
+			continue
+		}
+		if !p.prune {
+			s.kept = append(s.kept, ls.Name)
+			continue
+		}
+		s.changes = append(s.changes, change{op: opRemove, name: ls.Name})
+		secrets = append(secrets, client.ProjectSecret{Name: ls.Name})
+	}
+	if len(secrets) > 0 {
+		trigger.Secrets = secrets
+		s.actions = append(s.actions, action{"Updating secrets", func() error {
+			return api.FactoryUpdateTrigger(p.factory, trigger)
+		}})
+	}
+	p.plan.sections = append(p.plan.sections, s)
+}
+
+func latestFiles(dcl *client.DeviceConfigList) []client.ConfigFile {
+	if len(dcl.Configs) == 0 {
+		return nil
+	}
+	return dcl.Configs[0].Files
+}
+
+func lines(value string) []string {
+	if len(value) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(value, "\n"), "\n")
+}
+
+// counts returns the number of resources to add, change, and remove, and those kept.
+func (p *plan) counts() (add, chg, remove, kept int) {
+	for _, s := range p.sections {
+		for _, c := range s.changes {
+			switch c.op {
+			case opAdd:
+				add += 1
+			case opChange:
+				chg += 1
+			case opRemove:
+				remove += 1
+			}
+		}
+		kept += len(s.kept)
+	}
+	return
+}
+
+func (p *plan) print() {
+	for _, s := range p.sections {
+		if len(s.changes) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", s.title)
+		for _, c := range s.changes {
+			line := fmt.Sprintf("  %s %s", c.op, c.name)
+			if len(c.details) > 0 {
+				line += " - " + strings.Join(c.details, ", ")
+			}
+			switch c.op {
+			case opAdd:
+				color.Green(line)
+			case opRemove:
+				color.Red(line)
+			default:
+				color.Yellow(line)
+			}
+			subcommands.PrintDiffHunks(subcommands.DiffHunks(c.diff, 3), "      ")
+		}
+		fmt.Println()
+	}
+
+	add, chg, remove, kept := p.counts()
+	if add+chg+remove == 0 {
+		fmt.Println("No changes, the Factory matches the manifest.")
+	} else {
+		fmt.Printf("Plan: %d to add, %d to change, %d to remove.\n", add, chg, remove)
+	}
+	if kept > 0 {
+		var names []string
+		for _, s := range p.sections {
+			for _, name := range s.kept {
+				names = append(names, strings.ToLower(s.title[:1])+s.title[1:]+" "+name)
+			}
+		}
+		fmt.Printf("Not in the manifest, and kept without --prune: %s\n", strings.Join(names, ", "))
+	}
+	if len(p.problems) > 0 {
+		fmt.Println()
+		for _, err := range p.problems {
+			fmt.Println(err)
+		}
+		subcommands.DieNotNil(fmt.Errorf("Found %d problems in the manifest", len(p.problems)))
+	}
+}
@@ -0,0 +1,314 @@
+package subcommands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// List and show commands print tables for humans by default. The -o flag makes them print the
+// underlying API data instead, so that scripts do not need to parse tables:
+//
+//	-o json                      the API data as JSON
+//	-o yaml                      the same data as YAML
+//	-o csv                       a row per list item, a column per field
+//	-o go-template=<template>    a Go template applied to the data
+//	-o go-template-file=<path>   a Go template read from a file
+//
+// Templates see the data with the field names of the JSON output, e.g.
+// '{{range .}}{{.uuid}}{{"\n"}}{{end}}'. The get function selects a field by a path in which
+// dashed names and list indexes are allowed, e.g. '{{get . "apps-state.apps.0.name"}}'.
+const outputFlag = "output"
+
+// AddOutputFlag adds the -o flag to a list or show command.
+func AddOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().VarP(&outputValue{}, outputFlag, "o",
+		"Output format: json, yaml, csv, go-template=<template>, or go-template-file=<path>. Default is a table")
+}
+
+// outputValue validates the format while the flag is parsed, before a command makes any API calls.
+type outputValue struct {
+	format string
+	writer outputWriter
+}
+
+func (v *outputValue) String() string {
+	return v.format
+}
+
+func (v *outputValue) Set(format string) error {
+	writer, err := newOutputWriter(format)
+	if err != nil {
+		return err
+	}
+	v.format = format
+	v.writer = writer
+	return nil
+}
+
+func (v *outputValue) Type() string {
+	return "format"
+}
+
+func selectedOutput(cmd *cobra.Command) *outputValue {
+	if flag := cmd.Flags().Lookup(outputFlag); flag != nil {
+		if v, ok := flag.Value.(*outputValue); ok && v.writer != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// PrintOutput prints data in the format selected by the -o flag. It returns false if no format
+// was selected, in which case the command should print its human-readable output.
+func PrintOutput(cmd *cobra.Command, data interface{}) bool {
+	output := selectedOutput(cmd)
+	if output == nil {
+		return false
+	}
+	// An empty list is printed as [] rather than null
+	if v := reflect.ValueOf(data); v.Kind() == reflect.Slice && v.IsNil() {
+		data = []interface{}{}
+	}
+	DieNotNil(output.writer(os.Stdout, data))
+	return true
+}
+
+// OutputSelected tells if the -o flag selects a machine-readable output. Commands which need
+// extra API calls only for their human-readable output may check it to skip them.
+func OutputSelected(cmd *cobra.Command) bool {
+	return selectedOutput(cmd) != nil
+}
+
+// WriteOutput writes data in one of the -o formats.
+func WriteOutput(w io.Writer, format string, data interface{}) error {
+	writer, err := newOutputWriter(format)
+	if err != nil {
+		return err
+	}
+	return writer(w, data)
+}
+
+type outputWriter func(w io.Writer, data interface{}) error
+
+func newOutputWriter(format string) (outputWriter, error) {
+	kind, arg, _ := strings.Cut(format, "=")
+	switch kind {
+	case "json":
+		return writeJson, nil
+	case "yaml":
+		return writeYaml, nil
+	case "csv":
+		return writeCsv, nil
+	case "go-template", "go-template-file":
+		if len(arg) == 0 {
+			return nil, fmt.Errorf("A template is required, e.g. -o %s=...", kind)
+		}
+		if kind == "go-template-file" {
+			buf, err := os.ReadFile(arg)
+			if err != nil {
+				return nil, fmt.Errorf("Unable to read template: %w", err)
+			}
+			arg = string(buf)
+		}
+		tmpl, err := template.New("output").Funcs(templateFuncs).Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid template: %w", err)
+		}
+		return func(w io.Writer, data interface{}) error {
+			generic, err := toGeneric(data)
+			if err != nil {
+				return err
+			}
+			return tmpl.Execute(w, generic)
+		}, nil
+	}
+	return nil, fmt.Errorf("Invalid output format %q: must be json, yaml, csv, go-template=<template>, or go-template-file=<path>", format)
+}
+
+func writeJson(w io.Writer, data interface{}) error {
+	buf, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(buf))
+	return err
+}
+
+func writeYaml(w io.Writer, data interface{}) error {
+	// The YAML output has the same field names as the JSON one, so it is converted from it
+	generic, err := toGeneric(data)
+	if err != nil {
+		return err
+	}
+	buf, err := yaml.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+// writeCsv writes a row per item of a list, or a single row for other data. The columns are the
+// fields of the items in the order of the JSON output. Nested values are written as JSON.
+func writeCsv(w io.Writer, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(buf, &items); err != nil {
+		items = []json.RawMessage{buf}
+	}
+
+	var columns []string
+	seen := make(map[string]bool)
+	rows := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		keys, row, err := csvRow(item)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				columns = append(columns, key)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+	out := csv.NewWriter(w)
+	if err := out.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, col := range columns {
+			record[i] = row[col]
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// csvRow returns the fields of a JSON object in their order. A scalar or a list is a row with
+// a single "value" column.
+func csvRow(item json.RawMessage) ([]string, map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(item))
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil {
+		return nil, nil, err
+	} else if tok != json.Delim('{') {
+		return []string{"value"}, map[string]string{"value": csvCell(item)}, nil
+	}
+	var keys []string
+	row := make(map[string]string)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := tok.(string)
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		row[key] = csvCell(val)
+	}
+	return keys, row, nil
+}
+
+func csvCell(val json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(val, &s); err == nil {
+		return s
+	}
+	if string(val) == "null" {
+		return ""
+	}
+	return string(val)
+}
+
+// toGeneric converts data to the maps and lists of its JSON representation.
+// Numbers are kept as integers when possible, so that e.g. IDs are not printed as floats.
+func toGeneric(data interface{}) (interface{}, error) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return fromJsonNumbers(generic), nil
+}
+
+func fromJsonNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = fromJsonNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = fromJsonNumbers(item)
+		}
+	}
+	return val
+}
+
+var templateFuncs = template.FuncMap{
+	"get": getPath,
+	"json": func(val interface{}) (string, error) {
+		buf, err := json.Marshal(val)
+		return string(buf), err
+	},
+}
+
+// getPath selects a value by a dot separated path of field names and list indexes.
+func getPath(val interface{}, path string) (interface{}, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+	for _, part := range strings.Split(path, ".") {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			val = v[part]
+		case []interface{}:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil, fmt.Errorf("Invalid list index %q in %q", part, path)
+			}
+			val = v[idx]
+		default:
+			return nil, nil
+		}
+	}
+	return val, nil
+}
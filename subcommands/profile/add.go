@@ -0,0 +1,72 @@
+package profile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	addCmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Add a profile",
+		Run:   doAdd,
+		Args:  cobra.ExactArgs(1),
+	}
+	cmd.AddCommand(addCmd)
+	addCmd.Flags().String("server-url", "", "REST API server URL (default is https://api.foundries.io)")
+	addCmd.Flags().String("factory", "", "Default factory for this profile")
+	addCmd.Flags().String("cacert", "", "Path to a CA bundle used to verify the server's TLS certificate")
+	addCmd.Flags().String("token", "", "API token to use instead of OAuth credentials from \"fioctl login\"")
+	addCmd.Flags().StringToString("header", nil, "Extra HTTP header to send with each request, e.g. --header X-Env=staging")
+	addCmd.Flags().Bool("use", false, "Make this the current profile")
+}
+
+func doAdd(cmd *cobra.Command, args []string) {
+	name := args[0]
+	serverUrl, _ := cmd.Flags().GetString("server-url")
+	factory, _ := cmd.Flags().GetString("factory")
+	cacert, _ := cmd.Flags().GetString("cacert")
+	token, _ := cmd.Flags().GetString("token")
+	headers, _ := cmd.Flags().GetStringToString("header")
+	use, _ := cmd.Flags().GetBool("use")
+
+	if name == subcommands.DefaultProfile || strings.ContainsAny(name, ". \t") {
+		subcommands.DieNotNil(fmt.Errorf("Invalid profile name: %s", name))
+	}
+	file, err := subcommands.ReadConfigFile()
+	subcommands.DieNotNil(err)
+	if _, ok := file.Profile(name); ok {
+		subcommands.DieNotNil(fmt.Errorf("Profile %s already exists", name))
+	}
+
+	section := make(map[string]interface{})
+	server := make(map[string]interface{})
+	if len(serverUrl) > 0 {
+		server["url"] = strings.TrimRight(serverUrl, "/")
+	}
+	if len(cacert) > 0 {
+		server["cacert"] = cacert
+	}
+	if len(server) > 0 {
+		section["server"] = server
+	}
+	if len(factory) > 0 {
+		section["factory"] = factory
+	}
+	if len(token) > 0 {
+		section["token"] = token
+	}
+	if len(headers) > 0 {
+		section["extraheaders"] = headers
+	}
+	file.SetProfile(name, section)
+	if use {
+		file.SetCurrentProfile(name)
+	}
+	subcommands.DieNotNil(file.Write())
+	fmt.Printf("Profile %s added to %s\n", name, file.Path)
+}
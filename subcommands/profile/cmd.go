@@ -0,0 +1,31 @@
+package profile
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var cmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage named profiles of servers, factories, and credentials",
+	Long: `Profiles allow to switch between several Foundries.io servers, factories, and
+credentials kept in one config file. Each profile has its own server URL, CA
+bundle, OAuth credentials, extra HTTP headers, and default factory.
+
+The settings at the top level of the config file form the "default" profile.
+A profile is selected by the --profile flag, the FIOCTL_PROFILE environment
+variable, or by "fioctl profile use" in that order.`,
+	Example: `
+  # Add a profile for a staging server, then log in with its credentials:
+  fioctl profile add staging --server-url https://api.staging.example.com --factory acme-staging
+  fioctl login --profile staging
+
+  # Run a single command against the staging server:
+  fioctl --profile staging devices list
+
+  # Make it the profile used by all commands:
+  fioctl profile use staging`,
+}
+
+func NewCommand() *cobra.Command {
+	return cmd
+}
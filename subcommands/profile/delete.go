@@ -0,0 +1,36 @@
+package profile
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	cmd.AddCommand(&cobra.Command{
+		Use:     "delete <name>",
+		Aliases: []string{"rm"},
+		Short:   "Delete a profile along with its credentials",
+		Run:     doDelete,
+		Args:    cobra.ExactArgs(1),
+	})
+}
+
+func doDelete(cmd *cobra.Command, args []string) {
+	name := args[0]
+	if name == subcommands.DefaultProfile {
+		subcommands.DieNotNil(fmt.Errorf("The default profile cannot be deleted"))
+	}
+	file, err := subcommands.ReadConfigFile()
+	subcommands.DieNotNil(err)
+	if _, ok := file.Profile(name); !ok {
+		subcommands.DieNotNil(fmt.Errorf("Profile %s not found in %s", name, file.Path))
+	}
+	if file.CurrentProfile() == name {
+		fmt.Printf("Profile %s was the current one, switching to the default profile\n", name)
+	}
+	file.DeleteProfile(name)
+	subcommands.DieNotNil(file.Write())
+}
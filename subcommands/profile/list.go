@@ -0,0 +1,63 @@
+package profile
+
+import (
+	"fmt"
+
+	"github.com/cheynewallace/tabby"
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	cmd.AddCommand(&cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List profiles",
+		Run:     doList,
+		Args:    cobra.NoArgs,
+	})
+}
+
+func doList(cmd *cobra.Command, args []string) {
+	file, err := subcommands.ReadConfigFile()
+	subcommands.DieNotNil(err)
+
+	t := tabby.New()
+	t.AddHeader("CURRENT", "NAME", "FACTORY", "SERVER", "CREDENTIALS")
+	for _, name := range file.ProfileNames() {
+		section, _ := file.Profile(name)
+		current := ""
+		if name == subcommands.ActiveProfile {
+			current = "*"
+		}
+		server := "https://api.foundries.io"
+		if s, ok := section["server"].(map[interface{}]interface{}); ok {
+			if url, ok := s["url"].(string); ok && len(url) > 0 {
+				server = url
+			}
+		}
+		t.AddLine(current, name, valueOf(section["factory"]), server, credentials(section))
+	}
+	t.Print()
+}
+
+func valueOf(val interface{}) string {
+	if val == nil {
+		return ""
+	}
+	return fmt.Sprint(val)
+}
+
+// credentials describes what kind of credentials a profile has, without revealing them.
+func credentials(section map[string]interface{}) string {
+	if len(valueOf(section["token"])) > 0 {
+		return "token"
+	}
+	if creds, ok := section["clientcredentials"].(map[interface{}]interface{}); ok {
+		if len(valueOf(creds["client_id"])) > 0 {
+			return "oauth"
+		}
+	}
+	return ""
+}
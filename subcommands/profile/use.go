@@ -0,0 +1,30 @@
+package profile
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/foundriesio/fioctl/subcommands"
+)
+
+func init() {
+	cmd.AddCommand(&cobra.Command{
+		Use:   "use <name>",
+		Short: "Set the profile used by default",
+		Run:   doUse,
+		Args:  cobra.ExactArgs(1),
+	})
+}
+
+func doUse(cmd *cobra.Command, args []string) {
+	name := args[0]
+	file, err := subcommands.ReadConfigFile()
+	subcommands.DieNotNil(err)
+	if _, ok := file.Profile(name); !ok {
+		subcommands.DieNotNil(fmt.Errorf("Profile %s not found in %s", name, file.Path))
+	}
+	file.SetCurrentProfile(name)
+	subcommands.DieNotNil(file.Write())
+	fmt.Printf("Switched to profile %s\n", name)
+}
@@ -0,0 +1,201 @@
+package subcommands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Profiles keep settings for several servers, factories, and credentials in one config file.
+// The top level settings form the default profile, while named profiles are kept under the
+// "profiles" key. Each profile has the same layout as the top level, e.g.:
+//
+//	factory: acme
+//	current_profile: staging
+//	profiles:
+//	  staging:
+//	    factory: acme-staging
+//	    server:
+//	      url: https://api.staging.example.com
+//	      cacert: /etc/ssl/staging-ca.pem
+//	    extraheaders:
+//	      X-Env: staging
+const DefaultProfile = "default"
+
+// ActiveProfile is the name of the profile the config was loaded from.
+var ActiveProfile = DefaultProfile
+
+// ActivateProfile makes the settings of a named profile the only config settings seen by viper.
+// The settings of other profiles, including the default one, are not inherited: a profile for
+// another server must not pick up credentials from the top level.
+func ActivateProfile(name string) error {
+	if len(name) == 0 || name == DefaultProfile {
+		ActiveProfile = DefaultProfile
+		return nil
+	}
+	key := "profiles." + name
+	if !viper.IsSet(key) {
+		return fmt.Errorf("Profile %s not found in %s", name, viper.ConfigFileUsed())
+	}
+	buf, err := yaml.Marshal(viper.GetStringMap(key))
+	if err != nil {
+		return fmt.Errorf("Unable to load profile %s: %w", name, err)
+	}
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(bytes.NewReader(buf)); err != nil {
+		return fmt.Errorf("Unable to load profile %s: %w", name, err)
+	}
+	logrus.Debugf("Using profile %s", name)
+	ActiveProfile = name
+	return nil
+}
+
+// ConfigFile is the raw content of a fioctl config file. Unlike viper, it preserves all profiles
+// and only has settings which a user actually saved.
+type ConfigFile struct {
+	Path string
+	Data map[string]interface{}
+}
+
+// ReadConfigFile reads the config file in use, or an empty config if it does not exist yet.
+func ReadConfigFile() (*ConfigFile, error) {
+	name, err := configFilePath()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ConfigFile{Path: name, Data: make(map[string]interface{})}
+	buf, err := os.ReadFile(name)
+	if err == nil {
+		if err := yaml.Unmarshal(buf, &cfg.Data); err != nil {
+			return nil, fmt.Errorf("Unable unmarshal configuration: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func configFilePath() (string, error) {
+	name := viper.ConfigFileUsed()
+	if len(name) == 0 {
+		logrus.Debug("Guessing config file from path")
+		path, err := homedir.Expand("~/.config")
+		if err != nil {
+			return "", err
+		}
+		name = filepath.Join(path, "fioctl.yaml")
+	}
+	return name, nil
+}
+
+// ConfigDir returns the directory of the fioctl config file, where other local state is kept.
+func ConfigDir() (string, error) {
+	name, err := configFilePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(name), nil
+}
+
+func (c *ConfigFile) Write() error {
+	buf, err := yaml.Marshal(c.Data)
+	if err != nil {
+		return fmt.Errorf("Unable to marshall config: %w", err)
+	}
+	if err := os.WriteFile(c.Path, buf, os.FileMode(0600)); err != nil {
+		return fmt.Errorf("Unable to update config: %w", err)
+	}
+	// The config may have secrets of the plain credential store, so fix older world-readable files
+	if err := os.Chmod(c.Path, os.FileMode(0600)); err != nil {
+		return fmt.Errorf("Unable to update config permissions: %w", err)
+	}
+	return nil
+}
+
+// CurrentProfile returns the profile selected by "fioctl profile use".
+func (c *ConfigFile) CurrentProfile() string {
+	if name, ok := c.Data["current_profile"].(string); ok && len(name) > 0 {
+		return name
+	}
+	return DefaultProfile
+}
+
+func (c *ConfigFile) SetCurrentProfile(name string) {
+	if name == DefaultProfile {
+		delete(c.Data, "current_profile")
+	} else {
+		c.Data["current_profile"] = name
+	}
+}
+
+// ProfileNames returns the names of all profiles, the default one being first.
+func (c *ConfigFile) ProfileNames() []string {
+	var names []string
+	for name := range c.profiles() {
+		names = append(names, fmt.Sprint(name))
+	}
+	sort.Strings(names)
+	return append([]string{DefaultProfile}, names...)
+}
+
+// Profile returns the settings of a profile. For the default profile those are the top level
+// settings, except for the profiles themselves.
+func (c *ConfigFile) Profile(name string) (map[string]interface{}, bool) {
+	section := make(map[string]interface{})
+	if name == DefaultProfile {
+		for k, v := range c.Data {
+			if k != "profiles" && k != "current_profile" {
+				section[k] = v
+			}
+		}
+		return section, true
+	}
+	val, ok := c.profiles()[name]
+	if !ok {
+		return nil, false
+	}
+	if m, ok := val.(map[interface{}]interface{}); ok {
+		for k, v := range m {
+			section[fmt.Sprint(k)] = v
+		}
+	}
+	return section, true
+}
+
+func (c *ConfigFile) SetProfile(name string, section map[string]interface{}) {
+	if name == DefaultProfile {
+		for k, v := range section {
+			c.Data[k] = v
+		}
+		return
+	}
+	profiles := c.profiles()
+	if profiles == nil {
+		profiles = make(map[interface{}]interface{})
+		c.Data["profiles"] = profiles
+	}
+	profiles[name] = section
+}
+
+func (c *ConfigFile) DeleteProfile(name string) {
+	profiles := c.profiles()
+	delete(profiles, name)
+	if len(profiles) == 0 {
+		delete(c.Data, "profiles")
+	}
+	if c.CurrentProfile() == name {
+		c.SetCurrentProfile(DefaultProfile)
+	}
+}
+
+func (c *ConfigFile) profiles() map[interface{}]interface{} {
+	profiles, _ := c.Data["profiles"].(map[interface{}]interface{})
+	return profiles
+}
@@ -9,15 +9,18 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/foundriesio/fioctl/client"
 	"github.com/foundriesio/fioctl/subcommands"
 )
 
 func init() {
-	cmd.AddCommand(&cobra.Command{
+	listCmd := &cobra.Command{
 		Use:   "list",
 		Short: "List secret credentials configured in the Factory",
 		Run:   doList,
-	})
+	}
+	cmd.AddCommand(listCmd)
+	subcommands.AddOutputFlag(listCmd)
 }
 
 func doList(cmd *cobra.Command, args []string) {
@@ -27,15 +30,21 @@ func doList(cmd *cobra.Command, args []string) {
 	triggers, err := api.FactoryTriggers(factory)
 	subcommands.DieNotNil(err)
 
-	t := tabby.New()
-	t.AddHeader("SECRETS")
+	var secrets []client.ProjectSecret
 	if len(triggers) == 1 {
-		for _, secret := range triggers[0].Secrets {
-			t.AddLine(secret.Name)
-		}
+		secrets = triggers[0].Secrets
 	} else if len(triggers) != 0 {
 		fmt.Println("ERROR: Factory configuration issue. Factory has unexpected number of triggers.")
 		os.Exit(1)
 	}
+	if subcommands.PrintOutput(cmd, secrets) {
+		return
+	}
+
+	t := tabby.New()
+	t.AddHeader("SECRETS")
+	for _, secret := range secrets {
+		t.AddLine(secret.Name)
+	}
 	t.Print()
 }
@@ -26,6 +26,7 @@ func NewCommand() *cobra.Command {
 	}
 	subcommands.RequireFactory(cmd)
 	cmd.Flags().IntVarP(&inactiveThreshold, "offline-threshold", "", 4, "Consider device 'OFFLINE' if not seen in the last X hours")
+	subcommands.AddOutputFlag(cmd)
 	return cmd
 }
 
@@ -35,6 +36,9 @@ func showStatus(cmd *cobra.Command, args []string) {
 
 	status, err := api.FactoryStatus(factory, inactiveThreshold)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, status) {
+		return
+	}
 
 	fmt.Println("Total number of devices:", status.TotalDevices)
 
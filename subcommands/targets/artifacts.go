@@ -1,6 +1,7 @@
 package targets
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -78,7 +79,7 @@ func (d *DlStatus) Write(p []byte) (int, error) {
 	return n, nil
 }
 
-func downloadArtifact(factory string, target int, artifact string) {
+func downloadArtifact(ctx context.Context, factory string, target int, artifact string) {
 	firstSlash := strings.Index(artifact, "/")
 	if firstSlash < 1 {
 		fmt.Println("ERROR: Invalid artifact path:", artifact)
@@ -88,11 +89,11 @@ func downloadArtifact(factory string, target int, artifact string) {
 	artifact = artifact[firstSlash+1:]
 
 	if strings.HasSuffix(artifact, "console.log") {
-		api.JobservTailRun(factory, target, run, artifact)
+		subcommands.DieNotNil(api.JobservTailRunCtx(ctx, factory, target, run, artifact))
 		return
 	}
 
-	resp, err := api.JobservRunArtifact(factory, target, run, artifact)
+	resp, err := api.JobservRunArtifactCtx(ctx, factory, target, run, artifact)
 	subcommands.DieNotNil(err)
 
 	status := DlStatus{resp.ContentLength, 0, 20, time.Now()}
@@ -114,7 +115,7 @@ func doArtifacts(cmd *cobra.Command, args []string) {
 
 	if len(args) == 0 {
 		logrus.Debugf("Showing all testing done for Factory: %s", factory)
-		listAll(factory)
+		listAll(cmd, factory)
 		os.Exit(0)
 	}
 
@@ -128,6 +129,6 @@ func doArtifacts(cmd *cobra.Command, args []string) {
 	} else {
 		artifact := args[1]
 		logrus.Debugf("Downloading artifact %s %d %s", factory, target, artifact)
-		downloadArtifact(factory, target, artifact)
+		downloadArtifact(cmd.Context(), factory, target, artifact)
 	}
 }
@@ -116,6 +116,6 @@ func doDeltas(cmd *cobra.Command, args []string) {
 	subcommands.DieNotNil(err)
 	fmt.Printf("CI URL: %s\n", webUrl)
 	if !noTail {
-		api.JobservTail(jobServUrl)
+		subcommands.DieNotNil(api.JobservTailCtx(cmd.Context(), jobServUrl))
 	}
 }
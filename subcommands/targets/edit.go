@@ -101,6 +101,6 @@ func doEdit(cmd *cobra.Command, args []string) {
 	subcommands.DieNotNil(err)
 	fmt.Printf("CI URL: %s\n", webUrl)
 	if !editNoTail {
-		api.JobservTail(jobservUrl)
+		subcommands.DieNotNil(api.JobservTailCtx(cmd.Context(), jobservUrl))
 	}
 }
@@ -57,6 +57,6 @@ func doImage(cmd *cobra.Command, args []string) {
 	subcommands.DieNotNil(err)
 	fmt.Printf("CI URL: %s\n", webUrl)
 	if !noTail {
-		api.JobservTail(jobServUrl)
+		subcommands.DieNotNil(api.JobservTailCtx(cmd.Context(), jobServUrl))
 	}
 }
@@ -1,6 +1,7 @@
 package targets
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -15,6 +16,7 @@ import (
 	"github.com/spf13/viper"
 	"github.com/theupdateframework/notary/tuf/data"
 
+	"github.com/foundriesio/fioctl/client"
 	"github.com/foundriesio/fioctl/subcommands"
 )
 
@@ -37,6 +39,21 @@ type targetListing struct {
 	containerSha string
 }
 
+// targetOutput is a Target as printed by the -o flag of the list and show commands
+type targetOutput struct {
+	Name       string `json:"name"`
+	OstreeHash string `json:"ostree-hash"`
+	client.TufCustom
+}
+
+func newTargetOutput(name string, target data.FileMeta, custom client.TufCustom) targetOutput {
+	return targetOutput{
+		Name:       name,
+		OstreeHash: base64.StdEncoding.EncodeToString(target.Hashes["sha256"]),
+		TufCustom:  custom,
+	}
+}
+
 type byTargetKey []string
 
 func (t byTargetKey) Len() int {
@@ -89,6 +106,8 @@ func init() {
 	listCmd.Flags().BoolVarP(&listProd, "production", "", false, "Show the production version targets.json")
 	listCmd.Flags().StringVarP(&listByTag, "by-tag", "", "", "Only list Targets that match the given tag")
 	listCmd.Flags().StringSliceVarP(&showColumns, "columns", "", defCols, "Specify which columns to display")
+	subcommands.AddOutputFlag(listCmd)
+	listCmd.MarkFlagsMutuallyExclusive("raw", "output")
 }
 
 func doList(cmd *cobra.Command, args []string) {
@@ -121,13 +140,14 @@ func doList(cmd *cobra.Command, args []string) {
 		targets = meta.Signed.Targets
 	} else {
 		var err error
-		targets, err = api.TargetsList(factory)
+		targets, err = api.TargetsListCtx(cmd.Context(), factory)
 		subcommands.DieNotNil(err)
 	}
 
 	var keys []string
+	var outputs []targetOutput
 	listing := make(map[string]*targetListing)
-	for _, target := range targets {
+	for name, target := range targets {
 		custom, err := api.TargetCustom(target)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
@@ -154,6 +174,7 @@ func doList(cmd *cobra.Command, args []string) {
 		if err != nil {
 			panic(fmt.Sprintf("Invalid version: %v. Error: %s", target, err))
 		}
+		outputs = append(outputs, newTargetOutput(name, target, *custom))
 		key := fmt.Sprintf("%d-%s", ver, strings.Join(custom.Tags, ","))
 		build, ok := listing[key]
 		if ok {
@@ -187,6 +208,18 @@ func doList(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	sort.Slice(outputs, func(i, j int) bool {
+		verI, _ := strconv.Atoi(outputs[i].Version)
+		verJ, _ := strconv.Atoi(outputs[j].Version)
+		if verI == verJ {
+			return outputs[i].Name < outputs[j].Name
+		}
+		return verI < verJ
+	})
+	if subcommands.PrintOutput(cmd, outputs) {
+		return
+	}
+
 	t := tabby.New()
 	var cols = make([]interface{}, len(showColumns))
 	for idx, c := range showColumns {
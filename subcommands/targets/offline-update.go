@@ -3,6 +3,7 @@ package targets
 import (
 	"archive/tar"
 	"compress/bzip2"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -17,6 +18,7 @@ import (
 	"time"
 
 	canonical "github.com/docker/go/canonical/json"
+	"github.com/sirupsen/logrus"
 	tuf "github.com/theupdateframework/notary/tuf/data"
 
 	"github.com/foundriesio/fioctl/client"
@@ -180,7 +182,7 @@ Notice that multiple Targets in the same directory is only supported in LmP >= v
 			subcommands.DieNotNil(copyOstree(ouOstreeRepoSrc, dstDir+"/ostree_repo/"), "Failed to copy local ostree repo:")
 		} else {
 			fmt.Printf("Downloading an ostree repo from the Target's OE build %d...\n", ti.ostreeVersion)
-			subcommands.DieNotNil(downloadOstree(factory, ti.ostreeVersion, ti.hardwareID, dstDir), "Failed to download Target's ostree repo:")
+			subcommands.DieNotNil(downloadOstree(cmd.Context(), factory, ti.ostreeVersion, ti.hardwareID, dstDir), "Failed to download Target's ostree repo:")
 		}
 
 		sha256String := base64.StdEncoding.EncodeToString(ti.sha256)
@@ -204,11 +206,11 @@ Notice that multiple Targets in the same directory is only supported in LmP >= v
 
 			if ti.fetchedApps == nil {
 				fmt.Printf("Downloading Apps fetched by the `assemble-system-image` run; build number: %d, tag: %s...\n", ti.version, ti.buildTag)
-				err = downloadApps(factory, targetName, ti.version, ti.buildTag, path.Join(dstDir, "apps"))
+				err = downloadApps(cmd.Context(), factory, targetName, ti.version, ti.buildTag, path.Join(dstDir, "apps"))
 			} else {
 				if len(ti.fetchedApps.Uri) > 0 {
 					fmt.Printf("Downloading Apps fetched by the `publish-compose-apps` run; apps: %s, uri: %s...\n", ti.fetchedApps.Shortlist, ti.fetchedApps.Uri)
-					err = downloadAppsArchive(ti.fetchedApps.Uri, path.Join(dstDir, "apps"))
+					err = downloadAppsArchive(cmd.Context(), ti.fetchedApps.Uri, path.Join(dstDir, "apps"))
 				} else {
 					fmt.Println("No apps found to fetch for an offline update to a given Target. " +
 						"The bundle will only update rootfs/ostree. Check your Factory configuration if this is not your intention.")
@@ -341,12 +343,15 @@ func downloadTufRepo(factory string, target string, tag string, prod bool, wave
 		if err != nil {
 			return err
 		}
-		f, err := os.Create(path.Join(dstDir, metadataFileName))
+		dst := path.Join(dstDir, metadataFileName)
+		f, err := os.Create(dst)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
-		_, err = f.Write(*data)
+		if _, err = f.Write(*data); err != nil {
+			_ = os.Remove(dst)
+		}
 		return err
 	}
 
@@ -451,12 +456,12 @@ func copyOstree(srcDir string, dstDir string) error {
 	return copyRecursive(srcDir, dstDir)
 }
 
-func downloadOstree(factory string, targetVer int, hardwareID string, dstDir string) error {
+func downloadOstree(ctx context.Context, factory string, targetVer int, hardwareID string, dstDir string) error {
 	runName := hardwareID
 	artifactName := hardwareID + "-ostree_repo.tar.bz2"
 	artifactPath := path.Join("other", artifactName)
 
-	return downloadItem(factory, targetVer, runName, artifactPath, func(r io.Reader) error {
+	return downloadItem(ctx, factory, targetVer, runName, artifactPath, func(r io.Reader) error {
 		bzr := bzip2.NewReader(r)
 		if bzr == nil {
 			return fmt.Errorf("failed to create bzip2 reader")
@@ -465,24 +470,24 @@ func downloadOstree(factory string, targetVer int, hardwareID string, dstDir str
 	})
 }
 
-func downloadApps(factory string, targetName string, targetVer int, tag string, dstDir string) error {
+func downloadApps(ctx context.Context, factory string, targetName string, targetVer int, tag string, dstDir string) error {
 	runName := "assemble-system-image"
 	artifactPath := path.Join(tag, targetName+"-apps.tar")
 
-	return downloadItem(factory, targetVer, runName, artifactPath, func(r io.Reader) error {
+	return downloadItem(ctx, factory, targetVer, runName, artifactPath, func(r io.Reader) error {
 		return untar(r, dstDir)
 	})
 }
 
-func downloadAppsArchive(uri string, dstDir string) error {
-	resp, err := api.RawGet(uri, nil)
+func downloadAppsArchive(ctx context.Context, uri string, dstDir string) error {
+	resp, err := api.RawGetCtx(ctx, uri, nil)
 	return processDownloadResponse(uri, resp, err, func(r io.Reader) error {
 		return untar(r, dstDir)
 	})
 }
 
-func downloadItem(factory string, targetVer int, runName string, artifactPath string, storeHandler func(r io.Reader) error) error {
-	resp, err := api.JobservRunArtifact(factory, targetVer, runName, artifactPath)
+func downloadItem(ctx context.Context, factory string, targetVer int, runName string, artifactPath string, storeHandler func(r io.Reader) error) error {
+	resp, err := api.JobservRunArtifactCtx(ctx, factory, targetVer, runName, artifactPath)
 	return processDownloadResponse(artifactPath, resp, err, storeHandler)
 }
 
@@ -508,13 +513,18 @@ func untar(r io.Reader, dstDir string) error {
 		case tar.TypeDir:
 			return os.MkdirAll(path.Join(dstDir, name), 0755)
 		default:
-			f, err := os.Create(path.Join(dstDir, name))
+			dst := path.Join(dstDir, name)
+			f, err := os.Create(dst)
 			if err != nil {
 				return err
 			}
 			defer f.Close()
 			w, err := io.Copy(f, tr)
 			if err != nil {
+				// Do not leave a truncated file behind, e.g. if a download was interrupted
+				if rmErr := os.Remove(dst); rmErr != nil {
+					logrus.Debugf("Unable to remove partial file %s: %s", dst, rmErr)
+				}
 				return err
 			}
 			if w != size {
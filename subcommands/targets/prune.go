@@ -149,6 +149,6 @@ func doPrune(cmd *cobra.Command, args []string) {
 	subcommands.DieNotNil(err)
 	fmt.Printf("CI URL: %s\n", webUrl)
 	if !pruneNoTail {
-		api.JobservTail(jobservUrl)
+		subcommands.DieNotNil(api.JobservTailCtx(cmd.Context(), jobservUrl))
 	}
 }
@@ -41,6 +41,8 @@ func init() {
 	cmd.AddCommand(showCmd)
 	showCmd.PersistentFlags().String("production-tag", "", "Look up Target from the production tag")
 	showCmd.PersistentFlags().BoolP("raw", "r", false, "Print raw target custom json")
+	subcommands.AddOutputFlag(showCmd)
+	showCmd.MarkFlagsMutuallyExclusive("raw", "output")
 
 	showAppCmd := &cobra.Command{
 		Use:   "compose-app <version> <app>",
@@ -50,6 +52,7 @@ func init() {
 	}
 	showCmd.AddCommand(showAppCmd)
 	showAppCmd.Flags().Bool("manifest", false, "Show an app docker manifest")
+	subcommands.AddOutputFlag(showAppCmd)
 
 	sbomCmd := &cobra.Command{
 		Use:   "sboms <version> [<build/run> [<artifact>]] ",
@@ -93,6 +96,8 @@ func init() {
 	showCmd.AddCommand(sbomCmd)
 	sbomCmd.Flags().String("format", "table", "The format to download/display. Must be one of "+allowed)
 	sbomCmd.Flags().String("download", "", "Download SBOM(s) to a directory")
+	subcommands.AddOutputFlag(sbomCmd)
+	sbomCmd.MarkFlagsMutuallyExclusive("download", "output")
 }
 
 func sortedAppsNames(target client.TufCustom) []string {
@@ -114,6 +119,14 @@ func doShow(cmd *cobra.Command, args []string) {
 
 	shownCiUrl := false
 	sortedTargetNames, hashes, targets := getTargets(factory, prodTag, version)
+	if subcommands.OutputSelected(cmd) {
+		outputs := make([]targetOutput, 0, len(sortedTargetNames))
+		for _, name := range sortedTargetNames {
+			outputs = append(outputs, targetOutput{Name: name, OstreeHash: hashes[name], TufCustom: targets[name]})
+		}
+		subcommands.PrintOutput(cmd, outputs)
+		return
+	}
 	for _, targetName := range sortedTargetNames {
 		target := targets[targetName]
 		hash := hashes[targetName]
@@ -179,6 +192,9 @@ func doShowComposeApp(cmd *cobra.Command, args []string) {
 		}
 		appInfo, err := api.TargetComposeApp(factory, name, appName)
 		subcommands.DieNotNil(err)
+		if subcommands.PrintOutput(cmd, appInfo) {
+			return
+		}
 
 		fmt.Println("Version:\n\t", appInfo.Uri)
 		if len(appInfo.Error) > 0 {
@@ -322,12 +338,22 @@ func doShowSboms(cmd *cobra.Command, args []string) {
 
 	if len(args) == 3 {
 		path := fmt.Sprintf("%s/%s", args[1], args[2])
-		displaySbom(factory, name, path, format)
+		displaySbom(cmd, factory, name, path, format)
 		return
 	}
 
 	sboms, err := api.TargetSboms(factory, name)
 	subcommands.DieNotNil(err)
+	if subcommands.OutputSelected(cmd) {
+		var matches []client.Sbom
+		for _, sbom := range sboms {
+			if strings.HasPrefix(sbom.CiBuild+"/"+sbom.CiRun, filter) {
+				matches = append(matches, sbom)
+			}
+		}
+		subcommands.PrintOutput(cmd, matches)
+		return
+	}
 	t := tabby.New()
 	t.AddHeader("BUILD/RUN", "BOM ARTIFACT")
 	for _, sbom := range sboms {
@@ -348,7 +374,7 @@ func getSbomTargetName(factory, prodTag, version string) string {
 	return "" // Make compiler happy
 }
 
-func displaySbom(factory, targetName, path, format string) {
+func displaySbom(cmd *cobra.Command, factory, targetName, path, format string) {
 	contentType := format
 	if format == "table" {
 		contentType = "application/spdx.json"
@@ -359,6 +385,9 @@ func displaySbom(factory, targetName, path, format string) {
 		// special handling for default
 		var doc client.SpdxDocument
 		subcommands.DieNotNil(json.Unmarshal(data, &doc))
+		if subcommands.PrintOutput(cmd, doc.Packages) {
+			return
+		}
 		t := tabby.New()
 		t.AddHeader("PACKAGE", "VERSION", "LICENSE")
 		for _, pkg := range doc.Packages {
@@ -124,6 +124,6 @@ func doTag(cmd *cobra.Command, args []string) {
 	subcommands.DieNotNil(err)
 	fmt.Printf("CI URL: %s\n", webUrl)
 	if !tagNoTail {
-		api.JobservTail(jobServUrl)
+		subcommands.DieNotNil(api.JobservTailCtx(cmd.Context(), jobServUrl))
 	}
 }
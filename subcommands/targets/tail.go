@@ -27,5 +27,5 @@ func doTail(cmd *cobra.Command, args []string) {
 	subcommands.DieNotNil(err)
 	run := args[1]
 
-	api.JobservTailRun(factory, build, run, "console.log")
+	subcommands.DieNotNil(api.JobservTailRunCtx(cmd.Context(), factory, build, run, "console.log"))
 }
@@ -16,7 +16,7 @@ import (
 )
 
 func init() {
-	cmd.AddCommand(&cobra.Command{
+	testsCmd := &cobra.Command{
 		Use:   "tests [<target> [<test-id> [<artifact name>]]]",
 		Short: "Show testing done against a Target",
 		Run:   doShowTests,
@@ -34,7 +34,10 @@ func init() {
   # Display a test artifact
   fioctl targets tests 12 <test-id> console.log
 `,
-	})
+	}
+	cmd.AddCommand(testsCmd)
+	testsCmd.Flags().Bool("all", false, "Display the tests from all pages. This is the default.")
+	subcommands.AddOutputFlag(testsCmd)
 }
 
 func timestamp(ts float32) string {
@@ -46,47 +49,50 @@ func timestamp(ts float32) string {
 	return time.Unix(secs, nsecs).UTC().String()
 }
 
-func listAll(factory string) {
+func listAll(cmd *cobra.Command, factory string) {
 	versions, err := api.TargetTesting(factory)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, versions) {
+		return
+	}
 	fmt.Println("Tested Targets:")
 	for _, ver := range versions {
 		fmt.Println(" ", ver)
 	}
 }
 
-func list(factory string, target int) {
+func list(cmd *cobra.Command, factory string, target int) {
+	if subcommands.OutputSelected(cmd) {
+		var tests []client.TargetTest
+		for test, err := range api.TargetTestsAll(factory, target) {
+			subcommands.DieNotNil(err)
+			tests = append(tests, test)
+		}
+		subcommands.PrintOutput(cmd, tests)
+		return
+	}
+
 	t := tabby.New()
 	t.AddHeader("NAME", "STATUS", "ID", "CREATED AT", "DEVICE")
 
-	var tl *client.TargetTestList
-	for {
-		var err error
-		if tl == nil {
-			tl, err = api.TargetTests(factory, target)
-		} else {
-			if tl.Next != nil {
-				tl, err = api.TargetTestsCont(*tl.Next)
-			} else {
-				break
-			}
-		}
+	for test, err := range api.TargetTestsAll(factory, target) {
 		subcommands.DieNotNil(err)
-		for _, test := range tl.Tests {
-			created := timestamp(test.CreatedOn)
-			name := test.DeviceUUID
-			if len(test.DeviceName) > 0 {
-				name = test.DeviceName
-			}
-			t.AddLine(test.Name, test.Status, test.Id, created, name)
+		created := timestamp(test.CreatedOn)
+		name := test.DeviceUUID
+		if len(test.DeviceName) > 0 {
+			name = test.DeviceName
 		}
+		t.AddLine(test.Name, test.Status, test.Id, created, name)
 	}
 	t.Print()
 }
 
-func show(factory string, target int, testId string) {
+func show(cmd *cobra.Command, factory string, target int, testId string) {
 	test, err := api.TargetTestResults(factory, target, testId)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, test) {
+		return
+	}
 	fmt.Println("Name:     ", test.Name)
 	fmt.Println("Status:   ", test.Status)
 	fmt.Println("Created:  ", timestamp(test.CreatedOn))
@@ -123,7 +129,7 @@ func doShowTests(cmd *cobra.Command, args []string) {
 
 	if len(args) == 0 {
 		logrus.Debugf("Showing all testing done for Factory: %s", factory)
-		listAll(factory)
+		listAll(cmd, factory)
 		os.Exit(0)
 	}
 
@@ -131,11 +137,11 @@ func doShowTests(cmd *cobra.Command, args []string) {
 	subcommands.DieNotNil(err)
 	if len(args) == 1 {
 		logrus.Debugf("Showing Target testing for %s %d", factory, target)
-		list(factory, target)
+		list(cmd, factory, target)
 	} else if len(args) == 2 {
 		testId := args[1]
 		logrus.Debugf("Showing Target test results for %s %d - %s", factory, target, testId)
-		show(factory, target, testId)
+		show(cmd, factory, target, testId)
 	} else {
 		testId := args[1]
 		artifact := args[2]
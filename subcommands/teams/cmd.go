@@ -18,23 +18,27 @@ func NewCommand() *cobra.Command {
 		Run:   doTeamsCommand,
 	}
 	subcommands.RequireFactory(cmd)
+	subcommands.AddOutputFlag(cmd)
 	return cmd
 }
 
 func doTeamsCommand(cmd *cobra.Command, args []string) {
 	if len(args) == 0 {
-		doList(subcommands.Login(cmd), viper.GetString("factory"))
+		doList(cmd, subcommands.Login(cmd), viper.GetString("factory"))
 	} else {
-		doGetTeam(subcommands.Login(cmd), viper.GetString("factory"), args[0])
+		doGetTeam(cmd, subcommands.Login(cmd), viper.GetString("factory"), args[0])
 	}
 
 }
 
-func doList(api *client.Api, factory string) {
+func doList(cmd *cobra.Command, api *client.Api, factory string) {
 	logrus.Debugf("Listing teams for %s", factory)
 
 	teams, err := api.TeamsList(factory)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, teams) {
+		return
+	}
 
 	t := tabby.New()
 	t.AddHeader("NAME", "DESCRIPTION")
@@ -44,9 +48,12 @@ func doList(api *client.Api, factory string) {
 	t.Print()
 }
 
-func doGetTeam(api *client.Api, factory, team_name string) {
+func doGetTeam(cmd *cobra.Command, api *client.Api, factory, team_name string) {
 	team, err := api.TeamDetails(factory, team_name)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, team) {
+		return
+	}
 
 	t := tabby.New()
 	t.AddHeader("NAME", "DESCRIPTION")
@@ -19,23 +19,27 @@ func NewCommand() *cobra.Command {
 		Run:   doUserCommand,
 	}
 	subcommands.RequireFactory(cmd)
+	subcommands.AddOutputFlag(cmd)
 	return cmd
 }
 
 func doUserCommand(cmd *cobra.Command, args []string) {
 	if len(args) == 0 {
-		doList(subcommands.Login(cmd), viper.GetString("factory"))
+		doList(cmd, subcommands.Login(cmd), viper.GetString("factory"))
 	} else {
-		doGetUser(subcommands.Login(cmd), viper.GetString("factory"), args[0])
+		doGetUser(cmd, subcommands.Login(cmd), viper.GetString("factory"), args[0])
 	}
 
 }
 
-func doList(api *client.Api, factory string) {
+func doList(cmd *cobra.Command, api *client.Api, factory string) {
 	logrus.Debugf("Listing users for %s", factory)
 
 	users, err := api.UsersList(factory)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, users) {
+		return
+	}
 
 	t := tabby.New()
 	t.AddHeader("ID", "NAME", "ROLE")
@@ -45,9 +49,12 @@ func doList(api *client.Api, factory string) {
 	t.Print()
 }
 
-func doGetUser(api *client.Api, factory, user_id string) {
+func doGetUser(cmd *cobra.Command, api *client.Api, factory, user_id string) {
 	user, err := api.UserAccessDetails(factory, user_id)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, user) {
+		return
+	}
 	t := tabby.New()
 	t.AddHeader("ID", "NAME", "ROLE")
 	t.AddLine(user.PolisId, user.Name, user.Role)
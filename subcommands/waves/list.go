@@ -20,6 +20,7 @@ func init() {
 	listCmd.Flags().Uint64P("page", "p", 1, "Page of Waves to display when pagination is needed")
 	listCmd.Flags().StringP("status", "S", "", "Only show Waves with a given status; one of (active, complete, canceled)")
 	listCmd.Flags().StringP("tag", "T", "", "Only show Waves with a given tag")
+	subcommands.AddOutputFlag(listCmd)
 }
 
 func doListWaves(cmd *cobra.Command, args []string) {
@@ -32,6 +33,9 @@ func doListWaves(cmd *cobra.Command, args []string) {
 
 	lst, err := api.FactoryListWaves(factory, limit, showPage, status, tag)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, lst.Waves) {
+		return
+	}
 
 	t := tabby.New()
 	t.AddHeader("NAME", "VERSION", "TAG", "STATUS", "CREATED AT", "FINISHED AT")
@@ -19,6 +19,7 @@ func init() {
 	}
 	cmd.AddCommand(showCmd)
 	showCmd.Flags().BoolP("show-targets", "s", false, "Show Wave Targets")
+	subcommands.AddOutputFlag(showCmd)
 }
 
 func doShowWave(cmd *cobra.Command, args []string) {
@@ -29,6 +30,9 @@ func doShowWave(cmd *cobra.Command, args []string) {
 
 	wave, err := api.FactoryGetWave(factory, name, showTargets)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, wave) {
+		return
+	}
 
 	fmt.Printf("Name: \t\t%s\n", wave.Name)
 	fmt.Printf("Version: \t%s\n", wave.Version)
@@ -54,6 +54,7 @@ This can be used to monitor how an update progresses after a Wave completes.
 	}
 	cmd.AddCommand(showCmd)
 	showCmd.Flags().Int("offline-threshold", 4, "Consider device 'OFFLINE' if not seen in the last X hours")
+	subcommands.AddOutputFlag(showCmd)
 }
 
 func doShowWaveStatus(cmd *cobra.Command, args []string) {
@@ -67,8 +68,11 @@ func doShowWaveStatus(cmd *cobra.Command, args []string) {
 		logrus.Debugf("Showing active Wave status for %s", factory)
 	}
 
-	status, err := api.FactoryWaveStatus(factory, name, offlineThreshold)
+	status, err := api.FactoryWaveStatusCtx(cmd.Context(), factory, name, offlineThreshold)
 	subcommands.DieNotNil(err)
+	if subcommands.PrintOutput(cmd, status) {
+		return
+	}
 
 	fmt.Printf("Wave '%s' for tag '%s' version %d is %s\n",
 		status.Name, status.Tag, status.Version, status.Status)
@@ -6,28 +6,42 @@
 package x509
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/foundriesio/fioctl/subcommands"
 )
 
 type KeyStorage interface {
-	configure()
+	// environ returns the variables which tell a script where its key is.
+	environ() []string
 }
 
-func (s *fileStorage) configure() {}
+func (s *fileStorage) environ() []string {
+	return []string{"KEY_FILE=" + s.Filename}
+}
 
-func (s *hsmStorage) configure() {
-	os.Setenv("HSM_MODULE", s.Module)
-	os.Setenv("HSM_PIN", s.Pin)
-	os.Setenv("HSM_TOKEN_LABEL", s.TokenLabel)
+func (s *hsmStorage) environ() []string {
+	return []string{
+		"HSM_MODULE=" + s.Module,
+		"HSM_PIN=" + s.Pin,
+		"HSM_TOKEN_LABEL=" + s.TokenLabel,
+		"HSM_KEY_LABEL=" + s.Label,
+	}
 }
 
 func run(script string, arg ...string) string {
-	factoryCaKeyStorage.configure()
+	return runWithKey(factoryCaKeyStorage, script, arg...)
+}
+
+func runWithKey(key KeyStorage, script string, arg ...string) string {
 	arg = append([]string{"-s"}, arg...)
 	cmd := exec.Command("/bin/sh", arg...)
+	cmd.Env = append(os.Environ(), key.environ()...)
 	cmd.Stderr = os.Stderr
 	in, err := cmd.StdinPipe()
 	subcommands.DieNotNil(err, "Failed to start the shell")
@@ -159,6 +173,94 @@ func SignEl2GoCsr(csrPem string) string {
 	return signCaCsr("el2g-*", csrPem)
 }
 
+func SignDeviceCsr(csrPem, factory string, production bool) string {
+	csrFile, err := os.CreateTemp("", "device-*.csr")
+	subcommands.DieNotNil(err)
+	defer os.Remove(csrFile.Name())
+	defer csrFile.Close()
+	_, err = csrFile.Write([]byte(csrPem))
+	subcommands.DieNotNil(err)
+	return signDeviceCsr(csrFile.Name(), factory, production)
+}
+
+func CreateDeviceCert(uuid, factory string, production bool) (keyPem, crtPem string) {
+	const script = `#!/bin/sh -e
+## This script creates a device key and a certificate signing request for it.
+
+if [ $# -ne 3 ] ; then
+	echo "ERROR: $0 <key> <csr> <cn>"
+	exit 1
+fi
+key=$1
+csr=$2
+cn=$3
+
+openssl ecparam -genkey -name prime256v1 | openssl ec -out $key
+openssl req -new -key $key -subj "/CN=${cn}" -out $csr`
+	dir, err := os.MkdirTemp("", "device-*")
+	subcommands.DieNotNil(err)
+	defer os.RemoveAll(dir)
+	keyFile, csrFile := filepath.Join(dir, "pkey.pem"), filepath.Join(dir, "device.csr")
+	run(script, keyFile, csrFile, uuid)
+	crtPem = signDeviceCsr(csrFile, factory, production)
+	return readFile(keyFile), crtPem
+}
+
+func signDeviceCsr(csrFile, factory string, production bool) string {
+	const script = `#!/bin/sh -e
+## This script signs a device certificate signing request with a local device CA, so that the
+## device can connect to the Foundries.io device gateway without registering first.
+
+if [ $# -ne 6 ] ; then
+	echo "ERROR: $0 <csr> <crt> <ca crt> <ou> <production> <days>"
+	exit 1
+fi
+csr=$1
+crt=$2
+ca=$3
+ou=$4
+days=$6
+
+cn=$(openssl req -noout -subject -nameopt multiline -verify -in $csr | sed -n 's/ *commonName *= //p')
+subj="/CN=${cn}/OU=${ou}"
+if [ "$5" = "true" ] ; then
+	subj="${subj}/businessCategory=production"
+fi
+
+cat >device.ext <<EOF
+keyUsage=critical, digitalSignature, keyAgreement
+extendedKeyUsage=critical, clientAuth
+EOF
+
+if [ -n "$HSM_MODULE" ] ; then
+	lbl=${HSM_TOKEN_LABEL-device-gateway-root}
+	key="pkcs11:token=${lbl};object=${HSM_KEY_LABEL};type=private;pin-value=$HSM_PIN"
+	extra="-CAkeyform engine -engine pkcs11"
+else
+	key=$KEY_FILE
+fi
+
+openssl x509 -req -days $days $extra -in $csr -subj "$subj" -extfile device.ext \
+	-CAkey "$key" -CA $ca -CAcreateserial -sha256 -out $crt
+rm device.ext`
+	crtFile, err := os.CreateTemp("", "device-*.crt")
+	subcommands.DieNotNil(err)
+	defer os.Remove(crtFile.Name())
+	defer crtFile.Close()
+
+	// As with the Go implementation, certificates are valid for 20 years, but not past the local CA
+	days := 7300
+	caDays := int(time.Until(LoadCertFromFile(deviceCaCertFile).NotAfter).Hours() / 24)
+	if caDays < 1 {
+		subcommands.DieNotNil(fmt.Errorf("The local CA %s expires in less than a day", deviceCaCertFile))
+	} else if caDays < days {
+		days = caDays
+	}
+	runWithKey(deviceCaKeyStorage, script, csrFile, crtFile.Name(), deviceCaCertFile, factory,
+		strconv.FormatBool(production), strconv.Itoa(days))
+	return readFile(crtFile.Name())
+}
+
 func CreateCrl(serials map[string]int) string {
 	if true {
 		panic("This function is not implemented in Bash implementation")
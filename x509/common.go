@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/foundriesio/fioctl/subcommands"
 )
@@ -15,6 +16,7 @@ const (
 	FactoryCaKeyLabel string = "root-ca"
 	FactoryCaCertFile string = "factory_ca.pem"
 	DeviceCaKeyFile   string = "local-ca.key"
+	DeviceCaKeyLabel  string = "local-ca"
 	DeviceCaCertFile  string = "local-ca.pem"
 	TlsCertFile       string = "tls-crt"
 	OnlineCaCertFile  string = "online-crt"
@@ -74,6 +76,47 @@ func InitHsm(hsm *HsmInfo) {
 	}
 }
 
+var (
+	deviceCaKeyStorage KeyStorage = &fileStorage{DeviceCaKeyFile}
+	deviceCaCertFile              = DeviceCaCertFile
+)
+
+// InitDeviceCa selects the local device CA which issues device client certificates. Its key is
+// either the file named after the certFile, or the key with the hsmKeyLabel on the HSM.
+func InitDeviceCa(certFile string, hsm *HsmInfo, hsmKeyLabel string) {
+	deviceCaCertFile = certFile
+	if hsm != nil {
+		deviceCaKeyStorage = &hsmStorage{*hsm, hsmKeyLabel}
+	} else {
+		deviceCaKeyStorage = &fileStorage{strings.TrimSuffix(certFile, ".pem") + ".key"}
+	}
+}
+
+// CheckDeviceCsr returns the device UUID, which is the common name of a device CSR. The CSR must
+// be signed by its own key, and its organizational unit, if set, must be the factory.
+func CheckDeviceCsr(csrPem, factory string) (string, error) {
+	block, rest := pem.Decode([]byte(csrPem))
+	if block == nil || len(strings.TrimSpace(string(rest))) > 0 {
+		return "", errors.New("Malformed PEM data")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return "", err
+	}
+	if len(csr.Subject.CommonName) == 0 {
+		return "", errors.New("The CSR has no common name, which must be the device UUID")
+	}
+	for _, ou := range csr.Subject.OrganizationalUnit {
+		if ou != factory {
+			return "", fmt.Errorf("The CSR is for the factory %s rather than %s", ou, factory)
+		}
+	}
+	return csr.Subject.CommonName, nil
+}
+
 func ValidateHsmArgs(hsmModule, hsmPin, hsmTokenLabel, moduleArg, pinArg, tokenArg string) (*HsmInfo, error) {
 	if len(hsmModule) > 0 {
 		if len(hsmPin) == 0 {
@@ -66,6 +66,74 @@ func TestNoHsm(t *testing.T) {
 	})
 }
 
+func TestIssueDeviceCerts(t *testing.T) {
+	t.Chdir(t.TempDir())
+	factoryCa, err := x509.ParseCertificate(pemToDer(t, CreateFactoryCa(testFactory)))
+	require.Nil(t, err)
+	localCa, err := x509.ParseCertificate(pemToDer(t, CreateDeviceCaExt(testUser, testFactory, "line-1.key", "line-1.pem")))
+	require.Nil(t, err)
+	InitDeviceCa("line-1.pem", nil, DeviceCaKeyLabel)
+
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(factoryCa)
+	intermediates.AddCert(localCa)
+	verify := func(crtPem string, uuid string, production bool) *x509.Certificate {
+		crt, err := x509.ParseCertificate(pemToDer(t, crtPem))
+		require.Nil(t, err)
+		chain, err := crt.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		require.Nil(t, err)
+		assert.Equal(t, [][]*x509.Certificate{{crt, localCa, factoryCa}}, chain)
+		assert.Equal(t, false, crt.IsCA)
+		assert.Equal(t, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyAgreement, crt.KeyUsage)
+		assert.Equal(t, uuid, crt.Subject.CommonName)
+		// The local CA is valid for 10 years, so caps the 20 years of a device certificate
+		assert.False(t, crt.NotAfter.After(localCa.NotAfter))
+		assert.Equal(t, []string{testFactory}, crt.Subject.OrganizationalUnit)
+		var category []string
+		for _, name := range crt.Subject.Names {
+			if name.Type.Equal(asn1.ObjectIdentifier{2, 5, 4, 15}) {
+				category = append(category, name.Value.(string))
+			}
+		}
+		if production {
+			assert.Equal(t, []string{"production"}, category)
+		} else {
+			assert.Empty(t, category)
+		}
+		return crt
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	csrDer, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "uuid-1", OrganizationalUnit: []string{testFactory}},
+	}, key)
+	require.Nil(t, err)
+	csrPem := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDer}))
+	uuid, err := CheckDeviceCsr(csrPem, testFactory)
+	require.Nil(t, err)
+	assert.Equal(t, "uuid-1", uuid)
+	crt := verify(SignDeviceCsr(csrPem, testFactory, true), "uuid-1", true)
+	assert.Equal(t, key.Public(), crt.PublicKey)
+
+	_, err = CheckDeviceCsr(csrPem, "other")
+	assert.ErrorContains(t, err, "is for the factory factory rather than other")
+	_, err = CheckDeviceCsr("garbage", testFactory)
+	assert.NotNil(t, err)
+
+	keyPem, crtPem := CreateDeviceCert("uuid-2", testFactory, false)
+	crt = verify(crtPem, "uuid-2", false)
+	deviceKey, err := x509.ParseECPrivateKey(pemToDer(t, keyPem))
+	require.Nil(t, err)
+	assert.Equal(t, deviceKey.Public(), crt.PublicKey)
+	// The key of the local CA is given to the scripts of the bash implementation, not the process
+	assert.Empty(t, os.Getenv("KEY_FILE"))
+}
+
 func runTest(t *testing.T, verifyFiles func(factoryCa, tlsCert, onlineCa, offlineCa *x509.Certificate)) {
 	dir, err := os.MkdirTemp("", "test-certs-*")
 	require.Nil(t, err)
@@ -5,6 +5,8 @@ package x509
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -83,6 +85,21 @@ func marshalSubject(cn string, ou string) pkix.Name {
 	return pkix.Name{ExtraNames: pkixAttrTypeValue}
 }
 
+// marshalDeviceSubject returns the subject of a device certificate, which the device gateway
+// requires to have the business category of production devices.
+func marshalDeviceSubject(uuid, factory string, production bool) pkix.Name {
+	subject := marshalSubject(uuid, factory)
+	if production {
+		categoryBytes, err := asn1.MarshalWithParams("production", "utf8")
+		subcommands.DieNotNil(err)
+		subject.ExtraNames = append(subject.ExtraNames, pkix.AttributeTypeAndValue{
+			Type:  []int{2, 5, 4, 15}, // businessCategory
+			Value: asn1.RawValue{FullBytes: categoryBytes},
+		})
+	}
+	return subject
+}
+
 func CreateFactoryCa(ou string) string {
 	priv := factoryCaKeyStorage.genAndSaveKey()
 	crtTemplate := x509.Certificate{
@@ -136,6 +153,23 @@ func SignEstCsr(csrPem string) string {
 	return genTlsCert(csr.Subject, csr.DNSNames, csr.PublicKey)
 }
 
+// SignDeviceCsr issues a device client certificate for a CSR checked by CheckDeviceCsr.
+func SignDeviceCsr(csrPem, factory string, production bool) string {
+	csr := parsePemCertificateRequest(csrPem)
+	return genDeviceCert(marshalDeviceSubject(csr.Subject.CommonName, factory, production), csr.PublicKey)
+}
+
+// CreateDeviceCert generates a device key, and issues a device client certificate for it.
+func CreateDeviceCert(uuid, factory string, production bool) (keyPem, crtPem string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	subcommands.DieNotNil(err)
+	keyRaw, err := x509.MarshalECPrivateKey(priv)
+	subcommands.DieNotNil(err)
+	keyPem = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyRaw}))
+	crtPem = genDeviceCert(marshalDeviceSubject(uuid, factory, production), priv.Public())
+	return
+}
+
 func CreateCrl(serials map[string]int) string {
 	factoryKey := factoryCaKeyStorage.loadKey()
 	factoryCa := LoadCertFromFile(FactoryCaCertFile)
@@ -186,6 +220,38 @@ func genTlsCert(subject pkix.Name, dnsNames []string, pubkey crypto.PublicKey) s
 	return genCertificate(&crtTemplate, factoryCa, pubkey, factoryKey)
 }
 
+// The local device CA is loaded once to issue many device certificates, as loading a key from an
+// HSM can take a while.
+var deviceCa struct {
+	storage KeyStorage
+	key     crypto.Signer
+	crt     *x509.Certificate
+}
+
+func genDeviceCert(subject pkix.Name, pubkey crypto.PublicKey) string {
+	if deviceCa.storage != deviceCaKeyStorage {
+		deviceCa.crt = LoadCertFromFile(deviceCaCertFile)
+		deviceCa.key = deviceCaKeyStorage.loadKey()
+		deviceCa.storage = deviceCaKeyStorage
+	}
+	notAfter := time.Now().AddDate(20, 0, 0)
+	if deviceCa.crt.NotAfter.Before(notAfter) {
+		notAfter = deviceCa.crt.NotAfter
+	}
+	crtTemplate := x509.Certificate{
+		SerialNumber: genRandomSerialNumber(),
+		Subject:      subject,
+		Issuer:       deviceCa.crt.Subject,
+		NotBefore:    time.Now(),
+		NotAfter:     notAfter,
+
+		IsCA:        false,
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return genCertificate(&crtTemplate, deviceCa.crt, pubkey, deviceCa.key)
+}
+
 func genCaCert(subject pkix.Name, pubkey crypto.PublicKey) string {
 	factoryKey := factoryCaKeyStorage.loadKey()
 	factoryCa := LoadCertFromFile(FactoryCaCertFile)